@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/repository"
+)
+
+// holdSweepInterval is how often expired room holds and bookings not paid in time
+// are released
+const holdSweepInterval = time.Minute
+
+// sweepHolds releases the rooms held past their hold for guests filling in the
+// reservation form, and the bookings still awaiting payment after theirs, every
+// interval until ctx is done. The returned channel is closed once it has stopped.
+func sweepHolds(ctx context.Context, db repository.DatabaseRepo, log *slog.Logger, interval time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				releaseExpiredRoomHolds(ctx, db, log, now)
+				releaseExpiredHolds(ctx, db, log, now)
+			}
+		}
+	}()
+	return done
+}
+
+// releaseExpiredHolds releases the holds ended by now and returns how many were released
+func releaseExpiredHolds(ctx context.Context, db repository.DatabaseRepo, log *slog.Logger, now time.Time) int {
+	ids, err := db.ReleaseExpiredHolds(ctx, now)
+	if err != nil {
+		log.Error("cannot release expired holds", "error", err)
+		return 0
+	}
+
+	for _, id := range ids {
+		log.Info("unpaid reservation released", "reservation_id", id)
+	}
+	return len(ids)
+}
+
+// releaseExpiredRoomHolds removes the room holds ended by now and returns how many
+// were removed
+func releaseExpiredRoomHolds(ctx context.Context, db repository.DatabaseRepo, log *slog.Logger, now time.Time) int {
+	n, err := db.ReleaseExpiredRoomHolds(ctx, now)
+	if err != nil {
+		log.Error("cannot release expired room holds", "error", err)
+		return 0
+	}
+
+	if n > 0 {
+		log.Info("expired room holds released", "count", n)
+	}
+	return n
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/config"
+	"github.com/florian-lahitte-uvi/bookings/internal/repository/dbrepo"
+)
+
+func TestReleaseExpiredHolds(t *testing.T) {
+	db := dbrepo.NewTestingRepo(&config.AppConfig{})
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	var tests = []struct {
+		name     string
+		year     int
+		expected int
+	}{
+		{"expired", 2060, 2},
+		{"none", 2040, 0},
+		{"error", 2070, 0},
+	}
+
+	for _, e := range tests {
+		got := releaseExpiredHolds(context.Background(), db, log, time.Date(e.year, 1, 1, 0, 0, 0, 0, time.UTC))
+		if got != e.expected {
+			t.Errorf("%s: released %d holds, wanted %d", e.name, got, e.expected)
+		}
+	}
+}
+
+func TestReleaseExpiredRoomHolds(t *testing.T) {
+	db := dbrepo.NewTestingRepo(&config.AppConfig{})
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	var tests = []struct {
+		name     string
+		year     int
+		expected int
+	}{
+		{"expired", 2060, 3},
+		{"none", 2040, 0},
+		{"error", 2070, 0},
+	}
+
+	for _, e := range tests {
+		got := releaseExpiredRoomHolds(context.Background(), db, log, time.Date(e.year, 1, 1, 0, 0, 0, 0, time.UTC))
+		if got != e.expected {
+			t.Errorf("%s: released %d room holds, wanted %d", e.name, got, e.expected)
+		}
+	}
+}
+
+func TestSweepHoldsStops(t *testing.T) {
+	db := dbrepo.NewTestingRepo(&config.AppConfig{})
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := sweepHolds(ctx, db, log, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("sweeper did not stop when its context was done")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/importer"
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/florian-lahitte-uvi/bookings/internal/repository"
+)
+
+// runCommand runs the subcommand in args instead of the server and returns the exit code
+func runCommand(ctx context.Context, db repository.DatabaseRepo, args []string) int {
+	switch args[0] {
+	case "import":
+		return importCommand(ctx, os.Stdout, db, args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q, expected import\n", args[0])
+	return 2
+}
+
+// importCommand checks a CSV file of reservations and blocks and, with -commit,
+// imports it in a single transaction
+func importCommand(ctx context.Context, out io.Writer, db repository.DatabaseRepo, args []string) int {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.SetOutput(out)
+	commit := fs.Bool("commit", false, "Import the file if it checks clean, otherwise only check it")
+	mapping := fs.String("map", "", "Column of each field as field=Column pairs separated by commas, e.g. first_name=First,start_date=Arrival")
+	property := fs.String("property", "", "Slug of the property the rooms belong to, the default property if empty")
+	fs.Usage = func() {
+		fmt.Fprintln(out, "Usage: bookings [flags] import [-commit] [-property slug] [-map field=Column,...] file.csv")
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	m, err := importer.ParseMapping(*mapping)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 2
+	}
+
+	propertyID := models.DefaultPropertyID
+	if *property != "" {
+		p, err := db.PropertyBySlug(ctx, *property)
+		if errors.Is(err, sql.ErrNoRows) {
+			fmt.Fprintf(out, "unknown property %q\n", *property)
+			return 2
+		}
+		if err != nil {
+			fmt.Fprintln(out, err)
+			return 1
+		}
+		propertyID = p.ID
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	defer f.Close()
+
+	im, err := importer.New(ctx, db.ForProperty(propertyID))
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+
+	res, err := im.Check(ctx, f, m)
+	if err != nil {
+		fmt.Fprintf(out, "cannot read %s: %s\n", fs.Arg(0), err)
+		return 1
+	}
+
+	fmt.Fprintf(out, "%d reservations and %d blocks are valid\n", res.Reservations, res.Blocks)
+	for _, issue := range res.Errors {
+		fmt.Fprintf(out, "error: %s\n", issue)
+	}
+	for _, issue := range res.Conflicts {
+		fmt.Fprintf(out, "conflict: %s\n", issue)
+	}
+
+	if !res.OK() {
+		fmt.Fprintln(out, "nothing was imported")
+		return 1
+	}
+	if !*commit {
+		fmt.Fprintln(out, "dry run, run again with -commit to import")
+		return 0
+	}
+
+	if err := im.Commit(ctx, res); err != nil {
+		fmt.Fprintf(out, "nothing was imported: %s\n", err)
+		return 1
+	}
+	fmt.Fprintf(out, "imported %d reservations and %d blocks\n", res.Reservations, res.Blocks)
+	return 0
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/config"
+	"github.com/florian-lahitte-uvi/bookings/internal/repository/dbrepo"
+)
+
+func TestImportCommand(t *testing.T) {
+	db := dbrepo.NewTestingRepo(&config.AppConfig{})
+
+	file := filepath.Join(t.TempDir(), "import.csv")
+	os.WriteFile(file, []byte("first_name,last_name,email,room,start_date,end_date\nJohn,Smith,bad,Penthouse,2040-01-01,2040-01-03\n"), 0600)
+	otherRoom := filepath.Join(t.TempDir(), "other.csv")
+	os.WriteFile(otherRoom, []byte("first_name,last_name,email,room,start_date,end_date\nJohn,Smith,john@smith.com,General's Quarters,2040-01-01,2040-01-03\n"), 0600)
+
+	var tests = []struct {
+		name         string
+		args         []string
+		expectedCode int
+		expectedOut  string
+	}{
+		{"invalid-rows", []string{"-commit", file}, 1, "error: line 2: email: Invalid email address"},
+		{"missing-file", []string{filepath.Join(t.TempDir(), "none.csv")}, 1, "no such file"},
+		{"no-file", []string{}, 2, "Usage: bookings"},
+		{"bad-mapping", []string{"-map", "guest=Name", file}, 2, "invalid mapping"},
+		{"own-room", []string{otherRoom}, 0, "dry run"},
+		{"room-of-other-property", []string{"-property", "harbour", otherRoom}, 1, "error: line 2: room: unknown room"},
+		{"unknown-property", []string{"-property", "nowhere", otherRoom}, 2, `unknown property "nowhere"`},
+		{"unknown-flag", []string{"-force", file}, 2, "flag provided but not defined"},
+	}
+
+	for _, e := range tests {
+		var out bytes.Buffer
+		code := importCommand(context.Background(), &out, db, e.args)
+		if code != e.expectedCode {
+			t.Errorf("%s: got exit code %d, wanted %d", e.name, code, e.expectedCode)
+		}
+		if !strings.Contains(out.String(), e.expectedOut) {
+			t.Errorf("%s: expected output to contain %q, got %q", e.name, e.expectedOut, out.String())
+		}
+	}
+}
@@ -1,136 +1,217 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
-	"flag"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
-	"github.com/florian-lahitte-uvi/bookings/helpers"
 	"github.com/florian-lahitte-uvi/bookings/internal/config"
 	"github.com/florian-lahitte-uvi/bookings/internal/driver"
 	"github.com/florian-lahitte-uvi/bookings/internal/handlers"
+	"github.com/florian-lahitte-uvi/bookings/internal/logger"
 	"github.com/florian-lahitte-uvi/bookings/internal/models"
-	"github.com/florian-lahitte-uvi/bookings/internal/render"
 )
 
-const portNumber = ":8080"
+// command holds a subcommand to run instead of the server, from the arguments after the flags
+var command []string
 
-var app config.AppConfig
-var session *scs.SessionManager
-var infoLog *log.Logger
-var errorLog *log.Logger
+// Server timeouts protect against slow or stalled clients
+const (
+	readHeaderTimeout = 5 * time.Second
+	readTimeout       = 15 * time.Second
+	writeTimeout      = 30 * time.Second
+	idleTimeout       = 120 * time.Second
+)
 
 // main is the main function
 func main() {
-	db, err := run()
+	app, db, err := run(os.Args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.SQL.Close()
+	appLog := app.App.Logger
 
-	defer close(app.MailChan)
+	if len(command) > 0 {
+		code := runCommand(context.Background(), app.DB, command)
+		db.SQL.Close()
+		os.Exit(code)
+	}
 
-	fmt.Println("Starting Mail Listener")
-	listenForMail()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// msg := models.MailData{
-	// 	From:    "from@example.com",
-	// 	To:      "to@example.com",
-	// 	Subject: "Test Email",
-	// 	Content: "Hello, this is a test email",
-	// }
+	appLog.Info("starting mail listener")
+	mailDone := listenForMail(app)
 
-	// app.MailChan <- msg
+	// rooms held for guests filling in the reservation form, and bookings held for a
+	// payment online, are given back if not booked or paid in time
+	appLog.Info("starting hold sweeper", "room_hold", app.App.RoomHold, "payment_hold", app.App.PaymentHold)
+	sweepDone := sweepHolds(ctx, app.DB, appLog, holdSweepInterval)
 
-	fmt.Println(fmt.Sprintf("Staring application on port %s", portNumber))
+	servers := []*http.Server{newServer(app.App.Addr, routes(app), appLog)}
+	if app.App.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", app.Metrics.Handler())
+		servers = append(servers, newServer(app.App.MetricsAddr, mux, appLog))
+	}
 
-	srv := &http.Server{
-		Addr:    portNumber,
-		Handler: routes(&app),
+	serverErr := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			appLog.Info("starting listener", "addr", srv.Addr)
+			serverErr <- srv.ListenAndServe()
+		}(srv)
 	}
 
-	err = srv.ListenAndServe()
-	if err != nil {
-		log.Fatal(err)
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			appLog.Error("listener stopped", "error", err)
+		}
+	case <-ctx.Done():
+		appLog.Info("shutdown signal received")
+	}
+	stop()
+
+	shutdown(app, servers, mailDone, sweepDone, db)
+}
+
+// newServer creates an http server with the application timeouts, logging its errors
+// to appLog
+func newServer(addr string, handler http.Handler, appLog *slog.Logger) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+		ErrorLog:          slog.NewLogLogger(appLog.Handler(), slog.LevelError),
 	}
 }
 
-func run() (*driver.DB, error) {
+// shutdown stops accepting connections, drains in-flight requests, flushes the
+// mail queue, waits for the hold sweeper and closes the database pool,
+// all within the shutdown timeout of app
+func shutdown(app *handlers.Application, servers []*http.Server, mailDone, sweepDone <-chan struct{}, db *driver.DB) {
+	appLog := app.App.Logger
+	ctx, cancel := context.WithTimeout(context.Background(), app.App.ShutdownTimeout)
+	defer cancel()
+
+	drained := true
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			appLog.Error("cannot drain listener", "addr", srv.Addr, "error", err)
+			drained = false
+		}
+	}
+
+	// handlers still running could send on the mail channel, so only close it
+	// once every request has completed
+	if drained {
+		close(app.MailChan)
+		select {
+		case <-mailDone:
+			appLog.Info("mail queue flushed")
+		case <-ctx.Done():
+			appLog.Error("mail queue not flushed before deadline")
+		}
+	}
+
+	if sweepDone != nil {
+		select {
+		case <-sweepDone:
+		case <-ctx.Done():
+			appLog.Error("hold sweeper not stopped before deadline")
+		}
+	}
+
+	if err := db.SQL.Close(); err != nil {
+		appLog.Error("cannot close database pool", "error", err)
+	}
+	appLog.Info("shutdown complete")
+}
+
+// run loads the settings from the command line arguments in args, connects to the
+// database and sets up the application
+func run(args []string) (*handlers.Application, *driver.DB, error) {
 	// what am I going to put in the session
 	gob.Register(models.Reservation{})
 	gob.Register(models.User{})
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
+	gob.Register(models.RoomRestriction{})
 	gob.Register(map[string]int{})
 
-	// Read flags
-	inProduction := flag.Bool("production", true, "Application in production mode")
-	useCache := flag.Bool("cache", true, "Use cache")
-	dbHost := flag.String("dbhost", "localhost", "Database host")
-	dbName := flag.String("dbname", "", "Database name")
-	dbUser := flag.String("dbuser", "", "Database user")
-	dbPass := flag.String("dbpass", "", "Database password")
-	dbPort := flag.String("dbport", "5432", "Database port")
-	dbSSL := flag.String("dbssl", "disable", "Database SSL mode")
-
-	flag.Parse()
-
-	if *dbName == "" || *dbUser == "" || *dbPass == "" {
-		fmt.Println("Database name, user, and password must be provided.")
-		os.Exit(1)
+	settings, printConfig, err := config.Load(args)
+	if printConfig {
+		if printErr := settings.Print(os.Stdout); printErr != nil {
+			return nil, nil, printErr
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		os.Exit(0)
+	}
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// create a channel for mail data
 	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-
-	// change this to true when in production
-	app.InProduction = *inProduction
-	app.UseCache = *useCache
 
-	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
+	var cfg config.AppConfig
+	settings.Apply(&cfg)
+	command = settings.Args
 
-	errorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errorLog
+	appLog := logger.New(os.Stdout, settings.Log.Format, settings.Log.Level)
+	cfg.Logger = appLog
+	slog.SetDefault(appLog)
 
 	// set up the session
-	session = scs.New()
-	session.Lifetime = 24 * time.Hour
+	session := scs.New()
+	session.Lifetime = settings.SessionLifetime
 	session.Cookie.Persist = true
 	session.Cookie.SameSite = http.SameSiteLaxMode
-	session.Cookie.Secure = app.InProduction
-
-	app.Session = session
+	session.Cookie.Secure = cfg.InProduction
 
 	// Connect to database
-	log.Println("Connecting to database...")
-	connectionString := fmt.Sprintf("user=%s password=%s dbname=%s port=%s host=%s sslmode=%s",
-		*dbUser, *dbPass, *dbName, *dbPort, *dbHost, *dbSSL)
-	db, err := driver.ConnectSQL(connectionString)
+	appLog.Info("connecting to database")
+	db, err := driver.ConnectSQL(settings.ConnectionString(), driver.PoolOptions{
+		MaxOpenConns: settings.DB.MaxOpenConns,
+		MaxIdleConns: settings.DB.MaxIdleConns,
+		MaxLifetime:  settings.DB.MaxLifetime,
+	})
 	if err != nil {
-		log.Fatal("cannot connect to database")
+		return nil, nil, fmt.Errorf("cannot connect to database: %w", err)
 	}
-	log.Println("Connected to database!")
+	appLog.Info("connected to database")
+
+	app := handlers.NewApplication(&cfg, db, session, mailChan)
 
 	// Create template cache
-	tc, err := render.CreateTemplateCache()
+	tc, err := app.Render.CreateTemplateCache()
 	if err != nil {
-		log.Fatal("cannot create template cache")
-		return nil, err
+		return nil, nil, fmt.Errorf("cannot create template cache: %w", err)
 	}
 
-	app.TemplateCache = tc
+	cfg.TemplateCache = tc
 
-	repo := handlers.NewRepo(&app, db)
-	handlers.NewHandlers(repo)
-	render.NewRenderer(&app)
-	helpers.NewHelpers(&app)
+	if err := app.Metrics.RegisterDB(db.SQL); err != nil {
+		return nil, nil, err
+	}
+	if err := app.Metrics.RegisterBusiness(app.DB); err != nil {
+		return nil, nil, err
+	}
 
-	return db, nil
+	return app, db, nil
 }
@@ -3,7 +3,7 @@ package main
 import "testing"
 
 func TestRun(t *testing.T) {
-	db, err := run()
+	_, db, err := run([]string{})
 	if err != nil {
 		t.Error("failed run")
 	}
@@ -1,37 +1,113 @@
 package main
 
 import (
+	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 
-	"github.com/florian-lahitte-uvi/bookings/helpers"
+	"github.com/florian-lahitte-uvi/bookings/internal/handlers"
+	"github.com/florian-lahitte-uvi/bookings/internal/logger"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
 	"github.com/justinas/nosurf"
 )
 
 // NoSurf is the csrf protection middleware
-func NoSurf(next http.Handler) http.Handler {
-	csrfHandler := nosurf.New(next)
-
-	csrfHandler.SetBaseCookie(http.Cookie{
-		HttpOnly: true,
-		Path:     "/",
-		Secure:   app.InProduction,
-		SameSite: http.SameSiteLaxMode,
-	})
-	return csrfHandler
+func NoSurf(app *handlers.Application) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		csrfHandler := nosurf.New(next)
+		// guest replies are posted by the mail provider, and payment events by the payment
+		// provider, both authenticated by a shared secret
+		csrfHandler.ExemptPath("/mail/inbound")
+		csrfHandler.ExemptPath("/payments/webhook")
+
+		csrfHandler.SetBaseCookie(http.Cookie{
+			HttpOnly: true,
+			Path:     "/",
+			Secure:   app.App.InProduction,
+			SameSite: http.SameSiteLaxMode,
+		})
+		return csrfHandler
+	}
 }
 
 // SessionLoad loads and saves session data for current request
-func SessionLoad(next http.Handler) http.Handler {
-	return session.LoadAndSave(next)
+func SessionLoad(app *handlers.Application) func(http.Handler) http.Handler {
+	return app.Session.LoadAndSave
+}
+
+// Auth sends visitors who aren't logged in to the login page
+func Auth(app *handlers.Application) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !app.IsAuthenticated(r) {
+				app.Session.Put(r.Context(), "error", "You must be logged in to access this page")
+				http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequestLogger tags the request context with the request ID, method, path and
+// user ID so every log line can be correlated, and logs the outcome of the request
+func RequestLogger(app *handlers.Application) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			requestID := middleware.GetReqID(r.Context())
+
+			attrs := []slog.Attr{
+				slog.String("request_id", requestID),
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+			}
+			if userID := app.Session.GetInt(r.Context(), "user_id"); userID > 0 {
+				attrs = append(attrs, slog.Int("user_id", userID))
+			}
+			ctx := logger.WithAttrs(r.Context(), attrs...)
+
+			w.Header().Set(middleware.RequestIDHeader, requestID)
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r.WithContext(ctx))
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			app.App.Logger.InfoContext(ctx, "request completed",
+				"status", status,
+				"duration", time.Since(start),
+			)
+		})
+	}
 }
 
-func Auth(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !helpers.IsAuthenticated(r) {
-			session.Put(r.Context(), "error", "You must be logged in to access this page")
-			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
+// Metrics records request counts and latency per chi route pattern in the metrics
+// of app
+func Metrics(app *handlers.Application) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+			next.ServeHTTP(ww, r)
+
+			// the pattern is only known once the router has matched the request
+			route := "unmatched"
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			app.Metrics.HTTPRequests.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+			app.Metrics.HTTPDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+		})
+	}
 }
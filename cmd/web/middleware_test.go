@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -10,7 +11,7 @@ func (m *myHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {}
 
 func TestNoSurf(t *testing.T) {
 	var myH myHandler
-	h := NoSurf(&myH)
+	h := NoSurf(newTestApplication(t))(&myH)
 
 	switch v := h.(type) {
 	case http.Handler:
@@ -22,7 +23,7 @@ func TestNoSurf(t *testing.T) {
 
 func TestSessionLoad(t *testing.T) {
 	var myH myHandler
-	h := SessionLoad(&myH)
+	h := SessionLoad(newTestApplication(t))(&myH)
 
 	switch v := h.(type) {
 	case http.Handler:
@@ -31,3 +32,41 @@ func TestSessionLoad(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not http.Handler but is %T", v))
 	}
 }
+
+func TestRequestLogger(t *testing.T) {
+	var myH myHandler
+	h := RequestLogger(newTestApplication(t))(&myH)
+
+	switch v := h.(type) {
+	case http.Handler:
+		// do nothing
+	default:
+		t.Error(fmt.Sprintf("type is not http.Handler but is %T", v))
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	var myH myHandler
+	h := Metrics(newTestApplication(t))(&myH)
+
+	switch v := h.(type) {
+	case http.Handler:
+		// do nothing
+	default:
+		t.Error(fmt.Sprintf("type is not http.Handler but is %T", v))
+	}
+}
+
+func TestAuth(t *testing.T) {
+	var myH myHandler
+	app := newTestApplication(t)
+	h := SessionLoad(app)(Auth(app)(&myH))
+
+	req, _ := http.NewRequest("GET", "/admin/dashboard", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/user/login" {
+		t.Errorf("expected a redirect to the login page, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+}
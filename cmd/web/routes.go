@@ -3,56 +3,136 @@ package main
 import (
 	"net/http"
 
-	"github.com/florian-lahitte-uvi/bookings/internal/config"
 	"github.com/florian-lahitte-uvi/bookings/internal/handlers"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 )
 
-func routes(app *config.AppConfig) http.Handler {
+// routes returns the routes of the site served by app
+func routes(app *handlers.Application) http.Handler {
 	mux := chi.NewRouter()
 
+	mux.Use(middleware.RequestID)
+	mux.Use(Metrics(app))
 	mux.Use(middleware.Recoverer)
-	mux.Use(NoSurf)
-	mux.Use(SessionLoad)
+	mux.Use(NoSurf(app))
+	mux.Use(SessionLoad(app))
+	mux.Use(RequestLogger(app))
 
-	mux.Get("/", handlers.Repo.Home)
-	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/generals-quarters", handlers.Repo.Generals)
-	mux.Get("/majors-suite", handlers.Repo.Majors)
+	mux.Get("/healthz", app.Healthz)
+	mux.Get("/readyz", app.Readyz)
 
-	mux.Get("/search-availability", handlers.Repo.Availability)
-	mux.Post("/search-availability", handlers.Repo.PostAvailability)
-	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
-	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
-	mux.Get("/book-room", handlers.Repo.BookRoom)
+	mux.Post("/mail/inbound", app.InboundMail)
+	mux.Post("/payments/webhook", app.PaymentWebhook)
+	mux.Get("/payments/fake/{id}", app.FakeCheckout)
+	mux.Post("/payments/fake/{id}", app.PostFakeCheckout)
 
-	mux.Get("/contact", handlers.Repo.Contact)
-	mux.Get("/user/login", handlers.Repo.ShowLogin)
-	mux.Post("/user/login", handlers.Repo.PostShowLogin)
-	mux.Get("/user/logout", handlers.Repo.Logout)
-
-	mux.Get("/make-reservation", handlers.Repo.Reservation)
-	mux.Post("/make-reservation", handlers.Repo.PostReservation)
-	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
+	// the public pages of the property claiming the hostname, or of the default
+	// one, and of every property under its slug
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.SiteProperty)
+		siteRoutes(mux, app)
+	})
+	mux.Route("/p/{property}", func(mux chi.Router) {
+		mux.Use(app.SiteProperty)
+		siteRoutes(mux, app)
+	})
 
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
 	mux.Route("/admin", func(mux chi.Router) {
-		mux.Use(Auth)
-		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
-		mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
-		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
-		mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
-		mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
-
-		mux.Get("/process-reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
-		mux.Get("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
-
-		mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
-		mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+		mux.Use(Auth(app))
+		mux.Use(app.AdminProperty)
+		mux.Post("/property", app.AdminSwitchProperty)
+
+		mux.Get("/dashboard", app.AdminDashboard)
+		mux.Get("/dashboard/occupancy", app.AdminDashboardOccupancy)
+		mux.Get("/dashboard/pace", app.AdminDashboardPace)
+		mux.Get("/reservations-new", app.AdminNewReservations)
+		mux.Get("/reservations-all", app.AdminAllReservations)
+		mux.Get("/reservations-calendar", app.AdminReservationsCalendar)
+		mux.Get("/reservations-calendar/json", app.AdminCalendarJSON)
+		mux.Post("/reservations-calendar/blocks", app.AdminCalendarAddBlock)
+		mux.Post("/reservations-calendar/blocks/{id}/delete", app.AdminCalendarRemoveBlock)
+		mux.Post("/reservations-calendar/reservations/{id}/unit", app.AdminCalendarAssignUnit)
+		mux.Get("/reservations/{src}/export", app.AdminExportReservations)
+		mux.Get("/reservations/create", app.AdminNewReservation)
+		mux.Post("/reservations/create", app.AdminPostNewReservation)
+
+		mux.Get("/guests", app.AdminGuests)
+		mux.Get("/guests/{id}", app.AdminShowGuest)
+		mux.Post("/guests/{id}", app.AdminPostGuest)
+
+		mux.Get("/promo-codes", app.AdminPromoCodes)
+		mux.Get("/promo-codes/new", app.AdminNewPromoCode)
+		mux.Post("/promo-codes/new", app.AdminPostPromoCode)
+		mux.Get("/promo-codes/{id}", app.AdminShowPromoCode)
+		mux.Post("/promo-codes/{id}", app.AdminPostPromoCode)
+		mux.Post("/promo-codes/{id}/delete", app.AdminDeletePromoCode)
+
+		mux.Get("/cancellation-policies", app.AdminCancellationPolicies)
+		mux.Get("/cancellation-policies/new", app.AdminNewCancellationPolicy)
+		mux.Post("/cancellation-policies/new", app.AdminPostCancellationPolicy)
+		mux.Get("/cancellation-policies/{id}", app.AdminShowCancellationPolicy)
+		mux.Post("/cancellation-policies/{id}", app.AdminPostCancellationPolicy)
+		mux.Post("/cancellation-policies/{id}/delete", app.AdminDeleteCancellationPolicy)
+
+		mux.Get("/properties", app.AdminProperties)
+		mux.Get("/properties/new", app.AdminNewProperty)
+		mux.Post("/properties/new", app.AdminPostProperty)
+		mux.Get("/properties/{id}", app.AdminShowProperty)
+		mux.Post("/properties/{id}", app.AdminPostProperty)
+		mux.Post("/properties/{id}/rooms", app.AdminPostPropertyRoom)
+		mux.Post("/properties/{id}/rooms/{room}/units", app.AdminPostRoomUnit)
+
+		mux.Get("/reports", app.AdminReports)
+		mux.Get("/reports/occupancy", app.AdminReportOccupancy)
+
+		mux.Get("/import", app.AdminImport)
+		mux.Post("/import", app.AdminPostImport)
+
+		mux.Get("/process-reservation/{src}/{id}/do", app.AdminProcessReservation)
+		mux.Get("/delete-reservation/{src}/{id}/do", app.AdminDeleteReservation)
+
+		mux.Get("/reservations/{src}/{id}/show", app.AdminShowReservation)
+		mux.Post("/reservations/{src}/{id}", app.AdminPostShowReservation)
+		mux.Post("/reservations/{src}/{id}/notes", app.AdminPostReservationNote)
+		mux.Post("/reservations/{src}/{id}/messages", app.AdminPostReservationMessage)
+		mux.Post("/reservations/{src}/{id}/payments", app.AdminPostReservationPayment)
+		mux.Post("/reservations/{src}/{id}/amount-due", app.AdminPostAmountDue)
+		mux.Post("/reservations/{src}/{id}/invoice", app.AdminPostInvoice)
+		mux.Get("/reservations/{src}/{id}/invoice.pdf", app.AdminInvoicePDF)
+		mux.Post("/reservations/{src}/{id}/cancel", app.AdminPostCancelReservation)
 	})
 
 	return mux
 }
+
+// siteRoutes adds the public pages of a property served by app to mux
+func siteRoutes(mux chi.Router, app *handlers.Application) {
+	mux.Get("/", app.Home)
+	mux.Get("/about", app.About)
+	mux.Get("/generals-quarters", app.Generals)
+	mux.Get("/majors-suite", app.Majors)
+
+	mux.Get("/search-availability", app.Availability)
+	mux.Post("/search-availability", app.PostAvailability)
+	mux.Post("/search-availability-json", app.AvailabilityJSON)
+	mux.Get("/api/availability/calendar", app.AvailabilityCalendar)
+	mux.Get("/choose-room/{id}", app.ChooseRoom)
+	mux.Get("/book-room", app.BookRoom)
+
+	mux.Get("/contact", app.Contact)
+	mux.Get("/user/login", app.ShowLogin)
+	mux.Post("/user/login", app.PostShowLogin)
+	mux.Get("/user/logout", app.Logout)
+
+	mux.Get("/make-reservation", app.Reservation)
+	mux.Post("/make-reservation", app.PostReservation)
+	mux.Get("/reservation-summary", app.ReservationSummary)
+	mux.Get("/my-reservation/{token}", app.GuestReservation)
+	mux.Get("/my-reservation/{token}/invoice.pdf", app.GuestInvoicePDF)
+	mux.Get("/my-reservation/{token}/cancel", app.GuestCancelReservation)
+	mux.Post("/my-reservation/{token}/cancel", app.GuestPostCancelReservation)
+}
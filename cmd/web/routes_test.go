@@ -1,17 +1,26 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 
+	"github.com/alexedwards/scs/v2"
 	"github.com/florian-lahitte-uvi/bookings/internal/config"
+	"github.com/florian-lahitte-uvi/bookings/internal/handlers"
+	"github.com/florian-lahitte-uvi/bookings/internal/logger"
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/florian-lahitte-uvi/bookings/internal/payments"
 	"github.com/go-chi/chi"
 )
 
 func TestRoutes(t *testing.T) {
-	var app config.AppConfig
-
-	mux := routes(&app)
+	mux := routes(newTestApplication(t))
 
 	switch v := mux.(type) {
 	case *chi.Mux:
@@ -20,3 +29,24 @@ func TestRoutes(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not *chi.Mux, but is %T", v))
 	}
 }
+
+// TestPaymentWebhookRoute tests that the payment provider reaches the webhook through
+// the middleware, without a csrf token
+func TestPaymentWebhookRoute(t *testing.T) {
+	cfg := config.AppConfig{
+		Logger:   logger.New(os.Stdout, "text", "info"),
+		Payments: payments.NewFake("s3cret"),
+	}
+	app := handlers.NewTestApplication(&cfg, scs.New(), make(chan models.MailData, 1))
+
+	payload, _ := json.Marshal(payments.Event{Type: payments.EventCheckoutExpired, CheckoutID: "cs_1", ReservationID: 1})
+	req, _ := http.NewRequest("POST", "/payments/webhook", bytes.NewReader(payload))
+	req.Header.Set(payments.SignatureHeader, payments.Sign("s3cret", payload, time.Now()))
+	rr := httptest.NewRecorder()
+
+	routes(app).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected the signed event to be accepted, got %d %q", rr.Code, rr.Body.String())
+	}
+}
@@ -1,56 +1,80 @@
 package main
 
 import (
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 	"strings"
 	"time"
 
+	"github.com/florian-lahitte-uvi/bookings/internal/handlers"
 	"github.com/florian-lahitte-uvi/bookings/internal/models"
 	mail "github.com/xhit/go-simple-mail"
 )
 
-func listenForMail() {
+// listenForMail sends the mail queued by app until app.MailChan is closed. The
+// returned channel is closed once the queue has been drained
+func listenForMail(app *handlers.Application) <-chan struct{} {
+	done := make(chan struct{})
 	go func() {
-		for {
-			msg := <-app.MailChan
-			sendMsg(msg)
+		defer close(done)
+		for msg := range app.MailChan {
+			sendMsg(app, msg)
 		}
 	}()
+	return done
 }
 
-func sendMsg(m models.MailData) {
+// sendMsg sends m through the SMTP server of the config of app
+func sendMsg(app *handlers.Application, m models.MailData) {
+	log := app.App.Logger.With("component", "mail", "request_id", m.RequestID, "to", m.To)
+
 	server := mail.NewSMTPClient()
-	server.Host = "localhost"
-	server.Port = 1025
+	server.Host = app.App.SMTPHost
+	server.Port = app.App.SMTPPort
 	server.KeepAlive = false
 	server.ConnectTimeout = 10 * time.Second
 
 	client, err := server.Connect()
 	if err != nil {
-		errorLog.Println(err)
+		log.Error("cannot connect to smtp server", "error", err)
+		app.Metrics.MailFailed.Inc()
 		return
 	}
 
 	email := mail.NewMSG()
 	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
+	if m.ReplyTo != "" {
+		email.SetReplyTo(m.ReplyTo)
+	}
 	if m.Template == "" {
 		email.SetBody(mail.TextHTML, m.Content)
 	} else {
 		data, err := ioutil.ReadFile(fmt.Sprintf("emailTemplate/%s.html", m.Template))
 		if err != nil {
-			errorLog.Println(err)
+			log.Error("cannot read mail template", "template", m.Template, "error", err)
+			app.Metrics.MailFailed.Inc()
 			return
 		}
 		mailTemplate := string(data)
 		msgTosend := strings.Replace(mailTemplate, "[%body%]", m.Content, 1)
 		email.SetBody(mail.TextHTML, msgTosend)
 	}
+	for _, a := range m.Attachments {
+		email.AddAttachmentBase64(base64.StdEncoding.EncodeToString(a.Data), a.Name)
+	}
+	if email.Error != nil {
+		log.Error("cannot build mail", "error", email.Error)
+		app.Metrics.MailFailed.Inc()
+		return
+	}
 	err = email.Send(client)
 	if err != nil {
-		errorLog.Println(err)
+		log.Error("cannot send mail", "error", err)
+		app.Metrics.MailFailed.Inc()
 		return
 	} else {
-		infoLog.Println("Mail sent!")
+		log.Info("mail sent")
+		app.Metrics.MailSent.Inc()
 	}
 }
@@ -3,11 +3,19 @@ package main
 import (
 	"os"
 	"testing"
-)
 
-func TestMain(m *testing.M) {
+	"github.com/alexedwards/scs/v2"
+	"github.com/florian-lahitte-uvi/bookings/internal/config"
+	"github.com/florian-lahitte-uvi/bookings/internal/handlers"
+	"github.com/florian-lahitte-uvi/bookings/internal/logger"
+)
 
-	os.Exit(m.Run())
+// newTestApplication returns an application of its own for a test to build the
+// routes and middleware on
+func newTestApplication(t *testing.T) *handlers.Application {
+	t.Helper()
+	cfg := config.AppConfig{Logger: logger.New(os.Stdout, "text", "info")}
+	return handlers.NewTestApplication(&cfg, scs.New(), nil)
 }
 
 type myHandler struct{}
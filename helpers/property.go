@@ -0,0 +1,46 @@
+package helpers
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+)
+
+type propertyKey struct{}
+
+// PropertyContext is the property a request is for
+type PropertyContext struct {
+	Property models.Property
+	// BasePath prefixes the public pages of the property, such as /p/harbour,
+	// it is empty when the property is served on its own hostname
+	BasePath string
+	// Choices are the properties the logged in staff member can switch to
+	Choices []models.Property
+}
+
+// WithProperty returns a copy of ctx carrying the property of the request
+func WithProperty(ctx context.Context, pc PropertyContext) context.Context {
+	return context.WithValue(ctx, propertyKey{}, pc)
+}
+
+// PropertyFromContext returns the property of the request, and whether one was set
+func PropertyFromContext(ctx context.Context) (PropertyContext, bool) {
+	pc, ok := ctx.Value(propertyKey{}).(PropertyContext)
+	return pc, ok
+}
+
+// PropertyID returns the id of the property of the request, the default property
+// for requests no property was resolved for
+func PropertyID(r *http.Request) int {
+	if pc, ok := PropertyFromContext(r.Context()); ok && pc.Property.ID > 0 {
+		return pc.Property.ID
+	}
+	return models.DefaultPropertyID
+}
+
+// SitePath returns path on the public site of the property of the request
+func SitePath(r *http.Request, path string) string {
+	pc, _ := PropertyFromContext(r.Context())
+	return pc.BasePath + path
+}
@@ -2,19 +2,39 @@ package config
 
 import (
 	"html/template"
-	"log"
+	"log/slog"
+	"time"
 
-	"github.com/alexedwards/scs/v2"
-	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/florian-lahitte-uvi/bookings/internal/payments"
 )
 
 // AppConfig holds the application config
 type AppConfig struct {
-	UseCache      bool
-	TemplateCache map[string]*template.Template
-	InfoLog       *log.Logger
-	ErrorLog      *log.Logger
-	InProduction  bool
-	Session       *scs.SessionManager
-	MailChan      chan models.MailData
+	UseCache        bool
+	TemplateCache   map[string]*template.Template
+	Logger          *slog.Logger
+	InProduction    bool
+	Addr            string
+	MetricsAddr     string
+	ShutdownTimeout time.Duration
+	// QueryTimeout is the default timeout of the database queries of a repository call
+	QueryTimeout time.Duration
+	SMTPHost     string
+	SMTPPort     int
+	// MailReplyDomain and MailInboundSecret thread guest replies, see MailSettings
+	MailReplyDomain   string
+	MailInboundSecret string
+	// Payments takes card payments, nil when bookings are not paid online
+	Payments       payments.PaymentProvider
+	Currency       string
+	DepositPercent int
+	PaymentHold    time.Duration
+	// RoomHold is how long a room stays held while a guest fills in the form
+	RoomHold time.Duration
+	// FlexibleDays is how far either way a search finding no room suggests other dates
+	FlexibleDays int
+	// BaseURL is where guests reach the site, without a trailing slash
+	BaseURL        string
+	InvoiceIssuer  string
+	InvoiceAddress string
 }
@@ -0,0 +1,475 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/payments"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is prepended to the upper-cased flag name to get its environment variable
+const envPrefix = "BOOKINGS_"
+
+// redacted replaces secrets in printed configuration
+const redacted = "********"
+
+// maxFlexibleDays caps flexible_days, as searches look that far either side of a stay
+const maxFlexibleDays = 30
+
+// Settings holds everything that can be configured from the config file, the
+// environment or the command line
+type Settings struct {
+	Addr            string        `yaml:"addr"`
+	MetricsAddr     string        `yaml:"metrics_addr"`
+	InProduction    bool          `yaml:"production"`
+	UseCache        bool          `yaml:"cache"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	SessionLifetime time.Duration `yaml:"session_lifetime"`
+	// RoomHold is how long a room picked by a guest is kept for them while they
+	// fill in the reservation form
+	RoomHold time.Duration `yaml:"room_hold"`
+	// FlexibleDays is how many days earlier or later a search finding no room
+	// suggests the same stay, 0 for none
+	FlexibleDays int             `yaml:"flexible_days"`
+	Log          LogSettings     `yaml:"log"`
+	DB           DBSettings      `yaml:"database"`
+	Mail         MailSettings    `yaml:"mail"`
+	Payments     PaymentSettings `yaml:"payments"`
+	Invoice      InvoiceSettings `yaml:"invoice"`
+	// BaseURL is where guests reach the site, for the links in emails
+	BaseURL string `yaml:"base_url"`
+	// Args holds what follows the flags, such as a subcommand and its arguments
+	Args []string `yaml:"-"`
+}
+
+// LogSettings configures the structured logger
+type LogSettings struct {
+	Format string `yaml:"format"`
+	Level  string `yaml:"level"`
+}
+
+// DBSettings configures the database connection and pool
+type DBSettings struct {
+	DSN          string        `yaml:"dsn"`
+	Host         string        `yaml:"host"`
+	Port         string        `yaml:"port"`
+	Name         string        `yaml:"name"`
+	User         string        `yaml:"user"`
+	Password     string        `yaml:"password"`
+	PasswordFile string        `yaml:"password_file"`
+	SSLMode      string        `yaml:"sslmode"`
+	MaxOpenConns int           `yaml:"max_open_conns"`
+	MaxIdleConns int           `yaml:"max_idle_conns"`
+	MaxLifetime  time.Duration `yaml:"max_lifetime"`
+	// QueryTimeout bounds the queries of a repository call, within the request deadline
+	QueryTimeout time.Duration `yaml:"query_timeout"`
+}
+
+// MailSettings configures the SMTP transport
+type MailSettings struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	// ReplyDomain receives guest replies at reply+<token>@ReplyDomain, empty to not
+	// set a Reply-To on guest messages
+	ReplyDomain string `yaml:"reply_domain"`
+	// InboundSecret authenticates replies posted to /mail/inbound, empty to disable it
+	InboundSecret string `yaml:"inbound_secret"`
+}
+
+// PaymentSettings configures card payments and the holds on bookings awaiting them
+type PaymentSettings struct {
+	// Provider takes card payments, fake for the in-memory provider or empty for none
+	Provider      string `yaml:"provider"`
+	WebhookSecret string `yaml:"webhook_secret"`
+	Currency      string `yaml:"currency"`
+	// DepositPercent is the share of the stay guests pay when booking
+	DepositPercent int `yaml:"deposit_percent"`
+	// HoldTimeout is how long a booking awaiting payment keeps its room
+	HoldTimeout time.Duration `yaml:"hold_timeout"`
+}
+
+// InvoiceSettings configures who the invoices are from
+type InvoiceSettings struct {
+	Issuer string `yaml:"issuer"`
+	// Address is printed under the issuer, one line per line
+	Address string `yaml:"address"`
+}
+
+// NewProvider returns the configured payment provider, nil if there is none
+func (p PaymentSettings) NewProvider() payments.PaymentProvider {
+	switch p.Provider {
+	case "fake":
+		return payments.NewFake(p.WebhookSecret)
+	}
+	return nil
+}
+
+// Defaults returns the settings used when nothing else is configured
+func Defaults() Settings {
+	return Settings{
+		Addr:            ":8080",
+		MetricsAddr:     "localhost:9090",
+		InProduction:    true,
+		UseCache:        true,
+		ShutdownTimeout: 30 * time.Second,
+		SessionLifetime: 24 * time.Hour,
+		RoomHold:        10 * time.Minute,
+		FlexibleDays:    7,
+		Log: LogSettings{
+			Format: "text",
+			Level:  "info",
+		},
+		DB: DBSettings{
+			Host:         "localhost",
+			Port:         "5432",
+			SSLMode:      "disable",
+			MaxOpenConns: 10,
+			MaxIdleConns: 5,
+			MaxLifetime:  5 * time.Minute,
+			QueryTimeout: 3 * time.Second,
+		},
+		Mail: MailSettings{
+			Host: "localhost",
+			Port: 1025,
+		},
+		Payments: PaymentSettings{
+			Currency:       "usd",
+			DepositPercent: 100,
+			HoldTimeout:    30 * time.Minute,
+		},
+		Invoice: InvoiceSettings{
+			Issuer: "Fort Smythe Bed and Breakfast",
+		},
+		BaseURL: "http://localhost:8080",
+	}
+}
+
+// Load builds the settings by layering, from lowest to highest precedence, the
+// defaults, the YAML file named by -config or BOOKINGS_CONFIG, BOOKINGS_*
+// environment variables and the command line flags in args. Arguments after the
+// flags are kept in Args. It reports whether --print-config was requested.
+func Load(args []string) (Settings, bool, error) {
+	s := Defaults()
+
+	fs := flag.NewFlagSet("bookings", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv(envPrefix+"CONFIG"), "Path to a YAML config file")
+	printConfig := fs.Bool("print-config", false, "Print the resolved configuration with secrets redacted and exit")
+	s.bindFlags(fs)
+
+	if err := fs.Parse(args); err != nil {
+		return s, false, err
+	}
+	s.Args = fs.Args()
+
+	// remember what was given on the command line, so it can win over the file and environment
+	explicit := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	// the layer that last set the password and the password file, 0 for the defaults
+	passLayer, passFileLayer := 0, 0
+
+	if *configFile != "" {
+		before := s.DB
+		if err := s.loadFile(*configFile); err != nil {
+			return s, false, err
+		}
+		if s.DB.Password != before.Password {
+			passLayer = 1
+		}
+		if s.DB.PasswordFile != before.PasswordFile {
+			passFileLayer = 1
+		}
+	}
+
+	fromEnv, err := applyEnv(fs)
+	if err != nil {
+		return s, false, err
+	}
+	if fromEnv["dbpass"] {
+		passLayer = 2
+	}
+	if fromEnv["dbpassfile"] {
+		passFileLayer = 2
+	}
+
+	for name, value := range explicit {
+		if err := fs.Set(name, value); err != nil {
+			return s, false, err
+		}
+	}
+
+	if _, given := explicit["dbpass"]; given {
+		passLayer = 3
+	}
+	if _, given := explicit["dbpassfile"]; given {
+		passFileLayer = 3
+	}
+
+	// a password file replaces a password set in the same layer or a lower one,
+	// except that -dbpass wins over -dbpassfile on the command line
+	if s.DB.PasswordFile != "" && passFileLayer >= passLayer && passLayer < 3 {
+		secret, err := readSecret(s.DB.PasswordFile)
+		if err != nil {
+			return s, false, err
+		}
+		s.DB.Password = secret
+	}
+
+	if err := s.Validate(); err != nil {
+		return s, *printConfig, err
+	}
+
+	return s, *printConfig, nil
+}
+
+// bindFlags registers a flag for every setting, defaulting to its current value
+func (s *Settings) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&s.Addr, "addr", s.Addr, "Address of the application listener")
+	fs.StringVar(&s.MetricsAddr, "metricsaddr", s.MetricsAddr, "Address of the Prometheus metrics listener, empty to disable")
+	fs.BoolVar(&s.InProduction, "production", s.InProduction, "Application in production mode")
+	fs.BoolVar(&s.UseCache, "cache", s.UseCache, "Use cache")
+	fs.DurationVar(&s.ShutdownTimeout, "shutdowntimeout", s.ShutdownTimeout, "Time allowed to drain requests and mail on shutdown")
+	fs.DurationVar(&s.SessionLifetime, "sessionlifetime", s.SessionLifetime, "Lifetime of a session")
+	fs.DurationVar(&s.RoomHold, "roomhold", s.RoomHold, "How long a room is held for a guest filling in the reservation form")
+	fs.IntVar(&s.FlexibleDays, "flexibledays", s.FlexibleDays, "Days earlier or later to suggest when a search finds no room, 0 for none")
+	fs.StringVar(&s.BaseURL, "baseurl", s.BaseURL, "URL guests reach the site at, for the links in emails")
+
+	fs.StringVar(&s.Log.Format, "logformat", s.Log.Format, "Log format (text or json)")
+	fs.StringVar(&s.Log.Level, "loglevel", s.Log.Level, "Log level (debug, info, warn or error)")
+
+	fs.StringVar(&s.DB.DSN, "dsn", s.DB.DSN, "Database DSN or postgres:// URL, overrides the other database settings")
+	fs.StringVar(&s.DB.Host, "dbhost", s.DB.Host, "Database host")
+	fs.StringVar(&s.DB.Port, "dbport", s.DB.Port, "Database port")
+	fs.StringVar(&s.DB.Name, "dbname", s.DB.Name, "Database name")
+	fs.StringVar(&s.DB.User, "dbuser", s.DB.User, "Database user")
+	fs.StringVar(&s.DB.Password, "dbpass", s.DB.Password, "Database password")
+	fs.StringVar(&s.DB.PasswordFile, "dbpassfile", s.DB.PasswordFile, "File containing the database password")
+	fs.StringVar(&s.DB.SSLMode, "dbssl", s.DB.SSLMode, "Database SSL mode")
+	fs.IntVar(&s.DB.MaxOpenConns, "dbmaxopen", s.DB.MaxOpenConns, "Maximum open database connections")
+	fs.IntVar(&s.DB.MaxIdleConns, "dbmaxidle", s.DB.MaxIdleConns, "Maximum idle database connections")
+	fs.DurationVar(&s.DB.MaxLifetime, "dbmaxlifetime", s.DB.MaxLifetime, "Maximum lifetime of a database connection")
+	fs.DurationVar(&s.DB.QueryTimeout, "dbquerytimeout", s.DB.QueryTimeout, "Default timeout of the database queries of a request")
+
+	fs.StringVar(&s.Mail.Host, "smtphost", s.Mail.Host, "SMTP host")
+	fs.IntVar(&s.Mail.Port, "smtpport", s.Mail.Port, "SMTP port")
+	fs.StringVar(&s.Mail.ReplyDomain, "mailreplydomain", s.Mail.ReplyDomain, "Domain receiving guest replies, empty for no Reply-To")
+	fs.StringVar(&s.Mail.InboundSecret, "mailinboundsecret", s.Mail.InboundSecret, "Secret required to post guest replies to /mail/inbound, empty to disable")
+
+	fs.StringVar(&s.Payments.Provider, "paymentprovider", s.Payments.Provider, "Card payment provider (fake), empty to take no payments online")
+	fs.StringVar(&s.Payments.WebhookSecret, "paymentwebhooksecret", s.Payments.WebhookSecret, "Secret signing the payment provider's webhook calls")
+	fs.StringVar(&s.Payments.Currency, "currency", s.Payments.Currency, "Currency of prices and payments, as an ISO 4217 code")
+	fs.IntVar(&s.Payments.DepositPercent, "depositpercent", s.Payments.DepositPercent, "Percentage of the stay paid online when booking")
+	fs.DurationVar(&s.Payments.HoldTimeout, "paymenthold", s.Payments.HoldTimeout, "Time a booking awaiting payment keeps its room")
+
+	fs.StringVar(&s.Invoice.Issuer, "invoiceissuer", s.Invoice.Issuer, "Name invoices are issued by")
+	fs.StringVar(&s.Invoice.Address, "invoiceaddress", s.Invoice.Address, "Address printed on invoices, one line per line")
+}
+
+// loadFile merges the YAML file at path into s
+func (s *Settings) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read config file: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, s); err != nil {
+		return fmt.Errorf("cannot parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv sets every flag that has a matching BOOKINGS_* variable. A
+// BOOKINGS_*_FILE variable reads the value from a file, for mounted secrets.
+// It returns the names of the flags it set.
+func applyEnv(fs *flag.FlagSet) (map[string]bool, error) {
+	set := make(map[string]bool)
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || f.Name == "config" || f.Name == "print-config" {
+			return
+		}
+
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		value, ok := os.LookupEnv(name)
+		if file, fromFile := os.LookupEnv(name + "_FILE"); fromFile {
+			value, err = readSecret(file)
+			if err != nil {
+				return
+			}
+			ok = true
+		}
+
+		if ok {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("invalid value for %s: %w", name, setErr)
+				return
+			}
+			set[f.Name] = true
+		}
+	})
+	return set, err
+}
+
+// readSecret returns the trimmed contents of a secret file
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot read secret file: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Validate checks the settings are complete and consistent
+func (s Settings) Validate() error {
+	var errs []error
+
+	if s.Addr == "" {
+		errs = append(errs, errors.New("addr must be set"))
+	}
+	if s.DB.DSN == "" && (s.DB.Name == "" || s.DB.User == "" || s.DB.Password == "") {
+		errs = append(errs, errors.New("database name, user, and password must be provided, or a dsn"))
+	}
+	if s.DB.MaxOpenConns < 1 {
+		errs = append(errs, errors.New("database max_open_conns must be at least 1"))
+	}
+	if s.DB.MaxIdleConns < 0 || s.DB.MaxIdleConns > s.DB.MaxOpenConns {
+		errs = append(errs, errors.New("database max_idle_conns must be between 0 and max_open_conns"))
+	}
+	if s.DB.MaxLifetime <= 0 {
+		errs = append(errs, errors.New("database max_lifetime must be positive"))
+	}
+	if s.DB.QueryTimeout <= 0 {
+		errs = append(errs, errors.New("database query_timeout must be positive"))
+	}
+	if s.Mail.Host == "" {
+		errs = append(errs, errors.New("mail host must be set"))
+	}
+	if s.Mail.Port < 1 || s.Mail.Port > 65535 {
+		errs = append(errs, errors.New("mail port must be between 1 and 65535"))
+	}
+	if s.SessionLifetime <= 0 {
+		errs = append(errs, errors.New("session_lifetime must be positive"))
+	}
+	if s.RoomHold <= 0 {
+		errs = append(errs, errors.New("room_hold must be positive"))
+	}
+	if s.FlexibleDays < 0 || s.FlexibleDays > maxFlexibleDays {
+		errs = append(errs, fmt.Errorf("flexible_days must be between 0 and %d", maxFlexibleDays))
+	}
+	if s.ShutdownTimeout <= 0 {
+		errs = append(errs, errors.New("shutdown_timeout must be positive"))
+	}
+	if f := strings.ToLower(s.Log.Format); f != "text" && f != "json" {
+		errs = append(errs, fmt.Errorf("log format must be text or json, not %q", s.Log.Format))
+	}
+	switch s.Payments.Provider {
+	case "":
+	case "fake":
+		if s.InProduction {
+			errs = append(errs, errors.New("the fake payment provider can't be used in production"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown payment provider %q", s.Payments.Provider))
+	}
+	if s.Payments.Provider != "" && s.Payments.WebhookSecret == "" {
+		errs = append(errs, errors.New("payments webhook_secret must be set with a provider"))
+	}
+	if len(s.Payments.Currency) != 3 {
+		errs = append(errs, fmt.Errorf("payments currency must be a 3 letter code, not %q", s.Payments.Currency))
+	}
+	if s.Payments.DepositPercent < 1 || s.Payments.DepositPercent > 100 {
+		errs = append(errs, errors.New("payments deposit_percent must be between 1 and 100"))
+	}
+	if s.Payments.HoldTimeout <= 0 {
+		errs = append(errs, errors.New("payments hold_timeout must be positive"))
+	}
+	if u, err := url.Parse(s.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("base_url must be an http or https URL, not %q", s.BaseURL))
+	}
+	if strings.TrimSpace(s.Invoice.Issuer) == "" {
+		errs = append(errs, errors.New("invoice issuer must be set"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// ConnectionString returns the DSN to open the database with
+func (s Settings) ConnectionString() string {
+	if s.DB.DSN != "" {
+		return s.DB.DSN
+	}
+	return fmt.Sprintf("user=%s password=%s dbname=%s port=%s host=%s sslmode=%s",
+		s.DB.User, s.DB.Password, s.DB.Name, s.DB.Port, s.DB.Host, s.DB.SSLMode)
+}
+
+// Apply copies the settings used at runtime into app
+func (s Settings) Apply(app *AppConfig) {
+	app.Addr = s.Addr
+	app.InProduction = s.InProduction
+	app.UseCache = s.UseCache
+	app.MetricsAddr = s.MetricsAddr
+	app.ShutdownTimeout = s.ShutdownTimeout
+	app.QueryTimeout = s.DB.QueryTimeout
+	app.SMTPHost = s.Mail.Host
+	app.SMTPPort = s.Mail.Port
+	app.MailReplyDomain = s.Mail.ReplyDomain
+	app.MailInboundSecret = s.Mail.InboundSecret
+	app.Payments = s.Payments.NewProvider()
+	app.Currency = strings.ToLower(s.Payments.Currency)
+	app.DepositPercent = s.Payments.DepositPercent
+	app.PaymentHold = s.Payments.HoldTimeout
+	app.RoomHold = s.RoomHold
+	app.FlexibleDays = s.FlexibleDays
+	app.BaseURL = strings.TrimSuffix(s.BaseURL, "/")
+	app.InvoiceIssuer = s.Invoice.Issuer
+	app.InvoiceAddress = s.Invoice.Address
+}
+
+// dsnPassword matches the password of a keyword DSN, which may be quoted to hold spaces
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// Redacted returns a copy of s with every secret masked
+func (s Settings) Redacted() Settings {
+	if s.DB.Password != "" {
+		s.DB.Password = redacted
+	}
+	if s.Mail.InboundSecret != "" {
+		s.Mail.InboundSecret = redacted
+	}
+	if s.Payments.WebhookSecret != "" {
+		s.Payments.WebhookSecret = redacted
+	}
+
+	if u, err := url.Parse(s.DB.DSN); err == nil && u.User != nil {
+		if pw, ok := u.User.Password(); ok {
+			masked := strings.Replace(s.DB.DSN, ":"+pw+"@", ":"+redacted+"@", 1)
+			if masked == s.DB.DSN {
+				// the password was escaped in the URL, let net/url mask it
+				masked = u.Redacted()
+			}
+			s.DB.DSN = masked
+		}
+	} else {
+		s.DB.DSN = dsnPassword.ReplaceAllString(s.DB.DSN, "${1}"+redacted)
+	}
+
+	return s
+}
+
+// Print writes the settings as YAML with secrets redacted
+func (s Settings) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	defer enc.Close()
+	return enc.Encode(s.Redacted())
+}
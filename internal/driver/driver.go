@@ -16,20 +16,23 @@ type DB struct {
 
 var dbConn = &DB{}
 
-const maxOpenDbConn = 10
-const maxIdleDbConn = 5
-const maxDbLifetime = 5 * time.Minute
+// PoolOptions sizes the database connection pool
+type PoolOptions struct {
+	MaxOpenConns int
+	MaxIdleConns int
+	MaxLifetime  time.Duration
+}
 
 // ConnectSQL creates database pool for Postgres
-func ConnectSQL(dsn string) (*DB, error) {
+func ConnectSQL(dsn string, opts PoolOptions) (*DB, error) {
 	d, err := NewDatabase(dsn)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	d.SetMaxOpenConns(maxOpenDbConn)
-	d.SetMaxIdleConns(maxIdleDbConn)
-	d.SetConnMaxLifetime(maxDbLifetime)
+	d.SetMaxOpenConns(opts.MaxOpenConns)
+	d.SetMaxIdleConns(opts.MaxIdleConns)
+	d.SetConnMaxLifetime(opts.MaxLifetime)
 
 	dbConn.SQL = d
 
@@ -0,0 +1,43 @@
+package export
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+)
+
+// formulaPrefixes are the characters that make spreadsheets read a cell as a formula
+const formulaPrefixes = "=+-@\t\r"
+
+type csvWriter struct {
+	w      *csv.Writer
+	record []string
+}
+
+// NewCSV returns a Writer producing comma separated values
+func NewCSV(w io.Writer) Writer {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (c *csvWriter) WriteRow(cells ...interface{}) error {
+	c.record = c.record[:0]
+	for _, cell := range cells {
+		c.record = append(c.record, csvCell(cell))
+	}
+	return c.w.Write(c.record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// csvCell renders a cell, quoting text that starts like a formula so that values
+// entered by guests are shown rather than run when the file is opened
+func csvCell(c interface{}) string {
+	s := formatCell(c)
+	if _, text := c.(string); text && s != "" && strings.ContainsRune(formulaPrefixes, rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,67 @@
+// Package export writes tabular reports as CSV or XLSX, one row at a time, so
+// large exports can be streamed straight to the client
+package export
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Supported formats
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+// ErrUnknownFormat is returned by New for an unsupported format
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// Writer writes the rows of a single table. Cells may be strings, ints,
+// float64, or time.Time values, which are written as dates.
+type Writer interface {
+	WriteRow(cells ...interface{}) error
+	// Close flushes the remaining output. It does not close the underlying writer.
+	Close() error
+}
+
+// New returns a Writer for format writing to w
+func New(format string, w io.Writer, sheet string) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return NewCSV(w), nil
+	case FormatXLSX:
+		return NewXLSX(w, sheet)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// ContentType returns the MIME type of format
+func ContentType(format string) string {
+	if format == FormatXLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// formatCell renders a cell as text
+func formatCell(c interface{}) string {
+	switch v := c.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format("2006-01-02")
+	}
+	return fmt.Sprint(c)
+}
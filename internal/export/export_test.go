@@ -0,0 +1,125 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSV(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := New(FormatCSV, &buf, "Reservations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteRow("ID", "Name", "Arrival", "Rate")
+	w.WriteRow(1, "Smith, John", time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), 0.5)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "ID,Name,Arrival,Rate\n1,\"Smith, John\",2050-01-01,0.5\n"
+	if buf.String() != expected {
+		t.Errorf("got %q, wanted %q", buf.String(), expected)
+	}
+}
+
+func TestCSVFormulas(t *testing.T) {
+	var tests = []struct {
+		name     string
+		cell     interface{}
+		expected string
+	}{
+		{"equals", "=1+2", "'=1+2"},
+		{"plus", "+33 6 12 34 56 78", "'+33 6 12 34 56 78"},
+		{"minus", "-2+3", "'-2+3"},
+		{"at", "@SUM(A1)", "'@SUM(A1)"},
+		{"tab", "\t=1", "'\t=1"},
+		{"carriage-return", "\r=1", "\"'\r=1\""},
+		{"text", "Smith", "Smith"},
+		{"negative-number", -5, "-5"},
+		{"empty", "", ""},
+	}
+
+	for _, e := range tests {
+		var buf bytes.Buffer
+		w := NewCSV(&buf)
+		w.WriteRow(e.cell)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSuffix(buf.String(), "\n"); got != e.expected {
+			t.Errorf("%s: got %q, wanted %q", e.name, got, e.expected)
+		}
+	}
+}
+
+func TestXLSX(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := New(FormatXLSX, &buf, "Reservations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.WriteRow("ID", "Name", "Arrival")
+	w.WriteRow(1, "Smith & <Sons>", time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("not a zip archive: %s", err)
+	}
+
+	var sheet string
+	for _, f := range z.File {
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			rc, _ := f.Open()
+			data, _ := io.ReadAll(rc)
+			rc.Close()
+			sheet = string(data)
+		}
+	}
+
+	for _, want := range []string{
+		`<c r="A2"><v>1</v></c>`,
+		`Smith &amp; &lt;Sons&gt;`,
+		`<c r="C2" s="1"><v>54789</v></c>`,
+		`</sheetData></worksheet>`,
+	} {
+		if !strings.Contains(sheet, want) {
+			t.Errorf("expected sheet to contain %q", want)
+		}
+	}
+}
+
+func TestNewUnknownFormat(t *testing.T) {
+	if _, err := New("pdf", io.Discard, "x"); err != ErrUnknownFormat {
+		t.Errorf("expected ErrUnknownFormat, got %v", err)
+	}
+}
+
+var columnNameTests = []struct {
+	index    int
+	expected string
+}{
+	{0, "A"},
+	{25, "Z"},
+	{26, "AA"},
+	{27, "AB"},
+	{701, "ZZ"},
+	{702, "AAA"},
+}
+
+func TestColumnName(t *testing.T) {
+	for _, e := range columnNameTests {
+		if got := columnName(e.index); got != e.expected {
+			t.Errorf("column %d: got %s, wanted %s", e.index, got, e.expected)
+		}
+	}
+}
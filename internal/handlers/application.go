@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"net/http"
+	"runtime/debug"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/florian-lahitte-uvi/bookings/internal/config"
+	"github.com/florian-lahitte-uvi/bookings/internal/driver"
+	"github.com/florian-lahitte-uvi/bookings/internal/metrics"
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/florian-lahitte-uvi/bookings/internal/render"
+	"github.com/florian-lahitte-uvi/bookings/internal/repository"
+	"github.com/florian-lahitte-uvi/bookings/internal/repository/dbrepo"
+)
+
+// Application holds what the handlers need to serve a site: its config, database,
+// renderer, session, mail queue and metrics. Each instance is independent, so several can be
+// mounted in one process.
+type Application struct {
+	App     *config.AppConfig
+	DB      repository.DatabaseRepo
+	Render  *render.Renderer
+	Session *scs.SessionManager
+	// MailChan queues the mail the handlers send
+	MailChan chan models.MailData
+	// Metrics records the requests, queries and mail of the application
+	Metrics *metrics.Metrics
+}
+
+// NewApplication creates an application backed by the database
+func NewApplication(a *config.AppConfig, db *driver.DB, session *scs.SessionManager, mailChan chan models.MailData) *Application {
+	mx := metrics.New()
+	return &Application{
+		App:      a,
+		DB:       mx.InstrumentRepo(dbrepo.NewPostgresRepo(db.SQL, a)),
+		Render:   render.New(a, session),
+		Session:  session,
+		MailChan: mailChan,
+		Metrics:  mx,
+	}
+}
+
+// NewTestApplication creates an application backed by the test repository
+func NewTestApplication(a *config.AppConfig, session *scs.SessionManager, mailChan chan models.MailData) *Application {
+	return &Application{
+		App:      a,
+		DB:       dbrepo.NewTestingRepo(a),
+		Render:   render.New(a, session),
+		Session:  session,
+		MailChan: mailChan,
+		Metrics:  metrics.New(),
+	}
+}
+
+// IsAuthenticated reports whether a user is logged in
+func (m *Application) IsAuthenticated(r *http.Request) bool {
+	return m.Session.Exists(r.Context(), "user_id")
+}
+
+// clientError logs and answers a request the client got wrong
+func (m *Application) clientError(w http.ResponseWriter, r *http.Request, status int) {
+	m.App.Logger.InfoContext(r.Context(), "client error", "status", status)
+	http.Error(w, http.StatusText(status), status)
+}
+
+// serverError logs err with the stack and answers with an internal server error
+func (m *Application) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	m.App.Logger.ErrorContext(r.Context(), err.Error(), "trace", string(debug.Stack()))
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+)
+
+// maxAvailabilityNights caps the nights asked of the availability calendar, a year
+const maxAvailabilityNights = 366
+
+type availabilityNight struct {
+	Date string `json:"date"`
+	// Available tells whether a stay can include the night, and Units how many units
+	// of the room are free on it
+	Available bool `json:"available"`
+	Units     int  `json:"units"`
+	// Price is the price of the night, in cents
+	Price int `json:"price"`
+}
+
+type availabilityCalendarResponse struct {
+	OK        bool                `json:"ok"`
+	Message   string              `json:"message,omitempty"`
+	RoomID    int                 `json:"room_id,omitempty"`
+	RoomName  string              `json:"room_name,omitempty"`
+	MinNights int                 `json:"min_nights,omitempty"`
+	Currency  string              `json:"currency,omitempty"`
+	From      string              `json:"from,omitempty"`
+	To        string              `json:"to,omitempty"`
+	Nights    []availabilityNight `json:"nights"`
+}
+
+// AvailabilityCalendar returns whether each night of a room from from up to to, the
+// day after the last night, can be booked and at what price, with the shortest stay
+// the room is booked for. Nights before today can't be booked.
+func (m *Application) AvailabilityCalendar(w http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(r.URL.Query().Get("room_id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, availabilityCalendarResponse{Message: "room_id must be a number"})
+		return
+	}
+	from, err := time.Parse("2006-01-02", r.URL.Query().Get("from"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, availabilityCalendarResponse{Message: "from must be a date"})
+		return
+	}
+	to, err := time.Parse("2006-01-02", r.URL.Query().Get("to"))
+	if err != nil || !to.After(from) {
+		writeJSON(w, http.StatusBadRequest, availabilityCalendarResponse{Message: "to must be a date after from"})
+		return
+	}
+	if to.Sub(from) > maxAvailabilityNights*24*time.Hour {
+		writeJSON(w, http.StatusBadRequest, availabilityCalendarResponse{Message: fmt.Sprintf("the calendar covers at most %d nights", maxAvailabilityNights)})
+		return
+	}
+
+	room, err := m.db(r).GetRoomByID(r.Context(), roomID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, availabilityCalendarResponse{Message: "Room not found"})
+		return
+	}
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "error getting room", "room_id", roomID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, availabilityCalendarResponse{Message: "Error querying database"})
+		return
+	}
+
+	nights, err := m.db(r).RoomAvailability(r.Context(), roomID, from, to)
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "error getting room availability", "room_id", roomID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, availabilityCalendarResponse{Message: "Error querying database"})
+		return
+	}
+
+	resp := availabilityCalendarResponse{
+		OK:        true,
+		RoomID:    room.ID,
+		RoomName:  room.RoomName,
+		MinNights: max(room.MinNights, 1),
+		Currency:  m.App.Currency,
+		From:      from.Format("2006-01-02"),
+		To:        to.Format("2006-01-02"),
+		Nights:    []availabilityNight{},
+	}
+	first := today()
+	for _, n := range nights {
+		resp.Nights = append(resp.Nights, availabilityNight{
+			Date:      n.Date.Format("2006-01-02"),
+			Available: n.Free > 0 && !n.Date.Before(first),
+			Units:     n.Free,
+			Price:     n.Price,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// staySuggestions are the stays offered when no room is free for the dates searched
+type staySuggestions struct {
+	Flexible []models.FlexibleStay
+	Split    []models.StayOption
+}
+
+// suggestible reports whether stays are suggested around the stay from start to end,
+// which must not be longer than the availability calendar allows
+func suggestible(start, end time.Time) bool {
+	return end.After(start) && end.Sub(start) <= maxAvailabilityNights*24*time.Hour
+}
+
+// suggestStays finds the stay from start to end moved by up to m.App.FlexibleDays
+// either way, and split across rooms, from the free nights of every room around it
+func (m *Application) suggestStays(r *http.Request, start, end time.Time) (staySuggestions, error) {
+	days := m.App.FlexibleDays
+	from, to := start.AddDate(0, 0, -days), end.AddDate(0, 0, days)
+
+	rooms, err := m.db(r).AllRooms(r.Context())
+	if err != nil {
+		return staySuggestions{}, err
+	}
+	free, err := m.db(r).FreeUnitNights(r.Context(), from, to)
+	if err != nil {
+		return staySuggestions{}, err
+	}
+
+	grid := models.NewAvailabilityGrid(from, to, rooms, free)
+	return staySuggestions{
+		Flexible: grid.FlexibleStays(start, end, days, today()),
+		Split:    grid.SplitStay(start, end),
+	}, nil
+}
+
+// stayJSON is a stay suggested in the JSON API
+type stayJSON struct {
+	RoomID    int    `json:"room_id"`
+	RoomName  string `json:"room_name"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
+func stayToJSON(s models.StayOption) stayJSON {
+	return stayJSON{
+		RoomID:    s.Room.ID,
+		RoomName:  s.Room.RoomName,
+		StartDate: s.StartDate.Format("2006-01-02"),
+		EndDate:   s.EndDate.Format("2006-01-02"),
+	}
+}
+
+// alternativesJSON returns the other dates the room is free on among the suggestions,
+// and the split stay if any
+func (s staySuggestions) alternativesJSON(roomID int) ([]stayJSON, []stayJSON) {
+	var alternatives, split []stayJSON
+	for _, f := range s.Flexible {
+		for _, room := range f.Rooms {
+			if room.ID == roomID {
+				alternatives = append(alternatives, stayToJSON(models.StayOption{Room: room, StartDate: f.StartDate, EndDate: f.EndDate}))
+			}
+		}
+	}
+	for _, part := range s.Split {
+		split = append(split, stayToJSON(part))
+	}
+	return alternatives, split
+}
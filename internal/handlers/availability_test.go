@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/florian-lahitte-uvi/bookings/helpers"
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+)
+
+var availabilityCalendarTests = []struct {
+	name               string
+	query              string
+	property           int
+	expectedStatusCode int
+	expectedNights     int
+}{
+	{"three-nights", "room_id=1&from=2040-01-01&to=2040-01-04", 1, http.StatusOK, 3},
+	{"missing-room", "from=2040-01-01&to=2040-01-04", 1, http.StatusBadRequest, 0},
+	{"missing-from", "room_id=1&to=2040-01-04", 1, http.StatusBadRequest, 0},
+	{"to-before-from", "room_id=1&from=2040-01-04&to=2040-01-01", 1, http.StatusBadRequest, 0},
+	{"too-long", "room_id=1&from=2040-01-01&to=2041-01-03", 1, http.StatusBadRequest, 0},
+	{"other-property", "room_id=1&from=2040-01-01&to=2040-01-04", 2, http.StatusNotFound, 0},
+	{"room-error", "room_id=3&from=2040-01-01&to=2040-01-04", 1, http.StatusInternalServerError, 0},
+	{"database-error", "room_id=1&from=2060-01-01&to=2060-01-04", 1, http.StatusInternalServerError, 0},
+}
+
+// TestAvailabilityCalendar tests the nights of a room guests can book
+func TestAvailabilityCalendar(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	properties, _ := repo.DB.UserProperties(context.Background(), 1)
+
+	for _, e := range availabilityCalendarTests {
+		req, _ := http.NewRequest("GET", "/api/availability/calendar?"+e.query, nil)
+		ctx := helpers.WithProperty(getCtx(req), helpers.PropertyContext{Property: properties[e.property-1]})
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.AvailabilityCalendar)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		var resp availabilityCalendarResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: failed to parse json", e.name)
+		}
+		if len(resp.Nights) != e.expectedNights {
+			t.Fatalf("%s: expected %d nights, got %d", e.name, e.expectedNights, len(resp.Nights))
+		}
+		if e.expectedNights == 0 {
+			if resp.OK || resp.Message == "" {
+				t.Errorf("%s: expected an error message, got %+v", e.name, resp)
+			}
+			continue
+		}
+
+		if !resp.OK || resp.RoomID != 1 || resp.MinNights != 1 || resp.Currency != "usd" {
+			t.Errorf("%s: unexpected room %+v", e.name, resp)
+		}
+		// the second night is taken
+		expected := []availabilityNight{
+			{Date: "2040-01-01", Available: true, Units: 1, Price: 10000},
+			{Date: "2040-01-02", Available: false, Units: 0, Price: 10000},
+			{Date: "2040-01-03", Available: true, Units: 1, Price: 10000},
+		}
+		for i, n := range resp.Nights {
+			if n != expected[i] {
+				t.Errorf("%s: expected night %+v, got %+v", e.name, expected[i], n)
+			}
+		}
+	}
+}
+
+// TestAvailabilityCalendarPast tests that nights gone by can't be booked
+func TestAvailabilityCalendarPast(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	req, _ := http.NewRequest("GET", "/api/availability/calendar?room_id=1&from=2020-01-01&to=2020-01-02", nil)
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(repo.AvailabilityCalendar)
+	handler.ServeHTTP(rr, req)
+
+	var resp availabilityCalendarResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal("failed to parse json")
+	}
+	if len(resp.Nights) != 1 || resp.Nights[0].Available || resp.Nights[0].Units != 1 {
+		t.Errorf("expected a past night with a free unit not to be available, got %+v", resp.Nights)
+	}
+}
+
+// TestPostReservationMinNights tests that a stay shorter than the minimum of its room
+// is refused
+func TestPostReservationMinNights(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	postedData := url.Values{
+		"start_date": {"2040-01-01"},
+		"end_date":   {"2040-01-01"},
+		"first_name": {"John"},
+		"last_name":  {"Smith"},
+		"email":      {"john@smith.com"},
+		"room_id":    {"1"},
+	}
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	session.Put(ctx, "reservation", models.Reservation{RoomID: 1})
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(repo.PostReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "The departure must be after the arrival") {
+		t.Error("expected the minimum stay error")
+	}
+}
+
+var minStayErrorTests = []struct {
+	minNights int
+	expected  string
+}{
+	{1, "The departure must be after the arrival"},
+	{3, "This room is booked for 3 nights or more"},
+}
+
+func TestMinStayError(t *testing.T) {
+	for _, e := range minStayErrorTests {
+		if got := minStayError(e.minNights); got != e.expected {
+			t.Errorf("%d: expected %q, got %q", e.minNights, e.expected, got)
+		}
+	}
+}
+
+// TestAvailabilitySuggestions tests the stays suggested on the search page when no
+// room is free for the dates searched
+func TestAvailabilitySuggestions(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	req, _ := http.NewRequest("GET", "/search-availability?start=2050-01-01&end=2050-01-03", nil)
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(repo.Availability)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	for _, expected := range []string{
+		"Other dates",
+		"/book-room?id=2&s=2049-12-31&e=2050-01-02",
+		"Split your stay",
+		"/book-room?id=2&s=2050-01-01&e=2050-01-02",
+		"/book-room?id=1&s=2050-01-02&e=2050-01-03",
+	} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("expected the page to contain %q", expected)
+		}
+	}
+
+	// no stays are suggested around a search longer than the calendar covers
+	req, _ = http.NewRequest("GET", "/search-availability?start=2050-01-01&end=2052-01-01", nil)
+	req = req.WithContext(getCtx(req))
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if strings.Contains(rr.Body.String(), "Other dates") || strings.Contains(rr.Body.String(), "Split your stay") {
+		t.Error("expected no suggestions for a stay of two years")
+	}
+}
+
+var availabilityJSONSuggestionsTests = []struct {
+	name                 string
+	roomID               int
+	start                string
+	end                  string
+	expectedAlternatives []string
+	expectedSplit        int
+}{
+	{"room-2", 2, "2050-01-01", "2050-01-03", []string{"2049-12-31", "2049-12-30", "2049-12-29", "2050-01-04", "2049-12-28"}, 2},
+	{"room-1", 1, "2050-01-01", "2050-01-03", []string{"2049-12-30", "2049-12-29", "2050-01-04", "2049-12-28"}, 2},
+	{"available", 1, "2040-01-01", "2040-01-03", nil, 0},
+	{"database-error", 1, "2059-12-30", "2060-01-01", nil, 0},
+	{"too-long", 1, "2050-01-01", "2052-01-01", nil, 0},
+}
+
+// TestAvailabilityJSONSuggestions tests the other dates and split stay returned with a
+// room that isn't available
+func TestAvailabilityJSONSuggestions(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	for _, e := range availabilityJSONSuggestionsTests {
+		postedData := url.Values{"start": {e.start}, "end": {e.end}, "room_id": {strconv.Itoa(e.roomID)}}
+		req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.AvailabilityJSON)
+		handler.ServeHTTP(rr, req)
+
+		var j jsonResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &j); err != nil {
+			t.Fatalf("%s: failed to parse json", e.name)
+		}
+
+		var starts []string
+		for _, a := range j.Alternatives {
+			if a.RoomID != e.roomID {
+				t.Errorf("%s: expected other dates for room %d only, got %+v", e.name, e.roomID, a)
+			}
+			starts = append(starts, a.StartDate)
+		}
+		if !slices.Equal(starts, e.expectedAlternatives) {
+			t.Errorf("%s: expected other dates %v, got %v", e.name, e.expectedAlternatives, starts)
+		}
+		if len(j.SplitStay) != e.expectedSplit {
+			t.Errorf("%s: expected a split stay of %d rooms, got %+v", e.name, e.expectedSplit, j.SplitStay)
+		}
+	}
+}
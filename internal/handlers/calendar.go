@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/florian-lahitte-uvi/bookings/internal/repository/dbrepo"
+	"github.com/go-chi/chi"
+)
+
+// Calendar zoom levels, each showing one week, month or quarter
+const (
+	zoomWeek    = "week"
+	zoomMonth   = "month"
+	zoomQuarter = "quarter"
+)
+
+// maxCalendarNights caps the window requested from the calendar JSON
+const maxCalendarNights = 186
+
+// calendarWindow returns the period shown at the given zoom level around day
+func calendarWindow(zoom string, day time.Time) (time.Time, time.Time) {
+	y, mo, d := day.Date()
+	switch zoom {
+	case zoomWeek:
+		// weeks start on Monday
+		from := time.Date(y, mo, d, 0, 0, 0, 0, time.UTC)
+		from = from.AddDate(0, 0, -((int(from.Weekday()) + 6) % 7))
+		return from, from.AddDate(0, 0, 7)
+	case zoomQuarter:
+		from := time.Date(y, ((mo-1)/3)*3+1, 1, 0, 0, 0, 0, time.UTC)
+		return from, from.AddDate(0, 3, 0)
+	default:
+		from := time.Date(y, mo, 1, 0, 0, 0, 0, time.UTC)
+		return from, from.AddDate(0, 1, 0)
+	}
+}
+
+// calendarTitle describes the period shown at the given zoom level
+func calendarTitle(zoom string, from, to time.Time) string {
+	switch zoom {
+	case zoomWeek:
+		return fmt.Sprintf("%s - %s", from.Format("2 Jan 2006"), to.AddDate(0, 0, -1).Format("2 Jan 2006"))
+	case zoomQuarter:
+		return fmt.Sprintf("Q%d %d", (int(from.Month())-1)/3+1, from.Year())
+	default:
+		return from.Format("January 2006")
+	}
+}
+
+// AdminReservationsCalendar shows the timeline of reservations and blocks for every room.
+// The period is picked with zoom (week, month or quarter) and date, or with y and m
+// for a month.
+func (m *Application) AdminReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	zoom := r.URL.Query().Get("zoom")
+	if zoom != zoomWeek && zoom != zoomQuarter {
+		zoom = zoomMonth
+	}
+
+	day := today()
+	if d, err := time.Parse("2006-01-02", r.URL.Query().Get("date")); err == nil {
+		day = d
+	} else if r.URL.Query().Get("y") != "" {
+		year, _ := strconv.Atoi(r.URL.Query().Get("y"))
+		month, _ := strconv.Atoi(r.URL.Query().Get("m"))
+		if year > 0 && month >= 1 && month <= 12 {
+			day = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+		}
+	}
+
+	from, to := calendarWindow(zoom, day)
+	prev, _ := calendarWindow(zoom, from.AddDate(0, 0, -1))
+
+	stringMap := make(map[string]string)
+	stringMap["zoom"] = zoom
+	stringMap["title"] = calendarTitle(zoom, from, to)
+	stringMap["from"] = from.Format("2006-01-02")
+	stringMap["to"] = to.Format("2006-01-02")
+	stringMap["prev"] = prev.Format("2006-01-02")
+	stringMap["next"] = to.Format("2006-01-02")
+	stringMap["today"] = today().Format("2006-01-02")
+
+	m.Render.Template(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+	})
+}
+
+type calendarRoom struct {
+	ID    int            `json:"id"`
+	Name  string         `json:"name"`
+	Units []calendarUnit `json:"units"`
+}
+
+type calendarUnit struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type calendarItem struct {
+	ID            int    `json:"id"`
+	RoomID        int    `json:"room_id"`
+	UnitID        int    `json:"unit_id"`
+	Kind          string `json:"kind"`
+	ReservationID int    `json:"reservation_id,omitempty"`
+	Label         string `json:"label"`
+	// Start is the first night and End the day after the last one
+	Start string `json:"start"`
+	End   string `json:"end"`
+	URL   string `json:"url,omitempty"`
+}
+
+type calendarResponse struct {
+	OK      bool           `json:"ok"`
+	Message string         `json:"message,omitempty"`
+	From    string         `json:"from,omitempty"`
+	To      string         `json:"to,omitempty"`
+	Rooms   []calendarRoom `json:"rooms"`
+	Items   []calendarItem `json:"items"`
+}
+
+// AdminCalendarJSON returns the rooms and their units, with the reservations, blocks and
+// holds of each unit between from and to, the day after the last night shown
+func (m *Application) AdminCalendarJSON(w http.ResponseWriter, r *http.Request) {
+	from, err := time.Parse("2006-01-02", r.URL.Query().Get("from"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, calendarResponse{Message: "from must be a date"})
+		return
+	}
+	to, err := time.Parse("2006-01-02", r.URL.Query().Get("to"))
+	if err != nil || !to.After(from) {
+		writeJSON(w, http.StatusBadRequest, calendarResponse{Message: "to must be a date after from"})
+		return
+	}
+	if to.Sub(from) > maxCalendarNights*24*time.Hour {
+		writeJSON(w, http.StatusBadRequest, calendarResponse{Message: fmt.Sprintf("the calendar shows at most %d nights", maxCalendarNights)})
+		return
+	}
+
+	rooms, err := m.db(r).AllRooms(r.Context())
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "error listing rooms", "error", err)
+		writeJSON(w, http.StatusInternalServerError, calendarResponse{Message: "Error querying database"})
+		return
+	}
+
+	units, err := m.db(r).AllRoomUnits(r.Context())
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "error listing room units", "error", err)
+		writeJSON(w, http.StatusInternalServerError, calendarResponse{Message: "Error querying database"})
+		return
+	}
+
+	restrictions, err := m.db(r).RestrictionsForPeriod(r.Context(), from, to)
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "error listing restrictions", "error", err)
+		writeJSON(w, http.StatusInternalServerError, calendarResponse{Message: "Error querying database"})
+		return
+	}
+
+	resp := calendarResponse{
+		OK:    true,
+		From:  from.Format("2006-01-02"),
+		To:    to.Format("2006-01-02"),
+		Rooms: []calendarRoom{},
+		Items: []calendarItem{},
+	}
+	for _, rm := range rooms {
+		room := calendarRoom{ID: rm.ID, Name: rm.RoomName, Units: []calendarUnit{}}
+		for _, u := range units {
+			if u.RoomID == rm.ID {
+				room.Units = append(room.Units, calendarUnit{ID: u.ID, Name: u.Name})
+			}
+		}
+		resp.Rooms = append(resp.Rooms, room)
+	}
+	for _, rr := range restrictions {
+		resp.Items = append(resp.Items, calendarItemFor(rr))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// calendarItemFor describes a reservation, block or hold for the calendar
+func calendarItemFor(rr models.RoomRestriction) calendarItem {
+	end := rr.EndDate
+	// blocks made before blocks had an end date cover the night they start on
+	if !end.After(rr.StartDate) {
+		end = rr.StartDate.AddDate(0, 0, 1)
+	}
+
+	item := calendarItem{
+		ID:     rr.ID,
+		RoomID: rr.RoomID,
+		UnitID: rr.UnitID,
+		Kind:   "block",
+		Label:  "Blocked",
+		Start:  rr.StartDate.Format("2006-01-02"),
+		End:    end.Format("2006-01-02"),
+	}
+	if rr.ReservationID > 0 {
+		item.Kind = "reservation"
+		item.ReservationID = rr.ReservationID
+		item.Label = fmt.Sprintf("%s %s", rr.Reservation.FirstName, rr.Reservation.LastName)
+		item.URL = fmt.Sprintf("/admin/reservations/cal/%d/show?y=%s&m=%s",
+			rr.ReservationID, rr.StartDate.Format("2006"), rr.StartDate.Format("01"))
+	} else if rr.RestrictionID == models.RestrictionHold {
+		item.Kind = "hold"
+		item.Label = "Held until " + rr.ExpiresAt.Format("15:04")
+	}
+	return item
+}
+
+type blockResponse struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+	ID      int    `json:"id,omitempty"`
+}
+
+// AdminCalendarAddBlock blocks a room unit from start_date until the day before end_date
+func (m *Application) AdminCalendarAddBlock(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, blockResponse{Message: "can't parse form"})
+		return
+	}
+
+	unitID, err := strconv.Atoi(r.Form.Get("unit_id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, blockResponse{Message: "unit_id is required"})
+		return
+	}
+
+	start, err := time.Parse("2006-01-02", r.Form.Get("start_date"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, blockResponse{Message: "start_date must be a date"})
+		return
+	}
+	end, err := time.Parse("2006-01-02", r.Form.Get("end_date"))
+	if err != nil || !end.After(start) {
+		writeJSON(w, http.StatusBadRequest, blockResponse{Message: "end_date must be a date after start_date"})
+		return
+	}
+
+	id, err := m.db(r).InsertBlock(r.Context(), unitID, start, end)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusBadRequest, blockResponse{Message: "unknown unit"})
+		return
+	}
+	if errors.Is(err, dbrepo.ErrRestrictionConflict) {
+		writeJSON(w, http.StatusConflict, blockResponse{Message: "The unit is already booked or blocked on some of these nights"})
+		return
+	}
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "error inserting block", "unit_id", unitID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, blockResponse{Message: "Error saving block"})
+		return
+	}
+
+	m.App.Logger.InfoContext(r.Context(), "room blocked", "block_id", id, "unit_id", unitID,
+		"start_date", start.Format("2006-01-02"), "end_date", end.Format("2006-01-02"))
+	writeJSON(w, http.StatusCreated, blockResponse{OK: true, ID: id})
+}
+
+// AdminCalendarRemoveBlock removes a block
+func (m *Application) AdminCalendarRemoveBlock(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	err = m.db(r).DeleteBlockByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, blockResponse{Message: "block not found"})
+		return
+	}
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "error deleting block", "block_id", id, "error", err)
+		writeJSON(w, http.StatusInternalServerError, blockResponse{Message: "Error removing block"})
+		return
+	}
+
+	m.App.Logger.InfoContext(r.Context(), "block removed", "block_id", id)
+	writeJSON(w, http.StatusOK, blockResponse{OK: true, ID: id})
+}
+
+// AdminCalendarAssignUnit moves a reservation to the unit_id unit of its room
+func (m *Application) AdminCalendarAssignUnit(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, blockResponse{Message: "can't parse form"})
+		return
+	}
+
+	unitID, err := strconv.Atoi(r.Form.Get("unit_id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, blockResponse{Message: "unit_id is required"})
+		return
+	}
+
+	err = m.db(r).AssignUnit(r.Context(), id, unitID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusBadRequest, blockResponse{Message: "A reservation can only move to another unit of its room"})
+		return
+	}
+	if errors.Is(err, dbrepo.ErrRestrictionConflict) {
+		writeJSON(w, http.StatusConflict, blockResponse{Message: "The unit is already booked or blocked during this stay"})
+		return
+	}
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "error assigning unit", "reservation_id", id, "unit_id", unitID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, blockResponse{Message: "Error moving reservation"})
+		return
+	}
+
+	m.App.Logger.InfoContext(r.Context(), "unit assigned", "reservation_id", id, "unit_id", unitID)
+	writeJSON(w, http.StatusOK, blockResponse{OK: true, ID: id})
+}
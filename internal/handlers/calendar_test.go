@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/go-chi/chi"
+)
+
+var calendarWindowTests = []struct {
+	name         string
+	zoom         string
+	day          string
+	expectedFrom string
+	expectedTo   string
+}{
+	{"week-from-sunday", zoomWeek, "2040-01-08", "2040-01-02", "2040-01-09"},
+	{"week-from-monday", zoomWeek, "2040-01-02", "2040-01-02", "2040-01-09"},
+	{"month", zoomMonth, "2040-02-15", "2040-02-01", "2040-03-01"},
+	{"quarter", zoomQuarter, "2040-05-20", "2040-04-01", "2040-07-01"},
+	{"last-quarter", zoomQuarter, "2040-12-31", "2040-10-01", "2041-01-01"},
+}
+
+// TestCalendarWindow tests the period shown at each zoom level
+func TestCalendarWindow(t *testing.T) {
+	for _, e := range calendarWindowTests {
+		day, _ := time.Parse("2006-01-02", e.day)
+		from, to := calendarWindow(e.zoom, day)
+		if got := from.Format("2006-01-02"); got != e.expectedFrom {
+			t.Errorf("%s: expected window to start on %s, got %s", e.name, e.expectedFrom, got)
+		}
+		if got := to.Format("2006-01-02"); got != e.expectedTo {
+			t.Errorf("%s: expected window to end on %s, got %s", e.name, e.expectedTo, got)
+		}
+	}
+}
+
+var calendarJSONTests = []struct {
+	name               string
+	query              string
+	expectedStatusCode int
+	expectedItems      int
+}{
+	{"month", "from=2040-01-01&to=2040-02-01", http.StatusOK, 2},
+	{"missing-from", "to=2040-02-01", http.StatusBadRequest, 0},
+	{"to-before-from", "from=2040-02-01&to=2040-01-01", http.StatusBadRequest, 0},
+	{"window-too-long", "from=2040-01-01&to=2041-01-01", http.StatusBadRequest, 0},
+	{"database-error", "from=2060-01-01&to=2060-02-01", http.StatusInternalServerError, 0},
+}
+
+// TestAdminCalendarJSON tests the timeline data
+func TestAdminCalendarJSON(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	for _, e := range calendarJSONTests {
+		req, _ := http.NewRequest("GET", "/admin/reservations-calendar/json?"+e.query, nil)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.AdminCalendarJSON)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		var resp calendarResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: failed to parse json", e.name)
+		}
+		if len(resp.Items) != e.expectedItems {
+			t.Fatalf("%s: expected %d items, got %d", e.name, e.expectedItems, len(resp.Items))
+		}
+		if e.expectedItems == 0 {
+			continue
+		}
+
+		if len(resp.Rooms) != 2 {
+			t.Fatalf("%s: expected 2 rooms, got %d", e.name, len(resp.Rooms))
+		}
+		// rooms list their own units
+		if len(resp.Rooms[0].Units) != 2 || resp.Rooms[0].Units[1].ID != 3 || len(resp.Rooms[1].Units) != 1 {
+			t.Errorf("%s: unexpected units %+v", e.name, resp.Rooms)
+		}
+		res := resp.Items[0]
+		if res.Kind != "reservation" || res.Label != "John Smith" || res.UnitID != 3 || res.URL != "/admin/reservations/cal/1/show?y=2040&m=01" {
+			t.Errorf("%s: unexpected reservation %+v", e.name, res)
+		}
+		// the block was stored with the same start and end date
+		block := resp.Items[1]
+		if block.Kind != "block" || block.Start != "2040-01-03" || block.End != "2040-01-04" {
+			t.Errorf("%s: unexpected block %+v", e.name, block)
+		}
+	}
+}
+
+// TestCalendarItemForHold tests that rooms held for guests show until their hold ends
+func TestCalendarItemForHold(t *testing.T) {
+	start := time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC)
+	item := calendarItemFor(models.RoomRestriction{
+		ID:            7,
+		RoomID:        1,
+		UnitID:        3,
+		RestrictionID: models.RestrictionHold,
+		StartDate:     start,
+		EndDate:       start.AddDate(0, 0, 2),
+		ExpiresAt:     time.Date(2039, 12, 1, 14, 30, 0, 0, time.UTC),
+	})
+
+	if item.Kind != "hold" || item.Label != "Held until 14:30" || item.UnitID != 3 || item.End != "2040-01-03" || item.URL != "" {
+		t.Errorf("unexpected hold %+v", item)
+	}
+}
+
+var calendarAddBlockTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+}{
+	{
+		name:               "valid",
+		postedData:         url.Values{"unit_id": {"1"}, "start_date": {"2040-01-01"}, "end_date": {"2040-01-04"}},
+		expectedStatusCode: http.StatusCreated,
+	},
+	{
+		name:               "taken",
+		postedData:         url.Values{"unit_id": {"1"}, "start_date": {"2050-01-01"}, "end_date": {"2050-01-04"}},
+		expectedStatusCode: http.StatusConflict,
+	},
+	{
+		name:               "end-before-start",
+		postedData:         url.Values{"unit_id": {"1"}, "start_date": {"2040-01-04"}, "end_date": {"2040-01-04"}},
+		expectedStatusCode: http.StatusBadRequest,
+	},
+	{
+		name:               "unknown-unit",
+		postedData:         url.Values{"unit_id": {"4"}, "start_date": {"2040-01-01"}, "end_date": {"2040-01-04"}},
+		expectedStatusCode: http.StatusBadRequest,
+	},
+	{
+		name:               "missing-unit",
+		postedData:         url.Values{"start_date": {"2040-01-01"}, "end_date": {"2040-01-04"}},
+		expectedStatusCode: http.StatusBadRequest,
+	},
+	{
+		name:               "database-error",
+		postedData:         url.Values{"unit_id": {"1"}, "start_date": {"2060-01-01"}, "end_date": {"2060-01-04"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+// TestAdminCalendarAddBlock tests blocking a room unit from the calendar
+func TestAdminCalendarAddBlock(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	for _, e := range calendarAddBlockTests {
+		req, _ := http.NewRequest("POST", "/admin/reservations-calendar/blocks", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.AdminCalendarAddBlock)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		var resp blockResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: failed to parse json", e.name)
+		}
+		if resp.OK != (e.expectedStatusCode == http.StatusCreated) {
+			t.Errorf("%s: unexpected ok %v", e.name, resp.OK)
+		}
+	}
+}
+
+var calendarRemoveBlockTests = []struct {
+	name               string
+	id                 string
+	expectedStatusCode int
+}{
+	{"existing", "1", http.StatusOK},
+	{"database-error", "2", http.StatusInternalServerError},
+	{"missing", "3", http.StatusNotFound},
+	{"not-a-number", "abc", http.StatusNotFound},
+}
+
+// TestAdminCalendarRemoveBlock tests removing a block from the calendar
+func TestAdminCalendarRemoveBlock(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	for _, e := range calendarRemoveBlockTests {
+		req, _ := http.NewRequest("POST", "/admin/reservations-calendar/blocks/"+e.id+"/delete", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.AdminCalendarRemoveBlock)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+var calendarAssignUnitTests = []struct {
+	name               string
+	id                 string
+	postedData         url.Values
+	expectedStatusCode int
+}{
+	{"free-unit", "1", url.Values{"unit_id": {"3"}}, http.StatusOK},
+	{"taken-unit", "1", url.Values{"unit_id": {"1"}}, http.StatusConflict},
+	{"other-room", "1", url.Values{"unit_id": {"2"}}, http.StatusBadRequest},
+	{"unknown-reservation", "5", url.Values{"unit_id": {"3"}}, http.StatusBadRequest},
+	{"missing-unit", "1", url.Values{}, http.StatusBadRequest},
+	{"database-error", "1", url.Values{"unit_id": {"1000"}}, http.StatusInternalServerError},
+}
+
+// TestAdminCalendarAssignUnit tests reassigning a reservation to another unit from the calendar
+func TestAdminCalendarAssignUnit(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	for _, e := range calendarAssignUnitTests {
+		req, _ := http.NewRequest("POST", "/admin/reservations-calendar/reservations/"+e.id+"/unit", strings.NewReader(e.postedData.Encode()))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.AdminCalendarAssignUnit)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		var resp blockResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: failed to parse json", e.name)
+		}
+		if resp.OK != (e.expectedStatusCode == http.StatusOK) {
+			t.Errorf("%s: unexpected ok %v", e.name, resp.OK)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	forms "github.com/florian-lahitte-uvi/bookings/internal/form"
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/florian-lahitte-uvi/bookings/internal/repository/dbrepo"
+	"github.com/go-chi/chi"
+)
+
+// blankPolicyRules is the number of empty rules the policy form offers to fill in
+const blankPolicyRules = 2
+
+// AdminCancellationPolicies lists the cancellation policies with their rooms
+func (m *Application) AdminCancellationPolicies(w http.ResponseWriter, r *http.Request) {
+	policies, err := m.db(r).AllCancellationPolicies(r.Context())
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	rooms, err := m.db(r).AllRooms(r.Context())
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["cancellation_policies"] = policies
+	data["rooms"] = rooms
+
+	m.Render.Template(w, r, "admin-cancellation-policies.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminNewCancellationPolicy shows the form adding a cancellation policy
+func (m *Application) AdminNewCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	m.renderCancellationPolicy(w, r, models.CancellationPolicy{}, forms.New(nil))
+}
+
+// AdminShowCancellationPolicy shows a cancellation policy
+func (m *Application) AdminShowCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	policy, err := m.db(r).GetCancellationPolicyByID(r.Context(), id)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	m.renderCancellationPolicy(w, r, policy, forms.New(nil))
+}
+
+// renderCancellationPolicy shows the cancellation policy page, with empty rules to
+// add more
+func (m *Application) renderCancellationPolicy(w http.ResponseWriter, r *http.Request, policy models.CancellationPolicy, form *forms.Form) {
+	rooms, err := m.db(r).AllRooms(r.Context())
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	rules := append([]models.CancellationRule(nil), policy.Rules...)
+	for i := 0; i < blankPolicyRules; i++ {
+		rules = append(rules, models.CancellationRule{})
+	}
+
+	data := make(map[string]interface{})
+	data["cancellation_policy"] = policy
+	data["rules"] = rules
+	data["rooms"] = rooms
+
+	m.Render.Template(w, r, "admin-cancellation-policy.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminPostCancellationPolicy adds a cancellation policy, or saves the one in the URL
+func (m *Application) AdminPostCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	var policy models.CancellationPolicy
+	if param := chi.URLParam(r, "id"); param != "" {
+		id, err := strconv.Atoi(param)
+		if err != nil {
+			m.clientError(w, r, http.StatusNotFound)
+			return
+		}
+		policy, err = m.db(r).GetCancellationPolicyByID(r.Context(), id)
+		if err != nil {
+			m.serverError(w, r, err)
+			return
+		}
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	policy = cancellationPolicyFromForm(form, policy)
+	if !form.Valid() {
+		m.renderCancellationPolicy(w, r, policy, form)
+		return
+	}
+
+	if policy.ID == 0 {
+		policy.ID, err = m.db(r).InsertCancellationPolicy(r.Context(), policy)
+	} else {
+		err = m.db(r).UpdateCancellationPolicy(r.Context(), policy)
+	}
+	if errors.Is(err, dbrepo.ErrCancellationPolicyExists) {
+		form.Errors.Add("name", "A policy has this name already")
+		m.renderCancellationPolicy(w, r, policy, form)
+		return
+	}
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	m.Session.Put(r.Context(), "flash", "Cancellation policy "+policy.Name+" saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/cancellation-policies/%d", policy.ID), http.StatusSeeOther)
+}
+
+// cancellationPolicyFromForm updates p with the posted policy form, adding its
+// errors to form. Rules are posted as days_before and fee_percent pairs, those
+// left empty are skipped.
+func cancellationPolicyFromForm(form *forms.Form, p models.CancellationPolicy) models.CancellationPolicy {
+	p.Name = strings.TrimSpace(form.Get("name"))
+	p.Description = strings.TrimSpace(form.Get("description"))
+	form.Required("name")
+
+	days, percents := form.Values["days_before"], form.Values["fee_percent"]
+	p.Rules = nil
+	seen := make(map[int]bool)
+	for i := 0; i < len(days) && i < len(percents); i++ {
+		d, f := strings.TrimSpace(days[i]), strings.TrimSpace(percents[i])
+		if d == "" && f == "" {
+			continue
+		}
+
+		var rule models.CancellationRule
+		var err error
+		if rule.DaysBefore, err = strconv.Atoi(d); err != nil || rule.DaysBefore < 0 {
+			form.Errors.Add("rules", "Enter a number of days for every fee")
+			continue
+		}
+		if rule.FeePercent, err = strconv.Atoi(f); err != nil || rule.FeePercent < 1 || rule.FeePercent > 100 {
+			form.Errors.Add("rules", "Enter fees as a percentage between 1 and 100")
+			continue
+		}
+		if seen[rule.DaysBefore] {
+			form.Errors.Add("rules", fmt.Sprintf("There are two fees for %d days", rule.DaysBefore))
+			continue
+		}
+		seen[rule.DaysBefore] = true
+		p.Rules = append(p.Rules, rule)
+	}
+
+	p.RoomIDs = nil
+	for _, s := range form.Values["room_id"] {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			form.Errors.Add("room_id", "Unknown room")
+			continue
+		}
+		p.RoomIDs = append(p.RoomIDs, id)
+	}
+
+	return p
+}
+
+// AdminDeleteCancellationPolicy deletes a cancellation policy no reservation was made with
+func (m *Application) AdminDeleteCancellationPolicy(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	err = m.db(r).DeleteCancellationPolicy(r.Context(), id)
+	if errors.Is(err, dbrepo.ErrCancellationPolicyInUse) {
+		m.Session.Put(r.Context(), "error", "Reservations were made with this policy, it can't be deleted")
+		http.Redirect(w, r, fmt.Sprintf("/admin/cancellation-policies/%d", id), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	m.Session.Put(r.Context(), "flash", "Cancellation policy deleted")
+	http.Redirect(w, r, "/admin/cancellation-policies", http.StatusSeeOther)
+}
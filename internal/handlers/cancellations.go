@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/florian-lahitte-uvi/bookings/helpers"
+	forms "github.com/florian-lahitte-uvi/bookings/internal/form"
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+// cancellationFee returns the cancellation policy of a reservation, and what
+// cancelling it now costs
+func (m *Application) cancellationFee(r *http.Request, res models.Reservation) (models.CancellationPolicy, int, error) {
+	if res.CancellationPolicyID == 0 {
+		return models.CancellationPolicy{}, 0, nil
+	}
+	policy, err := m.db(r).GetCancellationPolicyByID(r.Context(), res.CancellationPolicyID)
+	if err != nil {
+		return policy, 0, err
+	}
+	return policy, policy.Fee(res, time.Now()), nil
+}
+
+// feeConfirmed reports whether the fee posted with a cancellation is the one
+// owed now. It changes when the day does, the guest must then confirm again.
+func feeConfirmed(form *forms.Form, fee int) bool {
+	return form.Get("fee") == strconv.Itoa(fee)
+}
+
+// GuestCancelReservation shows guests what cancelling their reservation costs,
+// before they confirm
+func (m *Application) GuestCancelReservation(w http.ResponseWriter, r *http.Request) {
+	id, ok := m.guestReservationID(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := m.db(r).GetReservationByID(r.Context(), id)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	if !res.Cancellable(time.Now()) {
+		http.Redirect(w, r, helpers.SitePath(r, "/my-reservation/"+chi.URLParam(r, "token")), http.StatusSeeOther)
+		return
+	}
+
+	m.renderGuestCancel(w, r, res, forms.New(nil))
+}
+
+// renderGuestCancel shows the page confirming the cancellation of a reservation
+func (m *Application) renderGuestCancel(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form) {
+	policy, fee, err := m.cancellationFee(r, res)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["cancellation_policy"] = policy
+
+	m.Render.Template(w, r, "guest-cancel-reservation.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: map[string]string{"token": chi.URLParam(r, "token")},
+		IntMap:    map[string]int{"fee": fee},
+		Form:      form,
+	})
+}
+
+// GuestPostCancelReservation cancels a guest's reservation for the fee they were shown
+func (m *Application) GuestPostCancelReservation(w http.ResponseWriter, r *http.Request) {
+	id, ok := m.guestReservationID(w, r)
+	if !ok {
+		return
+	}
+	page := helpers.SitePath(r, "/my-reservation/"+chi.URLParam(r, "token"))
+
+	err := r.ParseForm()
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+
+	res, err := m.db(r).GetReservationByID(r.Context(), id)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	if !res.Cancellable(time.Now()) {
+		http.Redirect(w, r, page, http.StatusSeeOther)
+		return
+	}
+
+	_, fee, err := m.cancellationFee(r, res)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	if !feeConfirmed(form, fee) {
+		form.Errors.Add("fee", "The cancellation fee has changed, please check it and confirm again")
+		m.renderGuestCancel(w, r, res, form)
+		return
+	}
+
+	reason := strings.TrimSpace(form.Get("reason"))
+	if !m.cancelReservation(w, r, res, fee, reason) {
+		return
+	}
+
+	if res.Email != "" {
+		m.MailChan <- cancellationMail(r, res, fee, m.App.Currency)
+	}
+
+	m.Session.Put(r.Context(), "flash", "Your reservation is cancelled")
+	http.Redirect(w, r, page, http.StatusSeeOther)
+}
+
+// cancelReservation cancels res for fee and logs it. It answers with a server
+// error and returns false if that fails.
+func (m *Application) cancelReservation(w http.ResponseWriter, r *http.Request, res models.Reservation, fee int, reason string) bool {
+	cancelled, err := m.db(r).CancelReservation(r.Context(), res.ID, fee, reason)
+	if err != nil {
+		m.serverError(w, r, err)
+		return false
+	}
+	if cancelled {
+		m.App.Logger.InfoContext(r.Context(), "reservation cancelled", "reservation_id", res.ID, "fee", fee)
+	}
+	return true
+}
+
+// cancellationMail builds the email confirming a cancellation to the guest
+func cancellationMail(r *http.Request, res models.Reservation, fee int, currency string) models.MailData {
+	owed := "There is no fee for this cancellation."
+	if fee > 0 {
+		owed = fmt.Sprintf("The cancellation fee is %s.", models.FormatMoney(fee, currency))
+	}
+	content := fmt.Sprintf(`<h1>Reservation Cancelled</h1>
+<p>Dear %s %s,</p>
+<p>Your reservation from %s to %s is cancelled. %s</p>`,
+		template.HTMLEscapeString(res.FirstName), template.HTMLEscapeString(res.LastName),
+		res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"), owed)
+
+	return models.MailData{
+		From:      mailFrom,
+		To:        res.Email,
+		Subject:   "Reservation Cancelled",
+		Content:   content,
+		Template:  "basic",
+		RequestID: middleware.GetReqID(r.Context()),
+	}
+}
+
+// AdminPostCancelReservation cancels a reservation for the fee of its policy, with
+// the reason staff give
+func (m *Application) AdminPostCancelReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	page := reservationPage(chi.URLParam(r, "src"), id, form.Get("year"), form.Get("month"))
+
+	res, err := m.db(r).GetReservationByID(r.Context(), id)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	if !res.Cancellable(time.Now()) {
+		m.Session.Put(r.Context(), "error", "This reservation can't be cancelled anymore")
+		http.Redirect(w, r, page, http.StatusSeeOther)
+		return
+	}
+
+	reason := strings.TrimSpace(form.Get("reason"))
+	if reason == "" {
+		m.Session.Put(r.Context(), "error", "Give the reason of the cancellation")
+		http.Redirect(w, r, page, http.StatusSeeOther)
+		return
+	}
+
+	_, fee, err := m.cancellationFee(r, res)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	if !feeConfirmed(form, fee) {
+		m.Session.Put(r.Context(), "error", "The cancellation fee has changed, please check it and confirm again")
+		http.Redirect(w, r, page, http.StatusSeeOther)
+		return
+	}
+
+	if !m.cancelReservation(w, r, res, fee, reason) {
+		return
+	}
+
+	m.Session.Put(r.Context(), "flash", "Reservation cancelled, the fee is "+models.FormatMoney(fee, m.App.Currency))
+	http.Redirect(w, r, page, http.StatusSeeOther)
+}
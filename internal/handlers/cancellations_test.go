@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+var guestCancelTests = []struct {
+	name               string
+	token              string
+	expectedStatusCode int
+	expectedHTML       string
+}{
+	{"free", "guest123", http.StatusOK, "Cancelling now is <strong>free</strong>"},
+	{"fee", "guest456", http.StatusOK, "Cancelling now costs <strong>200.00 USD</strong>"},
+	{"unknown-link", "nope", http.StatusNotFound, ""},
+}
+
+// TestGuestCancelReservation tests showing guests what cancelling costs
+func TestGuestCancelReservation(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	for _, e := range guestCancelTests {
+		req, _ := http.NewRequest("GET", "/my-reservation/"+e.token+"/cancel", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("token", e.token)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.GuestCancelReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %q", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var guestPostCancelTests = []struct {
+	name               string
+	token              string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedHTML       string
+	expectedMail       bool
+}{
+	{"free", "guest123", url.Values{"fee": {"0"}, "reason": {"Plans changed"}}, http.StatusSeeOther, "", true},
+	{"fee", "guest456", url.Values{"fee": {"20000"}}, http.StatusSeeOther, "", false},
+	{"fee-changed", "guest123", url.Values{"fee": {"10000"}}, http.StatusOK, "The cancellation fee has changed", false},
+	{"fee-missing", "guest456", url.Values{}, http.StatusOK, "The cancellation fee has changed", false},
+	{"cancel-fails", "guest123", url.Values{"fee": {"0"}, "reason": {"fail"}}, http.StatusInternalServerError, "", false},
+	{"unknown-link", "nope", url.Values{"fee": {"0"}}, http.StatusNotFound, "", false},
+}
+
+// TestGuestPostCancelReservation tests guests cancelling their reservation
+func TestGuestPostCancelReservation(t *testing.T) {
+	t.Parallel()
+
+	for _, e := range guestPostCancelTests {
+		repo := newTestApplication(t)
+
+		req, _ := http.NewRequest("POST", "/my-reservation/"+e.token+"/cancel", strings.NewReader(e.postedData.Encode()))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("token", e.token)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.GuestPostCancelReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedStatusCode == http.StatusSeeOther {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc == nil || actualLoc.String() != "/my-reservation/"+e.token {
+				t.Errorf("%s: wrong location %v", e.name, actualLoc)
+			}
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %q", e.name, e.expectedHTML)
+		}
+
+		if sent := len(repo.MailChan) == 1; sent != e.expectedMail {
+			t.Fatalf("%s: mail sent %v, wanted %v", e.name, sent, e.expectedMail)
+		}
+		if e.expectedMail {
+			msg := <-repo.MailChan
+			if msg.Subject != "Reservation Cancelled" || !strings.Contains(msg.Content, "There is no fee") {
+				t.Errorf("%s: wrong mail %q: %q", e.name, msg.Subject, msg.Content)
+			}
+		}
+	}
+}
+
+var adminCancelTests = []struct {
+	name               string
+	id                 string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedFlash      string
+	expectedError      string
+}{
+	{"free", "1", url.Values{"fee": {"0"}, "reason": {"Guest called"}}, http.StatusSeeOther, "Reservation cancelled, the fee is 0.00 USD", ""},
+	{"fee", "2", url.Values{"fee": {"20000"}, "reason": {"No show"}}, http.StatusSeeOther, "Reservation cancelled, the fee is 200.00 USD", ""},
+	{"no-reason", "1", url.Values{"fee": {"0"}, "reason": {" "}}, http.StatusSeeOther, "", "Give the reason of the cancellation"},
+	{"fee-changed", "2", url.Values{"fee": {"0"}, "reason": {"No show"}}, http.StatusSeeOther, "", "The cancellation fee has changed, please check it and confirm again"},
+	{"cancel-fails", "1", url.Values{"fee": {"0"}, "reason": {"fail"}}, http.StatusInternalServerError, "", ""},
+	{"unknown-reservation", "3", url.Values{"fee": {"0"}, "reason": {"Guest called"}}, http.StatusInternalServerError, "", ""},
+	{"bad-id", "x", url.Values{}, http.StatusNotFound, "", ""},
+}
+
+// TestAdminPostCancelReservation tests staff cancelling a reservation
+func TestAdminPostCancelReservation(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	for _, e := range adminCancelTests {
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/"+e.id+"/cancel", strings.NewReader(e.postedData.Encode()))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "all")
+		rctx.URLParams.Add("id", e.id)
+		ctx := getCtx(req)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.AdminPostCancelReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedStatusCode != http.StatusSeeOther {
+			continue
+		}
+
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc == nil || actualLoc.String() != "/admin/reservations/all/"+e.id+"/show" {
+			t.Errorf("%s: wrong location %v", e.name, actualLoc)
+		}
+		if got := session.PopString(ctx, "flash"); got != e.expectedFlash {
+			t.Errorf("%s: expected flash %q, got %q", e.name, e.expectedFlash, got)
+		}
+		if got := session.PopString(ctx, "error"); got != e.expectedError {
+			t.Errorf("%s: expected error %q, got %q", e.name, e.expectedError, got)
+		}
+	}
+}
+
+var adminCancellationPolicyTests = []struct {
+	name               string
+	method             string
+	url                string
+	id                 string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+}{
+	{"list", "GET", "/admin/cancellation-policies", "", nil, http.StatusOK, "", "Free cancellation up to 14 days before arrival."},
+	{"new", "GET", "/admin/cancellation-policies/new", "", nil, http.StatusOK, "", "New Cancellation Policy"},
+	{"show", "GET", "/admin/cancellation-policies/1", "1", nil, http.StatusOK, "", "Flexible"},
+	{"show-unknown", "GET", "/admin/cancellation-policies/9", "9", nil, http.StatusInternalServerError, "", ""},
+	{"show-bad-id", "GET", "/admin/cancellation-policies/x", "x", nil, http.StatusNotFound, "", ""},
+	{"create", "POST", "/admin/cancellation-policies/new", "", url.Values{
+		"name": {"Moderate"}, "fee_percent": {"50", "100", ""}, "days_before": {"5", "1", ""}, "room_id": {"1"},
+	}, http.StatusSeeOther, "/admin/cancellation-policies/3", ""},
+	{"create-exists", "POST", "/admin/cancellation-policies/new", "", url.Values{
+		"name": {"flexible"},
+	}, http.StatusOK, "", "A policy has this name already"},
+	{"create-fails", "POST", "/admin/cancellation-policies/new", "", url.Values{
+		"name": {"fail"},
+	}, http.StatusInternalServerError, "", ""},
+	{"no-name", "POST", "/admin/cancellation-policies/new", "", url.Values{
+		"name": {" "},
+	}, http.StatusOK, "", "This field cannot be blank"},
+	{"bad-percentage", "POST", "/admin/cancellation-policies/new", "", url.Values{
+		"name": {"Strict"}, "fee_percent": {"150"}, "days_before": {"7"},
+	}, http.StatusOK, "", "Enter fees as a percentage between 1 and 100"},
+	{"bad-days", "POST", "/admin/cancellation-policies/new", "", url.Values{
+		"name": {"Strict"}, "fee_percent": {"50"}, "days_before": {""},
+	}, http.StatusOK, "", "Enter a number of days for every fee"},
+	{"same-days", "POST", "/admin/cancellation-policies/new", "", url.Values{
+		"name": {"Strict"}, "fee_percent": {"50", "100"}, "days_before": {"7", "7"},
+	}, http.StatusOK, "", "There are two fees for 7 days"},
+	{"update", "POST", "/admin/cancellation-policies/1", "1", url.Values{
+		"name": {"Flexible"}, "fee_percent": {"50"}, "days_before": {"7"},
+	}, http.StatusSeeOther, "/admin/cancellation-policies/1", ""},
+	{"update-taken", "POST", "/admin/cancellation-policies/2", "2", url.Values{
+		"name": {"Flexible"},
+	}, http.StatusOK, "", "A policy has this name already"},
+	{"update-fails", "POST", "/admin/cancellation-policies/2", "2", url.Values{
+		"name": {"Non-refundable"},
+	}, http.StatusInternalServerError, "", ""},
+	{"update-unknown", "POST", "/admin/cancellation-policies/9", "9", url.Values{}, http.StatusInternalServerError, "", ""},
+	{"delete", "POST", "/admin/cancellation-policies/3/delete", "3", url.Values{}, http.StatusSeeOther, "/admin/cancellation-policies", ""},
+	{"delete-in-use", "POST", "/admin/cancellation-policies/1/delete", "1", url.Values{}, http.StatusSeeOther, "/admin/cancellation-policies/1", ""},
+	{"delete-fails", "POST", "/admin/cancellation-policies/2/delete", "2", url.Values{}, http.StatusInternalServerError, "", ""},
+}
+
+// TestAdminCancellationPolicies tests listing, saving and deleting cancellation policies
+func TestAdminCancellationPolicies(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	for _, e := range adminCancellationPolicyTests {
+		var req *http.Request
+		if e.postedData != nil {
+			req, _ = http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest(e.method, e.url, nil)
+		}
+		rctx := chi.NewRouteContext()
+		if e.id != "" {
+			rctx.URLParams.Add("id", e.id)
+		}
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+
+		var handler http.HandlerFunc
+		switch {
+		case strings.HasSuffix(e.url, "/delete"):
+			handler = repo.AdminDeleteCancellationPolicy
+		case e.method == "POST":
+			handler = repo.AdminPostCancellationPolicy
+		case e.url == "/admin/cancellation-policies":
+			handler = repo.AdminCancellationPolicies
+		case e.url == "/admin/cancellation-policies/new":
+			handler = repo.AdminNewCancellationPolicy
+		default:
+			handler = repo.AdminShowCancellationPolicy
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc == nil || actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: wrong location %v, wanted %s", e.name, actualLoc, e.expectedLocation)
+			}
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %q", e.name, e.expectedHTML)
+		}
+	}
+}
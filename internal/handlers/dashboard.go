@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+)
+
+// occupancyWindows are the forward-looking periods, in days, offered by the occupancy chart
+var occupancyWindows = map[int]bool{30: true, 60: true, 90: true}
+
+// today returns the current date at midnight UTC, matching how dates are stored
+func today() time.Time {
+	y, mo, d := time.Now().Date()
+	return time.Date(y, mo, d, 0, 0, 0, 0, time.UTC)
+}
+
+// writeJSON sends v as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	out, _ := json.MarshalIndent(v, "", "     ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// AdminDashboard shows today's activity and the summary figures
+func (m *Application) AdminDashboard(w http.ResponseWriter, r *http.Request) {
+	day := today()
+
+	arrivals, err := m.db(r).ArrivalsForDate(r.Context(), day)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	departures, err := m.db(r).DeparturesForDate(r.Context(), day)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	inHouse, err := m.db(r).CountInHouseReservations(r.Context(), day)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	unprocessed, err := m.db(r).CountNewReservations(r.Context())
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	avgStay, err := m.db(r).AverageLengthOfStay(r.Context(), day.AddDate(-1, 0, 0), day.AddDate(0, 0, 1))
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["arrivals"] = arrivals
+	data["departures"] = departures
+
+	intMap := make(map[string]int)
+	intMap["arrivals"] = len(arrivals)
+	intMap["departures"] = len(departures)
+	intMap["in_house"] = inHouse
+	intMap["unprocessed"] = unprocessed
+
+	stringMap := make(map[string]string)
+	stringMap["today"] = day.Format("2006-01-02")
+	stringMap["average_stay"] = strconv.FormatFloat(avgStay, 'f', 1, 64)
+
+	m.Render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{
+		Data:      data,
+		IntMap:    intMap,
+		StringMap: stringMap,
+	})
+}
+
+type occupancyRoom struct {
+	RoomID       int     `json:"room_id"`
+	RoomName     string  `json:"room_name"`
+	BookedNights int     `json:"booked_nights"`
+	TotalNights  int     `json:"total_nights"`
+	Rate         float64 `json:"rate"`
+}
+
+type occupancyResponse struct {
+	OK      bool            `json:"ok"`
+	Message string          `json:"message,omitempty"`
+	From    string          `json:"from"`
+	To      string          `json:"to"`
+	Rooms   []occupancyRoom `json:"rooms"`
+}
+
+// AdminDashboardOccupancy returns the occupancy rate of each room for the next 30, 60 or 90 days
+func (m *Application) AdminDashboardOccupancy(w http.ResponseWriter, r *http.Request) {
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+	if err != nil || !occupancyWindows[days] {
+		writeJSON(w, http.StatusBadRequest, occupancyResponse{Message: "days must be 30, 60 or 90"})
+		return
+	}
+
+	start := today()
+	end := start.AddDate(0, 0, days)
+
+	occupancy, err := m.db(r).OccupancyByRoom(r.Context(), start, end)
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "error computing occupancy", "error", err)
+		writeJSON(w, http.StatusInternalServerError, occupancyResponse{Message: "Error querying database"})
+		return
+	}
+
+	resp := occupancyResponse{
+		OK:    true,
+		From:  start.Format("2006-01-02"),
+		To:    end.Format("2006-01-02"),
+		Rooms: []occupancyRoom{},
+	}
+	for _, o := range occupancy {
+		resp.Rooms = append(resp.Rooms, occupancyRoom{
+			RoomID:       o.Room.ID,
+			RoomName:     o.Room.RoomName,
+			BookedNights: o.BookedNights,
+			TotalNights:  o.TotalNights,
+			Rate:         o.Rate(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type paceResponse struct {
+	OK       bool     `json:"ok"`
+	Message  string   `json:"message,omitempty"`
+	Labels   []string `json:"labels"`
+	ThisYear []int    `json:"this_year"`
+	LastYear []int    `json:"last_year"`
+}
+
+// AdminDashboardPace returns the cumulative number of bookings made over the last
+// 30 days, next to the same days one year earlier
+func (m *Application) AdminDashboardPace(w http.ResponseWriter, r *http.Request) {
+	end := today().AddDate(0, 0, 1)
+	start := end.AddDate(0, 0, -30)
+
+	thisYear, err := m.db(r).ReservationsCreatedByDay(r.Context(), start, end)
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "error computing booking pace", "error", err)
+		writeJSON(w, http.StatusInternalServerError, paceResponse{Message: "Error querying database"})
+		return
+	}
+
+	lastYear, err := m.db(r).ReservationsCreatedByDay(r.Context(), start.AddDate(-1, 0, 0), end.AddDate(-1, 0, 0))
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "error computing booking pace", "error", err)
+		writeJSON(w, http.StatusInternalServerError, paceResponse{Message: "Error querying database"})
+		return
+	}
+
+	resp := paceResponse{
+		OK:       true,
+		Labels:   []string{},
+		ThisYear: cumulative(thisYear),
+		LastYear: cumulative(lastYear),
+	}
+	for _, c := range thisYear {
+		resp.Labels = append(resp.Labels, c.Date.Format("01-02"))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// cumulative turns daily counts into a running total
+func cumulative(counts []models.DailyCount) []int {
+	totals := make([]int, 0, len(counts))
+	sum := 0
+	for _, c := range counts {
+		sum += c.Count
+		totals = append(totals, sum)
+	}
+	return totals
+}
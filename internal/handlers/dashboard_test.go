@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestAdminDashboard tests the dashboard shows today's arrivals
+func TestAdminDashboard(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	req, _ := http.NewRequest("GET", "/admin/dashboard", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(repo.AdminDashboard)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("dashboard returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	if !strings.Contains(rr.Body.String(), "John Smith") {
+		t.Error("expected today's arrival to be listed")
+	}
+}
+
+var occupancyTests = []struct {
+	name               string
+	days               string
+	expectedStatusCode int
+	expectedRate       float64
+}{
+	{"30-days", "30", http.StatusOK, 0.5},
+	{"90-days", "90", http.StatusOK, 0.5},
+	{"unsupported-window", "45", http.StatusBadRequest, 0},
+	{"not-a-number", "abc", http.StatusBadRequest, 0},
+}
+
+// TestAdminDashboardOccupancy tests the occupancy JSON endpoint
+func TestAdminDashboardOccupancy(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	for _, e := range occupancyTests {
+		req, _ := http.NewRequest("GET", "/admin/dashboard/occupancy?days="+e.days, nil)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.AdminDashboardOccupancy)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		var resp occupancyResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: failed to parse json", e.name)
+		}
+
+		if e.expectedStatusCode == http.StatusOK {
+			if len(resp.Rooms) != 2 {
+				t.Fatalf("%s: expected 2 rooms, got %d", e.name, len(resp.Rooms))
+			}
+			if resp.Rooms[0].Rate != e.expectedRate {
+				t.Errorf("%s: expected rate %v, got %v", e.name, e.expectedRate, resp.Rooms[0].Rate)
+			}
+		}
+	}
+}
+
+// TestAdminDashboardPace tests the booking pace JSON endpoint
+func TestAdminDashboardPace(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	req, _ := http.NewRequest("GET", "/admin/dashboard/pace", nil)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(repo.AdminDashboardPace)
+	handler.ServeHTTP(rr, req)
+
+	var resp paceResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal("failed to parse json")
+	}
+
+	if len(resp.Labels) != 30 || len(resp.ThisYear) != 30 || len(resp.LastYear) != 30 {
+		t.Fatalf("expected 30 days of pace, got %d labels", len(resp.Labels))
+	}
+
+	// the test repository returns one booking a day, so the running total ends at 30
+	if resp.ThisYear[29] != 30 {
+		t.Errorf("expected cumulative total of 30, got %d", resp.ThisYear[29])
+	}
+}
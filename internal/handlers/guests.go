@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	forms "github.com/florian-lahitte-uvi/bookings/internal/form"
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/go-chi/chi"
+)
+
+// AdminGuests lists the guests, optionally searching them with q
+func (m *Application) AdminGuests(w http.ResponseWriter, r *http.Request) {
+	search := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	guests, err := m.db(r).ListGuests(r.Context(), search)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["guests"] = guests
+
+	stringMap := make(map[string]string)
+	stringMap["q"] = search
+
+	m.Render.Template(w, r, "admin-guests.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// AdminShowGuest shows a guest with their past and upcoming stays
+func (m *Application) AdminShowGuest(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	guest, err := m.db(r).GetGuestByID(r.Context(), id)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	m.renderShowGuest(w, r, guest, forms.New(nil))
+}
+
+// renderShowGuest shows the guest page
+func (m *Application) renderShowGuest(w http.ResponseWriter, r *http.Request, guest models.Guest, form *forms.Form) {
+	stays, err := m.db(r).GuestStays(r.Context(), guest.ID)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	// stays still going on count as upcoming
+	day := today()
+	var past, upcoming []models.Reservation
+	nights, pastNights := 0, 0
+	for _, s := range stays {
+		n := int(s.EndDate.Sub(s.StartDate).Hours() / 24)
+		nights += n
+		if s.EndDate.After(day) {
+			upcoming = append(upcoming, s)
+		} else {
+			past = append(past, s)
+			pastNights += n
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["guest"] = guest
+	data["past"] = past
+	data["upcoming"] = upcoming
+
+	intMap := make(map[string]int)
+	intMap["stays"] = len(stays)
+	intMap["nights"] = nights
+	intMap["past_nights"] = pastNights
+
+	stringMap := make(map[string]string)
+	stringMap["tags"] = strings.Join(guest.Tags, ", ")
+
+	m.Render.Template(w, r, "admin-guest-show.page.tmpl", &models.TemplateData{
+		Data:      data,
+		IntMap:    intMap,
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
+
+// AdminPostGuest saves the details, notes and tags of a guest
+func (m *Application) AdminPostGuest(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	guest, err := m.db(r).GetGuestByID(r.Context(), id)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	guest.FirstName = r.Form.Get("first_name")
+	guest.LastName = r.Form.Get("last_name")
+	guest.Phone = r.Form.Get("phone")
+	guest.Notes = r.Form.Get("notes")
+	guest.Tags = models.ParseTags(r.Form.Get("tags"))
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name")
+	if len(strings.Join(guest.Tags, ",")) > 255 {
+		form.Errors.Add("tags", "Too many tags")
+	}
+	if !form.Valid() {
+		m.renderShowGuest(w, r, guest, form)
+		return
+	}
+
+	err = m.db(r).UpdateGuest(r.Context(), guest)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	m.Session.Put(r.Context(), "flash", "Guest saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/guests/%d", guest.ID), http.StatusSeeOther)
+}
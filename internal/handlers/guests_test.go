@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var guestPageTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+	expectedBody       []string
+}{
+	{"list", "/admin/guests", http.StatusOK, []string{"Smith, John", "vip"}},
+	{"list-error", "/admin/guests?q=error", http.StatusInternalServerError, nil},
+	{"show", "/admin/guests/1", http.StatusOK, []string{"Prefers a quiet room", "<strong>2</strong> stays", "<strong>5</strong> nights booked", "<strong>3</strong> nights stayed"}},
+	{"show-stays-error", "/admin/guests/2", http.StatusInternalServerError, nil},
+	{"show-missing", "/admin/guests/3", http.StatusInternalServerError, nil},
+	{"show-bad-id", "/admin/guests/abc", http.StatusNotFound, nil},
+	{"reservation-links-guest", "/admin/reservations/all/1/show", http.StatusOK, []string{`href="/admin/guests/1"`}},
+}
+
+// TestGuestPages tests the guest list and guest page
+func TestGuestPages(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	routes := getRoutes(repo)
+
+	for _, e := range guestPageTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		req.RequestURI = e.url
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		for _, s := range e.expectedBody {
+			if !strings.Contains(rr.Body.String(), s) {
+				t.Errorf("%s: expected page to contain %q", e.name, s)
+			}
+		}
+	}
+}
+
+var postGuestTests = []struct {
+	name               string
+	url                string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedBody       string
+}{
+	{
+		name:               "valid",
+		url:                "/admin/guests/1",
+		postedData:         url.Values{"first_name": {"John"}, "last_name": {"Smith"}, "tags": {"VIP, Do not rent"}, "notes": {"Late arrival"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/guests/1",
+	},
+	{
+		name:               "missing-name",
+		url:                "/admin/guests/1",
+		postedData:         url.Values{"first_name": {"John"}, "tags": {"vip"}},
+		expectedStatusCode: http.StatusOK,
+		expectedBody:       "This field cannot be blank",
+	},
+	{
+		name:               "too-many-tags",
+		url:                "/admin/guests/1",
+		postedData:         url.Values{"first_name": {"John"}, "last_name": {"Smith"}, "tags": {strings.Repeat("x", 256)}},
+		expectedStatusCode: http.StatusOK,
+		expectedBody:       "Too many tags",
+	},
+	{
+		name:               "save-fails",
+		url:                "/admin/guests/2",
+		postedData:         url.Values{"first_name": {"John"}, "last_name": {"Smith"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "missing-guest",
+		url:                "/admin/guests/3",
+		postedData:         url.Values{"first_name": {"John"}, "last_name": {"Smith"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+// TestAdminPostGuest tests saving a guest's details, notes and tags
+func TestAdminPostGuest(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	routes := getRoutes(repo)
+
+	for _, e := range postGuestTests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc == nil || actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: wrong location %v, wanted %s", e.name, actualLoc, e.expectedLocation)
+			}
+		}
+		if e.expectedBody != "" && !strings.Contains(rr.Body.String(), e.expectedBody) {
+			t.Errorf("%s: expected page to contain %q", e.name, e.expectedBody)
+		}
+	}
+}
@@ -1,84 +1,56 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/florian-lahitte-uvi/bookings/helpers"
-	"github.com/florian-lahitte-uvi/bookings/internal/config"
-	"github.com/florian-lahitte-uvi/bookings/internal/driver"
 	forms "github.com/florian-lahitte-uvi/bookings/internal/form"
 	"github.com/florian-lahitte-uvi/bookings/internal/models"
-	"github.com/florian-lahitte-uvi/bookings/internal/render"
-	"github.com/florian-lahitte-uvi/bookings/internal/repository"
 	"github.com/florian-lahitte-uvi/bookings/internal/repository/dbrepo"
 	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
 )
 
-// Repo the repository used by the handlers
-var Repo *Repository
-
-// Repository is the repository type
-type Repository struct {
-	App *config.AppConfig
-	DB  repository.DatabaseRepo
-}
-
-// NewRepo creates a new repository
-func NewRepo(a *config.AppConfig, db *driver.DB) *Repository {
-	return &Repository{
-		App: a,
-		DB:  dbrepo.NewPostgresRepo(db.SQL, a),
-	}
-}
-
-// NewTestRepo creates a new test repository
-func NewTestRepo(a *config.AppConfig) *Repository {
-	return &Repository{
-		App: a,
-		DB:  dbrepo.NewTestingRepo(a),
-	}
-}
-
-// NewHandlers sets the repository for the handlers
-func NewHandlers(r *Repository) {
-	Repo = r
-}
+// mailFrom is the sender of the emails to guests
+const mailFrom = "me@here.com"
 
 // Home is the handler for the home page
-func (m *Repository) Home(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "home.page.tmpl", &models.TemplateData{})
+func (m *Application) Home(w http.ResponseWriter, r *http.Request) {
+	m.Render.Template(w, r, "home.page.tmpl", &models.TemplateData{})
 }
 
 // About is the handler for the about page
-func (m *Repository) About(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "about.page.tmpl", &models.TemplateData{})
+func (m *Application) About(w http.ResponseWriter, r *http.Request) {
+	m.Render.Template(w, r, "about.page.tmpl", &models.TemplateData{})
 }
 
 // Reservation renders the make a reservation page and displays form
-func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
-	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+func (m *Application) Reservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.Session.Get(r.Context(), "reservation").(models.Reservation)
 	if !ok {
-		m.App.Session.Put(r.Context(), "error", "unable to retrieve reservation from session")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		m.Session.Put(r.Context(), "error", "unable to retrieve reservation from session")
+		http.Redirect(w, r, helpers.SitePath(r, "/"), http.StatusSeeOther)
 		return
 	}
 
-	room, err := m.DB.GetRoomByID(res.RoomID)
+	room, err := m.db(r).GetRoomByID(r.Context(), res.RoomID)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "unable to retrieve room from database")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		m.Session.Put(r.Context(), "error", "unable to retrieve room from database")
+		http.Redirect(w, r, helpers.SitePath(r, "/"), http.StatusSeeOther)
 		return
 	}
 
 	res.Room.RoomName = room.RoomName
 
-	m.App.Session.Put(r.Context(), "reservation", res)
+	m.Session.Put(r.Context(), "reservation", res)
 
 	sd := res.StartDate.Format("2006-01-02")
 	ed := res.EndDate.Format("2006-01-02")
@@ -90,11 +62,12 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	stringMap := make(map[string]string)
 	stringMap["start_date"] = sd
 	stringMap["end_date"] = ed
+	stringMap["hold_until"] = m.heldUntil(r)
 
 	data := make(map[string]interface{})
 	data["reservation"] = res
 
-	render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
+	m.Render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
 		Form:      forms.New(nil),
 		Data:      data,
 		StringMap: stringMap,
@@ -102,49 +75,49 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 }
 
 // PostReservation handles the posting of a reservation form
-func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
-	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+func (m *Application) PostReservation(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.Session.Get(r.Context(), "reservation").(models.Reservation)
 
 	if !ok {
-		m.App.Session.Put(r.Context(), "can't retrieve reservation from session", "error")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		m.Session.Put(r.Context(), "can't retrieve reservation from session", "error")
+		http.Redirect(w, r, helpers.SitePath(r, "/"), http.StatusSeeOther)
 		return
 	}
 
 	err := r.ParseForm()
 	if err != nil {
-		m.App.Session.Put(r.Context(), "can't parse form", "error")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		m.Session.Put(r.Context(), "can't parse form", "error")
+		http.Redirect(w, r, helpers.SitePath(r, "/"), http.StatusSeeOther)
 		return
 	}
 
 	// Parse and validate dates from form
 	startDate, err := time.Parse("2006-01-02", r.Form.Get("start_date"))
 	if err != nil {
-		m.App.Session.Put(r.Context(), "invalid start date", "error")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		m.Session.Put(r.Context(), "invalid start date", "error")
+		http.Redirect(w, r, helpers.SitePath(r, "/"), http.StatusSeeOther)
 		return
 	}
 
 	endDate, err := time.Parse("2006-01-02", r.Form.Get("end_date"))
 	if err != nil {
-		m.App.Session.Put(r.Context(), "invalid end date", "error")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		m.Session.Put(r.Context(), "invalid end date", "error")
+		http.Redirect(w, r, helpers.SitePath(r, "/"), http.StatusSeeOther)
 		return
 	}
 
 	// Parse and validate room ID from form
 	roomID, err := strconv.Atoi(r.Form.Get("room_id"))
 	if err != nil {
-		m.App.Session.Put(r.Context(), "invalid room id", "error")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		m.Session.Put(r.Context(), "invalid room id", "error")
+		http.Redirect(w, r, helpers.SitePath(r, "/"), http.StatusSeeOther)
 		return
 	}
 
-	room, err := m.DB.GetRoomByID(roomID)
+	room, err := m.db(r).GetRoomByID(r.Context(), roomID)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "invalid room", "error")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		m.Session.Put(r.Context(), "invalid room", "error")
+		http.Redirect(w, r, helpers.SitePath(r, "/"), http.StatusSeeOther)
 		return
 	}
 
@@ -158,89 +131,186 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	reservation.Email = r.Form.Get("email")
 	reservation.Phone = r.Form.Get("phone")
 	reservation.Room = room
+	reservation.AmountDue = reservation.Nights() * room.Price
 
 	form := forms.New(r.PostForm)
 
 	form.Required("first_name", "last_name", "email")
 	form.MinLength("first_name", 3)
 	form.IsEmail("email")
+	if minNights := max(room.MinNights, 1); reservation.Nights() < minNights {
+		form.Errors.Add("end_date", minStayError(minNights))
+	}
 
-	if !form.Valid() {
-		data := make(map[string]interface{})
-		data["reservation"] = reservation
+	if code := models.NormalizeCode(form.Get("promo_code")); code != "" {
+		err = m.applyPromoCode(r, form, &reservation, code)
+		if err != nil {
+			m.serverError(w, r, err)
+			return
+		}
+	}
 
-		render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
-			Form: form,
-			Data: data,
-		})
+	if !form.Valid() {
+		m.renderMakeReservation(w, r, reservation, form)
 		return
 	}
 
-	newReservationID, err := m.DB.InsertReservation(reservation)
+	// the unit kept for the guest while they filled in the form is booked, unless their
+	// hold ended or was for another stay, in which case it is given back and the room
+	// may have been taken since
+	var holdID int
+	if hold, held := m.Session.Get(r.Context(), "room_hold").(models.RoomRestriction); held {
+		if hold.Covers(roomID, startDate, endDate, time.Now()) {
+			holdID = hold.ID
+		} else {
+			m.releaseRoomHold(r)
+		}
+	}
+
+	// with payments online, the room is only held until the guest pays
+	if m.App.Payments != nil && reservation.AmountDue > 0 {
+		reservation.PaymentStatus = models.PaymentPending
+		reservation.HoldUntil = time.Now().Add(m.App.PaymentHold)
+	}
 
+	newReservationID, err := m.db(r).InsertReservation(r.Context(), reservation, holdID)
+	if errors.Is(err, dbrepo.ErrRestrictionConflict) {
+		m.Session.Put(r.Context(), "error", "Sorry, this room was just taken, please search again")
+		http.Redirect(w, r, helpers.SitePath(r, "/search-availability"), http.StatusSeeOther)
+		return
+	}
+	if errors.Is(err, models.ErrPromoUsedUp) || errors.Is(err, models.ErrPromoAlreadyUsed) {
+		form.Errors.Add("promo_code", err.Error())
+		m.renderMakeReservation(w, r, reservation, form)
+		return
+	}
 	if err != nil {
-		m.App.Session.Put(r.Context(), "can't insert reservation", "error")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		m.Session.Put(r.Context(), "can't insert reservation", "error")
+		http.Redirect(w, r, helpers.SitePath(r, "/"), http.StatusSeeOther)
 		return
 	}
+	m.Session.Remove(r.Context(), "room_hold")
 
-	restriction := models.RoomRestriction{
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		RoomID:        reservation.RoomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1, // Assuming 1 is the ID for "Booked" restriction
+	reservation.ID = newReservationID
+	if reservation.PaymentStatus == models.PaymentPending {
+		m.startCheckout(w, r, reservation)
+		return
 	}
 
-	err = m.DB.InsertRoomRestriction(restriction)
+	// Send email notification to guest
+	m.MailChan <- reservationConfirmation(r, reservation)
 
-	htmlMessage := fmt.Sprintf(`<h1>Reservation Confirmation</h1>
-<p>Thank you for your reservation, %s %s.</p>
-<p>Your reservation is from %s to %s.</p>
-<p>We will contact you at %s.</p>`, reservation.FirstName, reservation.LastName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"), reservation.Email)
+	m.Session.Put(r.Context(), "reservation", reservation)
+	http.Redirect(w, r, helpers.SitePath(r, "/reservation-summary"), http.StatusSeeOther)
+}
 
-	// // Send email notification to guest
-	msg := models.MailData{
-		From:     "me@here.com",
-		To:       reservation.Email,
-		Subject:  "Reservation Confirmation",
-		Content:  htmlMessage,
-		Template: "basic",
+// minStayError tells the guest their stay is shorter than the minimum of the room
+func minStayError(minNights int) string {
+	if minNights == 1 {
+		return "The departure must be after the arrival"
 	}
+	return fmt.Sprintf("This room is booked for %d nights or more", minNights)
+}
 
-	m.App.MailChan <- msg
-
+// applyPromoCode takes the discount of the promo code a guest entered off their
+// reservation, or adds to the form why the code can't be used
+func (m *Application) applyPromoCode(r *http.Request, form *forms.Form, res *models.Reservation, code string) error {
+	promo, err := m.db(r).GetPromoCodeByCode(r.Context(), code)
+	if errors.Is(err, sql.ErrNoRows) {
+		form.Errors.Add("promo_code", "Unknown promo code")
+		return nil
+	}
 	if err != nil {
-		m.App.Session.Put(r.Context(), "can't insert room restriction", "error")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
+		return err
+	}
+
+	if err := promo.Check(*res); err != nil {
+		form.Errors.Add("promo_code", err.Error())
+		return nil
 	}
 
-	m.App.Session.Put(r.Context(), "reservation", reservation)
-	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+	res.PromoCodeID = promo.ID
+	res.PromoCode = promo.Code
+	res.Discount = promo.Discount(res.AmountDue)
+	res.AmountDue -= res.Discount
+	return nil
+}
+
+// renderMakeReservation shows the reservation form again with its errors
+func (m *Application) renderMakeReservation(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["reservation"] = res
+
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = res.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = res.EndDate.Format("2006-01-02")
+	stringMap["hold_until"] = m.heldUntil(r)
+
+	m.Render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// reservationConfirmation builds the confirmation email sent to the guest
+func reservationConfirmation(r *http.Request, reservation models.Reservation) models.MailData {
+	htmlMessage := fmt.Sprintf(`<h1>Reservation Confirmation</h1>
+<p>Thank you for your reservation, %s %s.</p>
+<p>Your reservation is from %s to %s.</p>
+<p>We will contact you at %s.</p>`, reservation.FirstName, reservation.LastName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"), reservation.Email)
+
+	return models.MailData{
+		From:      mailFrom,
+		To:        reservation.Email,
+		Subject:   "Reservation Confirmation",
+		Content:   htmlMessage,
+		Template:  "basic",
+		RequestID: middleware.GetReqID(r.Context()),
+	}
 }
 
 // Generals renders the room page
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
+func (m *Application) Generals(w http.ResponseWriter, r *http.Request) {
+	m.Render.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
 }
 
 // Majors renders the room page
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "majors.page.tmpl", &models.TemplateData{})
+func (m *Application) Majors(w http.ResponseWriter, r *http.Request) {
+	m.Render.Template(w, r, "majors.page.tmpl", &models.TemplateData{})
 }
 
 // Availability renders the search availability page
-func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{})
+func (m *Application) Availability(w http.ResponseWriter, r *http.Request) {
+	data := make(map[string]interface{})
+	stringMap := make(map[string]string)
+
+	// a search that found no room comes back with its dates, to suggest others
+	start, err1 := time.Parse("2006-01-02", r.URL.Query().Get("start"))
+	end, err2 := time.Parse("2006-01-02", r.URL.Query().Get("end"))
+	if err1 == nil && err2 == nil && suggestible(start, end) {
+		suggestions, err := m.suggestStays(r, start, end)
+		if err != nil {
+			m.App.Logger.ErrorContext(r.Context(), "error suggesting stays", "error", err)
+		}
+		data["flexible"] = suggestions.Flexible
+		data["split"] = suggestions.Split
+		stringMap["start"] = start.Format("2006-01-02")
+		stringMap["end"] = end.Format("2006-01-02")
+	}
+
+	m.Render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
 }
 
 // PostAvailability handles post
-func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
+func (m *Application) PostAvailability(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Can't parse form")
-		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		m.Session.Put(r.Context(), "error", "Can't parse form")
+		http.Redirect(w, r, helpers.SitePath(r, "/search-availability"), http.StatusSeeOther)
 		return
 	}
 
@@ -251,33 +321,34 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 	layout := "2006-01-02"
 	startDate, err := time.Parse(layout, start)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Invalid start date")
-		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		m.Session.Put(r.Context(), "error", "Invalid start date")
+		http.Redirect(w, r, helpers.SitePath(r, "/search-availability"), http.StatusSeeOther)
 		return
 	}
 	endDate, err := time.Parse(layout, end)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Invalid end date")
-		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		m.Session.Put(r.Context(), "error", "Invalid end date")
+		http.Redirect(w, r, helpers.SitePath(r, "/search-availability"), http.StatusSeeOther)
 		return
 	}
 	// Check availability for all rooms
-	rooms, err := m.DB.SearchAvaibilityForAllRooms(startDate, endDate)
+	rooms, err := m.db(r).SearchAvaibilityForAllRooms(r.Context(), startDate, endDate)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Error searching for rooms")
-		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		m.Session.Put(r.Context(), "error", "Error searching for rooms")
+		http.Redirect(w, r, helpers.SitePath(r, "/search-availability"), http.StatusSeeOther)
 		return
 	}
 	// available rooms
 	for _, room := range rooms {
-		m.App.InfoLog.Println("Room ID:", room.ID, "Room Name:", room.RoomName)
+		m.App.Logger.DebugContext(r.Context(), "room available", "room_id", room.ID, "room_name", room.RoomName)
 
 	}
 
 	// If no rooms are available
 	if len(rooms) == 0 {
-		m.App.Session.Put(r.Context(), "error", "No rooms available for the selected dates")
-		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		m.Session.Put(r.Context(), "error", "No rooms available for the selected dates")
+		q := url.Values{"start": {start}, "end": {end}}
+		http.Redirect(w, r, helpers.SitePath(r, "/search-availability")+"?"+q.Encode(), http.StatusSeeOther)
 		return
 	}
 
@@ -289,9 +360,9 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 		EndDate:   endDate,
 	}
 
-	m.App.Session.Put(r.Context(), "reservation", res)
+	m.Session.Put(r.Context(), "reservation", res)
 
-	render.Template(w, r, "choose-room.page.tmpl", &models.TemplateData{
+	m.Render.Template(w, r, "choose-room.page.tmpl", &models.TemplateData{
 		Data: data,
 	})
 }
@@ -302,10 +373,14 @@ type jsonResponse struct {
 	RoomID    string `json:"room_id"`
 	StartDate string `json:"start_date"`
 	EndDate   string `json:"end_date"`
+	// Alternatives are other dates the room is free for the stay, and SplitStay rooms
+	// to stay in one after the other, when the room isn't available
+	Alternatives []stayJSON `json:"alternatives,omitempty"`
+	SplitStay    []stayJSON `json:"split_stay,omitempty"`
 }
 
 // AvailabilityJSON handles request for availability and sends JSON response
-func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
+func (m *Application) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 	// Need to parse the form values
 	err := r.ParseForm()
 	if err != nil {
@@ -341,7 +416,7 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 
 	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
 
-	available, err := m.DB.SearchAvaibilityByDatesByRoomID(roomID, startDate, endDate)
+	available, err := m.db(r).SearchAvaibilityByDatesByRoomID(r.Context(), roomID, startDate, endDate)
 
 	if err != nil {
 		resp := jsonResponse{
@@ -362,6 +437,14 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 		RoomID:    strconv.Itoa(roomID),
 	}
 
+	if !available && suggestible(startDate, endDate) {
+		suggestions, err := m.suggestStays(r, startDate, endDate)
+		if err != nil {
+			m.App.Logger.ErrorContext(r.Context(), "error suggesting stays", "error", err)
+		}
+		resp.Alternatives, resp.SplitStay = suggestions.alternativesJSON(roomID)
+	}
+
 	out, _ := json.MarshalIndent(resp, "", "     ")
 
 	w.Header().Set("Content-Type", "application/json")
@@ -369,21 +452,32 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 }
 
 // Contact renders the contact page
-func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "contact.page.tmpl", &models.TemplateData{})
+func (m *Application) Contact(w http.ResponseWriter, r *http.Request) {
+	m.Render.Template(w, r, "contact.page.tmpl", &models.TemplateData{})
 }
 
 // ReservationSummary displays the res summary page
-func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
-	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+func (m *Application) ReservationSummary(w http.ResponseWriter, r *http.Request) {
+	reservation, ok := m.Session.Get(r.Context(), "reservation").(models.Reservation)
 	if !ok {
-		m.App.ErrorLog.Println("Can't get reservation from session")
-		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		m.App.Logger.ErrorContext(r.Context(), "can't get reservation from session")
+		m.Session.Put(r.Context(), "error", "Can't get reservation from session")
+		http.Redirect(w, r, helpers.SitePath(r, "/"), http.StatusSeeOther)
 		return
 	}
 
-	m.App.Session.Remove(r.Context(), "reservation")
+	m.Session.Remove(r.Context(), "reservation")
+
+	// the provider may have confirmed the payment since the guest left for the checkout
+	if reservation.PaymentStatus == models.PaymentPending {
+		current, err := m.db(r).GetReservationByID(r.Context(), reservation.ID)
+		if err != nil {
+			m.serverError(w, r, err)
+			return
+		}
+		reservation.PaymentStatus = current.PaymentStatus
+		reservation.HoldUntil = current.HoldUntil
+	}
 
 	data := make(map[string]interface{})
 	data["reservation"] = reservation
@@ -397,14 +491,14 @@ func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request)
 	stringMap["start_date"] = sd
 	stringMap["end_date"] = ed
 
-	render.Template(w, r, "reservation-summary.page.tmpl", &models.TemplateData{
+	m.Render.Template(w, r, "reservation-summary.page.tmpl", &models.TemplateData{
 		Data:      data,
 		StringMap: stringMap,
 	})
 }
 
 // Display list of availaible room
-func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
+func (m *Application) ChooseRoom(w http.ResponseWriter, r *http.Request) {
 	// Try to get ID from chi URL param first, then fall back to extracting from URL path
 	vars := chi.URLParam(r, "id")
 	if vars == "" {
@@ -417,27 +511,87 @@ func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
 
 	roomID, err := strconv.Atoi(vars)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Invalid room ID")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		m.Session.Put(r.Context(), "error", "Invalid room ID")
+		http.Redirect(w, r, helpers.SitePath(r, "/"), http.StatusSeeOther)
 		return
 	}
 
-	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	res, ok := m.Session.Get(r.Context(), "reservation").(models.Reservation)
 	if !ok {
-		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		m.Session.Put(r.Context(), "error", "Can't get reservation from session")
+		http.Redirect(w, r, helpers.SitePath(r, "/"), http.StatusSeeOther)
 		return
 	}
 
 	res.RoomID = roomID
 
-	m.App.Session.Put(r.Context(), "reservation", res)
+	if !m.holdRoom(w, r, res) {
+		return
+	}
+
+	m.Session.Put(r.Context(), "reservation", res)
+
+	http.Redirect(w, r, helpers.SitePath(r, "/make-reservation"), http.StatusSeeOther)
+}
+
+// holdRoom keeps a unit of the room picked by the guest for them while they fill in
+// the reservation form, giving back the room they held before if any. If the room
+// can't be held it redirects with an error and returns false.
+func (m *Application) holdRoom(w http.ResponseWriter, r *http.Request, res models.Reservation) bool {
+	m.releaseRoomHold(r)
+
+	// a stay without dates is checked when the form is posted
+	if !res.EndDate.After(res.StartDate) {
+		return true
+	}
+
+	until := time.Now().Add(m.App.RoomHold)
+	id, err := m.db(r).HoldRoom(r.Context(), res.RoomID, res.StartDate, res.EndDate, until)
+	if errors.Is(err, dbrepo.ErrRestrictionConflict) {
+		m.Session.Put(r.Context(), "error", "Sorry, this room was just taken, please search again")
+		http.Redirect(w, r, helpers.SitePath(r, "/search-availability"), http.StatusSeeOther)
+		return false
+	}
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "error holding room", "room_id", res.RoomID, "error", err)
+		m.Session.Put(r.Context(), "error", "Can't hold the room")
+		http.Redirect(w, r, helpers.SitePath(r, "/"), http.StatusSeeOther)
+		return false
+	}
+
+	m.Session.Put(r.Context(), "room_hold", models.RoomRestriction{
+		ID:            id,
+		RoomID:        res.RoomID,
+		StartDate:     res.StartDate,
+		EndDate:       res.EndDate,
+		RestrictionID: models.RestrictionHold,
+		ExpiresAt:     until,
+	})
+	return true
+}
+
+// releaseRoomHold gives back the room held for the guest, if any
+func (m *Application) releaseRoomHold(r *http.Request) {
+	hold, ok := m.Session.Pop(r.Context(), "room_hold").(models.RoomRestriction)
+	if !ok {
+		return
+	}
+	if err := m.db(r).ReleaseRoomHold(r.Context(), hold.ID); err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "error releasing room hold", "hold_id", hold.ID, "error", err)
+	}
+}
 
-	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+// heldUntil returns when the room held for the guest is given back, empty if none is
+func (m *Application) heldUntil(r *http.Request) string {
+	hold, ok := m.Session.Get(r.Context(), "room_hold").(models.RoomRestriction)
+	if !ok || !hold.Active(time.Now()) {
+		return ""
+	}
+	return hold.ExpiresAt.Format("15:04")
 }
 
 // BookRoom takes URL parameters, builds a sessional variable, and takes user to make res screen
-func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
+func (m *Application) BookRoom(w http.ResponseWriter, r *http.Request) {
 
 	// id , s, e
 	roomID, _ := strconv.Atoi(r.URL.Query().Get("id"))
@@ -450,10 +604,10 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 
 	var res models.Reservation
 
-	room, err := m.DB.GetRoomByID(roomID)
+	room, err := m.db(r).GetRoomByID(r.Context(), roomID)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Room not found")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		m.Session.Put(r.Context(), "error", "Room not found")
+		http.Redirect(w, r, helpers.SitePath(r, "/"), http.StatusSeeOther)
 		return
 	}
 
@@ -461,26 +615,31 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 	res.RoomID = roomID
 	res.StartDate = start_date
 	res.EndDate = end_date
-	m.App.Session.Put(r.Context(), "reservation", res)
+
+	if !m.holdRoom(w, r, res) {
+		return
+	}
+
+	m.Session.Put(r.Context(), "reservation", res)
 
 	// Redirect to make reservation page
-	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+	http.Redirect(w, r, helpers.SitePath(r, "/make-reservation"), http.StatusSeeOther)
 }
 
-func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+func (m *Application) ShowLogin(w http.ResponseWriter, r *http.Request) {
+	m.Render.Template(w, r, "login.page.tmpl", &models.TemplateData{
 		Form: forms.New(nil),
 	})
 }
 
 // handles login user in
-func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
+func (m *Application) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 	// Renew the session token
-	_ = m.App.Session.RenewToken(r.Context())
+	_ = m.Session.RenewToken(r.Context())
 
 	err := r.ParseForm()
 	if err != nil {
-		m.App.ErrorLog.Println("Error parsing form:", err)
+		m.App.Logger.ErrorContext(r.Context(), "error parsing form", "error", err)
 	}
 
 	var email = r.Form.Get("email")
@@ -491,75 +650,50 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 	form.Required("email", "password")
 
 	if !form.Valid() {
-		render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+		m.Render.Template(w, r, "login.page.tmpl", &models.TemplateData{
 			Form: form,
 		})
 		return
 	}
 
-	id, _, err := m.DB.Authenticate(email, password)
+	id, _, err := m.DB.Authenticate(r.Context(), email, password)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
-		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		m.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(w, r, helpers.SitePath(r, "/user/login"), http.StatusSeeOther)
 		return
 	}
 	// If we get here, authentication was successful
-	m.App.Session.Put(r.Context(), "user_id", id)
-	m.App.Session.Put(r.Context(), "flash", "Login successfully")
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	m.Session.Put(r.Context(), "user_id", id)
+	m.Session.Put(r.Context(), "flash", "Login successfully")
+	http.Redirect(w, r, helpers.SitePath(r, "/"), http.StatusSeeOther)
 }
 
 // Logout handles the logout process
-func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
+func (m *Application) Logout(w http.ResponseWriter, r *http.Request) {
 
-	_ = m.App.Session.Destroy(r.Context())
+	_ = m.Session.Destroy(r.Context())
 
-	m.App.Session.RenewToken(r.Context())
-	m.App.Session.Put(r.Context(), "flash", "You have been logged out")
-
-	http.Redirect(w, r, "/", http.StatusSeeOther)
-}
+	m.Session.RenewToken(r.Context())
+	m.Session.Put(r.Context(), "flash", "You have been logged out")
 
-func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
+	http.Redirect(w, r, helpers.SitePath(r, "/"), http.StatusSeeOther)
 }
 
 // Show all new reservations in admin tool
-func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllNewReservations()
-	if err != nil {
-		m.App.ErrorLog.Println("Error retrieving reservations:", err)
-	}
-
-	data := make(map[string]interface{})
-	data["reservations"] = reservations
-
-	render.Template(w, r, "admin-new-reservations.page.tmpl", &models.TemplateData{
-		Data: data,
-	})
+func (m *Application) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
+	m.renderReservationList(w, r, "new", "admin-new-reservations.page.tmpl")
 }
 
 // Show all reservations in admin tool
-func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllReservations()
-	if err != nil {
-		m.App.ErrorLog.Println("Error retrieving reservations:", err)
-	}
-
-	data := make(map[string]interface{})
-	data["reservations"] = reservations
-
-	render.Template(w, r, "admin-all-reservations.page.tmpl", &models.TemplateData{
-		Data: data,
-	})
-
+func (m *Application) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
+	m.renderReservationList(w, r, "all", "admin-all-reservations.page.tmpl")
 }
 
-func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
+func (m *Application) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
 	exploded := strings.Split(r.RequestURI, "/")
 	roomID, err := strconv.Atoi(exploded[4])
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.serverError(w, r, err)
 		return
 	}
 
@@ -573,26 +707,115 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 	stringMap["year"] = year
 	stringMap["month"] = month
 
-	reservation, err := m.DB.GetReservationByID(roomID)
+	reservation, err := m.db(r).GetReservationByID(r.Context(), roomID)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	m.renderShowReservation(w, r, reservation, forms.New(nil), stringMap)
+}
+
+// renderShowReservation shows the reservation edit page
+func (m *Application) renderShowReservation(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form, stringMap map[string]string) {
+	rooms, err := m.db(r).AllRooms(r.Context())
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.serverError(w, r, err)
 		return
 	}
 
+	// keep what was posted when the page is shown again with errors
+	intMap := map[string]int{"room_id": res.RoomID}
+	if roomID, err := strconv.Atoi(form.Get("room_id")); err == nil {
+		intMap["room_id"] = roomID
+	}
+	stringMap["start_date"] = res.StartDate.Format("2006-01-02")
+	if form.Has("start_date") {
+		stringMap["start_date"] = form.Get("start_date")
+	}
+	stringMap["end_date"] = res.EndDate.Format("2006-01-02")
+	if form.Has("end_date") {
+		stringMap["end_date"] = form.Get("end_date")
+	}
+
 	data := make(map[string]interface{})
-	data["reservation"] = reservation
+	data["reservation"] = res
+	data["rooms"] = rooms
+
+	if res.GuestID > 0 {
+		guest, err := m.db(r).GetGuestByID(r.Context(), res.GuestID)
+		if err != nil {
+			m.serverError(w, r, err)
+			return
+		}
+		data["guest"] = guest
+	}
+
+	notes, err := m.db(r).ReservationNotes(r.Context(), res.ID)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	data["notes"] = notes
+
+	messages, err := m.db(r).ReservationMessages(r.Context(), res.ID)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	data["messages"] = messages
+
+	paid, err := m.db(r).ReservationPayments(r.Context(), res.ID)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	data["payments"] = paid
+	data["payment_kinds"] = models.PaymentKinds
+	data["payment_methods"] = models.PaymentMethods
+	intMap["balance"] = models.Balance(res.Owed(), paid)
+	intMap["paid"] = res.Owed() - intMap["balance"]
+
+	if res.Cancellable(time.Now()) {
+		policy, fee, err := m.cancellationFee(r, res)
+		if err != nil {
+			m.serverError(w, r, err)
+			return
+		}
+		data["cancellation_policy"] = policy
+		intMap["cancellation_fee"] = fee
+	}
+
+	inv, err := m.db(r).InvoiceForReservation(r.Context(), res.ID)
+	switch {
+	case err == nil:
+		data["invoice"] = inv
+	case !errors.Is(err, sql.ErrNoRows):
+		m.serverError(w, r, err)
+		return
+	}
+
+	token, err := m.db(r).GuestToken(r.Context(), res.ID)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	stringMap["guest_link"] = m.guestLink(r, token)
 
-	render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
+	m.Render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
 		StringMap: stringMap,
+		IntMap:    intMap,
 		Data:      data,
-		Form:      forms.New(nil),
+		Form:      form,
 	})
 }
 
-func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
+// AdminPostShowReservation saves the guest details of a reservation and, when they
+// changed, moves it to the new room and dates if they are free
+func (m *Application) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		m.App.Session.Put(r.Context(), "can't parse form", "error")
+		m.Session.Put(r.Context(), "can't parse form", "error")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
@@ -600,7 +823,7 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 	exploded := strings.Split(r.RequestURI, "/")
 	roomID, err := strconv.Atoi(exploded[4])
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.serverError(w, r, err)
 		return
 	}
 
@@ -608,10 +831,15 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 	stringMap := make(map[string]string)
 	stringMap["src"] = src
 
+	month := r.Form.Get("month")
+	year := r.Form.Get("year")
+	stringMap["year"] = year
+	stringMap["month"] = month
+
 	// Get the reservation details
-	res, err := m.DB.GetReservationByID(roomID)
+	res, err := m.db(r).GetReservationByID(r.Context(), roomID)
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.serverError(w, r, err)
 		return
 	}
 	res.FirstName = r.Form.Get("first_name")
@@ -619,131 +847,138 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 	res.Email = r.Form.Get("email")
 	res.Phone = r.Form.Get("phone")
 
+	form := forms.New(r.PostForm)
+	moved, err := m.moveReservation(r, &res, form)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	if !form.Valid() {
+		m.renderShowReservation(w, r, res, form, stringMap)
+		return
+	}
+
 	// Update the reservation in the database
-	err = m.DB.UpdateReservation(res)
+	err = m.db(r).UpdateReservation(r.Context(), res)
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.serverError(w, r, err)
 		return
 	}
 
-	month := r.Form.Get("month")
-	year := r.Form.Get("year")
+	if moved && form.Has("notify") && res.Email != "" {
+		m.MailChan <- reservationChanged(r, res)
+	}
+
+	m.Session.Put(r.Context(), "flash", "Reservation updated successfully")
 
 	if year == "" {
 		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
 	} else {
 		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
 	}
-
-	m.App.Session.Put(r.Context(), "flash", "Reservation updated successfully")
 }
 
-func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Request) {
-	// there is  no month or year specified, so we just render the calendar page
-	now := time.Now()
+// moveReservation applies the room and dates posted in form to res, and saves them if
+// they changed. Problems with the posted values are added to the form errors, and
+// it reports whether the reservation was moved.
+func (m *Application) moveReservation(r *http.Request, res *models.Reservation, form *forms.Form) (bool, error) {
+	moved := *res
 
-	if r.URL.Query().Get("y") != "" {
-		year, _ := strconv.Atoi(r.URL.Query().Get("y"))
-		month, _ := strconv.Atoi(r.URL.Query().Get("m"))
-		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	if form.Has("room_id") {
+		roomID, err := strconv.Atoi(form.Get("room_id"))
+		if err != nil {
+			form.Errors.Add("room_id", "Choose a room")
+		}
+		moved.RoomID = roomID
 	}
-
-	data := make(map[string]interface{})
-	data["now"] = now
-
-	next := now.AddDate(0, 1, 0)
-	last := now.AddDate(0, -1, 0)
-
-	nextMonth := next.Format("01")
-	nextMonthYear := next.Format("2006")
-
-	lastMonth := last.Format("01")
-	lastMonthYear := last.Format("2006")
-
-	stringMap := make(map[string]string)
-	stringMap["next_month"] = nextMonth
-	stringMap["next_month_year"] = nextMonthYear
-	stringMap["last_month"] = lastMonth
-	stringMap["last_month_year"] = lastMonthYear
-
-	stringMap["this_month"] = now.Format("01")
-	stringMap["this_month_year"] = now.Format("2006")
-
-	// get and first and last days of the month
-	currentYear, currentMonth, _ := now.Date()
-	currentLocation := now.Location()
-
-	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
-	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
-
-	intMap := make(map[string]int)
-	intMap["days_in_month"] = lastOfMonth.Day()
-
-	// Get All the rooms
-	rooms, err := m.DB.AllRooms()
-	if err != nil {
-		helpers.ServerError(w, err)
-		return
+	if form.Has("start_date") {
+		start, err := time.Parse("2006-01-02", form.Get("start_date"))
+		if err != nil {
+			form.Errors.Add("start_date", "Invalid date")
+		}
+		moved.StartDate = start
+	}
+	if form.Has("end_date") {
+		end, err := time.Parse("2006-01-02", form.Get("end_date"))
+		if err != nil {
+			form.Errors.Add("end_date", "Invalid date")
+		}
+		moved.EndDate = end
+	}
+	if !form.Valid() {
+		return false, nil
 	}
 
-	data["rooms"] = rooms
-
-	for _, x := range rooms {
-		reservationMap := make(map[string]int)
-		blockMap := make(map[string]int)
+	if moved.RoomID == res.RoomID && moved.StartDate.Equal(res.StartDate) && moved.EndDate.Equal(res.EndDate) {
+		return false, nil
+	}
 
-		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
-			reservationMap[d.Format("2006-01-02")] = 0
-			blockMap[d.Format("2006-01-02")] = 0
-		}
+	if !moved.EndDate.After(moved.StartDate) {
+		form.Errors.Add("end_date", "Departure must be after arrival")
+		return false, nil
+	}
 
-		// get all the restrictions for current room
-		restrictions, err := m.DB.GetRestrictionsForRoomByDate(x.ID, firstOfMonth, lastOfMonth)
+	if moved.RoomID != res.RoomID {
+		room, err := m.db(r).GetRoomByID(r.Context(), moved.RoomID)
 		if err != nil {
-			helpers.ServerError(w, err)
-			return
-		}
-
-		for _, y := range restrictions {
-			if y.ReservationID > 0 {
-				//  it's reservation
-				for d := y.StartDate; d.After(y.EndDate) == false; d = d.AddDate(0, 0, 1) {
-					dateKey := d.Format("2006-01-02")
-					reservationMap[dateKey] = y.ReservationID
-				}
-			} else {
-				// It's a block - should span the entire date range like reservations
-				for d := y.StartDate; d.After(y.EndDate) == false; d = d.AddDate(0, 0, 1) {
-					dateKey := d.Format("2006-01-02")
-					blockMap[dateKey] = y.ID
-				}
-			}
+			form.Errors.Add("room_id", "Choose a room")
+			return false, nil
 		}
-		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
-		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
+		moved.Room = room
+	}
 
-		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
+	// the reservation's own nights don't count against it
+	available, err := m.db(r).SearchAvailabilityExcludingReservation(r.Context(), moved.RoomID, moved.StartDate, moved.EndDate, moved.ID)
+	if err != nil {
+		return false, err
+	}
+	if !available {
+		form.Errors.Add("start_date", "The room is not available for these dates")
+		return false, nil
+	}
 
+	err = m.db(r).MoveReservation(r.Context(), moved)
+	if errors.Is(err, dbrepo.ErrRestrictionConflict) {
+		form.Errors.Add("start_date", "The room is not available for these dates")
+		return false, nil
+	}
+	if err != nil {
+		return false, err
 	}
 
-	render.Template(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{
-		StringMap: stringMap,
-		Data:      data,
-		IntMap:    intMap,
-	})
+	*res = moved
+	return true, nil
+}
+
+// reservationChanged builds the email telling the guest their reservation was moved
+func reservationChanged(r *http.Request, reservation models.Reservation) models.MailData {
+	htmlMessage := fmt.Sprintf(`<h1>Reservation Changed</h1>
+<p>Dear %s %s,</p>
+<p>Your reservation has been changed. You are now staying in the %s from %s to %s.</p>`,
+		reservation.FirstName, reservation.LastName, reservation.Room.RoomName,
+		reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
+
+	return models.MailData{
+		From:      mailFrom,
+		To:        reservation.Email,
+		Subject:   "Reservation Changed",
+		Content:   htmlMessage,
+		Template:  "basic",
+		RequestID: middleware.GetReqID(r.Context()),
+	}
 }
 
 // AdminProcessReservation mark a reservation as processed
-func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
+func (m *Application) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	src := chi.URLParam(r, "src")
 
-	_ = m.DB.UpdateProcessedForReservation(id, 1)
+	_ = m.db(r).UpdateProcessedForReservation(r.Context(), id, 1)
 
 	year := r.URL.Query().Get("y")
 	month := r.URL.Query().Get("m")
 
-	m.App.Session.Put(r.Context(), "flash", "Reservation processed successfully")
+	m.Session.Put(r.Context(), "flash", "Reservation processed successfully")
 
 	if year == "" {
 		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
@@ -753,17 +988,17 @@ func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Requ
 }
 
 // AdminProcessReservation delete a reservation
-func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
+func (m *Application) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	src := chi.URLParam(r, "src")
 
-	_ = m.DB.DeleteReservation(id)
-	m.App.Session.Put(r.Context(), "flash", "Reservation deleted successfully")
+	_ = m.db(r).DeleteReservation(r.Context(), id)
+	m.Session.Put(r.Context(), "flash", "Reservation deleted successfully")
 
 	year := r.URL.Query().Get("y")
 	month := r.URL.Query().Get("m")
 
-	m.App.Session.Put(r.Context(), "flash", "Reservation processed successfully")
+	m.Session.Put(r.Context(), "flash", "Reservation processed successfully")
 
 	if year == "" {
 		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
@@ -772,66 +1007,3 @@ func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Reque
 	}
 
 }
-
-// handle post of reservation calendar
-func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
-	// Handle the form submission for the reservations calendar
-	err := r.ParseForm()
-	if err != nil {
-		helpers.ServerError(w, err)
-		return
-	}
-
-	year, _ := strconv.Atoi(r.Form.Get("y"))
-	month, _ := strconv.Atoi(r.Form.Get("m"))
-
-	// Process blocks
-	rooms, err := m.DB.AllRooms()
-	if err != nil {
-		helpers.ServerError(w, err)
-		return
-	}
-	form := forms.New(r.PostForm)
-
-	for _, x := range rooms {
-		// Get the block map from the session. Loop through entire map and if we have an entry in the map
-		// that does not exist in our posted data, and if the restriction id > 0, then it is a block we need to remove.
-		curMap := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", x.ID)).(map[string]int)
-		for name, value := range curMap {
-			// ok will be false if the value is not in the map
-			if val, ok := curMap[name]; ok {
-				// only pay attention to values > 0 and that are not in the form post
-				// the rest are just placeholders for days without blocks
-				if val > 0 {
-					if !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, name)) {
-						// delete the restriction by id
-						err := m.DB.DeleteBlockByID(value)
-						if err != nil {
-							log.Println("error deleting block:", err)
-						}
-					}
-				}
-			}
-		}
-	}
-
-	// now handle new blocks
-	for name := range r.PostForm {
-		if strings.HasPrefix(name, "add_block") {
-			exploded := strings.Split(name, "_")
-			if len(exploded) >= 4 {
-				roomID, _ := strconv.Atoi(exploded[2])
-				t, _ := time.Parse("2006-01-02", exploded[3])
-
-				// insert a new block
-				err := m.DB.InsertBlockForRoom(roomID, t)
-				if err != nil {
-					log.Println("error inserting block:", err)
-				}
-			}
-		}
-	}
-
-	m.App.Session.Put(r.Context(), "flash", "Reservations calendar updated successfully")
-	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
-}
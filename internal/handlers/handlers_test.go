@@ -28,6 +28,7 @@ var theTests = []struct {
 	method             string
 	expectedStatusCode int
 }{
+	{"healthz", "/healthz", "GET", http.StatusOK},
 	{"home", "/", "GET", http.StatusOK},
 	{"about", "/about", "GET", http.StatusOK},
 	{"gq", "/generals-quarters", "GET", http.StatusOK},
@@ -38,16 +39,27 @@ var theTests = []struct {
 	{"login", "/user/login", "GET", http.StatusOK},
 	{"logout", "/user/logout", "GET", http.StatusOK},
 	{"dashboard", "/admin/dashboard", "GET", http.StatusOK},
+	{"dashboard occupancy", "/admin/dashboard/occupancy?days=30", "GET", http.StatusOK},
+	{"dashboard occupancy bad window", "/admin/dashboard/occupancy?days=7", "GET", http.StatusBadRequest},
+	{"dashboard pace", "/admin/dashboard/pace", "GET", http.StatusOK},
 	{"new res", "/admin/reservations-new", "GET", http.StatusOK},
 	{"all res", "/admin/reservations-all", "GET", http.StatusOK},
+	{"reports", "/admin/reports", "GET", http.StatusOK},
+	{"import", "/admin/import", "GET", http.StatusOK},
+	{"admin new reservation", "/admin/reservations/create?room_id=1", "GET", http.StatusOK},
 	{"show res", "/admin/reservations/new/1/show", "GET", http.StatusOK},
 	{"show res cal", "/admin/reservations-calendar", "GET", http.StatusOK},
 	{"show res cal with params", "/admin/reservations-calendar?y=2020&m=1", "GET", http.StatusOK},
+	{"show res cal week", "/admin/reservations-calendar?zoom=week&date=2040-01-07", "GET", http.StatusOK},
+	{"show res cal quarter", "/admin/reservations-calendar?zoom=quarter&date=2040-05-20", "GET", http.StatusOK},
 }
 
 // TestHandlers tests all routes that don't require extra tests (gets)
 func TestHandlers(t *testing.T) {
-	routes := getRoutes()
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	routes := getRoutes(repo)
 	ts := httptest.NewTLSServer(routes)
 	defer ts.Close()
 
@@ -108,6 +120,9 @@ var reservationTests = []struct {
 
 // TestReservation tests the reservation handler
 func TestReservation(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
 	for _, e := range reservationTests {
 		req, _ := http.NewRequest("GET", "/make-reservation", nil)
 		ctx := getCtx(req)
@@ -118,7 +133,7 @@ func TestReservation(t *testing.T) {
 			session.Put(ctx, "reservation", e.reservation)
 		}
 
-		handler := http.HandlerFunc(Repo.Reservation)
+		handler := http.HandlerFunc(repo.Reservation)
 		handler.ServeHTTP(rr, req)
 
 		if rr.Code != e.expectedStatusCode {
@@ -153,6 +168,21 @@ var postReservationTests = []struct {
 }{
 	{
 		name: "valid-data",
+		postedData: url.Values{
+			"start_date": {"2040-01-01"},
+			"end_date":   {"2040-01-02"},
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+			"room_id":    {"1"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedHTML:         "",
+		expectedLocation:     "/reservation-summary",
+	},
+	{
+		name: "room-taken",
 		postedData: url.Values{
 			"start_date": {"2050-01-01"},
 			"end_date":   {"2050-01-02"},
@@ -164,7 +194,7 @@ var postReservationTests = []struct {
 		},
 		expectedResponseCode: http.StatusSeeOther,
 		expectedHTML:         "",
-		expectedLocation:     "/reservation-summary",
+		expectedLocation:     "/search-availability",
 	},
 	{
 		name:                 "missing-post-body",
@@ -267,6 +297,9 @@ var postReservationTests = []struct {
 
 // TestPostReservation tests the PostReservation handler
 func TestPostReservation(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
 	for _, e := range postReservationTests {
 		var req *http.Request
 		if e.postedData != nil {
@@ -294,7 +327,7 @@ func TestPostReservation(t *testing.T) {
 
 		rr := httptest.NewRecorder()
 
-		handler := http.HandlerFunc(Repo.PostReservation)
+		handler := http.HandlerFunc(repo.PostReservation)
 
 		handler.ServeHTTP(rr, req)
 
@@ -321,12 +354,15 @@ func TestPostReservation(t *testing.T) {
 	}
 }
 
-func TestNewRepo(t *testing.T) {
+func TestNewApplication(t *testing.T) {
 	var db driver.DB
-	testRepo := NewRepo(&app, &db)
+	testApp := NewApplication(&app, &db, session, nil)
 
-	if reflect.TypeOf(testRepo).String() != "*handlers.Repository" {
-		t.Errorf("Did not get correct type from NewRepo: got %s, wanted *Repository", reflect.TypeOf(testRepo).String())
+	if reflect.TypeOf(testApp).String() != "*handlers.Application" {
+		t.Errorf("Did not get correct type from NewApplication: got %s, wanted *Application", reflect.TypeOf(testApp).String())
+	}
+	if testApp.Render == nil || testApp.Render.Session != session {
+		t.Error("expected the renderer to use the session of the application")
 	}
 }
 
@@ -374,6 +410,9 @@ var testAvailabilityJSONData = []struct {
 
 // TestAvailabilityJSON tests the AvailabilityJSON handler
 func TestAvailabilityJSON(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
 	for _, e := range testAvailabilityJSONData {
 		// create request, get the context with session, set header, create recorder
 		var req *http.Request
@@ -388,7 +427,7 @@ func TestAvailabilityJSON(t *testing.T) {
 		rr := httptest.NewRecorder()
 
 		// make our handler a http.HandlerFunc and call
-		handler := http.HandlerFunc(Repo.AvailabilityJSON)
+		handler := http.HandlerFunc(repo.AvailabilityJSON)
 		handler.ServeHTTP(rr, req)
 
 		var j jsonResponse
@@ -417,6 +456,7 @@ var testPostAvailabilityData = []struct {
 			"end":   {"2050-01-02"},
 		},
 		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search-availability?end=2050-01-02&start=2050-01-01",
 	},
 	{
 		name: "rooms are available",
@@ -461,6 +501,9 @@ var testPostAvailabilityData = []struct {
 
 // TestPostAvailability tests the PostAvailabilityHandler
 func TestPostAvailability(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
 	for _, e := range testPostAvailabilityData {
 		req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(e.postedData.Encode()))
 
@@ -473,12 +516,18 @@ func TestPostAvailability(t *testing.T) {
 		rr := httptest.NewRecorder()
 
 		// make our handler a http.HandlerFunc and call
-		handler := http.HandlerFunc(Repo.PostAvailability)
+		handler := http.HandlerFunc(repo.PostAvailability)
 		handler.ServeHTTP(rr, req)
 
 		if rr.Code != e.expectedStatusCode {
 			t.Errorf("%s gave wrong status code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
 		}
+
+		if e.expectedLocation != "" {
+			if location, _ := rr.Result().Location(); location.String() != e.expectedLocation {
+				t.Errorf("%s: expected location %s, got %s", e.name, e.expectedLocation, location)
+			}
+		}
 	}
 }
 
@@ -503,6 +552,29 @@ var reservationSummaryTests = []struct {
 		expectedStatusCode: http.StatusOK,
 		expectedLocation:   "",
 	},
+	{
+		name: "res-pending-payment",
+		reservation: models.Reservation{
+			ID:            1,
+			RoomID:        1,
+			AmountDue:     20000,
+			PaymentStatus: models.PaymentPending,
+		},
+		url:                "/reservation-summary",
+		expectedStatusCode: http.StatusOK,
+		expectedLocation:   "",
+	},
+	{
+		name: "res-pending-payment-missing",
+		reservation: models.Reservation{
+			ID:            3,
+			RoomID:        1,
+			PaymentStatus: models.PaymentPending,
+		},
+		url:                "/reservation-summary",
+		expectedStatusCode: http.StatusInternalServerError,
+		expectedLocation:   "",
+	},
 	{
 		name:               "res-not-in-session",
 		reservation:        models.Reservation{},
@@ -514,6 +586,9 @@ var reservationSummaryTests = []struct {
 
 // TestReservationSummary tests the ReservationSummaryHandler
 func TestReservationSummary(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
 	for _, e := range reservationSummaryTests {
 		req, _ := http.NewRequest("GET", e.url, nil)
 		ctx := getCtx(req)
@@ -524,7 +599,7 @@ func TestReservationSummary(t *testing.T) {
 			session.Put(ctx, "reservation", e.reservation)
 		}
 
-		handler := http.HandlerFunc(Repo.ReservationSummary)
+		handler := http.HandlerFunc(repo.ReservationSummary)
 
 		handler.ServeHTTP(rr, req)
 
@@ -562,6 +637,39 @@ var chooseRoomTests = []struct {
 		expectedStatusCode: http.StatusSeeOther,
 		expectedLocation:   "/make-reservation",
 	},
+	{
+		name: "room-held",
+		reservation: models.Reservation{
+			RoomID:    1,
+			StartDate: time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2040, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+		url:                "/choose-room/1",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/make-reservation",
+	},
+	{
+		name: "room-taken",
+		reservation: models.Reservation{
+			RoomID:    1,
+			StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+		url:                "/choose-room/1",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search-availability",
+	},
+	{
+		name: "hold-fails",
+		reservation: models.Reservation{
+			RoomID:    1,
+			StartDate: time.Date(2060, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2060, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+		url:                "/choose-room/1",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/",
+	},
 	{
 		name:               "reservation-not-in-session",
 		reservation:        models.Reservation{},
@@ -580,6 +688,9 @@ var chooseRoomTests = []struct {
 
 // TestChooseRoom tests the ChooseRoom handler
 func TestChooseRoom(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
 	for _, e := range chooseRoomTests {
 		req, _ := http.NewRequest("GET", e.url, nil)
 		ctx := getCtx(req)
@@ -592,7 +703,7 @@ func TestChooseRoom(t *testing.T) {
 			session.Put(ctx, "reservation", e.reservation)
 		}
 
-		handler := http.HandlerFunc(Repo.ChooseRoom)
+		handler := http.HandlerFunc(repo.ChooseRoom)
 		handler.ServeHTTP(rr, req)
 
 		if rr.Code != e.expectedStatusCode {
@@ -608,6 +719,90 @@ func TestChooseRoom(t *testing.T) {
 	}
 }
 
+// TestChooseRoomHold tests that picking a room holds it for the guest, and gives back
+// the room they held before
+func TestChooseRoomHold(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	req, _ := http.NewRequest("GET", "/choose-room/1", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.RequestURI = "/choose-room/1"
+
+	session.Put(ctx, "reservation", models.Reservation{
+		StartDate: time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2040, 1, 2, 0, 0, 0, 0, time.UTC),
+	})
+	session.Put(ctx, "room_hold", models.RoomRestriction{ID: 5, RoomID: 2})
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(repo.ChooseRoom).ServeHTTP(rr, req)
+
+	hold, ok := session.Get(ctx, "room_hold").(models.RoomRestriction)
+	if !ok || hold.ID != 1 || hold.RoomID != 1 || hold.RestrictionID != models.RestrictionHold {
+		t.Fatalf("expected room 1 held in session, got %+v", hold)
+	}
+	if d := time.Until(hold.ExpiresAt); d <= 9*time.Minute || d > 10*time.Minute {
+		t.Errorf("expected the hold to end in 10 minutes, got %s", d)
+	}
+}
+
+var postReservationHoldTests = []struct {
+	name             string
+	year             int
+	hold             models.RoomRestriction
+	expectedLocation string
+	expectedHold     bool
+}{
+	{"held", 2050, models.RoomRestriction{ID: 1, RoomID: 1, ExpiresAt: time.Now().Add(time.Hour)}, "/reservation-summary", false},
+	{"expired-still-free", 2040, models.RoomRestriction{ID: 1, RoomID: 1, ExpiresAt: time.Now().Add(-time.Minute)}, "/reservation-summary", false},
+	{"expired-and-taken", 2050, models.RoomRestriction{ID: 1, RoomID: 1, ExpiresAt: time.Now().Add(-time.Minute)}, "/search-availability", false},
+	{"other-room-taken", 2050, models.RoomRestriction{ID: 1, RoomID: 2, ExpiresAt: time.Now().Add(time.Hour)}, "/search-availability", false},
+	{"insert-error", 2060, models.RoomRestriction{ID: 1, RoomID: 1, ExpiresAt: time.Now().Add(time.Hour)}, "/", true},
+}
+
+// TestPostReservationHold tests booking a room held for the guest, or no longer held
+func TestPostReservationHold(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	for _, e := range postReservationHoldTests {
+		start := time.Date(e.year, 1, 1, 0, 0, 0, 0, time.UTC)
+		postedData := url.Values{
+			"start_date": {start.Format("2006-01-02")},
+			"end_date":   {start.AddDate(0, 0, 1).Format("2006-01-02")},
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"room_id":    {"1"},
+		}
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		e.hold.StartDate = start
+		e.hold.EndDate = start.AddDate(0, 0, 1)
+		e.hold.RestrictionID = models.RestrictionHold
+		session.Put(ctx, "reservation", models.Reservation{RoomID: 1})
+		session.Put(ctx, "room_hold", e.hold)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(repo.PostReservation).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		if loc, _ := rr.Result().Location(); loc.String() != e.expectedLocation {
+			t.Errorf("%s: expected location %s, got %s", e.name, e.expectedLocation, loc)
+		}
+		if _, held := session.Get(ctx, "room_hold").(models.RoomRestriction); held != e.expectedHold {
+			t.Errorf("%s: expected hold in session %t, got %t", e.name, e.expectedHold, held)
+		}
+	}
+}
+
 // bookRoomTests is the data for the BookRoom handler tests
 var bookRoomTests = []struct {
 	name               string
@@ -628,6 +823,9 @@ var bookRoomTests = []struct {
 
 // TestBookRoom tests the BookRoom handler
 func TestBookRoom(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
 	reservation := models.Reservation{
 		RoomID: 1,
 		Room: models.Room{
@@ -644,7 +842,7 @@ func TestBookRoom(t *testing.T) {
 		rr := httptest.NewRecorder()
 		session.Put(ctx, "reservation", reservation)
 
-		handler := http.HandlerFunc(Repo.BookRoom)
+		handler := http.HandlerFunc(repo.BookRoom)
 
 		handler.ServeHTTP(rr, req)
 
@@ -686,6 +884,9 @@ var loginTests = []struct {
 }
 
 func TestLogin(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
 	// range through all tests
 	for _, e := range loginTests {
 		postedData := url.Values{}
@@ -702,7 +903,7 @@ func TestLogin(t *testing.T) {
 		rr := httptest.NewRecorder()
 
 		// call the handler
-		handler := http.HandlerFunc(Repo.PostShowLogin)
+		handler := http.HandlerFunc(repo.PostShowLogin)
 		handler.ServeHTTP(rr, req)
 
 		if rr.Code != e.expectedStatusCode {
@@ -781,6 +982,9 @@ var adminPostShowReservationTests = []struct {
 
 // TestAdminPostShowReservation tests the AdminPostReservation handler
 func TestAdminPostShowReservation(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
 	for _, e := range adminPostShowReservationTests {
 		var req *http.Request
 		if e.postedData != nil {
@@ -797,7 +1001,7 @@ func TestAdminPostShowReservation(t *testing.T) {
 		rr := httptest.NewRecorder()
 
 		// call the handler
-		handler := http.HandlerFunc(Repo.AdminPostShowReservation)
+		handler := http.HandlerFunc(repo.AdminPostShowReservation)
 		handler.ServeHTTP(rr, req)
 
 		if rr.Code != e.expectedResponseCode {
@@ -823,90 +1027,6 @@ func TestAdminPostShowReservation(t *testing.T) {
 	}
 }
 
-var adminPostReservationCalendarTests = []struct {
-	name                 string
-	postedData           url.Values
-	expectedResponseCode int
-	expectedLocation     string
-	expectedHTML         string
-	blocks               int
-	reservations         int
-}{
-	{
-		name: "cal",
-		postedData: url.Values{
-			"year":  {time.Now().Format("2006")},
-			"month": {time.Now().Format("01")},
-			fmt.Sprintf("add_block_1_%s", time.Now().AddDate(0, 0, 2).Format("2006-01-2")): {"1"},
-		},
-		expectedResponseCode: http.StatusSeeOther,
-	},
-	{
-		name:                 "cal-blocks",
-		postedData:           url.Values{},
-		expectedResponseCode: http.StatusSeeOther,
-		blocks:               1,
-	},
-	{
-		name:                 "cal-res",
-		postedData:           url.Values{},
-		expectedResponseCode: http.StatusSeeOther,
-		reservations:         1,
-	},
-}
-
-func TestPostReservationCalendar(t *testing.T) {
-	for _, e := range adminPostReservationCalendarTests {
-		var req *http.Request
-		if e.postedData != nil {
-			req, _ = http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(e.postedData.Encode()))
-		} else {
-			req, _ = http.NewRequest("POST", "/admin/reservations-calendar", nil)
-		}
-		ctx := getCtx(req)
-		req = req.WithContext(ctx)
-
-		now := time.Now()
-		bm := make(map[string]int)
-		rm := make(map[string]int)
-
-		currentYear, currentMonth, _ := now.Date()
-		currentLocation := now.Location()
-
-		firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
-		lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
-
-		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
-			rm[d.Format("2006-01-2")] = 0
-			bm[d.Format("2006-01-2")] = 0
-		}
-
-		if e.blocks > 0 {
-			bm[firstOfMonth.Format("2006-01-2")] = e.blocks
-		}
-
-		if e.reservations > 0 {
-			rm[lastOfMonth.Format("2006-01-2")] = e.reservations
-		}
-
-		session.Put(ctx, "block_map_1", bm)
-		session.Put(ctx, "reservation_map_1", rm)
-
-		// set the header
-		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-		rr := httptest.NewRecorder()
-
-		// call the handler
-		handler := http.HandlerFunc(Repo.AdminPostReservationsCalendar)
-		handler.ServeHTTP(rr, req)
-
-		if rr.Code != e.expectedResponseCode {
-			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
-		}
-
-	}
-}
-
 var adminProcessReservationTests = []struct {
 	name                 string
 	queryParams          string
@@ -928,6 +1048,9 @@ var adminProcessReservationTests = []struct {
 }
 
 func TestAdminProcessReservation(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
 	for _, e := range adminProcessReservationTests {
 		req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/process-reservation/cal/1/do%s", e.queryParams), nil)
 		ctx := getCtx(req)
@@ -935,7 +1058,7 @@ func TestAdminProcessReservation(t *testing.T) {
 
 		rr := httptest.NewRecorder()
 
-		handler := http.HandlerFunc(Repo.AdminProcessReservation)
+		handler := http.HandlerFunc(repo.AdminProcessReservation)
 		handler.ServeHTTP(rr, req)
 
 		if rr.Code != http.StatusSeeOther {
@@ -965,6 +1088,9 @@ var adminDeleteReservationTests = []struct {
 }
 
 func TestAdminDeleteReservation(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
 	for _, e := range adminDeleteReservationTests {
 		req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/process-reservation/cal/1/do%s", e.queryParams), nil)
 		ctx := getCtx(req)
@@ -972,7 +1098,7 @@ func TestAdminDeleteReservation(t *testing.T) {
 
 		rr := httptest.NewRecorder()
 
-		handler := http.HandlerFunc(Repo.AdminDeleteReservation)
+		handler := http.HandlerFunc(repo.AdminDeleteReservation)
 		handler.ServeHTTP(rr, req)
 
 		if rr.Code != http.StatusSeeOther {
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// smtpDialTimeout bounds the readiness check of the mail transport
+const smtpDialTimeout = 1 * time.Second
+
+// healthCheck is the result of a single readiness check. The error is only logged,
+// as /readyz is open to anyone.
+type healthCheck struct {
+	Status     string `json:"status"`
+	DurationMS int64  `json:"duration_ms"`
+	err        error
+}
+
+// readinessResponse is the JSON body returned by /readyz
+type readinessResponse struct {
+	Status        string                 `json:"status"`
+	SchemaVersion string                 `json:"schema_version,omitempty"`
+	Checks        map[string]healthCheck `json:"checks"`
+}
+
+// runCheck times fn and turns its error into a healthCheck
+func runCheck(fn func() error) healthCheck {
+	start := time.Now()
+	err := fn()
+	c := healthCheck{
+		Status:     "ok",
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		c.Status = "fail"
+		c.err = err
+	}
+	return c
+}
+
+// Healthz reports that the process is alive
+func (m *Application) Healthz(w http.ResponseWriter, r *http.Request) {
+	out, _ := json.Marshal(map[string]string{"status": "ok"})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// Readyz reports whether the instance can serve traffic, checking each dependency
+func (m *Application) Readyz(w http.ResponseWriter, r *http.Request) {
+	resp := readinessResponse{
+		Status: "ok",
+		Checks: make(map[string]healthCheck),
+	}
+
+	resp.Checks["database"] = runCheck(func() error {
+		return m.DB.Ping(r.Context())
+	})
+
+	resp.Checks["smtp"] = runCheck(func() error {
+		addr := net.JoinHostPort(m.App.SMTPHost, strconv.Itoa(m.App.SMTPPort))
+		conn, err := net.DialTimeout("tcp", addr, smtpDialTimeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	})
+
+	resp.Checks["templates"] = runCheck(func() error {
+		if m.App.UseCache && len(m.App.TemplateCache) == 0 {
+			return fmt.Errorf("template cache is empty")
+		}
+		return nil
+	})
+
+	resp.Checks["migrations"] = runCheck(func() error {
+		version, err := m.DB.SchemaVersion(r.Context())
+		resp.SchemaVersion = version
+		return err
+	})
+
+	status := http.StatusOK
+	for name, c := range resp.Checks {
+		if c.Status != "ok" {
+			resp.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+			m.App.Logger.WarnContext(r.Context(), "readiness check failed", "check", name, "error", c.err)
+		}
+	}
+
+	out, _ := json.MarshalIndent(resp, "", "     ")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
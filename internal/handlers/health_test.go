@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestReadyz tests the Readyz handler with the mail transport up and down
+func TestReadyz(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	repo.App.SMTPHost = host
+	repo.App.SMTPPort, _ = strconv.Atoi(port)
+
+	var readyzTests = []struct {
+		name               string
+		closeSMTP          bool
+		expectedStatusCode int
+		expectedStatus     string
+	}{
+		{"all-dependencies-up", false, http.StatusOK, "ok"},
+		{"smtp-down", true, http.StatusServiceUnavailable, "unavailable"},
+	}
+
+	for _, e := range readyzTests {
+		if e.closeSMTP {
+			ln.Close()
+		}
+
+		req, _ := http.NewRequest("GET", "/readyz", nil)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.Readyz)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		var resp readinessResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: failed to parse json", e.name)
+		}
+
+		if resp.Status != e.expectedStatus {
+			t.Errorf("%s: expected status %s but got %s", e.name, e.expectedStatus, resp.Status)
+		}
+
+		if resp.SchemaVersion == "" {
+			t.Errorf("%s: expected schema version to be reported", e.name)
+		}
+
+		if strings.Contains(rr.Body.String(), host) {
+			t.Errorf("%s: expected the dependency errors to stay out of the response, got %s", e.name, rr.Body.String())
+		}
+	}
+}
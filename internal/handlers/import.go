@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/importer"
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+)
+
+// maxImportSize caps the size of an uploaded CSV file
+const maxImportSize = 10 << 20
+
+// importTemplateData builds the data of the import page, keeping the mapping in the form
+func importTemplateData(mapping importer.Mapping) *models.TemplateData {
+	stringMap := make(map[string]string)
+	for _, field := range importer.Fields {
+		stringMap["map_"+field] = mapping[field]
+	}
+
+	data := make(map[string]interface{})
+	data["fields"] = importer.Fields
+
+	return &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	}
+}
+
+// AdminImport shows the CSV import form
+func (m *Application) AdminImport(w http.ResponseWriter, r *http.Request) {
+	m.Render.Template(w, r, "admin-import.page.tmpl", importTemplateData(importer.DefaultMapping()))
+}
+
+// AdminPostImport checks an uploaded CSV file and shows what would be imported. Once
+// the check passes, the file is posted back with action=commit to be imported.
+func (m *Application) AdminPostImport(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize+1<<20)
+	if err := r.ParseMultipartForm(maxImportSize); err != nil {
+		m.Session.Put(r.Context(), "error", "The file is too large or the form could not be read")
+		http.Redirect(w, r, "/admin/import", http.StatusSeeOther)
+		return
+	}
+
+	mapping := importer.DefaultMapping()
+	for _, field := range importer.Fields {
+		if column := strings.TrimSpace(r.Form.Get("map_" + field)); column != "" {
+			mapping[field] = column
+		}
+	}
+	td := importTemplateData(mapping)
+
+	// the checked file comes back in a hidden field, so it is never kept on the server
+	content := r.Form.Get("data")
+	if content == "" {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			m.Session.Put(r.Context(), "error", "Choose a CSV file to import")
+			m.Render.Template(w, r, "admin-import.page.tmpl", td)
+			return
+		}
+		defer file.Close()
+
+		raw, err := io.ReadAll(file)
+		if err != nil {
+			m.serverError(w, r, err)
+			return
+		}
+		content = string(raw)
+	}
+
+	im, err := importer.New(r.Context(), m.db(r))
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	res, err := im.Check(r.Context(), strings.NewReader(content), mapping)
+	if err != nil {
+		m.Session.Put(r.Context(), "error", fmt.Sprintf("Cannot read the file: %s", err))
+		m.Render.Template(w, r, "admin-import.page.tmpl", td)
+		return
+	}
+
+	if r.Form.Get("action") == "commit" && res.OK() {
+		if err := im.Commit(r.Context(), res); err != nil {
+			m.App.Logger.ErrorContext(r.Context(), "import failed", "error", err)
+			m.Session.Put(r.Context(), "error", fmt.Sprintf("Nothing was imported: %s", err))
+			http.Redirect(w, r, "/admin/import", http.StatusSeeOther)
+			return
+		}
+
+		m.App.Logger.InfoContext(r.Context(), "import committed", "reservations", res.Reservations, "blocks", res.Blocks)
+		m.Session.Put(r.Context(), "flash", fmt.Sprintf("Imported %d reservations and %d blocks", res.Reservations, res.Blocks))
+		http.Redirect(w, r, "/admin/reservations-all", http.StatusSeeOther)
+		return
+	}
+
+	if r.Form.Get("action") == "commit" {
+		m.Session.Put(r.Context(), "error", "The file changed or conflicts with new bookings, nothing was imported")
+	}
+
+	td.Data["result"] = res
+	td.StringMap["data"] = content
+	m.Render.Template(w, r, "admin-import.page.tmpl", td)
+}
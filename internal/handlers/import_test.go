@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// importRequest builds a multipart import post with an optional file and form fields
+func importRequest(file string, fields map[string]string) *http.Request {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	if file != "" {
+		fw, _ := mw.CreateFormFile("file", "import.csv")
+		fw.Write([]byte(file))
+	}
+	mw.Close()
+
+	req, _ := http.NewRequest("POST", "/admin/import", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+var importTests = []struct {
+	name               string
+	file               string
+	fields             map[string]string
+	property           int
+	expectedStatusCode int
+	expectedLocation   string
+	expectedBody       []string
+}{
+	{
+		name:               "check-reports-errors",
+		file:               "first_name,last_name,email,room,start_date,end_date\nJohn,Smith,bad,Penthouse,2040-01-01,2040-01-03\n",
+		fields:             map[string]string{"action": "check"},
+		expectedStatusCode: http.StatusOK,
+		expectedBody:       []string{"line 2: email: Invalid email address", "line 2: room: unknown room", "Nothing has been imported"},
+	},
+	{
+		name:               "check-with-mapping",
+		file:               "Name,Surname,Mail,Room,Arrival,Departure\nJohn,Smith,john@smith.com,Penthouse,2040-01-01,2040-01-03\n",
+		fields:             map[string]string{"action": "check", "map_first_name": "Name", "map_last_name": "Surname", "map_email": "Mail", "map_room": "Room", "map_start_date": "Arrival", "map_end_date": "Departure"},
+		expectedStatusCode: http.StatusOK,
+		expectedBody:       []string{"line 2: room: unknown room", `value="Surname"`},
+	},
+	{
+		name:               "room-of-other-property",
+		file:               "first_name,last_name,email,room,start_date,end_date\nJohn,Smith,john@smith.com,General's Quarters,2040-01-01,2040-01-03\n",
+		fields:             map[string]string{"action": "check"},
+		property:           2,
+		expectedStatusCode: http.StatusOK,
+		expectedBody:       []string{"line 2: room: unknown room", "Nothing has been imported"},
+	},
+	{
+		name:               "missing-columns",
+		file:               "first_name,last_name\nJohn,Smith\n",
+		fields:             map[string]string{"action": "check"},
+		expectedStatusCode: http.StatusOK,
+		expectedBody:       []string{"Column mapping"},
+	},
+	{
+		name:               "no-file",
+		fields:             map[string]string{"action": "check"},
+		expectedStatusCode: http.StatusOK,
+		expectedBody:       []string{"Column mapping"},
+	},
+	{
+		name: "commit-refused-when-invalid",
+		fields: map[string]string{
+			"action": "commit",
+			"data":   "first_name,last_name,email,room,start_date,end_date\nJohn,Smith,john@smith.com,Penthouse,2040-01-01,2040-01-03\n",
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedBody:       []string{"Nothing has been imported"},
+	},
+}
+
+// TestAdminPostImport tests the CSV upload is checked before anything is imported
+func TestAdminPostImport(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	for _, e := range importTests {
+		req := importRequest(e.file, e.fields)
+		ctx := getCtx(req)
+		if e.property != 0 {
+			ctx = adminPropertyCtx(repo, req, e.property)
+		}
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.AdminPostImport)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedLocation != "" {
+			if loc, _ := rr.Result().Location(); loc == nil || loc.String() != e.expectedLocation {
+				t.Errorf("%s: wrong location %v, wanted %s", e.name, loc, e.expectedLocation)
+			}
+		}
+
+		for _, want := range e.expectedBody {
+			if !strings.Contains(rr.Body.String(), want) {
+				t.Errorf("%s: expected page to contain %q", e.name, want)
+			}
+		}
+	}
+}
+
+// TestAdminPostImportTooLarge tests a form that can't be read is refused
+func TestAdminPostImportUnreadable(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	req, _ := http.NewRequest("POST", "/admin/import", strings.NewReader("not multipart"))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(repo.AdminPostImport)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/florian-lahitte-uvi/bookings/helpers"
+	"github.com/florian-lahitte-uvi/bookings/internal/invoices"
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+// guestLink returns the link guests open their reservation with, on the site of
+// the property of the request
+func (m *Application) guestLink(r *http.Request, token string) string {
+	pc, _ := helpers.PropertyFromContext(r.Context())
+	return m.propertyURL(pc.Property) + "/my-reservation/" + token
+}
+
+// invoicePDF renders an invoice with the payments of its reservation
+func (m *Application) invoicePDF(r *http.Request, inv models.Invoice) ([]byte, error) {
+	var paid []models.Payment
+	if inv.ReservationID != 0 {
+		var err error
+		paid, err = m.db(r).ReservationPayments(r.Context(), inv.ReservationID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return invoices.PDF(inv, paid, invoices.Issuer{Name: m.App.InvoiceIssuer, Address: m.App.InvoiceAddress})
+}
+
+// writeInvoice sends an invoice as a PDF download
+func (m *Application) writeInvoice(w http.ResponseWriter, r *http.Request, inv models.Invoice) {
+	out, err := m.invoicePDF(r, inv)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", invoices.Filename(inv)))
+	w.Write(out)
+}
+
+// AdminPostInvoice issues the invoice of a reservation, and emails it to the guest
+// when asked to. A reservation has one invoice, issuing it again keeps its number.
+func (m *Application) AdminPostInvoice(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	page := reservationPage(chi.URLParam(r, "src"), id, r.Form.Get("year"), r.Form.Get("month"))
+
+	res, err := m.db(r).GetReservationByID(r.Context(), id)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	if res.Owed() <= 0 {
+		m.Session.Put(r.Context(), "error", "Set the amount due before invoicing")
+		http.Redirect(w, r, page, http.StatusSeeOther)
+		return
+	}
+
+	send := r.Form.Get("send") == "1"
+	if send && res.Email == "" {
+		m.Session.Put(r.Context(), "error", "This reservation has no email address")
+		http.Redirect(w, r, page, http.StatusSeeOther)
+		return
+	}
+
+	inv, err := m.db(r).CreateInvoice(r.Context(), models.Invoice{
+		PropertyID:    res.Room.PropertyID,
+		ReservationID: res.ID,
+		BillTo:        res.FirstName + " " + res.LastName,
+		Email:         res.Email,
+		Currency:      m.App.Currency,
+		Lines:         models.PriceLines(res),
+	})
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	m.App.Logger.InfoContext(r.Context(), "invoice issued", "reservation_id", res.ID, "invoice", inv.Reference())
+
+	if !send {
+		m.Session.Put(r.Context(), "flash", "Invoice "+inv.Reference()+" issued")
+		http.Redirect(w, r, page, http.StatusSeeOther)
+		return
+	}
+
+	token, err := m.db(r).GuestToken(r.Context(), res.ID)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	out, err := m.invoicePDF(r, inv)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	m.MailChan <- invoiceMail(r, res, inv, out, m.guestLink(r, token))
+
+	m.Session.Put(r.Context(), "flash", fmt.Sprintf("Invoice %s sent to %s", inv.Reference(), res.Email))
+	http.Redirect(w, r, page, http.StatusSeeOther)
+}
+
+// invoiceMail builds the email sending an invoice to the guest, with the PDF attached
+func invoiceMail(r *http.Request, res models.Reservation, inv models.Invoice, pdf []byte, link string) models.MailData {
+	content := fmt.Sprintf(`<h1>Your invoice</h1>
+<p>Dear %s,</p>
+<p>Please find attached invoice %s for your stay from %s to %s, for a total of %s.</p>
+<p>You can see your reservation and download the invoice at any time at <a href="%s">%s</a>.</p>`,
+		template.HTMLEscapeString(inv.BillTo), inv.Reference(), res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"),
+		models.FormatMoney(inv.Total, inv.Currency), template.HTMLEscapeString(link), template.HTMLEscapeString(link))
+
+	return models.MailData{
+		From:        mailFrom,
+		To:          res.Email,
+		Subject:     "Invoice " + inv.Reference(),
+		Content:     content,
+		Template:    "basic",
+		RequestID:   middleware.GetReqID(r.Context()),
+		Attachments: []models.MailAttachment{{Name: invoices.Filename(inv), Data: pdf}},
+	}
+}
+
+// AdminInvoicePDF downloads the invoice of a reservation
+func (m *Application) AdminInvoicePDF(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	inv, err := m.db(r).InvoiceForReservation(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	m.writeInvoice(w, r, inv)
+}
+
+// guestReservationID returns the reservation of the guest link in the URL. It
+// answers 404 and returns false for an unknown link.
+func (m *Application) guestReservationID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := m.db(r).ReservationIDByGuestToken(r.Context(), chi.URLParam(r, "token"))
+	if errors.Is(err, sql.ErrNoRows) {
+		m.clientError(w, r, http.StatusNotFound)
+		return 0, false
+	}
+	if err != nil {
+		m.serverError(w, r, err)
+		return 0, false
+	}
+	return id, true
+}
+
+// GuestReservation shows guests their reservation, what they paid and their invoice
+func (m *Application) GuestReservation(w http.ResponseWriter, r *http.Request) {
+	id, ok := m.guestReservationID(w, r)
+	if !ok {
+		return
+	}
+
+	res, err := m.db(r).GetReservationByID(r.Context(), id)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	paid, err := m.db(r).ReservationPayments(r.Context(), id)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["payments"] = paid
+
+	policy, fee, err := m.cancellationFee(r, res)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	data["cancellation_policy"] = policy
+	data["cancellable"] = res.Cancellable(time.Now())
+
+	inv, err := m.db(r).InvoiceForReservation(r.Context(), id)
+	switch {
+	case err == nil:
+		data["invoice"] = inv
+	case !errors.Is(err, sql.ErrNoRows):
+		m.serverError(w, r, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["token"] = chi.URLParam(r, "token")
+
+	m.Render.Template(w, r, "guest-reservation.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    map[string]int{"balance": models.Balance(res.Owed(), paid), "cancellation_fee": fee},
+	})
+}
+
+// GuestInvoicePDF downloads the invoice of a guest's reservation
+func (m *Application) GuestInvoicePDF(w http.ResponseWriter, r *http.Request) {
+	id, ok := m.guestReservationID(w, r)
+	if !ok {
+		return
+	}
+
+	inv, err := m.db(r).InvoiceForReservation(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	m.writeInvoice(w, r, inv)
+}
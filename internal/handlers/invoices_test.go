@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+var adminInvoiceTests = []struct {
+	name               string
+	id                 string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedMail       bool
+}{
+	{"issue", "1", url.Values{}, http.StatusSeeOther, false},
+	{"issue-and-send", "1", url.Values{"send": {"1"}}, http.StatusSeeOther, true},
+	{"send-without-email", "2", url.Values{"send": {"1"}}, http.StatusSeeOther, false},
+	{"issue-fails", "2", url.Values{}, http.StatusInternalServerError, false},
+	{"unknown-reservation", "3", url.Values{}, http.StatusInternalServerError, false},
+	{"bad-id", "x", url.Values{}, http.StatusNotFound, false},
+}
+
+func TestAdminPostInvoice(t *testing.T) {
+	t.Parallel()
+
+	for _, e := range adminInvoiceTests {
+		// a repository of its own, to see the mail queued by the handler
+		repo := newTestApplication(t)
+
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/"+e.id+"/invoice", strings.NewReader(e.postedData.Encode()))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "all")
+		rctx.URLParams.Add("id", e.id)
+		ctx := getCtx(req)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.AdminPostInvoice)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if sent := len(repo.MailChan) == 1; sent != e.expectedMail {
+			t.Fatalf("%s: mail sent %v, wanted %v", e.name, sent, e.expectedMail)
+		}
+		if !e.expectedMail {
+			continue
+		}
+
+		msg := <-repo.MailChan
+		if msg.Subject != "Invoice INV-000001" || msg.To != "john@smith.com" {
+			t.Errorf("%s: wrong mail %q to %q", e.name, msg.Subject, msg.To)
+		}
+		if !strings.Contains(msg.Content, "https://bookings.test/my-reservation/guest123") {
+			t.Errorf("%s: expected the guest link in the content, got %q", e.name, msg.Content)
+		}
+		if len(msg.Attachments) != 1 || msg.Attachments[0].Name != "INV-000001.pdf" ||
+			!bytes.HasPrefix(msg.Attachments[0].Data, []byte("%PDF-")) {
+			t.Errorf("%s: expected the invoice attached, got %+v", e.name, msg.Attachments)
+		}
+	}
+}
+
+var invoicePDFTests = []struct {
+	name               string
+	path               string
+	expectedStatusCode int
+}{
+	{"admin", "/admin/reservations/all/1/invoice.pdf", http.StatusOK},
+	{"admin-not-invoiced", "/admin/reservations/all/2/invoice.pdf", http.StatusNotFound},
+	{"admin-fails", "/admin/reservations/all/3/invoice.pdf", http.StatusInternalServerError},
+	{"guest", "/my-reservation/guest123/invoice.pdf", http.StatusOK},
+	{"guest-not-invoiced", "/my-reservation/guest456/invoice.pdf", http.StatusNotFound},
+	{"guest-unknown-link", "/my-reservation/nope/invoice.pdf", http.StatusNotFound},
+	{"guest-lookup-fails", "/my-reservation/fail/invoice.pdf", http.StatusInternalServerError},
+}
+
+func TestInvoicePDF(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	routes := getRoutes(repo)
+
+	for _, e := range invoicePDFTests {
+		req, _ := http.NewRequest("GET", e.path, nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if rr.Code != http.StatusOK {
+			continue
+		}
+		if ct := rr.Header().Get("Content-Type"); ct != "application/pdf" {
+			t.Errorf("%s: wrong content type %q", e.name, ct)
+		}
+		if cd := rr.Header().Get("Content-Disposition"); !strings.Contains(cd, "INV-000007.pdf") {
+			t.Errorf("%s: wrong content disposition %q", e.name, cd)
+		}
+		if !bytes.HasPrefix(rr.Body.Bytes(), []byte("%PDF-")) {
+			t.Errorf("%s: not a PDF document", e.name)
+		}
+	}
+}
+
+var guestReservationTests = []struct {
+	name               string
+	token              string
+	expectedStatusCode int
+	expected           []string
+}{
+	{"invoiced", "guest123", http.StatusOK, []string{"John Smith", "160.00 USD", "Download invoice INV-000007"}},
+	{"not-invoiced", "guest456", http.StatusOK, []string{"John Smith", "100% of the stay when cancelled", "/my-reservation/guest456/cancel"}},
+	{"unknown-link", "nope", http.StatusNotFound, nil},
+	{"lookup-fails", "fail", http.StatusInternalServerError, nil},
+}
+
+func TestGuestReservation(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	routes := getRoutes(repo)
+
+	for _, e := range guestReservationTests {
+		req, _ := http.NewRequest("GET", "/my-reservation/"+e.token, nil)
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		for _, s := range e.expected {
+			if !strings.Contains(rr.Body.String(), s) {
+				t.Errorf("%s: expected %q on the page", e.name, s)
+			}
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/florian-lahitte-uvi/bookings/helpers"
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/florian-lahitte-uvi/bookings/internal/payments"
+	"github.com/go-chi/chi"
+)
+
+// maxWebhookSize caps the size of a webhook call from the payment provider
+const maxWebhookSize = 1 << 20
+
+// checkoutAmount is what the guest pays online for a reservation, in cents
+func (m *Application) checkoutAmount(res models.Reservation) int {
+	return res.AmountDue * m.App.DepositPercent / 100
+}
+
+// startCheckout sends the guest of a reservation held pending payment to the
+// provider's checkout page. The room is released if the checkout can't start.
+func (m *Application) startCheckout(w http.ResponseWriter, r *http.Request, res models.Reservation) {
+	checkout, err := m.App.Payments.CreateCheckout(r.Context(), payments.CheckoutRequest{
+		ReservationID: res.ID,
+		Amount:        m.checkoutAmount(res),
+		Currency:      m.App.Currency,
+		Description:   fmt.Sprintf("%s, %s to %s", res.Room.RoomName, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02")),
+		Email:         res.Email,
+		SuccessURL:    helpers.SitePath(r, "/reservation-summary"),
+		CancelURL:     helpers.SitePath(r, "/reservation-summary"),
+	})
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "cannot start checkout", "reservation_id", res.ID, "error", err)
+		if _, err := m.db(r).ReleaseHold(r.Context(), res.ID); err != nil {
+			m.App.Logger.ErrorContext(r.Context(), "cannot release hold", "reservation_id", res.ID, "error", err)
+		}
+		m.Session.Put(r.Context(), "error", "Payments are unavailable, please try again later")
+		http.Redirect(w, r, helpers.SitePath(r, "/"), http.StatusSeeOther)
+		return
+	}
+
+	m.Session.Put(r.Context(), "reservation", res)
+	http.Redirect(w, r, checkout.URL, http.StatusSeeOther)
+}
+
+type webhookResponse struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// PaymentWebhook receives the signed calls of the payment provider. A completed
+// checkout is recorded as a card payment and confirms the reservation, an expired
+// one releases it. Errors answer 500 so the provider calls again.
+func (m *Application) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	if m.App.Payments == nil {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, webhookResponse{Message: "can't read body"})
+		return
+	}
+
+	event, err := m.App.Payments.ParseWebhook(payload, r.Header)
+	if err != nil {
+		m.App.Logger.WarnContext(r.Context(), "payment webhook refused", "error", err)
+		writeJSON(w, http.StatusBadRequest, webhookResponse{Message: err.Error()})
+		return
+	}
+
+	err = m.handlePaymentEvent(r, event)
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "cannot handle payment event", "type", event.Type,
+			"reservation_id", event.ReservationID, "error", err)
+		writeJSON(w, http.StatusInternalServerError, webhookResponse{Message: "Error saving payment"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, webhookResponse{OK: true})
+}
+
+// handlePaymentEvent applies an event of the payment provider to its reservation
+func (m *Application) handlePaymentEvent(r *http.Request, event payments.Event) error {
+	switch event.Type {
+	case payments.EventCheckoutCompleted:
+		recorded, err := m.DB.RecordCheckoutPayment(r.Context(), models.Payment{
+			ReservationID: event.ReservationID,
+			Kind:          models.PaymentPayment,
+			Method:        models.MethodCard,
+			Amount:        event.Amount,
+			Reference:     m.App.Payments.Name(),
+			CheckoutID:    event.CheckoutID,
+		})
+		if err != nil {
+			return err
+		}
+		if !recorded {
+			m.App.Logger.InfoContext(r.Context(), "checkout already recorded", "checkout_id", event.CheckoutID)
+			return nil
+		}
+
+		res, err := m.DB.GetReservationByID(r.Context(), event.ReservationID)
+		if err != nil {
+			return err
+		}
+
+		if res.PaymentStatus == models.PaymentReleased {
+			// the guest paid after their room was given up, staff must refund or rebook them
+			m.App.Logger.WarnContext(r.Context(), "payment received for a released reservation", "reservation_id", res.ID)
+			_, err = m.DB.AddReservationNote(r.Context(), models.ReservationNote{
+				ReservationID: res.ID,
+				Body: fmt.Sprintf("A card payment of %s was received after the room was released. Refund or rebook the guest.",
+					models.FormatMoney(event.Amount, m.App.Currency)),
+			})
+			return err
+		}
+
+		m.App.Logger.InfoContext(r.Context(), "reservation paid", "reservation_id", res.ID, "amount", event.Amount)
+		if res.Email != "" {
+			m.MailChan <- reservationConfirmation(r, res)
+		}
+
+	case payments.EventCheckoutExpired:
+		released, err := m.DB.ReleaseHold(r.Context(), event.ReservationID)
+		if err != nil {
+			return err
+		}
+		if released {
+			m.App.Logger.InfoContext(r.Context(), "unpaid reservation released", "reservation_id", event.ReservationID)
+		}
+
+	default:
+		m.App.Logger.InfoContext(r.Context(), "payment event ignored", "type", event.Type)
+	}
+	return nil
+}
+
+// fakeProvider returns the fake payment provider, if it is the one configured
+func (m *Application) fakeProvider() (*payments.Fake, bool) {
+	fake, ok := m.App.Payments.(*payments.Fake)
+	return fake, ok
+}
+
+// FakeCheckout shows the checkout page of the fake payment provider
+func (m *Application) FakeCheckout(w http.ResponseWriter, r *http.Request) {
+	fake, ok := m.fakeProvider()
+	if !ok {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	checkout, ok := fake.Checkout(id)
+	if !ok {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["checkout"] = checkout
+
+	stringMap := make(map[string]string)
+	stringMap["id"] = id
+
+	m.Render.Template(w, r, "fake-checkout.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// PostFakeCheckout pays or abandons a checkout of the fake payment provider, and
+// handles the webhook call a real provider would send before returning the guest
+func (m *Application) PostFakeCheckout(w http.ResponseWriter, r *http.Request) {
+	fake, ok := m.fakeProvider()
+	if !ok {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	id := chi.URLParam(r, "id")
+	checkout, ok := fake.Checkout(id)
+	if !ok {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	complete, back := fake.Complete, checkout.SuccessURL
+	if r.Form.Get("action") == "cancel" {
+		complete, back = fake.Expire, checkout.CancelURL
+	}
+
+	payload, header, err := complete(id)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	event, err := fake.ParseWebhook(payload, header)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	err = m.handlePaymentEvent(r, event)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// AdminPostReservationPayment records a deposit, payment or refund taken by staff
+func (m *Application) AdminPostReservationPayment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	page := reservationPage(chi.URLParam(r, "src"), id, r.Form.Get("year"), r.Form.Get("month"))
+
+	p := models.Payment{
+		ReservationID: id,
+		Kind:          r.Form.Get("kind"),
+		Method:        r.Form.Get("method"),
+		Reference:     strings.TrimSpace(r.Form.Get("reference")),
+		UserID:        m.Session.GetInt(r.Context(), "user_id"),
+	}
+	p.Amount, err = models.ParseMoney(r.Form.Get("amount"))
+	if err != nil || p.Amount == 0 {
+		m.Session.Put(r.Context(), "error", "Enter an amount, such as 95 or 95.50")
+		http.Redirect(w, r, page, http.StatusSeeOther)
+		return
+	}
+	if !oneOf(p.Kind, models.PaymentKinds) || !oneOf(p.Method, models.PaymentMethods) {
+		m.Session.Put(r.Context(), "error", "Choose a kind of payment and a method")
+		http.Redirect(w, r, page, http.StatusSeeOther)
+		return
+	}
+
+	_, err = m.db(r).AddPayment(r.Context(), p)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	m.Session.Put(r.Context(), "flash", fmt.Sprintf("%s of %s recorded",
+		strings.ToUpper(p.Kind[:1])+p.Kind[1:], models.FormatMoney(p.Amount, m.App.Currency)))
+	http.Redirect(w, r, page, http.StatusSeeOther)
+}
+
+// AdminPostAmountDue changes the price of a reservation's stay
+func (m *Application) AdminPostAmountDue(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	page := reservationPage(chi.URLParam(r, "src"), id, r.Form.Get("year"), r.Form.Get("month"))
+
+	amount, err := models.ParseMoney(r.Form.Get("amount_due"))
+	if err != nil {
+		m.Session.Put(r.Context(), "error", "Enter an amount, such as 95 or 95.50")
+		http.Redirect(w, r, page, http.StatusSeeOther)
+		return
+	}
+
+	err = m.db(r).UpdateAmountDue(r.Context(), id, amount)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	m.Session.Put(r.Context(), "flash", "Amount due changed to "+models.FormatMoney(amount, m.App.Currency))
+	http.Redirect(w, r, page, http.StatusSeeOther)
+}
+
+// oneOf reports whether s is one of choices
+func oneOf(s string, choices []string) bool {
+	for _, c := range choices {
+		if s == c {
+			return true
+		}
+	}
+	return false
+}
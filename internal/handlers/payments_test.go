@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/florian-lahitte-uvi/bookings/internal/payments"
+	"github.com/go-chi/chi"
+)
+
+// paymentsApp returns an application of the test taking payments with the fake provider
+func paymentsApp(t *testing.T) (*Application, *payments.Fake) {
+	repo := newTestApplication(t)
+	fake := payments.NewFake("s3cret")
+	repo.App.Payments = fake
+	return repo, fake
+}
+
+// signedEvent returns the payload and headers of a webhook call signed with secret
+func signedEvent(secret string, event payments.Event) ([]byte, http.Header) {
+	payload, _ := json.Marshal(event)
+	header := make(http.Header)
+	header.Set(payments.SignatureHeader, payments.Sign(secret, payload, time.Now()))
+	return payload, header
+}
+
+var paymentWebhookTests = []struct {
+	name               string
+	disabled           bool
+	secret             string
+	event              payments.Event
+	expectedStatusCode int
+	expectedMail       bool
+}{
+	{
+		name:               "disabled",
+		disabled:           true,
+		secret:             "s3cret",
+		event:              payments.Event{Type: payments.EventCheckoutCompleted, CheckoutID: "cs_1", ReservationID: 1, Amount: 20000},
+		expectedStatusCode: http.StatusNotFound,
+	},
+	{
+		name:               "forged",
+		secret:             "guess",
+		event:              payments.Event{Type: payments.EventCheckoutCompleted, CheckoutID: "cs_1", ReservationID: 1, Amount: 20000},
+		expectedStatusCode: http.StatusBadRequest,
+	},
+	{
+		name:               "completed",
+		secret:             "s3cret",
+		event:              payments.Event{Type: payments.EventCheckoutCompleted, CheckoutID: "cs_1", ReservationID: 1, Amount: 20000},
+		expectedStatusCode: http.StatusOK,
+		expectedMail:       true,
+	},
+	{
+		name:               "completed-again",
+		secret:             "s3cret",
+		event:              payments.Event{Type: payments.EventCheckoutCompleted, CheckoutID: "cs_3", ReservationID: 3, Amount: 20000},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:               "completed-fails",
+		secret:             "s3cret",
+		event:              payments.Event{Type: payments.EventCheckoutCompleted, CheckoutID: "cs_2", ReservationID: 2, Amount: 20000},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "expired",
+		secret:             "s3cret",
+		event:              payments.Event{Type: payments.EventCheckoutExpired, CheckoutID: "cs_1", ReservationID: 1},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:               "expired-fails",
+		secret:             "s3cret",
+		event:              payments.Event{Type: payments.EventCheckoutExpired, CheckoutID: "cs_2", ReservationID: 2},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "other-event",
+		secret:             "s3cret",
+		event:              payments.Event{Type: "checkout.created", CheckoutID: "cs_1", ReservationID: 1},
+		expectedStatusCode: http.StatusOK,
+	},
+}
+
+// TestPaymentWebhook tests the calls of the payment provider
+func TestPaymentWebhook(t *testing.T) {
+	for _, e := range paymentWebhookTests {
+		repo, _ := paymentsApp(t)
+		if e.disabled {
+			repo.App.Payments = nil
+		}
+
+		payload, header := signedEvent(e.secret, e.event)
+		req, _ := http.NewRequest("POST", "/payments/webhook", bytes.NewReader(payload))
+		req.Header = header
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.PaymentWebhook)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if sent := len(repo.MailChan) == 1; sent != e.expectedMail {
+			t.Errorf("%s: confirmation sent %v, wanted %v", e.name, sent, e.expectedMail)
+		}
+	}
+}
+
+// TestPostReservationWithPayments tests holding a booking while the guest pays
+func TestPostReservationWithPayments(t *testing.T) {
+	postedData := url.Values{
+		"start_date": {"2040-01-01"},
+		"end_date":   {"2040-01-03"},
+		"first_name": {"John"},
+		"last_name":  {"Smith"},
+		"email":      {"john@smith.com"},
+		"phone":      {"555-555-5555"},
+		"room_id":    {"1"},
+	}
+
+	var tests = []struct {
+		name             string
+		depositPercent   int
+		expectedLocation string
+		expectedAmount   int
+	}{
+		{"full", 100, payments.FakeCheckoutPath + "fake_cs_1", 20000},
+		{"deposit", 25, payments.FakeCheckoutPath + "fake_cs_1", 5000},
+		{"checkout-fails", 0, "/", 0},
+	}
+
+	for _, e := range tests {
+		repo, fake := paymentsApp(t)
+		repo.App.DepositPercent = e.depositPercent
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "reservation", models.Reservation{RoomID: 1})
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc == nil || actualLoc.String() != e.expectedLocation {
+			t.Errorf("%s: wrong location %v, wanted %s", e.name, actualLoc, e.expectedLocation)
+		}
+		if len(repo.MailChan) != 0 {
+			t.Errorf("%s: the confirmation must wait for the payment", e.name)
+		}
+		if e.expectedAmount == 0 {
+			continue
+		}
+
+		checkout, ok := fake.Checkout("fake_cs_1")
+		if !ok || checkout.Amount != e.expectedAmount || checkout.ReservationID != 1 {
+			t.Errorf("%s: wrong checkout %+v", e.name, checkout)
+		}
+		res, _ := session.Get(ctx, "reservation").(models.Reservation)
+		if res.PaymentStatus != models.PaymentPending || res.AmountDue != 20000 || res.HoldUntil.IsZero() {
+			t.Errorf("%s: reservation not held pending payment: %+v", e.name, res)
+		}
+	}
+}
+
+// TestFakeCheckout tests the checkout pages of the fake payment provider
+func TestFakeCheckout(t *testing.T) {
+	var tests = []struct {
+		name               string
+		method             string
+		id                 string
+		action             string
+		fake               bool
+		expectedStatusCode int
+		expectedLocation   string
+		expectedMail       bool
+	}{
+		{"page", "GET", "fake_cs_1", "", true, http.StatusOK, "", false},
+		{"page-unknown", "GET", "fake_cs_9", "", true, http.StatusNotFound, "", false},
+		{"page-other-provider", "GET", "fake_cs_1", "", false, http.StatusNotFound, "", false},
+		{"pay", "POST", "fake_cs_1", "pay", true, http.StatusSeeOther, "/reservation-summary?paid", true},
+		{"cancel", "POST", "fake_cs_1", "cancel", true, http.StatusSeeOther, "/reservation-summary?cancelled", false},
+		{"pay-unknown", "POST", "fake_cs_9", "pay", true, http.StatusNotFound, "", false},
+		{"pay-other-provider", "POST", "fake_cs_1", "pay", false, http.StatusNotFound, "", false},
+	}
+
+	for _, e := range tests {
+		repo, fake := paymentsApp(t)
+		fake.CreateCheckout(context.Background(), payments.CheckoutRequest{
+			ReservationID: 1,
+			Amount:        20000,
+			Description:   "General's Quarters, 2040-01-01 to 2040-01-03",
+			SuccessURL:    "/reservation-summary?paid",
+			CancelURL:     "/reservation-summary?cancelled",
+		})
+		if !e.fake {
+			repo.App.Payments = nil
+		}
+
+		form := url.Values{"action": {e.action}}
+		req, _ := http.NewRequest(e.method, "/payments/fake/"+e.id, strings.NewReader(form.Encode()))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx := getCtx(req)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.FakeCheckout)
+		if e.method == "POST" {
+			handler = repo.PostFakeCheckout
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc == nil || actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: wrong location %v, wanted %s", e.name, actualLoc, e.expectedLocation)
+			}
+		}
+		if sent := len(repo.MailChan) == 1; sent != e.expectedMail {
+			t.Errorf("%s: confirmation sent %v, wanted %v", e.name, sent, e.expectedMail)
+		}
+	}
+}
+
+var reservationPaymentTests = []struct {
+	name               string
+	path               string
+	postedData         url.Values
+	expectedStatusCode int
+}{
+	{"deposit", "/admin/reservations/all/1/payments", url.Values{"kind": {"deposit"}, "method": {"transfer"}, "amount": {"50"}, "reference": {"TR-1"}}, http.StatusSeeOther},
+	{"refund", "/admin/reservations/all/1/payments", url.Values{"kind": {"refund"}, "method": {"card"}, "amount": {"10.50"}}, http.StatusSeeOther},
+	{"no-amount", "/admin/reservations/all/1/payments", url.Values{"kind": {"deposit"}, "method": {"cash"}, "amount": {"0"}}, http.StatusSeeOther},
+	{"bad-amount", "/admin/reservations/all/1/payments", url.Values{"kind": {"deposit"}, "method": {"cash"}, "amount": {"fifty"}}, http.StatusSeeOther},
+	{"bad-kind", "/admin/reservations/all/1/payments", url.Values{"kind": {"gift"}, "method": {"cash"}, "amount": {"50"}}, http.StatusSeeOther},
+	{"save-fails", "/admin/reservations/all/2/payments", url.Values{"kind": {"deposit"}, "method": {"cash"}, "amount": {"50"}}, http.StatusInternalServerError},
+	{"amount-due", "/admin/reservations/all/1/amount-due", url.Values{"amount_due": {"180"}}, http.StatusSeeOther},
+	{"amount-due-invalid", "/admin/reservations/all/1/amount-due", url.Values{"amount_due": {"-5"}}, http.StatusSeeOther},
+	{"amount-due-fails", "/admin/reservations/all/2/amount-due", url.Values{"amount_due": {"180"}}, http.StatusInternalServerError},
+}
+
+// TestAdminPostReservationPayment tests recording payments and changing the amount due
+func TestAdminPostReservationPayment(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	routes := getRoutes(repo)
+
+	for _, e := range reservationPaymentTests {
+		req, _ := http.NewRequest("POST", e.path, strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+// TestAdminShowReservationPayments tests the balance shown on a reservation
+func TestAdminShowReservationPayments(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	req, _ := http.NewRequest("GET", "/admin/reservations/all/1/show", nil)
+	req.RequestURI = "/admin/reservations/all/1/show"
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(repo.AdminShowReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("wrong response code: got %d", rr.Code)
+	}
+	// 200.00 due, 50.00 deposit and 10.00 refunded
+	for _, s := range []string{"200.00 USD", "40.00 USD", "160.00 USD", "-10.00 USD", "TR-1", "INV-000007",
+		"https://bookings.test/my-reservation/guest123"} {
+		if !strings.Contains(rr.Body.String(), s) {
+			t.Errorf("expected %q on the page", s)
+		}
+	}
+}
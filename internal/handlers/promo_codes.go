@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	forms "github.com/florian-lahitte-uvi/bookings/internal/form"
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/florian-lahitte-uvi/bookings/internal/repository/dbrepo"
+	"github.com/go-chi/chi"
+)
+
+// promoCodePattern is what promo codes are made of, once upper cased
+var promoCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,50}$`)
+
+// AdminPromoCodes lists the promo codes with their redemption figures
+func (m *Application) AdminPromoCodes(w http.ResponseWriter, r *http.Request) {
+	codes, err := m.db(r).AllPromoCodes(r.Context())
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["promo_codes"] = codes
+
+	intMap := make(map[string]int)
+	for _, p := range codes {
+		intMap["redemptions"] += p.Redemptions
+		intMap["discounted"] += p.Discounted
+		intMap["revenue"] += p.Revenue
+	}
+
+	m.Render.Template(w, r, "admin-promo-codes.page.tmpl", &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
+	})
+}
+
+// AdminNewPromoCode shows the form adding a promo code
+func (m *Application) AdminNewPromoCode(w http.ResponseWriter, r *http.Request) {
+	m.renderPromoCode(w, r, models.PromoCode{Kind: models.DiscountPercent, Active: true}, forms.New(nil))
+}
+
+// AdminShowPromoCode shows a promo code with the reservations made with it
+func (m *Application) AdminShowPromoCode(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	promo, err := m.db(r).GetPromoCodeByID(r.Context(), id)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	m.renderPromoCode(w, r, promo, forms.New(nil))
+}
+
+// renderPromoCode shows the promo code page, with its redemptions once it exists
+func (m *Application) renderPromoCode(w http.ResponseWriter, r *http.Request, promo models.PromoCode, form *forms.Form) {
+	rooms, err := m.db(r).AllRooms(r.Context())
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["promo_code"] = promo
+	data["rooms"] = rooms
+	data["discount_kinds"] = models.DiscountKinds
+
+	if promo.ID > 0 {
+		redemptions, err := m.db(r).PromoCodeRedemptions(r.Context(), promo.ID)
+		if err != nil {
+			m.serverError(w, r, err)
+			return
+		}
+		data["redemptions"] = redemptions
+	}
+
+	// keep what was posted when the page is shown again with errors
+	stringMap := make(map[string]string)
+	stringMap["value"] = promoValue(promo)
+	if !promo.ValidFrom.IsZero() {
+		stringMap["valid_from"] = promo.ValidFrom.Format("2006-01-02")
+	}
+	if !promo.ValidTo.IsZero() {
+		stringMap["valid_to"] = promo.ValidTo.Format("2006-01-02")
+	}
+	for _, field := range []string{"value", "valid_from", "valid_to"} {
+		if form.Has(field) {
+			stringMap[field] = form.Get(field)
+		}
+	}
+
+	m.Render.Template(w, r, "admin-promo-code.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
+
+// promoValue formats the value of a promo code as it is entered
+func promoValue(p models.PromoCode) string {
+	switch {
+	case p.Value == 0:
+		return ""
+	case p.Kind == models.DiscountFixed:
+		return fmt.Sprintf("%d.%02d", p.Value/100, p.Value%100)
+	}
+	return strconv.Itoa(p.Value)
+}
+
+// AdminPostPromoCode adds a promo code, or saves the one in the URL
+func (m *Application) AdminPostPromoCode(w http.ResponseWriter, r *http.Request) {
+	var promo models.PromoCode
+	if param := chi.URLParam(r, "id"); param != "" {
+		id, err := strconv.Atoi(param)
+		if err != nil {
+			m.clientError(w, r, http.StatusNotFound)
+			return
+		}
+		promo, err = m.db(r).GetPromoCodeByID(r.Context(), id)
+		if err != nil {
+			m.serverError(w, r, err)
+			return
+		}
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	promo = promoCodeFromForm(form, promo)
+	if !form.Valid() {
+		m.renderPromoCode(w, r, promo, form)
+		return
+	}
+
+	if promo.ID == 0 {
+		promo.ID, err = m.db(r).InsertPromoCode(r.Context(), promo)
+	} else {
+		err = m.db(r).UpdatePromoCode(r.Context(), promo)
+	}
+	if errors.Is(err, dbrepo.ErrPromoCodeExists) {
+		form.Errors.Add("code", "This code exists already")
+		m.renderPromoCode(w, r, promo, form)
+		return
+	}
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	m.Session.Put(r.Context(), "flash", "Promo code "+promo.Code+" saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/promo-codes/%d", promo.ID), http.StatusSeeOther)
+}
+
+// promoCodeFromForm updates p with the posted promo code form, adding its errors to form
+func promoCodeFromForm(form *forms.Form, p models.PromoCode) models.PromoCode {
+	p.Code = models.NormalizeCode(form.Get("code"))
+	p.Description = strings.TrimSpace(form.Get("description"))
+	p.Kind = form.Get("kind")
+	p.Active = form.Get("active") == "1"
+
+	form.Required("code", "value")
+	if p.Code != "" && !promoCodePattern.MatchString(p.Code) {
+		form.Errors.Add("code", "Use 3 to 50 letters, digits, dashes or underscores")
+	}
+
+	var err error
+	switch p.Kind {
+	case models.DiscountPercent:
+		p.Value, err = strconv.Atoi(strings.TrimSpace(form.Get("value")))
+		if err != nil || p.Value < 1 || p.Value > 100 {
+			form.Errors.Add("value", "Enter a percentage between 1 and 100")
+		}
+	case models.DiscountFixed:
+		p.Value, err = models.ParseMoney(form.Get("value"))
+		if err != nil || p.Value == 0 {
+			form.Errors.Add("value", "Enter an amount, such as 20 or 19.50")
+		}
+	default:
+		form.Errors.Add("kind", "Choose a percentage or a fixed amount")
+	}
+
+	p.ValidFrom, p.ValidTo = time.Time{}, time.Time{}
+	if s := form.Get("valid_from"); s != "" {
+		if p.ValidFrom, err = time.Parse("2006-01-02", s); err != nil {
+			form.Errors.Add("valid_from", "Enter a date")
+		}
+	}
+	if s := form.Get("valid_to"); s != "" {
+		if p.ValidTo, err = time.Parse("2006-01-02", s); err != nil {
+			form.Errors.Add("valid_to", "Enter a date")
+		}
+	}
+	if !p.ValidFrom.IsZero() && !p.ValidTo.IsZero() && p.ValidTo.Before(p.ValidFrom) {
+		form.Errors.Add("valid_to", "The last night can't be before the first")
+	}
+
+	p.MinNights = formCount(form, "min_nights")
+	p.MaxRedemptions = formCount(form, "max_redemptions")
+
+	p.RoomIDs = nil
+	for _, s := range form.Values["room_id"] {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			form.Errors.Add("room_id", "Unknown room")
+			continue
+		}
+		p.RoomIDs = append(p.RoomIDs, id)
+	}
+
+	return p
+}
+
+// formCount returns the optional count in field, 0 when it is empty, adding an
+// error to form if it isn't a positive number
+func formCount(form *forms.Form, field string) int {
+	s := strings.TrimSpace(form.Get(field))
+	if s == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		form.Errors.Add(field, "Enter a number, or leave empty")
+		return 0
+	}
+	return n
+}
+
+// AdminDeletePromoCode deletes a promo code no reservation was made with
+func (m *Application) AdminDeletePromoCode(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	err = m.db(r).DeletePromoCode(r.Context(), id)
+	if errors.Is(err, dbrepo.ErrPromoCodeInUse) {
+		m.Session.Put(r.Context(), "error", "Reservations were made with this code, deactivate it instead")
+		http.Redirect(w, r, fmt.Sprintf("/admin/promo-codes/%d", id), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	m.Session.Put(r.Context(), "flash", "Promo code deleted")
+	http.Redirect(w, r, "/admin/promo-codes", http.StatusSeeOther)
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/go-chi/chi"
+)
+
+// TestPostReservationWithPromoCode tests booking with a promo code
+func TestPostReservationWithPromoCode(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	var tests = []struct {
+		name               string
+		code               string
+		endDate            string
+		expectedStatusCode int
+		expectedHTML       string
+		expectedDiscount   int
+	}{
+		{"valid", " summer ", "2040-01-03", http.StatusSeeOther, "", 2000},
+		{"too-short", "SUMMER", "2040-01-02", http.StatusOK, "This code needs a stay of at least 2 nights", 0},
+		{"unknown", "NOPE", "2040-01-03", http.StatusOK, "Unknown promo code", 0},
+		{"inactive", "WINTER", "2040-01-03", http.StatusOK, models.ErrPromoInactive.Error(), 0},
+		{"used-up", "USEDUP", "2040-01-03", http.StatusOK, models.ErrPromoUsedUp.Error(), 0},
+		{"already-used", "TAKEN", "2040-01-03", http.StatusOK, models.ErrPromoAlreadyUsed.Error(), 0},
+		{"lookup-fails", "FAIL", "2040-01-03", http.StatusInternalServerError, "", 0},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{
+			"start_date": {"2040-01-01"},
+			"end_date":   {e.endDate},
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+			"room_id":    {"1"},
+			"promo_code": {e.code},
+		}
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "reservation", models.Reservation{RoomID: 1})
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %q", e.name, e.expectedHTML)
+		}
+		if e.expectedDiscount == 0 {
+			continue
+		}
+
+		res, _ := session.Get(ctx, "reservation").(models.Reservation)
+		if res.Discount != e.expectedDiscount || res.PromoCodeID == 0 {
+			t.Errorf("%s: wrong discount %d with promo code %d", e.name, res.Discount, res.PromoCodeID)
+		}
+	}
+}
+
+var adminPromoCodeTests = []struct {
+	name               string
+	method             string
+	url                string
+	id                 string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+}{
+	{"list", "GET", "/admin/promo-codes", "", nil, http.StatusOK, "", "SUMMER"},
+	{"new", "GET", "/admin/promo-codes/new", "", nil, http.StatusOK, "", "New Promo Code"},
+	{"show", "GET", "/admin/promo-codes/1", "1", nil, http.StatusOK, "", "Smith, John"},
+	{"show-unknown", "GET", "/admin/promo-codes/9", "9", nil, http.StatusInternalServerError, "", ""},
+	{"show-bad-id", "GET", "/admin/promo-codes/x", "x", nil, http.StatusNotFound, "", ""},
+	{"create", "POST", "/admin/promo-codes/new", "", url.Values{
+		"code": {"spring-25"}, "kind": {"percent"}, "value": {"25"}, "room_id": {"1", "2"}, "active": {"1"},
+	}, http.StatusSeeOther, "/admin/promo-codes/5", ""},
+	{"create-fixed", "POST", "/admin/promo-codes/new", "", url.Values{
+		"code": {"TENOFF"}, "kind": {"fixed"}, "value": {"10.00"}, "valid_from": {"2040-01-01"}, "valid_to": {"2040-02-01"},
+	}, http.StatusSeeOther, "/admin/promo-codes/5", ""},
+	{"create-exists", "POST", "/admin/promo-codes/new", "", url.Values{
+		"code": {"summer"}, "kind": {"percent"}, "value": {"10"},
+	}, http.StatusOK, "", "This code exists already"},
+	{"create-fails", "POST", "/admin/promo-codes/new", "", url.Values{
+		"code": {"FAIL"}, "kind": {"percent"}, "value": {"10"},
+	}, http.StatusInternalServerError, "", ""},
+	{"bad-code", "POST", "/admin/promo-codes/new", "", url.Values{
+		"code": {"no spaces"}, "kind": {"percent"}, "value": {"10"},
+	}, http.StatusOK, "", "Use 3 to 50 letters"},
+	{"bad-percentage", "POST", "/admin/promo-codes/new", "", url.Values{
+		"code": {"HALF"}, "kind": {"percent"}, "value": {"150"},
+	}, http.StatusOK, "", "Enter a percentage between 1 and 100"},
+	{"bad-dates", "POST", "/admin/promo-codes/new", "", url.Values{
+		"code": {"HALF"}, "kind": {"percent"}, "value": {"50"}, "valid_from": {"2040-02-01"}, "valid_to": {"2040-01-01"},
+	}, http.StatusOK, "", "The last night can&#39;t be before the first"},
+	{"bad-count", "POST", "/admin/promo-codes/new", "", url.Values{
+		"code": {"HALF"}, "kind": {"percent"}, "value": {"50"}, "max_redemptions": {"-1"},
+	}, http.StatusOK, "", "Enter a number, or leave empty"},
+	{"update", "POST", "/admin/promo-codes/1", "1", url.Values{
+		"code": {"SUMMER"}, "kind": {"percent"}, "value": {"15"}, "min_nights": {"3"},
+	}, http.StatusSeeOther, "/admin/promo-codes/1", ""},
+	{"update-taken", "POST", "/admin/promo-codes/3", "3", url.Values{
+		"code": {"SUMMER"}, "kind": {"fixed"}, "value": {"20"},
+	}, http.StatusOK, "", "This code exists already"},
+	{"update-fails", "POST", "/admin/promo-codes/2", "2", url.Values{
+		"code": {"WINTER"}, "kind": {"percent"}, "value": {"20"},
+	}, http.StatusInternalServerError, "", ""},
+	{"update-unknown", "POST", "/admin/promo-codes/9", "9", url.Values{}, http.StatusInternalServerError, "", ""},
+	{"delete", "POST", "/admin/promo-codes/4/delete", "4", url.Values{}, http.StatusSeeOther, "/admin/promo-codes", ""},
+	{"delete-in-use", "POST", "/admin/promo-codes/1/delete", "1", url.Values{}, http.StatusSeeOther, "/admin/promo-codes/1", ""},
+	{"delete-fails", "POST", "/admin/promo-codes/2/delete", "2", url.Values{}, http.StatusInternalServerError, "", ""},
+}
+
+// TestAdminPromoCodes tests listing, saving and deleting promo codes
+func TestAdminPromoCodes(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	for _, e := range adminPromoCodeTests {
+		var req *http.Request
+		if e.postedData != nil {
+			req, _ = http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest(e.method, e.url, nil)
+		}
+		rctx := chi.NewRouteContext()
+		if e.id != "" {
+			rctx.URLParams.Add("id", e.id)
+		}
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+
+		var handler http.HandlerFunc
+		switch {
+		case strings.HasSuffix(e.url, "/delete"):
+			handler = repo.AdminDeletePromoCode
+		case e.method == "POST":
+			handler = repo.AdminPostPromoCode
+		case e.url == "/admin/promo-codes":
+			handler = repo.AdminPromoCodes
+		case e.url == "/admin/promo-codes/new":
+			handler = repo.AdminNewPromoCode
+		default:
+			handler = repo.AdminShowPromoCode
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc == nil || actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: wrong location %v, wanted %s", e.name, actualLoc, e.expectedLocation)
+			}
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %q", e.name, e.expectedHTML)
+		}
+	}
+}
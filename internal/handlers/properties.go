@@ -0,0 +1,433 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/florian-lahitte-uvi/bookings/helpers"
+	forms "github.com/florian-lahitte-uvi/bookings/internal/form"
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/florian-lahitte-uvi/bookings/internal/repository"
+	"github.com/florian-lahitte-uvi/bookings/internal/repository/dbrepo"
+	"github.com/go-chi/chi"
+)
+
+// db returns the repository seeing only the property of the request
+func (m *Application) db(r *http.Request) repository.DatabaseRepo {
+	return m.DB.ForProperty(helpers.PropertyID(r))
+}
+
+// SiteProperty resolves the property of the public pages: the one named by the
+// {property} slug of /p/{property}, or else the one claiming the hostname, or else
+// the default property. An unknown slug is not found.
+func (m *Application) SiteProperty(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var pc helpers.PropertyContext
+		var err error
+
+		if slug := chi.URLParam(r, "property"); slug != "" {
+			pc.Property, err = m.DB.PropertyBySlug(r.Context(), slug)
+			pc.BasePath = "/p/" + pc.Property.Slug
+		} else {
+			pc.Property, err = m.DB.PropertyByHost(r.Context(), hostname(r.Host))
+			if errors.Is(err, sql.ErrNoRows) {
+				pc.Property, err = m.DB.GetPropertyByID(r.Context(), models.DefaultPropertyID)
+			}
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			m.clientError(w, r, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			m.serverError(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(helpers.WithProperty(r.Context(), pc)))
+	})
+}
+
+// hostname returns the host of a request without its port
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// AdminProperty resolves the property the admin pages show: the one the staff
+// member selected, or else the first they manage. Staff managing no property are
+// forbidden.
+func (m *Application) AdminProperty(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		properties, err := m.DB.UserProperties(r.Context(), m.Session.GetInt(r.Context(), "user_id"))
+		if err != nil {
+			m.serverError(w, r, err)
+			return
+		}
+		if len(properties) == 0 {
+			m.clientError(w, r, http.StatusForbidden)
+			return
+		}
+
+		pc := helpers.PropertyContext{Property: properties[0], Choices: properties}
+		selected := m.Session.GetInt(r.Context(), "property_id")
+		for _, p := range properties {
+			if p.ID == selected {
+				pc.Property = p
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(helpers.WithProperty(r.Context(), pc)))
+	})
+}
+
+// choice returns the property with an id among those the staff member of the
+// request manages
+func choice(r *http.Request, id int) (models.Property, bool) {
+	pc, _ := helpers.PropertyFromContext(r.Context())
+	for _, p := range pc.Choices {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return models.Property{}, false
+}
+
+// AdminSwitchProperty switches the admin pages to another property the staff member manages
+func (m *Application) AdminSwitchProperty(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(r.Form.Get("property_id"))
+	p, ok := choice(r, id)
+	if !ok {
+		m.clientError(w, r, http.StatusForbidden)
+		return
+	}
+
+	m.Session.Put(r.Context(), "property_id", p.ID)
+	m.Session.Put(r.Context(), "flash", "Showing "+p.Name)
+	http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+}
+
+// propertyURL returns the address of the public site of a property: its own
+// hostname, or its /p/{slug} prefix on the main site. The default property, and
+// requests no property was resolved for, are served at the root of the main site.
+func (m *Application) propertyURL(p models.Property) string {
+	base := strings.TrimSuffix(m.App.BaseURL, "/")
+	switch {
+	case p.Hostname != "":
+		scheme := "https"
+		if u, err := url.Parse(base); err == nil && u.Scheme != "" {
+			scheme = u.Scheme
+		}
+		return scheme + "://" + p.Hostname
+	case p.ID == models.DefaultPropertyID || p.Slug == "":
+		return base
+	}
+	return base + "/p/" + p.Slug
+}
+
+// AdminProperties lists the properties the staff member manages
+func (m *Application) AdminProperties(w http.ResponseWriter, r *http.Request) {
+	pc, _ := helpers.PropertyFromContext(r.Context())
+
+	urls := make(map[string]string)
+	for _, p := range pc.Choices {
+		urls[p.Slug] = m.propertyURL(p)
+	}
+
+	data := make(map[string]interface{})
+	data["properties"] = pc.Choices
+
+	m.Render.Template(w, r, "admin-properties.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: urls,
+	})
+}
+
+// AdminNewProperty shows the form adding a property
+func (m *Application) AdminNewProperty(w http.ResponseWriter, r *http.Request) {
+	m.renderProperty(w, r, models.Property{}, forms.New(nil))
+}
+
+// AdminShowProperty shows a property the staff member manages, with its rooms
+func (m *Application) AdminShowProperty(w http.ResponseWriter, r *http.Request) {
+	p, ok := m.propertyParam(w, r)
+	if !ok {
+		return
+	}
+
+	m.renderProperty(w, r, p, forms.New(nil))
+}
+
+// renderProperty shows the property page, with the staff who can manage it and its rooms.
+// Staff only see the colleagues they manage a property with.
+func (m *Application) renderProperty(w http.ResponseWriter, r *http.Request, p models.Property, form *forms.Form) {
+	users, err := m.DB.ListUsers(r.Context(), m.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["property"] = p
+	data["users"] = users
+
+	if p.ID > 0 {
+		rooms, err := m.DB.ForProperty(p.ID).AllRooms(r.Context())
+		if err != nil {
+			m.serverError(w, r, err)
+			return
+		}
+		units, err := m.DB.ForProperty(p.ID).AllRoomUnits(r.Context())
+		if err != nil {
+			m.serverError(w, r, err)
+			return
+		}
+		data["rooms"] = rooms
+		data["units"] = units
+	}
+
+	m.Render.Template(w, r, "admin-property.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: map[string]string{"url": m.propertyURL(p)},
+		Form:      form,
+	})
+}
+
+// slugPattern is the form of the slugs properties are served under
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// AdminPostProperty adds a property, or saves the one in the URL. The staff member
+// saving it keeps managing it.
+func (m *Application) AdminPostProperty(w http.ResponseWriter, r *http.Request) {
+	var p models.Property
+	if param := chi.URLParam(r, "id"); param != "" {
+		id, err := strconv.Atoi(param)
+		if err != nil {
+			m.clientError(w, r, http.StatusNotFound)
+			return
+		}
+		var ok bool
+		if p, ok = choice(r, id); !ok {
+			m.clientError(w, r, http.StatusNotFound)
+			return
+		}
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	p = propertyFromForm(form, p)
+
+	// staff can only share a property with the colleagues they see
+	userID := m.Session.GetInt(r.Context(), "user_id")
+	users, err := m.DB.ListUsers(r.Context(), userID)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	for _, id := range p.UserIDs {
+		if !hasUser(users, id) {
+			form.Errors.Add("user_id", "Unknown user")
+			break
+		}
+	}
+
+	if userID > 0 && !p.HasUser(userID) {
+		p.UserIDs = append(p.UserIDs, userID)
+	}
+	if !form.Valid() {
+		m.renderProperty(w, r, p, form)
+		return
+	}
+
+	if p.ID == 0 {
+		p.ID, err = m.DB.InsertProperty(r.Context(), p)
+	} else {
+		err = m.DB.UpdateProperty(r.Context(), p)
+	}
+	if errors.Is(err, dbrepo.ErrPropertyExists) {
+		form.Errors.Add("slug", "Another property has this slug or hostname")
+		m.renderProperty(w, r, p, form)
+		return
+	}
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	m.Session.Put(r.Context(), "flash", "Property "+p.Name+" saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/properties/%d", p.ID), http.StatusSeeOther)
+}
+
+// hasUser reports whether the staff member userID is among users
+func hasUser(users []models.User, userID int) bool {
+	for _, u := range users {
+		if u.ID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// propertyFromForm updates p with the posted property form, adding its errors to form
+func propertyFromForm(form *forms.Form, p models.Property) models.Property {
+	p.Name = strings.TrimSpace(form.Get("name"))
+	p.Slug = strings.ToLower(strings.TrimSpace(form.Get("slug")))
+	p.Hostname = strings.ToLower(strings.TrimSpace(form.Get("hostname")))
+	form.Required("name", "slug")
+
+	if p.Slug != "" && !slugPattern.MatchString(p.Slug) {
+		form.Errors.Add("slug", "Use lowercase letters, digits and dashes")
+	}
+	if strings.ContainsAny(p.Hostname, "/: ") {
+		form.Errors.Add("hostname", "Enter a hostname only, such as harbour.example.com")
+	}
+
+	p.UserIDs = nil
+	for _, s := range form.Values["user_id"] {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			form.Errors.Add("user_id", "Unknown user")
+			continue
+		}
+		p.UserIDs = append(p.UserIDs, id)
+	}
+
+	return p
+}
+
+// maxRoomUnits caps the units of a room added at once
+const maxRoomUnits = 100
+
+// AdminPostPropertyRoom adds a room to a property the staff member manages, with
+// its number of units
+func (m *Application) AdminPostPropertyRoom(w http.ResponseWriter, r *http.Request) {
+	p, ok := m.propertyParam(w, r)
+	if !ok {
+		return
+	}
+	page := fmt.Sprintf("/admin/properties/%d", p.ID)
+
+	err := r.ParseForm()
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	name := strings.TrimSpace(r.Form.Get("room_name"))
+	price, err := models.ParseMoney(r.Form.Get("price"))
+	if name == "" || err != nil {
+		m.Session.Put(r.Context(), "error", "Give the room a name and a price per night")
+		http.Redirect(w, r, page, http.StatusSeeOther)
+		return
+	}
+
+	units := 1
+	if s := r.Form.Get("units"); s != "" {
+		units, err = strconv.Atoi(s)
+		if err != nil || units < 1 || units > maxRoomUnits {
+			m.Session.Put(r.Context(), "error", fmt.Sprintf("Give the room between 1 and %d units", maxRoomUnits))
+			http.Redirect(w, r, page, http.StatusSeeOther)
+			return
+		}
+	}
+
+	minNights := 1
+	if s := r.Form.Get("min_nights"); s != "" {
+		minNights, err = strconv.Atoi(s)
+		if err != nil || minNights < 1 {
+			m.Session.Put(r.Context(), "error", "Give the room a minimum stay of 1 night or more")
+			http.Redirect(w, r, page, http.StatusSeeOther)
+			return
+		}
+	}
+
+	_, err = m.DB.ForProperty(p.ID).InsertRoom(r.Context(), models.Room{RoomName: name, Price: price, Units: units, MinNights: minNights})
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	m.Session.Put(r.Context(), "flash", "Room "+name+" added")
+	http.Redirect(w, r, page, http.StatusSeeOther)
+}
+
+// propertyParam returns the property in the URL, answering not found unless the staff
+// member manages it
+func (m *Application) propertyParam(w http.ResponseWriter, r *http.Request) (models.Property, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.clientError(w, r, http.StatusNotFound)
+		return models.Property{}, false
+	}
+	p, ok := choice(r, id)
+	if !ok {
+		m.clientError(w, r, http.StatusNotFound)
+		return models.Property{}, false
+	}
+	return p, true
+}
+
+// AdminPostRoomUnit adds a unit to a room of a property the staff member manages
+func (m *Application) AdminPostRoomUnit(w http.ResponseWriter, r *http.Request) {
+	p, ok := m.propertyParam(w, r)
+	if !ok {
+		return
+	}
+	page := fmt.Sprintf("/admin/properties/%d", p.ID)
+
+	roomID, err := strconv.Atoi(chi.URLParam(r, "room"))
+	if err != nil {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	name := strings.TrimSpace(r.Form.Get("unit_name"))
+	if name == "" {
+		m.Session.Put(r.Context(), "error", "Give the unit a name")
+		http.Redirect(w, r, page, http.StatusSeeOther)
+		return
+	}
+
+	_, err = m.DB.ForProperty(p.ID).AddRoomUnit(r.Context(), roomID, name)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, dbrepo.ErrUnitExists) {
+		m.Session.Put(r.Context(), "error", "The room already has a unit named "+name)
+		http.Redirect(w, r, page, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	m.Session.Put(r.Context(), "flash", "Unit "+name+" added")
+	http.Redirect(w, r, page, http.StatusSeeOther)
+}
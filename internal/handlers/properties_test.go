@@ -0,0 +1,420 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/florian-lahitte-uvi/bookings/helpers"
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/go-chi/chi"
+)
+
+var sitePropertyTests = []struct {
+	name               string
+	url                string
+	host               string
+	expectedStatusCode int
+	expectedProperty   int
+	expectedBasePath   string
+}{
+	{"default", "/", "localhost:8080", http.StatusOK, 1, ""},
+	{"hostname", "/", "harbour.example.com", http.StatusOK, 2, ""},
+	{"hostname-case", "/", "Harbour.Example.com:443", http.StatusOK, 2, ""},
+	{"slug", "/p/harbour/", "localhost:8080", http.StatusOK, 2, "/p/harbour"},
+	{"slug-wins", "/p/fort-smythe/", "harbour.example.com", http.StatusOK, 1, "/p/fort-smythe"},
+	{"unknown-slug", "/p/nowhere/", "localhost:8080", http.StatusNotFound, 0, ""},
+	{"database-error", "/p/fail/", "localhost:8080", http.StatusInternalServerError, 0, ""},
+}
+
+// TestSiteProperty tests resolving the property of the public pages
+func TestSiteProperty(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	for _, e := range sitePropertyTests {
+		var got helpers.PropertyContext
+		page := func(w http.ResponseWriter, r *http.Request) {
+			got, _ = helpers.PropertyFromContext(r.Context())
+		}
+
+		mux := chi.NewRouter()
+		mux.With(repo.SiteProperty).Get("/", page)
+		mux.Route("/p/{property}", func(mux chi.Router) {
+			mux.Use(repo.SiteProperty)
+			mux.Get("/", page)
+		})
+
+		req, _ := http.NewRequest("GET", e.url, nil)
+		req.Host = e.host
+		req = req.WithContext(getCtx(req))
+		rr := httptest.NewRecorder()
+
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if got.Property.ID != e.expectedProperty {
+			t.Errorf("%s resolved property %d, wanted %d", e.name, got.Property.ID, e.expectedProperty)
+		}
+		if got.BasePath != e.expectedBasePath {
+			t.Errorf("%s: got base path %q, wanted %q", e.name, got.BasePath, e.expectedBasePath)
+		}
+	}
+}
+
+var adminPropertyTests = []struct {
+	name               string
+	userID             int
+	selected           int
+	expectedStatusCode int
+	expectedProperty   int
+}{
+	{"first", 1, 0, http.StatusOK, 1},
+	{"selected", 1, 2, http.StatusOK, 2},
+	{"not-managed", 1, 3, http.StatusOK, 1},
+	{"no-property", 2, 0, http.StatusForbidden, 0},
+	{"database-error", 3, 0, http.StatusInternalServerError, 0},
+}
+
+// TestAdminProperty tests resolving the property the admin pages show
+func TestAdminProperty(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	for _, e := range adminPropertyTests {
+		var got helpers.PropertyContext
+		handler := repo.AdminProperty(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, _ = helpers.PropertyFromContext(r.Context())
+		}))
+
+		req, _ := http.NewRequest("GET", "/admin/dashboard", nil)
+		ctx := getCtx(req)
+		session.Put(ctx, "user_id", e.userID)
+		if e.selected > 0 {
+			session.Put(ctx, "property_id", e.selected)
+		}
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if got.Property.ID != e.expectedProperty {
+			t.Errorf("%s resolved property %d, wanted %d", e.name, got.Property.ID, e.expectedProperty)
+		}
+	}
+}
+
+// adminPropertyCtx returns the context of a request made by a staff member managing
+// both test properties, showing the property with the id shown
+func adminPropertyCtx(repo *Application, req *http.Request, shown int) context.Context {
+	properties, _ := repo.DB.UserProperties(context.Background(), 1)
+	pc := helpers.PropertyContext{Property: properties[shown-1], Choices: properties}
+	ctx := getCtx(req)
+	session.Put(ctx, "user_id", 1)
+	return helpers.WithProperty(ctx, pc)
+}
+
+// TestPropertyIsolation tests that the public pages of a property don't see the
+// reservations of another one
+func TestPropertyIsolation(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	tests := []struct {
+		name               string
+		property           int
+		expectedStatusCode int
+	}{
+		{"own-property", 1, http.StatusOK},
+		{"other-property", 2, http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		properties, _ := repo.DB.UserProperties(context.Background(), 1)
+
+		req, _ := http.NewRequest("GET", "/my-reservation/guest123", nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("token", "guest123")
+		ctx := helpers.WithProperty(getCtx(req), helpers.PropertyContext{Property: properties[e.property-1]})
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.GuestReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+// TestAdminSwitchProperty tests switching the admin pages to another property
+func TestAdminSwitchProperty(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	tests := []struct {
+		name               string
+		propertyID         string
+		expectedStatusCode int
+		expectedFlash      string
+	}{
+		{"managed", "2", http.StatusSeeOther, "Showing Harbour House"},
+		{"not-managed", "3", http.StatusForbidden, ""},
+		{"invalid", "x", http.StatusForbidden, ""},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"property_id": {e.propertyID}}
+		req, _ := http.NewRequest("POST", "/admin/property", strings.NewReader(postedData.Encode()))
+		req = req.WithContext(adminPropertyCtx(repo, req, 1))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.AdminSwitchProperty)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if flash := session.PopString(req.Context(), "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: got flash %q, wanted %q", e.name, flash, e.expectedFlash)
+		}
+		if e.expectedStatusCode == http.StatusSeeOther && session.GetInt(req.Context(), "property_id") != 2 {
+			t.Errorf("%s: the property was not selected", e.name)
+		}
+	}
+}
+
+var adminPostPropertyTests = []struct {
+	name               string
+	id                 string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedHTML       string
+	expectedLocation   string
+}{
+	{"new", "", url.Values{"name": {"Lake Lodge"}, "slug": {"lake-lodge"}}, http.StatusSeeOther, "", "/admin/properties/3"},
+	{"update", "2", url.Values{"name": {"Harbour House"}, "slug": {"harbour"}, "hostname": {"Harbour.example.com"}, "user_id": {"1", "2"}},
+		http.StatusSeeOther, "", "/admin/properties/2"},
+	{"missing-name", "", url.Values{"slug": {"lake"}}, http.StatusOK, "This field cannot be blank", ""},
+	{"unknown-user", "2", url.Values{"name": {"Harbour House"}, "slug": {"harbour"}, "user_id": {"1", "3"}}, http.StatusOK, "Unknown user", ""},
+	{"invalid-slug", "", url.Values{"name": {"Lake Lodge"}, "slug": {"Lake Lodge"}}, http.StatusOK, "Use lowercase letters, digits and dashes", ""},
+	{"invalid-hostname", "", url.Values{"name": {"Lake Lodge"}, "slug": {"lake"}, "hostname": {"https://lake.example.com"}},
+		http.StatusOK, "Enter a hostname only", ""},
+	{"slug-taken", "1", url.Values{"name": {"Fort Smythe"}, "slug": {"harbour"}}, http.StatusOK, "Another property has this slug or hostname", ""},
+	{"not-managed", "3", url.Values{"name": {"Lake Lodge"}, "slug": {"lake"}}, http.StatusNotFound, "", ""},
+	{"database-error", "", url.Values{"name": {"Lake Lodge"}, "slug": {"fail"}}, http.StatusInternalServerError, "", ""},
+}
+
+// TestAdminPostProperty tests adding and saving properties
+func TestAdminPostProperty(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	for _, e := range adminPostPropertyTests {
+		target := "/admin/properties/new"
+		if e.id != "" {
+			target = "/admin/properties/" + e.id
+		}
+		req, _ := http.NewRequest("POST", target, strings.NewReader(e.postedData.Encode()))
+		rctx := chi.NewRouteContext()
+		if e.id != "" {
+			rctx.URLParams.Add("id", e.id)
+		}
+		req = req.WithContext(context.WithValue(adminPropertyCtx(repo, req, 1), chi.RouteCtxKey, rctx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.AdminPostProperty)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %q", e.name, e.expectedHTML)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: got location %s, wanted %s", e.name, rr.Header().Get("Location"), e.expectedLocation)
+		}
+	}
+}
+
+// TestAdminProperties tests the list and pages of the properties
+func TestAdminProperties(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name               string
+		url                string
+		id                 string
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"list", "/admin/properties", "", http.StatusOK, "http://harbour.example.com"},
+		{"new", "/admin/properties/new", "", http.StatusOK, "New Property"},
+		{"show", "/admin/properties/2", "2", http.StatusOK, "http://harbour.example.com"},
+		{"rooms", "/admin/properties/1", "1", http.StatusOK, "2 units"},
+		{"not-managed", "/admin/properties/3", "3", http.StatusNotFound, ""},
+		{"invalid-id", "/admin/properties/x", "x", http.StatusNotFound, ""},
+	}
+
+	for _, e := range tests {
+		repo := newTestApplication(t)
+		repo.App.BaseURL = "http://localhost:8080"
+
+		req, _ := http.NewRequest("GET", e.url, nil)
+		rctx := chi.NewRouteContext()
+		if e.id != "" {
+			rctx.URLParams.Add("id", e.id)
+		}
+		req = req.WithContext(context.WithValue(adminPropertyCtx(repo, req, 1), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.AdminShowProperty)
+		switch e.name {
+		case "list":
+			handler = repo.AdminProperties
+		case "new":
+			handler = repo.AdminNewProperty
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("%s: expected to find %q", e.name, e.expectedHTML)
+		}
+	}
+}
+
+// TestAdminPostPropertyRoom tests adding rooms to a property
+func TestAdminPostPropertyRoom(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	tests := []struct {
+		name               string
+		id                 string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedFlash      string
+		expectedError      string
+	}{
+		{"added", "2", url.Values{"room_name": {"Captain's Cabin"}, "price": {"120.00"}}, http.StatusSeeOther, "Room Captain's Cabin added", ""},
+		{"missing-name", "2", url.Values{"price": {"120.00"}}, http.StatusSeeOther, "", "Give the room a name and a price per night"},
+		{"invalid-price", "2", url.Values{"room_name": {"Cabin"}, "price": {"a lot"}}, http.StatusSeeOther, "", "Give the room a name and a price per night"},
+		{"units", "2", url.Values{"room_name": {"Double"}, "price": {"90.00"}, "units": {"10"}}, http.StatusSeeOther, "Room Double added", ""},
+		{"no-units", "2", url.Values{"room_name": {"Double"}, "price": {"90.00"}, "units": {"0"}}, http.StatusSeeOther, "", "Give the room between 1 and 100 units"},
+		{"too-many-units", "2", url.Values{"room_name": {"Double"}, "price": {"90.00"}, "units": {"101"}}, http.StatusSeeOther, "", "Give the room between 1 and 100 units"},
+		{"min-nights", "2", url.Values{"room_name": {"Suite"}, "price": {"200.00"}, "min_nights": {"3"}}, http.StatusSeeOther, "Room Suite added", ""},
+		{"no-min-nights", "2", url.Values{"room_name": {"Suite"}, "price": {"200.00"}, "min_nights": {"0"}}, http.StatusSeeOther, "", "Give the room a minimum stay of 1 night or more"},
+		{"not-managed", "3", url.Values{"room_name": {"Cabin"}, "price": {"120.00"}}, http.StatusNotFound, "", ""},
+		{"database-error", "2", url.Values{"room_name": {"fail"}, "price": {"120.00"}}, http.StatusInternalServerError, "", ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/properties/"+e.id+"/rooms", strings.NewReader(e.postedData.Encode()))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		req = req.WithContext(context.WithValue(adminPropertyCtx(repo, req, 1), chi.RouteCtxKey, rctx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.AdminPostPropertyRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if flash := session.PopString(req.Context(), "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: got flash %q, wanted %q", e.name, flash, e.expectedFlash)
+		}
+		if msg := session.PopString(req.Context(), "error"); msg != e.expectedError {
+			t.Errorf("%s: got error %q, wanted %q", e.name, msg, e.expectedError)
+		}
+	}
+}
+
+// TestPropertyURL tests the addresses of the public sites of properties
+func TestPropertyURL(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	repo.App.BaseURL = "http://localhost:8080/"
+
+	tests := []struct {
+		name     string
+		property models.Property
+		expected string
+	}{
+		{"default", models.Property{ID: 1, Slug: "fort-smythe"}, "http://localhost:8080"},
+		{"slug", models.Property{ID: 3, Slug: "lake"}, "http://localhost:8080/p/lake"},
+		{"hostname", models.Property{ID: 2, Slug: "harbour", Hostname: "harbour.example.com"}, "http://harbour.example.com"},
+		{"unresolved", models.Property{}, "http://localhost:8080"},
+	}
+
+	for _, e := range tests {
+		if got := repo.propertyURL(e.property); got != e.expected {
+			t.Errorf("%s: got %s, wanted %s", e.name, got, e.expected)
+		}
+	}
+}
+
+// TestAdminPostRoomUnit tests adding units to the rooms of a property
+func TestAdminPostRoomUnit(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	tests := []struct {
+		name               string
+		id                 string
+		room               string
+		unitName           string
+		expectedStatusCode int
+		expectedFlash      string
+		expectedError      string
+	}{
+		{"added", "1", "1", "General's Quarters 3", http.StatusSeeOther, "Unit General's Quarters 3 added", ""},
+		{"missing-name", "1", "1", " ", http.StatusSeeOther, "", "Give the unit a name"},
+		{"name-taken", "1", "1", "General's Quarters 1", http.StatusSeeOther, "", "The room already has a unit named General's Quarters 1"},
+		{"unknown-room", "1", "7", "Cabin", http.StatusNotFound, "", ""},
+		{"invalid-room", "1", "x", "Cabin", http.StatusNotFound, "", ""},
+		{"not-managed", "3", "1", "Cabin", http.StatusNotFound, "", ""},
+		{"database-error", "1", "1", "fail", http.StatusInternalServerError, "", ""},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{"unit_name": {e.unitName}}
+		req, _ := http.NewRequest("POST", "/admin/properties/"+e.id+"/rooms/"+e.room+"/units", strings.NewReader(postedData.Encode()))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		rctx.URLParams.Add("room", e.room)
+		req = req.WithContext(context.WithValue(adminPropertyCtx(repo, req, 1), chi.RouteCtxKey, rctx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.AdminPostRoomUnit)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if flash := session.PopString(req.Context(), "flash"); flash != e.expectedFlash {
+			t.Errorf("%s: got flash %q, wanted %q", e.name, flash, e.expectedFlash)
+		}
+		if msg := session.PopString(req.Context(), "error"); msg != e.expectedError {
+			t.Errorf("%s: got error %q, wanted %q", e.name, msg, e.expectedError)
+		}
+	}
+}
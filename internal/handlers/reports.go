@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/export"
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/go-chi/chi"
+)
+
+// exportWriteTimeout replaces the server write timeout while a download is streamed
+const exportWriteTimeout = 5 * time.Minute
+
+// maxReportNights caps the period of the occupancy report
+const maxReportNights = 366
+
+// download streams a table to the client as a CSV or XLSX attachment. The
+// response is only started by the first row, so an error before it can still
+// be answered with an error page.
+type download struct {
+	w        http.ResponseWriter
+	format   string
+	filename string
+	sheet    string
+	header   []interface{}
+	out      export.Writer
+}
+
+// newDownload validates format and prepares a download named filename
+func newDownload(w http.ResponseWriter, format, filename, sheet string, header ...interface{}) (*download, error) {
+	if format == "" {
+		format = export.FormatCSV
+	}
+	if format != export.FormatCSV && format != export.FormatXLSX {
+		return nil, export.ErrUnknownFormat
+	}
+
+	return &download{
+		w:        w,
+		format:   format,
+		filename: fmt.Sprintf("%s.%s", filename, format),
+		sheet:    sheet,
+		header:   header,
+	}, nil
+}
+
+// start sends the response headers and the header row
+func (d *download) start() error {
+	// big exports can outlast the server write timeout
+	http.NewResponseController(d.w).SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+
+	d.w.Header().Set("Content-Type", export.ContentType(d.format))
+	d.w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, d.filename))
+
+	out, err := export.New(d.format, d.w, d.sheet)
+	if err != nil {
+		return err
+	}
+	d.out = out
+	return d.out.WriteRow(d.header...)
+}
+
+// WriteRow writes a row, starting the response if needed
+func (d *download) WriteRow(cells ...interface{}) error {
+	if d.out == nil {
+		if err := d.start(); err != nil {
+			return err
+		}
+	}
+	return d.out.WriteRow(cells...)
+}
+
+// Close finishes the file, which holds only the header row if nothing was written
+func (d *download) Close() error {
+	if d.out == nil {
+		if err := d.start(); err != nil {
+			return err
+		}
+	}
+	return d.out.Close()
+}
+
+// failDownload reports err. Once rows have been sent the status can't change any more,
+// so the connection is aborted rather than leaving a truncated file that looks complete.
+func (m *Application) failDownload(r *http.Request, d *download, err error) {
+	if d.out == nil {
+		m.serverError(d.w, r, err)
+		return
+	}
+	m.App.Logger.ErrorContext(r.Context(), "export aborted", "file", d.filename, "error", err)
+
+	// the Recoverer middleware swallows http.ErrAbortHandler and lets the response end
+	// normally, so the connection is closed here
+	conn, _, err := http.NewResponseController(d.w).Hijack()
+	if err != nil {
+		// HTTP/2 streams can't be hijacked, the server resets them on this panic if
+		// nothing recovers it
+		panic(http.ErrAbortHandler)
+	}
+	conn.Close()
+}
+
+// AdminExportReservations downloads the reservation list with the current filters and sort
+func (m *Application) AdminExportReservations(w http.ResponseWriter, r *http.Request) {
+	src := chi.URLParam(r, "src")
+	if src != "new" && src != "all" {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	q, invalid := reservationQueryFromRequest(r, src)
+	if len(invalid) > 0 {
+		m.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	d, err := newDownload(w, r.URL.Query().Get("format"),
+		fmt.Sprintf("reservations-%s-%s", src, today().Format("2006-01-02")), "Reservations",
+		"ID", "First Name", "Last Name", "Email", "Phone", "Room", "Arrival", "Departure", "Nights", "Status", "Created")
+	if err != nil {
+		m.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	err = m.db(r).EachReservation(r.Context(), q, func(res models.Reservation) error {
+		status := "New"
+		if res.Processed == 1 {
+			status = "Processed"
+		}
+		nights := int(res.EndDate.Sub(res.StartDate).Hours() / 24)
+		return d.WriteRow(res.ID, res.FirstName, res.LastName, res.Email, res.Phone, res.Room.RoomName,
+			res.StartDate, res.EndDate, nights, status, res.CreatedAt)
+	})
+	if err == nil {
+		err = d.Close()
+	}
+	if err != nil {
+		m.failDownload(r, d, err)
+	}
+}
+
+// AdminReports shows the reports page
+func (m *Application) AdminReports(w http.ResponseWriter, r *http.Request) {
+	start := today().AddDate(0, 0, 1-today().Day())
+
+	stringMap := make(map[string]string)
+	stringMap["from"] = start.Format("2006-01-02")
+	stringMap["to"] = start.AddDate(0, 1, 0).Format("2006-01-02")
+
+	m.Render.Template(w, r, "admin-reports.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+	})
+}
+
+// AdminReportOccupancy downloads, for each night of the period, which room units are reserved or blocked
+func (m *Application) AdminReportOccupancy(w http.ResponseWriter, r *http.Request) {
+	start, err := time.Parse("2006-01-02", r.URL.Query().Get("from"))
+	if err != nil {
+		m.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	end, err := time.Parse("2006-01-02", r.URL.Query().Get("to"))
+	if err != nil {
+		m.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	if nights := int(end.Sub(start).Hours() / 24); nights < 1 || nights > maxReportNights {
+		m.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	d, err := newDownload(w, r.URL.Query().Get("format"),
+		fmt.Sprintf("occupancy-%s-%s", start.Format("2006-01-02"), end.Format("2006-01-02")), "Occupancy",
+		"Night", "Occupied", "Occupancy %")
+	if err != nil {
+		m.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// one row per night, with a column per room unit and the totals. Every night
+	// lists every unit, so the columns are taken from the first one.
+	var units []models.RoomUnit
+	column := make(map[int]int)
+	var nights []models.RoomNight
+
+	flush := func() error {
+		if len(nights) == 0 {
+			return nil
+		}
+		if units == nil {
+			for i, n := range nights {
+				units = append(units, n.Unit)
+				column[n.Unit.ID] = i
+			}
+			header := append([]interface{}{"Night"}, unitNames(units)...)
+			d.header = append(header, "Occupied", "Occupancy %")
+		}
+
+		cells := make([]interface{}, len(units))
+		occupied := 0
+		for _, n := range nights {
+			i, ok := column[n.Unit.ID]
+			if !ok || !n.Occupied() {
+				continue
+			}
+			occupied++
+			if n.ReservationID != 0 {
+				cells[i] = n.GuestName
+			} else {
+				cells[i] = "Blocked"
+			}
+		}
+
+		rate := math.Round(float64(occupied)/float64(len(units))*1000) / 10
+		row := append([]interface{}{nights[0].Date}, cells...)
+		nights = nights[:0]
+		return d.WriteRow(append(row, occupied, rate)...)
+	}
+
+	err = m.db(r).EachRoomNight(r.Context(), start, end, func(n models.RoomNight) error {
+		if len(nights) > 0 && !n.Date.Equal(nights[0].Date) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		nights = append(nights, n)
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err == nil {
+		err = d.Close()
+	}
+	if err != nil {
+		m.failDownload(r, d, err)
+	}
+}
+
+// unitNames returns the names of room units as report cells
+func unitNames(units []models.RoomUnit) []interface{} {
+	names := make([]interface{}, len(units))
+	for i, u := range units {
+		names[i] = u.Name
+	}
+	return names
+}
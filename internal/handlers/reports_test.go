@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var exportTests = []struct {
+	name                string
+	url                 string
+	expectedStatusCode  int
+	expectedContentType string
+	expectedBody        []string
+}{
+	{
+		name:                "csv-by-default",
+		url:                 "/admin/reservations/all/export?q=smith",
+		expectedStatusCode:  http.StatusOK,
+		expectedContentType: "text/csv; charset=utf-8",
+		expectedBody: []string{
+			"ID,First Name,Last Name,Email,Phone,Room,Arrival,Departure,Nights,Status,Created\n",
+			"1,John,Smith,john@smith.com,,General's Quarters,2050-01-01,2050-01-03,2,New,\n",
+		},
+	},
+	{
+		name:                "xlsx",
+		url:                 "/admin/reservations/new/export?format=xlsx",
+		expectedStatusCode:  http.StatusOK,
+		expectedContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	},
+	{
+		name:               "unknown-format",
+		url:                "/admin/reservations/all/export?format=pdf",
+		expectedStatusCode: http.StatusBadRequest,
+	},
+	{
+		name:               "invalid-filter",
+		url:                "/admin/reservations/all/export?from=yesterday",
+		expectedStatusCode: http.StatusBadRequest,
+	},
+	{
+		name:               "unknown-list",
+		url:                "/admin/reservations/old/export",
+		expectedStatusCode: http.StatusNotFound,
+	},
+	{
+		name:               "database-error",
+		url:                "/admin/reservations/all/export?q=error",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:                "occupancy",
+		url:                 "/admin/reports/occupancy?from=2050-01-01&to=2050-01-04",
+		expectedStatusCode:  http.StatusOK,
+		expectedContentType: "text/csv; charset=utf-8",
+		expectedBody: []string{
+			"Night,General's Quarters,Major's Suite,Occupied,Occupancy %\n",
+			"2050-01-01,John Smith,,1,50\n",
+			"2050-01-02,Blocked,,1,50\n",
+			"2050-01-03,,,0,0\n",
+		},
+	},
+	{
+		name:               "occupancy-end-before-start",
+		url:                "/admin/reports/occupancy?from=2050-01-04&to=2050-01-01",
+		expectedStatusCode: http.StatusBadRequest,
+	},
+	{
+		name:               "occupancy-too-long",
+		url:                "/admin/reports/occupancy?from=2050-01-01&to=2052-01-01",
+		expectedStatusCode: http.StatusBadRequest,
+	},
+	{
+		name:               "occupancy-missing-dates",
+		url:                "/admin/reports/occupancy",
+		expectedStatusCode: http.StatusBadRequest,
+	},
+	{
+		name:               "occupancy-database-error",
+		url:                "/admin/reports/occupancy?from=2060-01-01&to=2060-01-04",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+// TestExports tests the reservation export and the occupancy report downloads
+func TestExports(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	routes := getRoutes(repo)
+
+	for _, e := range exportTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		rr := httptest.NewRecorder()
+
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+			continue
+		}
+
+		if e.expectedContentType != "" {
+			if ct := rr.Header().Get("Content-Type"); ct != e.expectedContentType {
+				t.Errorf("%s: got content type %s, wanted %s", e.name, ct, e.expectedContentType)
+			}
+			if cd := rr.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment;") {
+				t.Errorf("%s: expected an attachment, got %q", e.name, cd)
+			}
+		}
+
+		for _, want := range e.expectedBody {
+			if !strings.Contains(rr.Body.String(), want) {
+				t.Errorf("%s: expected body to contain %q, got %q", e.name, want, rr.Body.String())
+			}
+		}
+	}
+}
+
+// TestExportAborted tests the client sees the connection drop when an export fails
+// after its first rows were sent, rather than a file that looks complete
+func TestExportAborted(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	srv := httptest.NewServer(getRoutes(repo))
+	defer srv.Close()
+
+	// the rows sent may still be buffered when the connection drops, so the client
+	// sees it either before the response or while reading the body
+	resp, err := http.Get(srv.URL + "/admin/reservations/all/export?q=broken")
+	if err == nil {
+		_, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if err == nil {
+		t.Error("expected the connection to drop, got a complete download")
+	}
+}
+
+// TestExportXLSXIsWorkbook tests the Excel export is a readable archive
+func TestExportXLSXIsWorkbook(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	req, _ := http.NewRequest("GET", "/admin/reservations/all/export?format=xlsx", nil)
+	rr := httptest.NewRecorder()
+
+	getRoutes(repo).ServeHTTP(rr, req)
+
+	body := rr.Body.Bytes()
+	z, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("export is not a zip archive: %s", err)
+	}
+	if len(z.File) == 0 || z.File[0].Name != "[Content_Types].xml" {
+		t.Error("expected [Content_Types].xml as the first entry")
+	}
+}
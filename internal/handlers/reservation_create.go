@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	forms "github.com/florian-lahitte-uvi/bookings/internal/form"
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/florian-lahitte-uvi/bookings/internal/repository/dbrepo"
+)
+
+// renderNewReservation shows the admin reservation form with the values entered so far
+func (m *Application) renderNewReservation(w http.ResponseWriter, r *http.Request, form *forms.Form, res models.Reservation) {
+	rooms, err := m.db(r).AllRooms(r.Context())
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["rooms"] = rooms
+	data["sources"] = models.AdminSources
+
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = form.Get("start_date")
+	stringMap["end_date"] = form.Get("end_date")
+
+	m.Render.Template(w, r, "admin-make-reservation.page.tmpl", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// AdminNewReservation shows the form staff use to enter a phone, walk-in or email booking
+func (m *Application) AdminNewReservation(w http.ResponseWriter, r *http.Request) {
+	form := forms.New(r.URL.Query())
+	if !form.Has("notify") {
+		form.Set("notify", "1")
+	}
+
+	res := models.Reservation{Source: models.SourcePhone}
+	if roomID, err := strconv.Atoi(form.Get("room_id")); err == nil {
+		res.RoomID = roomID
+	}
+
+	m.renderNewReservation(w, r, form, res)
+}
+
+// AdminPostNewReservation creates a reservation entered by staff. The room must be
+// available unless the override is checked, in which case a reason is required and kept.
+func (m *Application) AdminPostNewReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	notify := form.Has("notify")
+	override := form.Has("override")
+
+	res := models.Reservation{
+		FirstName: strings.TrimSpace(form.Get("first_name")),
+		LastName:  strings.TrimSpace(form.Get("last_name")),
+		Email:     strings.TrimSpace(form.Get("email")),
+		Phone:     strings.TrimSpace(form.Get("phone")),
+		Source:    form.Get("source"),
+		CreatedBy: m.Session.GetInt(r.Context(), "user_id"),
+	}
+
+	form.Required("first_name", "last_name", "room_id", "start_date", "end_date", "source")
+	// walk-in guests may not leave an email, but it is needed to confirm by email
+	if notify {
+		form.Required("email")
+	}
+	if form.Has("email") {
+		form.IsEmail("email")
+	}
+
+	if form.Has("source") && !isAdminSource(res.Source) {
+		form.Errors.Add("source", "Choose how the reservation was made")
+	}
+
+	if form.Has("room_id") {
+		res.RoomID, err = strconv.Atoi(form.Get("room_id"))
+		if err == nil {
+			res.Room, err = m.db(r).GetRoomByID(r.Context(), res.RoomID)
+		}
+		if err != nil {
+			form.Errors.Add("room_id", "Choose a room")
+		}
+	}
+
+	if form.Has("start_date") {
+		res.StartDate, err = time.Parse("2006-01-02", form.Get("start_date"))
+		if err != nil {
+			form.Errors.Add("start_date", "Invalid date")
+		}
+	}
+	if form.Has("end_date") {
+		res.EndDate, err = time.Parse("2006-01-02", form.Get("end_date"))
+		if err != nil {
+			form.Errors.Add("end_date", "Invalid date")
+		}
+	}
+	if form.Errors.Get("start_date") == "" && form.Errors.Get("end_date") == "" && !res.EndDate.After(res.StartDate) {
+		form.Errors.Add("end_date", "Departure must be after arrival")
+	}
+
+	if !form.Valid() {
+		m.renderNewReservation(w, r, form, res)
+		return
+	}
+
+	available, err := m.db(r).SearchAvaibilityByDatesByRoomID(r.Context(), res.RoomID, res.StartDate, res.EndDate)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	if !available {
+		if !override {
+			form.Errors.Add("start_date", "The room is not available for these dates")
+			m.renderNewReservation(w, r, form, res)
+			return
+		}
+
+		form.Required("override_reason")
+		if form.Has("override_reason") {
+			form.MinLength("override_reason", 5)
+		}
+		if !form.Valid() {
+			m.renderNewReservation(w, r, form, res)
+			return
+		}
+		res.OverrideReason = strings.TrimSpace(form.Get("override_reason"))
+		m.App.Logger.WarnContext(r.Context(), "availability overridden",
+			"room_id", res.RoomID, "start_date", form.Get("start_date"), "end_date", form.Get("end_date"), "reason", res.OverrideReason)
+	}
+
+	// staff take payment at the desk, so the stay is not held for a payment online
+	res.AmountDue = res.Nights() * res.Room.Price
+	// the room may have been taken since it was found available, which only the override lets through
+	res.ID, err = m.db(r).CreateReservation(r.Context(), res, res.OverrideReason != "")
+	if errors.Is(err, dbrepo.ErrRestrictionConflict) {
+		form.Errors.Add("start_date", "The room is not available for these dates")
+		m.renderNewReservation(w, r, form, res)
+		return
+	}
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "cannot create reservation", "error", err)
+		m.Session.Put(r.Context(), "error", "Cannot create the reservation")
+		m.renderNewReservation(w, r, form, res)
+		return
+	}
+
+	if notify && res.Email != "" {
+		m.MailChan <- reservationConfirmation(r, res)
+	}
+
+	m.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation created for %s %s", res.FirstName, res.LastName))
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations/all/%d/show", res.ID), http.StatusSeeOther)
+}
+
+// isAdminSource reports whether source can be chosen by staff
+func isAdminSource(source string) bool {
+	for _, s := range models.AdminSources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
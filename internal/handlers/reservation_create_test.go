@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+)
+
+var adminNewReservationTests = []struct {
+	name               string
+	overrides          map[string]string
+	expectedStatusCode int
+	expectedLocation   string
+	expectedBody       string
+	expectedMail       bool
+}{
+	{
+		name:               "available",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/reservations/all/1/show",
+		expectedMail:       true,
+	},
+	{
+		name:               "email-suppressed",
+		overrides:          map[string]string{"notify": "", "email": ""},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/reservations/all/1/show",
+	},
+	{
+		name:               "not-available",
+		overrides:          map[string]string{"start_date": "2050-01-01", "end_date": "2050-01-03"},
+		expectedStatusCode: http.StatusOK,
+		expectedBody:       "The room is not available for these dates",
+	},
+	{
+		name:               "taken-since-search",
+		overrides:          map[string]string{"start_date": "2040-12-25", "end_date": "2040-12-27"},
+		expectedStatusCode: http.StatusOK,
+		expectedBody:       "The room is not available for these dates",
+	},
+	{
+		name:               "override-without-reason",
+		overrides:          map[string]string{"start_date": "2050-01-01", "end_date": "2050-01-03", "override": "1"},
+		expectedStatusCode: http.StatusOK,
+		expectedBody:       "This field cannot be blank",
+	},
+	{
+		name:               "override-with-reason",
+		overrides:          map[string]string{"start_date": "2050-01-01", "end_date": "2050-01-03", "override": "1", "override_reason": "Owner's family"},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/reservations/all/1/show",
+		expectedMail:       true,
+	},
+	{
+		name:               "missing-name",
+		overrides:          map[string]string{"first_name": ""},
+		expectedStatusCode: http.StatusOK,
+		expectedBody:       "This field cannot be blank",
+	},
+	{
+		name:               "notify-without-email",
+		overrides:          map[string]string{"email": ""},
+		expectedStatusCode: http.StatusOK,
+		expectedBody:       "This field cannot be blank",
+	},
+	{
+		name:               "invalid-source",
+		overrides:          map[string]string{"source": "web"},
+		expectedStatusCode: http.StatusOK,
+		expectedBody:       "Choose how the reservation was made",
+	},
+	{
+		name:               "departure-before-arrival",
+		overrides:          map[string]string{"start_date": "2040-01-03", "end_date": "2040-01-01"},
+		expectedStatusCode: http.StatusOK,
+		expectedBody:       "Departure must be after arrival",
+	},
+	{
+		name:               "unknown-room",
+		overrides:          map[string]string{"room_id": "3"},
+		expectedStatusCode: http.StatusOK,
+		expectedBody:       "Choose a room",
+	},
+	{
+		name:               "insert-fails",
+		overrides:          map[string]string{"room_id": "2"},
+		expectedStatusCode: http.StatusOK,
+		expectedBody:       "Create Reservation",
+	},
+	{
+		name:               "availability-error",
+		overrides:          map[string]string{"start_date": "2060-01-01", "end_date": "2060-01-03"},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+// TestAdminPostNewReservation tests reservations entered by staff
+func TestAdminPostNewReservation(t *testing.T) {
+	t.Parallel()
+
+	for _, e := range adminNewReservationTests {
+		// a repository of its own, to see the mail queued by the handler
+		repo := newTestApplication(t)
+
+		values := url.Values{}
+		values.Set("first_name", "John")
+		values.Set("last_name", "Smith")
+		values.Set("email", "john@smith.com")
+		values.Set("phone", "555-555-5555")
+		values.Set("room_id", "1")
+		values.Set("start_date", "2040-01-01")
+		values.Set("end_date", "2040-01-03")
+		values.Set("source", models.SourcePhone)
+		values.Set("notify", "1")
+		for k, v := range e.overrides {
+			if v == "" {
+				values.Del(k)
+			} else {
+				values.Set(k, v)
+			}
+		}
+
+		req, _ := http.NewRequest("POST", "/admin/reservations/create", strings.NewReader(values.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.AdminPostNewReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc == nil || actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: wrong location %v, wanted %s", e.name, actualLoc, e.expectedLocation)
+			}
+		}
+
+		if e.expectedBody != "" && !strings.Contains(rr.Body.String(), e.expectedBody) {
+			t.Errorf("%s: expected page to contain %q", e.name, e.expectedBody)
+		}
+
+		if sent := len(repo.MailChan) == 1; sent != e.expectedMail {
+			t.Errorf("%s: mail sent %v, wanted %v", e.name, sent, e.expectedMail)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/florian-lahitte-uvi/bookings/internal/repository"
+)
+
+// listColumn is a sortable column header of a reservation list
+type listColumn struct {
+	Label  string
+	URL    string
+	Active bool
+	Desc   bool
+}
+
+// listColumns are the columns shown in the reservation lists, by sort key
+var listColumns = []struct{ key, label string }{
+	{"id", "ID"},
+	{"last_name", "Last Name"},
+	{"room", "Room"},
+	{"start_date", "Arrival"},
+	{"end_date", "Departure"},
+}
+
+// listParams are the query string parameters understood by the reservation lists,
+// kept when building paging and sorting links
+var listParams = []string{"q", "room_id", "from", "to", "status", "sort", "dir", "limit"}
+
+// reservationQueryFromRequest reads the search, filter, sort and paging parameters.
+// The new reservations list is always restricted to unprocessed reservations.
+// Parameters that can't be parsed are ignored and reported in the returned slice.
+func reservationQueryFromRequest(r *http.Request, src string) (repository.ReservationQuery, []string) {
+	v := r.URL.Query()
+	var invalid []string
+
+	q := repository.ReservationQuery{
+		Search: strings.TrimSpace(v.Get("q")),
+		Status: v.Get("status"),
+		Sort:   v.Get("sort"),
+		Desc:   v.Get("dir") == "desc",
+		After:  v.Get("after"),
+		Before: v.Get("before"),
+	}
+
+	if id := v.Get("room_id"); id != "" {
+		roomID, err := strconv.Atoi(id)
+		if err != nil {
+			invalid = append(invalid, "room")
+		}
+		q.RoomID = roomID
+	}
+
+	for _, d := range []struct {
+		name   string
+		target *time.Time
+	}{{"from", &q.From}, {"to", &q.To}} {
+		if value := v.Get(d.name); value != "" {
+			t, err := time.Parse("2006-01-02", value)
+			if err != nil {
+				invalid = append(invalid, d.name)
+				continue
+			}
+			*d.target = t
+		}
+	}
+
+	if l := v.Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil {
+			invalid = append(invalid, "limit")
+		}
+		q.Limit = limit
+	}
+
+	if q.Sort != "" && !repository.IsSortKey(q.Sort) {
+		invalid = append(invalid, "sort")
+		q.Sort = ""
+	}
+
+	switch q.Status {
+	case repository.StatusAll, repository.StatusNew, repository.StatusProcessed:
+	default:
+		invalid = append(invalid, "status")
+		q.Status = repository.StatusAll
+	}
+	if src == "new" {
+		q.Status = repository.StatusNew
+	}
+
+	return q, invalid
+}
+
+// listURL builds a link to the list at path keeping the current filters, with overrides applied
+func listURL(path string, current url.Values, overrides map[string]string) string {
+	v := url.Values{}
+	for _, p := range listParams {
+		if current.Get(p) != "" {
+			v.Set(p, current.Get(p))
+		}
+	}
+	for key, value := range overrides {
+		if value == "" {
+			v.Del(key)
+		} else {
+			v.Set(key, value)
+		}
+	}
+
+	if len(v) == 0 {
+		return path
+	}
+	return path + "?" + v.Encode()
+}
+
+// renderReservationList renders one page of a reservation list with its filters
+func (m *Application) renderReservationList(w http.ResponseWriter, r *http.Request, src, tmpl string) {
+	q, invalid := reservationQueryFromRequest(r, src)
+	if len(invalid) > 0 {
+		m.Session.Put(r.Context(), "warning", fmt.Sprintf("Ignored invalid filter: %s", strings.Join(invalid, ", ")))
+	}
+
+	page, err := m.db(r).ListReservations(r.Context(), q)
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "error retrieving reservations", "error", err)
+		m.Session.Put(r.Context(), "error", "Error retrieving reservations")
+	}
+
+	rooms, err := m.db(r).AllRooms(r.Context())
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	path := fmt.Sprintf("/admin/reservations-%s", src)
+	current := r.URL.Query()
+
+	sort := q.Sort
+	if sort == "" {
+		sort = "start_date"
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+	stringMap["path"] = path
+	stringMap["q"] = q.Search
+	stringMap["from"] = current.Get("from")
+	stringMap["to"] = current.Get("to")
+	stringMap["status"] = q.Status
+	stringMap["sort"] = sort
+	stringMap["dir"] = current.Get("dir")
+	stringMap["limit"] = strconv.Itoa(q.PageSize())
+
+	if page.NextCursor != "" {
+		stringMap["next_url"] = listURL(path, current, map[string]string{"after": page.NextCursor})
+	}
+	if page.PrevCursor != "" {
+		stringMap["prev_url"] = listURL(path, current, map[string]string{"before": page.PrevCursor})
+	}
+
+	exportPath := fmt.Sprintf("/admin/reservations/%s/export", src)
+	stringMap["export_csv"] = listURL(exportPath, current, map[string]string{"format": "csv"})
+	stringMap["export_xlsx"] = listURL(exportPath, current, map[string]string{"format": "xlsx"})
+
+	// clicking a column sorts on it, clicking the current column flips the direction
+	columns := make([]listColumn, 0, len(listColumns))
+	for _, c := range listColumns {
+		dir := ""
+		if c.key == sort && !q.Desc {
+			dir = "desc"
+		}
+		columns = append(columns, listColumn{
+			Label:  c.label,
+			URL:    listURL(path, current, map[string]string{"sort": c.key, "dir": dir}),
+			Active: c.key == sort,
+			Desc:   q.Desc,
+		})
+	}
+
+	intMap := make(map[string]int)
+	intMap["room_id"] = q.RoomID
+
+	data := make(map[string]interface{})
+	data["reservations"] = page.Reservations
+	data["rooms"] = rooms
+	data["columns"] = columns
+
+	m.Render.Template(w, r, tmpl, &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    intMap,
+	})
+}
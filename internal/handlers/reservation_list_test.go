@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/repository"
+)
+
+var reservationQueryTests = []struct {
+	name            string
+	src             string
+	url             string
+	expectedQuery   repository.ReservationQuery
+	expectedInvalid int
+}{
+	{
+		name:          "defaults",
+		src:           "all",
+		url:           "/admin/reservations-all",
+		expectedQuery: repository.ReservationQuery{},
+	},
+	{
+		name: "all-filters",
+		src:  "all",
+		url:  "/admin/reservations-all?q=smith&room_id=1&from=2050-01-01&to=2050-01-31&status=processed&sort=last_name&dir=desc&limit=50&after=abc",
+		expectedQuery: repository.ReservationQuery{
+			Search: "smith",
+			RoomID: 1,
+			From:   time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			To:     time.Date(2050, 1, 31, 0, 0, 0, 0, time.UTC),
+			Status: repository.StatusProcessed,
+			Sort:   "last_name",
+			Desc:   true,
+			Limit:  50,
+			After:  "abc",
+		},
+	},
+	{
+		name:          "new-list-forces-status",
+		src:           "new",
+		url:           "/admin/reservations-new?status=processed",
+		expectedQuery: repository.ReservationQuery{Status: repository.StatusNew},
+	},
+	{
+		name:            "invalid-values",
+		src:             "all",
+		url:             "/admin/reservations-all?room_id=x&from=01/01/2050&sort=password&status=deleted&limit=many",
+		expectedQuery:   repository.ReservationQuery{},
+		expectedInvalid: 5,
+	},
+}
+
+// TestReservationQueryFromRequest tests the list parameters are parsed
+func TestReservationQueryFromRequest(t *testing.T) {
+	for _, e := range reservationQueryTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+
+		q, invalid := reservationQueryFromRequest(req, e.src)
+		if q != e.expectedQuery {
+			t.Errorf("%s: got query %+v, wanted %+v", e.name, q, e.expectedQuery)
+		}
+		if len(invalid) != e.expectedInvalid {
+			t.Errorf("%s: got %d invalid parameters (%v), wanted %d", e.name, len(invalid), invalid, e.expectedInvalid)
+		}
+	}
+}
+
+// TestAdminAllReservationsPagination tests paging links keep the current filters
+func TestAdminAllReservationsPagination(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	req, _ := http.NewRequest("GET", "/admin/reservations-all?q=smith&sort=last_name&after=abc", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(repo.AdminAllReservations)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("all reservations returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	body := rr.Body.String()
+	for _, want := range []string{
+		"/admin/reservations-all?after=next&amp;q=smith&amp;sort=last_name",
+		"/admin/reservations-all?before=prev&amp;q=smith&amp;sort=last_name",
+		"/admin/reservations-all?dir=desc&amp;q=smith&amp;sort=last_name",
+		"Smith",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected page to contain %q", want)
+		}
+	}
+}
+
+// TestAdminNewReservationsError tests a failing query still renders the page
+func TestAdminNewReservationsError(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	req, _ := http.NewRequest("GET", "/admin/reservations-new?q=error", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(repo.AdminNewReservations)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("new reservations returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	if !strings.Contains(rr.Body.String(), "No reservations match these filters") {
+		t.Error("expected empty list message")
+	}
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+// maxInboundSize caps the size of a guest reply posted to InboundMail
+const maxInboundSize = 10 << 20
+
+// replyAddress and replySubject find the reply token of a reservation in the address
+// a guest replied to, or failing that in the subject they kept
+var (
+	replyAddress = regexp.MustCompile(`(?i)reply\+([0-9a-z]+)@`)
+	replySubject = regexp.MustCompile(`(?i)\[ref:([0-9a-z]+)\]`)
+)
+
+// replyToken returns the reply token in a guest reply, or an empty string
+func replyToken(to, subject string) string {
+	if match := replyAddress.FindStringSubmatch(to); match != nil {
+		return strings.ToLower(match[1])
+	}
+	if match := replySubject.FindStringSubmatch(subject); match != nil {
+		return strings.ToLower(match[1])
+	}
+	return ""
+}
+
+// reservationPage returns the admin page of a reservation, going back to the
+// calendar month if there is one
+func reservationPage(src string, id int, year, month string) string {
+	page := fmt.Sprintf("/admin/reservations/%s/%d/show", src, id)
+	if year != "" {
+		page += fmt.Sprintf("?y=%s&m=%s", year, month)
+	}
+	return page
+}
+
+// AdminPostReservationNote adds a staff note to a reservation
+func (m *Application) AdminPostReservationNote(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	page := reservationPage(chi.URLParam(r, "src"), id, r.Form.Get("year"), r.Form.Get("month"))
+
+	body := strings.TrimSpace(r.Form.Get("note"))
+	if body == "" {
+		m.Session.Put(r.Context(), "error", "Write something before adding a note")
+		http.Redirect(w, r, page, http.StatusSeeOther)
+		return
+	}
+
+	res, err := m.db(r).GetReservationByID(r.Context(), id)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	_, err = m.db(r).AddReservationNote(r.Context(), models.ReservationNote{
+		ReservationID: res.ID,
+		UserID:        m.Session.GetInt(r.Context(), "user_id"),
+		Body:          body,
+	})
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	m.Session.Put(r.Context(), "flash", "Note added")
+	http.Redirect(w, r, page, http.StatusSeeOther)
+}
+
+// AdminPostReservationMessage emails the guest of a reservation and keeps the message
+// in the reservation's history. Replies can be threaded back with the reply token.
+func (m *Application) AdminPostReservationMessage(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	page := reservationPage(chi.URLParam(r, "src"), id, r.Form.Get("year"), r.Form.Get("month"))
+
+	subject := strings.TrimSpace(r.Form.Get("subject"))
+	body := strings.TrimSpace(r.Form.Get("message"))
+	if subject == "" || body == "" {
+		m.Session.Put(r.Context(), "error", "A message needs a subject and some text")
+		http.Redirect(w, r, page, http.StatusSeeOther)
+		return
+	}
+
+	res, err := m.db(r).GetReservationByID(r.Context(), id)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+	if res.Email == "" {
+		m.Session.Put(r.Context(), "error", "This reservation has no email address")
+		http.Redirect(w, r, page, http.StatusSeeOther)
+		return
+	}
+
+	token, err := m.db(r).ReplyToken(r.Context(), res.ID)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	msg := models.ReservationMessage{
+		ReservationID: res.ID,
+		Direction:     models.MessageOut,
+		UserID:        m.Session.GetInt(r.Context(), "user_id"),
+		From:          mailFrom,
+		To:            res.Email,
+		Subject:       fmt.Sprintf("%s [ref:%s]", subject, token),
+		Body:          body,
+	}
+	_, err = m.db(r).AddReservationMessage(r.Context(), msg)
+	if err != nil {
+		m.serverError(w, r, err)
+		return
+	}
+
+	mail := models.MailData{
+		From:      msg.From,
+		To:        msg.To,
+		Subject:   msg.Subject,
+		Content:   strings.ReplaceAll(template.HTMLEscapeString(body), "\n", "<br>"),
+		Template:  "basic",
+		RequestID: middleware.GetReqID(r.Context()),
+	}
+	if m.App.MailReplyDomain != "" {
+		mail.ReplyTo = fmt.Sprintf("reply+%s@%s", token, m.App.MailReplyDomain)
+	}
+	m.MailChan <- mail
+
+	m.Session.Put(r.Context(), "flash", "Message sent to "+res.Email)
+	http.Redirect(w, r, page, http.StatusSeeOther)
+}
+
+type inboundResponse struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// InboundMail receives the replies of guests from the mail provider, as a form with
+// to, from, subject and text fields, and adds them to the history of the reservation
+// named by the reply token. Requests must carry the configured secret in the
+// X-Inbound-Secret header.
+func (m *Application) InboundMail(w http.ResponseWriter, r *http.Request) {
+	secret := m.App.MailInboundSecret
+	if secret == "" {
+		m.clientError(w, r, http.StatusNotFound)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Inbound-Secret")), []byte(secret)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, inboundResponse{Message: "invalid secret"})
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxInboundSize)
+	err := r.ParseMultipartForm(maxInboundSize)
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		writeJSON(w, http.StatusBadRequest, inboundResponse{Message: "can't parse form"})
+		return
+	}
+
+	to := r.Form.Get("to")
+	subject := r.Form.Get("subject")
+	token := replyToken(to, subject)
+	if token == "" {
+		writeJSON(w, http.StatusNotFound, inboundResponse{Message: "no reply token in the address or subject"})
+		return
+	}
+
+	id, err := m.DB.ReservationIDByReplyToken(r.Context(), token)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSON(w, http.StatusNotFound, inboundResponse{Message: "no reservation for this reply token"})
+		return
+	}
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "error finding reservation by reply token", "error", err)
+		writeJSON(w, http.StatusInternalServerError, inboundResponse{Message: "Error querying database"})
+		return
+	}
+
+	_, err = m.DB.AddReservationMessage(r.Context(), models.ReservationMessage{
+		ReservationID: id,
+		Direction:     models.MessageIn,
+		From:          r.Form.Get("from"),
+		To:            to,
+		Subject:       subject,
+		Body:          r.Form.Get("text"),
+	})
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "error saving guest reply", "reservation_id", id, "error", err)
+		writeJSON(w, http.StatusInternalServerError, inboundResponse{Message: "Error saving message"})
+		return
+	}
+
+	m.App.Logger.InfoContext(r.Context(), "guest reply received", "reservation_id", id)
+	writeJSON(w, http.StatusOK, inboundResponse{OK: true})
+}
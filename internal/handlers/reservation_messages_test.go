@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+var replyTokenTests = []struct {
+	name     string
+	to       string
+	subject  string
+	expected string
+}{
+	{"address", "John <reply+abc123@bookings.test>", "Re: Your stay", "abc123"},
+	{"subject", "bookings@bookings.test", "Re: Your stay [ref:def456]", "def456"},
+	{"address-wins", "reply+abc123@bookings.test", "Re: Your stay [ref:def456]", "abc123"},
+	{"upper-case", "REPLY+ABC123@BOOKINGS.TEST", "", "abc123"},
+	{"none", "bookings@bookings.test", "Hello", ""},
+}
+
+// TestReplyToken tests finding the reply token in a guest reply
+func TestReplyToken(t *testing.T) {
+	for _, e := range replyTokenTests {
+		if got := replyToken(e.to, e.subject); got != e.expected {
+			t.Errorf("%s: expected token %q, got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+var reservationNoteTests = []struct {
+	name               string
+	id                 string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{
+		name:               "valid",
+		id:                 "1",
+		postedData:         url.Values{"note": {"Asked for a cot"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/reservations/all/1/show",
+	},
+	{
+		name:               "from-calendar",
+		id:                 "1",
+		postedData:         url.Values{"note": {"Asked for a cot"}, "year": {"2050"}, "month": {"01"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/reservations/all/1/show?y=2050&m=01",
+	},
+	{
+		name:               "empty",
+		id:                 "1",
+		postedData:         url.Values{"note": {"  "}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/reservations/all/1/show",
+	},
+	{
+		name:               "save-fails",
+		id:                 "2",
+		postedData:         url.Values{"note": {"Asked for a cot"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "missing-reservation",
+		id:                 "3",
+		postedData:         url.Values{"note": {"Asked for a cot"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+// TestAdminPostReservationNote tests adding staff notes to a reservation
+func TestAdminPostReservationNote(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestApplication(t)
+	routes := getRoutes(repo)
+
+	for _, e := range reservationNoteTests {
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/"+e.id+"/notes", strings.NewReader(e.postedData.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		routes.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc == nil || actualLoc.String() != e.expectedLocation {
+				t.Errorf("%s: wrong location %v, wanted %s", e.name, actualLoc, e.expectedLocation)
+			}
+		}
+	}
+}
+
+var reservationMessageTests = []struct {
+	name               string
+	id                 string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedMail       bool
+}{
+	{
+		name:               "valid",
+		id:                 "1",
+		postedData:         url.Values{"subject": {"Your stay"}, "message": {"Anything we can prepare?\nSee you soon"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedMail:       true,
+	},
+	{
+		name:               "missing-subject",
+		id:                 "1",
+		postedData:         url.Values{"message": {"Anything we can prepare?"}},
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name:               "no-email",
+		id:                 "2",
+		postedData:         url.Values{"subject": {"Your stay"}, "message": {"Anything we can prepare?"}},
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name:               "missing-reservation",
+		id:                 "3",
+		postedData:         url.Values{"subject": {"Your stay"}, "message": {"Anything we can prepare?"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+// TestAdminPostReservationMessage tests emailing the guest of a reservation
+func TestAdminPostReservationMessage(t *testing.T) {
+	t.Parallel()
+
+	for _, e := range reservationMessageTests {
+		// a repository of its own, to see the mail queued by the handler
+		repo := newTestApplication(t)
+		repo.App.MailReplyDomain = "bookings.test"
+
+		req, _ := http.NewRequest("POST", "/admin/reservations/all/"+e.id+"/messages", strings.NewReader(e.postedData.Encode()))
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "all")
+		rctx.URLParams.Add("id", e.id)
+		ctx := getCtx(req)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.AdminPostReservationMessage)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if sent := len(repo.MailChan) == 1; sent != e.expectedMail {
+			t.Fatalf("%s: mail sent %v, wanted %v", e.name, sent, e.expectedMail)
+		}
+		if !e.expectedMail {
+			continue
+		}
+
+		msg := <-repo.MailChan
+		if msg.ReplyTo != "reply+abc123@bookings.test" {
+			t.Errorf("%s: wrong reply-to %q", e.name, msg.ReplyTo)
+		}
+		if msg.Subject != "Your stay [ref:abc123]" {
+			t.Errorf("%s: wrong subject %q", e.name, msg.Subject)
+		}
+		if !strings.Contains(msg.Content, "prepare?<br>See you soon") {
+			t.Errorf("%s: expected line breaks in the content, got %q", e.name, msg.Content)
+		}
+	}
+}
+
+var inboundMailTests = []struct {
+	name               string
+	secret             string
+	header             string
+	postedData         url.Values
+	multipart          bool
+	expectedStatusCode int
+}{
+	{
+		name:               "disabled",
+		header:             "s3cret",
+		postedData:         url.Values{"to": {"reply+abc123@bookings.test"}, "text": {"Thanks"}},
+		expectedStatusCode: http.StatusNotFound,
+	},
+	{
+		name:               "wrong-secret",
+		secret:             "s3cret",
+		header:             "guess",
+		postedData:         url.Values{"to": {"reply+abc123@bookings.test"}, "text": {"Thanks"}},
+		expectedStatusCode: http.StatusUnauthorized,
+	},
+	{
+		name:               "token-in-address",
+		secret:             "s3cret",
+		header:             "s3cret",
+		postedData:         url.Values{"to": {"reply+abc123@bookings.test"}, "from": {"john@smith.com"}, "subject": {"Re: Your stay"}, "text": {"Thanks"}},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:               "token-in-subject",
+		secret:             "s3cret",
+		header:             "s3cret",
+		postedData:         url.Values{"to": {"me@here.com"}, "subject": {"Re: Your stay [ref:abc123]"}, "text": {"Thanks"}},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:               "multipart",
+		secret:             "s3cret",
+		header:             "s3cret",
+		postedData:         url.Values{"to": {"reply+abc123@bookings.test"}, "text": {"Thanks"}},
+		multipart:          true,
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name:               "no-token",
+		secret:             "s3cret",
+		header:             "s3cret",
+		postedData:         url.Values{"to": {"me@here.com"}, "subject": {"Hello"}, "text": {"Thanks"}},
+		expectedStatusCode: http.StatusNotFound,
+	},
+	{
+		name:               "unknown-token",
+		secret:             "s3cret",
+		header:             "s3cret",
+		postedData:         url.Values{"to": {"reply+ffffff@bookings.test"}, "text": {"Thanks"}},
+		expectedStatusCode: http.StatusNotFound,
+	},
+	{
+		name:               "lookup-fails",
+		secret:             "s3cret",
+		header:             "s3cret",
+		postedData:         url.Values{"to": {"reply+fail@bookings.test"}, "text": {"Thanks"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "save-fails",
+		secret:             "s3cret",
+		header:             "s3cret",
+		postedData:         url.Values{"to": {"reply+def456@bookings.test"}, "text": {"Thanks"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+// TestInboundMail tests threading guest replies back to their reservation
+func TestInboundMail(t *testing.T) {
+	t.Parallel()
+
+	for _, e := range inboundMailTests {
+		repo := newTestApplication(t)
+		repo.App.MailInboundSecret = e.secret
+
+		var req *http.Request
+		if e.multipart {
+			body := &bytes.Buffer{}
+			mw := multipart.NewWriter(body)
+			for k := range e.postedData {
+				mw.WriteField(k, e.postedData.Get(k))
+			}
+			mw.Close()
+			req, _ = http.NewRequest("POST", "/mail/inbound", body)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+		} else {
+			req, _ = http.NewRequest("POST", "/mail/inbound", strings.NewReader(e.postedData.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		req.Header.Set("X-Inbound-Secret", e.header)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.InboundMail)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
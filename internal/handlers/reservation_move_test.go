@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var adminMoveReservationTests = []struct {
+	name               string
+	url                string
+	overrides          map[string]string
+	expectedStatusCode int
+	expectedBody       string
+	expectedMail       bool
+}{
+	{
+		name:               "unchanged",
+		url:                "/admin/reservations/all/1/show",
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name:               "other-dates",
+		url:                "/admin/reservations/all/2/show",
+		overrides:          map[string]string{"start_date": "2040-01-01", "end_date": "2040-01-03"},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedMail:       true,
+	},
+	{
+		name:               "other-dates-without-notice",
+		url:                "/admin/reservations/all/2/show",
+		overrides:          map[string]string{"start_date": "2040-01-01", "end_date": "2040-01-03", "notify": ""},
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name:               "longer-stay-over-own-nights",
+		url:                "/admin/reservations/all/1/show",
+		overrides:          map[string]string{"end_date": "2050-01-05"},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedMail:       true,
+	},
+	{
+		name:               "taken-by-another-reservation",
+		url:                "/admin/reservations/all/2/show",
+		overrides:          map[string]string{"end_date": "2050-01-05"},
+		expectedStatusCode: http.StatusOK,
+		expectedBody:       "The room is not available for these dates",
+	},
+	{
+		name:               "departure-before-arrival",
+		url:                "/admin/reservations/all/1/show",
+		overrides:          map[string]string{"start_date": "2040-01-03", "end_date": "2040-01-01"},
+		expectedStatusCode: http.StatusOK,
+		expectedBody:       "Departure must be after arrival",
+	},
+	{
+		name:               "invalid-date",
+		url:                "/admin/reservations/all/1/show",
+		overrides:          map[string]string{"start_date": "not-a-date"},
+		expectedStatusCode: http.StatusOK,
+		expectedBody:       "Invalid date",
+	},
+	{
+		name:               "unknown-room",
+		url:                "/admin/reservations/all/1/show",
+		overrides:          map[string]string{"room_id": "3"},
+		expectedStatusCode: http.StatusOK,
+		expectedBody:       "Choose a room",
+	},
+	{
+		name:               "availability-error",
+		url:                "/admin/reservations/all/1/show",
+		overrides:          map[string]string{"start_date": "2060-01-01", "end_date": "2060-01-03"},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "move-fails",
+		url:                "/admin/reservations/all/1/show",
+		overrides:          map[string]string{"room_id": "2", "start_date": "2040-01-01", "end_date": "2040-01-03"},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+// TestAdminPostShowReservationMove tests changing the room and dates of a reservation
+func TestAdminPostShowReservationMove(t *testing.T) {
+	t.Parallel()
+
+	for _, e := range adminMoveReservationTests {
+		// a repository of its own, to see the mail queued by the handler
+		repo := newTestApplication(t)
+
+		values := url.Values{}
+		values.Set("first_name", "John")
+		values.Set("last_name", "Smith")
+		values.Set("email", "john@smith.com")
+		values.Set("phone", "555-555-5555")
+		values.Set("room_id", "1")
+		values.Set("start_date", "2050-01-01")
+		values.Set("end_date", "2050-01-03")
+		values.Set("notify", "1")
+		for k, v := range e.overrides {
+			if v == "" {
+				values.Del(k)
+			} else {
+				values.Set(k, v)
+			}
+		}
+
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(values.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(repo.AdminPostShowReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedStatusCode == http.StatusSeeOther {
+			if flash := session.GetString(req.Context(), "flash"); flash == "" {
+				t.Errorf("%s: expected a flash message", e.name)
+			}
+		}
+
+		if e.expectedBody != "" && !strings.Contains(rr.Body.String(), e.expectedBody) {
+			t.Errorf("%s: expected page to contain %q", e.name, e.expectedBody)
+		}
+
+		if sent := len(repo.MailChan) == 1; sent != e.expectedMail {
+			t.Errorf("%s: mail sent %v, wanted %v", e.name, sent, e.expectedMail)
+		}
+	}
+}
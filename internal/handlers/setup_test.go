@@ -13,6 +13,7 @@ import (
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/florian-lahitte-uvi/bookings/internal/config"
+	"github.com/florian-lahitte-uvi/bookings/internal/logger"
 	"github.com/florian-lahitte-uvi/bookings/internal/models"
 	"github.com/florian-lahitte-uvi/bookings/internal/render"
 	"github.com/go-chi/chi"
@@ -24,28 +25,25 @@ var app config.AppConfig
 var session *scs.SessionManager
 var pathToTemplates = "./../../templates"
 
-var functions = template.FuncMap{
-	"humanDate":  render.HumanDate,
-	"formatDate": render.FormatDate,
-	"iterate":    render.Iterate,
-	"add":        render.Add,
-}
-
 func TestMain(m *testing.M) {
 	gob.Register(models.Reservation{})
 	gob.Register(models.User{})
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
+	gob.Register(models.RoomRestriction{})
 	gob.Register(map[string]int{})
 
 	// change this to true when in production
 	app.InProduction = false
+	app.Currency = "usd"
+	app.DepositPercent = 100
+	app.PaymentHold = 30 * time.Minute
+	app.RoomHold = 10 * time.Minute
+	app.FlexibleDays = 7
+	app.BaseURL = "https://bookings.test"
+	app.InvoiceIssuer = "Fort Smythe Bed and Breakfast"
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
-
-	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errorLog
+	app.Logger = logger.New(os.Stdout, "text", "info")
 
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
@@ -53,14 +51,6 @@ func TestMain(m *testing.M) {
 	session.Cookie.SameSite = http.SameSiteLaxMode
 	session.Cookie.Secure = app.InProduction
 
-	app.Session = session
-
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-	defer close(mailChan)
-
-	listenForMail()
-
 	tc, err := CreateTestTemplateCache()
 	if err != nil {
 		log.Fatal("cannot create template cache")
@@ -69,58 +59,110 @@ func TestMain(m *testing.M) {
 	app.TemplateCache = tc
 	app.UseCache = true
 
-	repo := NewTestRepo(&app)
-	NewHandlers(repo)
-	render.NewRenderer(&app)
-
 	os.Exit(m.Run())
 }
 
-func listenForMail() {
-	go func() {
-		for {
-			_ = <-app.MailChan
-		}
-	}()
+// newTestApplication returns an application of its own for a test, on a copy of the
+// test config, so that a test can change its settings and read its mail queue
+// without affecting the others
+func newTestApplication(t *testing.T) *Application {
+	t.Helper()
+	cfg := app
+	return NewTestApplication(&cfg, session, make(chan models.MailData, 100))
 }
 
-func getRoutes() http.Handler {
+// getRoutes returns the routes served by repo
+func getRoutes(repo *Application) http.Handler {
 	mux := chi.NewRouter()
 
 	mux.Use(middleware.Recoverer)
 	//mux.Use(NoSurf)
 	mux.Use(SessionLoad)
 
-	mux.Get("/", Repo.Home)
-	mux.Get("/about", Repo.About)
-	mux.Get("/generals-quarters", Repo.Generals)
-	mux.Get("/majors-suite", Repo.Majors)
-
-	mux.Get("/search-availability", Repo.Availability)
-	mux.Post("/search-availability", Repo.PostAvailability)
-	mux.Post("/search-availability-json", Repo.AvailabilityJSON)
-
-	mux.Get("/contact", Repo.Contact)
-
-	mux.Get("/make-reservation", Repo.Reservation)
-	mux.Post("/make-reservation", Repo.PostReservation)
-	mux.Get("/reservation-summary", Repo.ReservationSummary)
-
-	mux.Get("/user/login", Repo.ShowLogin)
-	mux.Post("/user/login", Repo.PostShowLogin)
-	mux.Get("/user/logout", Repo.Logout)
-
-	mux.Get("/admin/dashboard", Repo.AdminDashboard)
-
-	mux.Get("/admin/reservations-new", Repo.AdminNewReservations)
-	mux.Get("/admin/reservations-all", Repo.AdminAllReservations)
-	mux.Get("/admin/reservations-calendar", Repo.AdminReservationsCalendar)
-	mux.Post("/admin/reservations-calendar", Repo.AdminPostReservationsCalendar)
-	mux.Get("/admin/process-reservation/{src}/{id}/do", Repo.AdminProcessReservation)
-	mux.Get("/admin/delete-reservation/{src}/{id}/do", Repo.AdminDeleteReservation)
-
-	mux.Get("/admin/reservations/{src}/{id}/show", Repo.AdminShowReservation)
-	mux.Post("/admin/reservations/{src}/{id}", Repo.AdminPostShowReservation)
+	mux.Get("/healthz", repo.Healthz)
+	mux.Post("/mail/inbound", repo.InboundMail)
+	mux.Post("/payments/webhook", repo.PaymentWebhook)
+	mux.Get("/payments/fake/{id}", repo.FakeCheckout)
+	mux.Post("/payments/fake/{id}", repo.PostFakeCheckout)
+
+	mux.Get("/", repo.Home)
+	mux.Get("/about", repo.About)
+	mux.Get("/generals-quarters", repo.Generals)
+	mux.Get("/majors-suite", repo.Majors)
+
+	mux.Get("/search-availability", repo.Availability)
+	mux.Post("/search-availability", repo.PostAvailability)
+	mux.Post("/search-availability-json", repo.AvailabilityJSON)
+	mux.Get("/api/availability/calendar", repo.AvailabilityCalendar)
+
+	mux.Get("/contact", repo.Contact)
+
+	mux.Get("/make-reservation", repo.Reservation)
+	mux.Post("/make-reservation", repo.PostReservation)
+	mux.Get("/reservation-summary", repo.ReservationSummary)
+	mux.Get("/my-reservation/{token}", repo.GuestReservation)
+	mux.Get("/my-reservation/{token}/invoice.pdf", repo.GuestInvoicePDF)
+	mux.Get("/my-reservation/{token}/cancel", repo.GuestCancelReservation)
+	mux.Post("/my-reservation/{token}/cancel", repo.GuestPostCancelReservation)
+
+	mux.Get("/user/login", repo.ShowLogin)
+	mux.Post("/user/login", repo.PostShowLogin)
+	mux.Get("/user/logout", repo.Logout)
+
+	mux.Get("/admin/dashboard", repo.AdminDashboard)
+	mux.Get("/admin/dashboard/occupancy", repo.AdminDashboardOccupancy)
+	mux.Get("/admin/dashboard/pace", repo.AdminDashboardPace)
+
+	mux.Get("/admin/reservations-new", repo.AdminNewReservations)
+	mux.Get("/admin/reservations-all", repo.AdminAllReservations)
+	mux.Get("/admin/reservations-calendar", repo.AdminReservationsCalendar)
+	mux.Get("/admin/reservations-calendar/json", repo.AdminCalendarJSON)
+	mux.Post("/admin/reservations-calendar/blocks", repo.AdminCalendarAddBlock)
+	mux.Post("/admin/reservations-calendar/blocks/{id}/delete", repo.AdminCalendarRemoveBlock)
+	mux.Post("/admin/reservations-calendar/reservations/{id}/unit", repo.AdminCalendarAssignUnit)
+	mux.Get("/admin/reservations/{src}/export", repo.AdminExportReservations)
+	mux.Get("/admin/reservations/create", repo.AdminNewReservation)
+	mux.Post("/admin/reservations/create", repo.AdminPostNewReservation)
+	mux.Get("/admin/guests", repo.AdminGuests)
+	mux.Get("/admin/guests/{id}", repo.AdminShowGuest)
+	mux.Post("/admin/guests/{id}", repo.AdminPostGuest)
+
+	mux.Get("/admin/promo-codes", repo.AdminPromoCodes)
+	mux.Get("/admin/promo-codes/new", repo.AdminNewPromoCode)
+	mux.Post("/admin/promo-codes/new", repo.AdminPostPromoCode)
+	mux.Get("/admin/promo-codes/{id}", repo.AdminShowPromoCode)
+	mux.Post("/admin/promo-codes/{id}", repo.AdminPostPromoCode)
+	mux.Post("/admin/promo-codes/{id}/delete", repo.AdminDeletePromoCode)
+	mux.Get("/admin/cancellation-policies", repo.AdminCancellationPolicies)
+	mux.Get("/admin/cancellation-policies/new", repo.AdminNewCancellationPolicy)
+	mux.Post("/admin/cancellation-policies/new", repo.AdminPostCancellationPolicy)
+	mux.Get("/admin/cancellation-policies/{id}", repo.AdminShowCancellationPolicy)
+	mux.Post("/admin/cancellation-policies/{id}", repo.AdminPostCancellationPolicy)
+	mux.Post("/admin/cancellation-policies/{id}/delete", repo.AdminDeleteCancellationPolicy)
+	mux.Post("/admin/property", repo.AdminSwitchProperty)
+	mux.Get("/admin/properties", repo.AdminProperties)
+	mux.Get("/admin/properties/new", repo.AdminNewProperty)
+	mux.Post("/admin/properties/new", repo.AdminPostProperty)
+	mux.Get("/admin/properties/{id}", repo.AdminShowProperty)
+	mux.Post("/admin/properties/{id}", repo.AdminPostProperty)
+	mux.Post("/admin/properties/{id}/rooms", repo.AdminPostPropertyRoom)
+	mux.Post("/admin/properties/{id}/rooms/{room}/units", repo.AdminPostRoomUnit)
+	mux.Get("/admin/reports", repo.AdminReports)
+	mux.Get("/admin/reports/occupancy", repo.AdminReportOccupancy)
+	mux.Get("/admin/import", repo.AdminImport)
+	mux.Post("/admin/import", repo.AdminPostImport)
+	mux.Get("/admin/process-reservation/{src}/{id}/do", repo.AdminProcessReservation)
+	mux.Get("/admin/delete-reservation/{src}/{id}/do", repo.AdminDeleteReservation)
+
+	mux.Get("/admin/reservations/{src}/{id}/show", repo.AdminShowReservation)
+	mux.Post("/admin/reservations/{src}/{id}", repo.AdminPostShowReservation)
+	mux.Post("/admin/reservations/{src}/{id}/notes", repo.AdminPostReservationNote)
+	mux.Post("/admin/reservations/{src}/{id}/messages", repo.AdminPostReservationMessage)
+	mux.Post("/admin/reservations/{src}/{id}/payments", repo.AdminPostReservationPayment)
+	mux.Post("/admin/reservations/{src}/{id}/amount-due", repo.AdminPostAmountDue)
+	mux.Post("/admin/reservations/{src}/{id}/invoice", repo.AdminPostInvoice)
+	mux.Get("/admin/reservations/{src}/{id}/invoice.pdf", repo.AdminInvoicePDF)
+	mux.Post("/admin/reservations/{src}/{id}/cancel", repo.AdminPostCancelReservation)
 
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
@@ -159,7 +201,7 @@ func CreateTestTemplateCache() (map[string]*template.Template, error) {
 
 	for _, page := range pages {
 		name := filepath.Base(page)
-		ts, err := template.New(name).Funcs(functions).ParseFiles(page)
+		ts, err := template.New(name).Funcs(render.New(&app, session).Funcs()).ParseFiles(page)
 		if err != nil {
 			log.Println(err)
 			return myCache, err
@@ -0,0 +1,433 @@
+// Package importer reads historical reservations and room blocks from CSV. A
+// file is always checked first, reporting invalid rows and date conflicts, and
+// is only written once it is clean, in a single transaction.
+package importer
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	forms "github.com/florian-lahitte-uvi/bookings/internal/form"
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/florian-lahitte-uvi/bookings/internal/repository"
+)
+
+// Fields that can be mapped to a CSV column
+const (
+	FieldType      = "type"
+	FieldFirstName = "first_name"
+	FieldLastName  = "last_name"
+	FieldEmail     = "email"
+	FieldPhone     = "phone"
+	FieldRoom      = "room"
+	FieldStartDate = "start_date"
+	FieldEndDate   = "end_date"
+)
+
+// Fields lists every field in the order they are shown to the user
+var Fields = []string{FieldType, FieldFirstName, FieldLastName, FieldEmail, FieldPhone, FieldRoom, FieldStartDate, FieldEndDate}
+
+// Row types, in the type column. Rows without a type are reservations.
+const (
+	TypeReservation = "reservation"
+	TypeBlock       = "block"
+)
+
+// Restriction ids of the restrictions table
+const (
+	restrictionReservation = 1
+	restrictionBlock       = 2
+)
+
+// MaxRows caps the size of an import
+const MaxRows = 10000
+
+const dateLayout = "2006-01-02"
+
+// Mapping names the CSV column holding each field
+type Mapping map[string]string
+
+// DefaultMapping expects the columns to be named after the fields
+func DefaultMapping() Mapping {
+	m := make(Mapping)
+	for _, f := range Fields {
+		m[f] = f
+	}
+	return m
+}
+
+// ParseMapping reads a mapping written as field=Column pairs separated by
+// commas, on top of the default mapping
+func ParseMapping(s string) (Mapping, error) {
+	m := DefaultMapping()
+	if strings.TrimSpace(s) == "" {
+		return m, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		field, column, ok := strings.Cut(pair, "=")
+		field = strings.TrimSpace(field)
+		if !ok || !isField(field) {
+			return nil, fmt.Errorf("invalid mapping %q, expected field=Column with field one of %s", pair, strings.Join(Fields, ", "))
+		}
+		m[field] = strings.TrimSpace(column)
+	}
+	return m, nil
+}
+
+func isField(name string) bool {
+	for _, f := range Fields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Issue is a problem found on a line of the file
+type Issue struct {
+	Line    int
+	Message string
+}
+
+func (i Issue) String() string {
+	return fmt.Sprintf("line %d: %s", i.Line, i.Message)
+}
+
+// Result is the outcome of checking a file
+type Result struct {
+	// Rows holds the valid rows, reservations with their Reservation set
+	Rows []models.RoomRestriction
+	// Lines holds the line of the file each row was read from
+	Lines        []int
+	Errors       []Issue
+	Conflicts    []Issue
+	Reservations int
+	Blocks       int
+}
+
+// OK reports whether the file can be committed
+func (r Result) OK() bool {
+	return len(r.Errors) == 0 && len(r.Conflicts) == 0 && len(r.Rows) > 0
+}
+
+// Importer checks and imports CSV files
+type Importer struct {
+	DB    repository.DatabaseRepo
+	Rooms []models.Room
+}
+
+// New returns an importer loading the rooms from db
+func New(ctx context.Context, db repository.DatabaseRepo) (*Importer, error) {
+	rooms, err := db.AllRooms(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Importer{DB: db, Rooms: rooms}, nil
+}
+
+// Check reads the CSV in r and validates every row, then looks for rows finding
+// every unit of their room taken by the existing room restrictions or by other
+// rows. Nothing is written.
+func (im *Importer) Check(ctx context.Context, r io.Reader, m Mapping) (Result, error) {
+	var res Result
+
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return res, errors.New("the file is empty")
+	}
+	if err != nil {
+		return res, err
+	}
+
+	columns, err := resolveColumns(header, m)
+	if err != nil {
+		return res, err
+	}
+
+	for line := 2; ; line++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return res, err
+		}
+		if len(res.Rows)+len(res.Errors) >= MaxRows {
+			return res, fmt.Errorf("files are limited to %d rows", MaxRows)
+		}
+
+		values := url.Values{}
+		for field, i := range columns {
+			if i < len(record) {
+				values.Set(field, strings.TrimSpace(record[i]))
+			}
+		}
+
+		row, problems := im.parseRow(values)
+		for _, p := range problems {
+			res.Errors = append(res.Errors, Issue{Line: line, Message: p})
+		}
+		if len(problems) == 0 {
+			res.Rows = append(res.Rows, row)
+			res.Lines = append(res.Lines, line)
+			if row.RestrictionID == restrictionBlock {
+				res.Blocks++
+			} else {
+				res.Reservations++
+			}
+		}
+	}
+
+	if len(res.Rows) == 0 && len(res.Errors) == 0 {
+		return res, errors.New("the file has no rows")
+	}
+
+	conflicts, err := im.conflicts(ctx, res.Rows, res.Lines)
+	if err != nil {
+		return res, err
+	}
+	res.Conflicts = append(res.Conflicts, conflicts...)
+	sort.SliceStable(res.Conflicts, func(i, j int) bool { return res.Conflicts[i].Line < res.Conflicts[j].Line })
+
+	return res, nil
+}
+
+// Commit writes the rows of a clean result in one transaction
+func (im *Importer) Commit(ctx context.Context, res Result) error {
+	if !res.OK() {
+		return errors.New("the file has errors or conflicts and cannot be imported")
+	}
+
+	// the rows take their units in the order Check counted them, by start date
+	rows := append([]models.RoomRestriction(nil), res.Rows...)
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].StartDate.Before(rows[j].StartDate) })
+	return im.DB.ImportRestrictions(ctx, rows)
+}
+
+// resolveColumns finds the index of the column mapped to each field
+func resolveColumns(header []string, m Mapping) (map[string]int, error) {
+	index := make(map[string]int)
+	for i, h := range header {
+		index[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))] = i
+	}
+
+	columns := make(map[string]int)
+	var missing []string
+	for _, field := range Fields {
+		name := strings.ToLower(strings.TrimSpace(m[field]))
+		i, ok := index[name]
+		if ok && name != "" {
+			columns[field] = i
+			continue
+		}
+
+		switch field {
+		case FieldType, FieldPhone, FieldEndDate, FieldFirstName, FieldLastName, FieldEmail:
+			// optional columns, or only needed by reservations, which is checked per row
+		default:
+			missing = append(missing, fmt.Sprintf("%s (column %q)", field, m[field]))
+		}
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing columns: %s", strings.Join(missing, ", "))
+	}
+	return columns, nil
+}
+
+// parseRow validates the values of one row and builds its restriction
+func (im *Importer) parseRow(values url.Values) (models.RoomRestriction, []string) {
+	var row models.RoomRestriction
+	form := forms.New(values)
+
+	kind := strings.ToLower(values.Get(FieldType))
+	if kind == "" {
+		kind = TypeReservation
+	}
+
+	switch kind {
+	case TypeReservation:
+		row.RestrictionID = restrictionReservation
+		form.Required(FieldFirstName, FieldLastName, FieldEmail, FieldRoom, FieldStartDate, FieldEndDate)
+		if form.Has(FieldEmail) {
+			form.IsEmail(FieldEmail)
+		}
+	case TypeBlock:
+		row.RestrictionID = restrictionBlock
+		form.Required(FieldRoom, FieldStartDate)
+	default:
+		form.Errors.Add(FieldType, fmt.Sprintf("must be %s or %s", TypeReservation, TypeBlock))
+	}
+
+	if form.Has(FieldRoom) {
+		room, ok := im.findRoom(values.Get(FieldRoom))
+		if ok {
+			row.RoomID = room.ID
+			row.Room = room
+		} else {
+			form.Errors.Add(FieldRoom, "unknown room")
+		}
+	}
+
+	if form.Has(FieldStartDate) {
+		start, err := time.Parse(dateLayout, values.Get(FieldStartDate))
+		if err != nil {
+			form.Errors.Add(FieldStartDate, "must be a date like 2025-01-31")
+		}
+		row.StartDate = start
+	}
+
+	// a block without an end date covers a single night, as in the calendar
+	row.EndDate = row.StartDate.AddDate(0, 0, 1)
+	if form.Has(FieldEndDate) {
+		end, err := time.Parse(dateLayout, values.Get(FieldEndDate))
+		if err != nil {
+			form.Errors.Add(FieldEndDate, "must be a date like 2025-01-31")
+		}
+		row.EndDate = end
+	}
+
+	if form.Valid() && !row.EndDate.After(row.StartDate) {
+		form.Errors.Add(FieldEndDate, "must be after the start date")
+	}
+
+	if !form.Valid() {
+		return row, formErrors(form)
+	}
+
+	if row.RestrictionID == restrictionReservation {
+		row.Reservation = models.Reservation{
+			FirstName: values.Get(FieldFirstName),
+			LastName:  values.Get(FieldLastName),
+			Email:     values.Get(FieldEmail),
+			Phone:     values.Get(FieldPhone),
+			StartDate: row.StartDate,
+			EndDate:   row.EndDate,
+			RoomID:    row.RoomID,
+			Room:      row.Room,
+			// historical bookings need no follow-up
+			Processed: 1,
+			Source:    models.SourceImport,
+		}
+	}
+	return row, nil
+}
+
+// formErrors flattens the form errors in field order
+func formErrors(form *forms.Form) []string {
+	var problems []string
+	for _, field := range Fields {
+		if msg := form.Errors.Get(field); msg != "" {
+			problems = append(problems, fmt.Sprintf("%s: %s", field, msg))
+		}
+	}
+	return problems
+}
+
+// findRoom matches a room by id or by name, ignoring case
+func (im *Importer) findRoom(value string) (models.Room, bool) {
+	id, err := strconv.Atoi(value)
+	for _, room := range im.Rooms {
+		if (err == nil && room.ID == id) || strings.EqualFold(room.RoomName, value) {
+			return room, true
+		}
+	}
+	return models.Room{}, false
+}
+
+// conflicts reports the rows finding no free unit of their room on one of their
+// nights, once the units taken by the existing restrictions and by the rows of
+// the file starting before them are counted
+func (im *Importer) conflicts(ctx context.Context, rows []models.RoomRestriction, lines []int) ([]Issue, error) {
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	start, end := rows[0].StartDate, rows[0].EndDate
+	for _, row := range rows {
+		if row.StartDate.Before(start) {
+			start = row.StartDate
+		}
+		if row.EndDate.After(end) {
+			end = row.EndDate
+		}
+	}
+
+	type roomNight struct {
+		roomID int
+		date   string
+	}
+
+	free, err := im.DB.FreeUnitNights(ctx, start, end)
+	if err != nil {
+		return nil, err
+	}
+	units := make(map[roomNight]int)
+	for _, n := range free {
+		units[roomNight{n.Room.ID, n.Date.Format(dateLayout)}]++
+	}
+
+	order := make([]int, len(rows))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return rows[order[i]].StartDate.Before(rows[order[j]].StartDate) })
+
+	// the lines of the file already occupying each night of a room
+	taken := make(map[roomNight][]int)
+	var issues []Issue
+	for _, i := range order {
+		row := rows[i]
+		var nights []roomNight
+		var issue string
+		for d := row.StartDate; d.Before(row.EndDate); d = d.AddDate(0, 0, 1) {
+			n := roomNight{row.RoomID, d.Format(dateLayout)}
+			if len(taken[n]) < units[n] {
+				nights = append(nights, n)
+				continue
+			}
+			if len(taken[n]) == 0 {
+				issue = fmt.Sprintf("%s is already booked or blocked on %s", row.Room.RoomName, n.date)
+			} else {
+				issue = fmt.Sprintf("%s has no unit left on %s, taken by %s", row.Room.RoomName, n.date, lineList(taken[n]))
+			}
+			break
+		}
+
+		if issue != "" {
+			issues = append(issues, Issue{Line: lines[i], Message: issue})
+			continue
+		}
+		for _, n := range nights {
+			taken[n] = append(taken[n], lines[i])
+		}
+	}
+	return issues, nil
+}
+
+// lineList names lines in order, as "line 2" or "lines 2, 5"
+func lineList(lines []int) string {
+	sorted := append([]int(nil), lines...)
+	sort.Ints(sorted)
+	s := make([]string, len(sorted))
+	for i, l := range sorted {
+		s[i] = strconv.Itoa(l)
+	}
+	if len(s) == 1 {
+		return "line " + s[0]
+	}
+	return "lines " + strings.Join(s, ", ")
+}
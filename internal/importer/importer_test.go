@@ -0,0 +1,220 @@
+package importer
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/config"
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/florian-lahitte-uvi/bookings/internal/repository/dbrepo"
+)
+
+func newTestImporter() *Importer {
+	return &Importer{
+		DB: dbrepo.NewTestingRepo(&config.AppConfig{}),
+		Rooms: []models.Room{
+			{ID: 1, RoomName: "General's Quarters"},
+			{ID: 2, RoomName: "Major's Suite"},
+			{ID: 1000, RoomName: "Broken Room"},
+		},
+	}
+}
+
+var checkTests = []struct {
+	name                 string
+	csv                  string
+	mapping              string
+	expectedErr          bool
+	expectedOK           bool
+	expectedReservations int
+	expectedBlocks       int
+	expectedErrors       []string
+	expectedConflicts    []string
+}{
+	{
+		name: "valid",
+		csv: "type,first_name,last_name,email,phone,room,start_date,end_date\n" +
+			"reservation,John,Smith,john@smith.com,555,General's Quarters,2040-01-01,2040-01-03\n" +
+			"block,,,,,2,2040-01-01,\n" +
+			",Jane,Doe,jane@doe.com,,1,2040-01-03,2040-01-05\n",
+		expectedOK:           true,
+		expectedReservations: 2,
+		expectedBlocks:       1,
+	},
+	{
+		name: "mapped-columns",
+		csv: "Guest First,Guest Last,Mail,Room,Arrival,Departure\n" +
+			"John,Smith,john@smith.com,1,2040-01-01,2040-01-03\n",
+		mapping:              "first_name=Guest First, last_name=Guest Last,email=Mail,room=Room,start_date=Arrival,end_date=Departure",
+		expectedOK:           true,
+		expectedReservations: 1,
+	},
+	{
+		name: "validation-errors",
+		csv: "type,first_name,last_name,email,room,start_date,end_date\n" +
+			"reservation,John,,not-an-email,General's Quarters,2040-01-01,2040-01-03\n" +
+			"block,,,,Penthouse,2040-01-01,\n" +
+			"reservation,John,Smith,john@smith.com,1,01/01/2040,2040-01-03\n" +
+			"reservation,John,Smith,john@smith.com,1,2040-01-03,2040-01-01\n" +
+			"cancellation,,,,1,2040-01-01,\n",
+		expectedErrors: []string{
+			"line 2: last_name: This field cannot be blank",
+			"line 2: email: Invalid email address",
+			"line 3: room: unknown room",
+			"line 4: start_date: must be a date like 2025-01-31",
+			"line 5: end_date: must be after the start date",
+			"line 6: type: must be reservation or block",
+		},
+	},
+	{
+		name: "conflicts-with-existing",
+		csv: "first_name,last_name,email,room,start_date,end_date\n" +
+			"John,Smith,john@smith.com,1,2050-01-01,2050-01-03\n",
+		expectedReservations: 1,
+		expectedConflicts:    []string{"line 2: General's Quarters is already booked or blocked on 2050-01-01"},
+	},
+	{
+		name: "last-unit-left",
+		csv: "first_name,last_name,email,room,start_date,end_date\n" +
+			"John,Smith,john@smith.com,1,2050-01-02,2050-01-03\n" +
+			"Jane,Doe,jane@doe.com,1,2050-01-02,2050-01-03\n",
+		expectedReservations: 2,
+		expectedConflicts:    []string{"line 3: General's Quarters has no unit left on 2050-01-02, taken by line 2"},
+	},
+	{
+		name: "one-row-per-unit",
+		csv: "type,first_name,last_name,email,room,start_date,end_date\n" +
+			"reservation,John,Smith,john@smith.com,1,2040-01-01,2040-01-05\n" +
+			"block,,,,1,2040-01-04,\n",
+		expectedOK:           true,
+		expectedReservations: 1,
+		expectedBlocks:       1,
+	},
+	{
+		name: "overlaps-in-file",
+		csv: "type,first_name,last_name,email,room,start_date,end_date\n" +
+			"reservation,John,Smith,john@smith.com,1,2040-01-01,2040-01-05\n" +
+			"reservation,Jane,Doe,jane@doe.com,2,2040-01-01,2040-01-05\n" +
+			"block,,,,1,2040-01-04,\n" +
+			"reservation,Jim,Doe,jim@doe.com,1,2040-01-03,2040-01-06\n" +
+			"block,,,,2,2040-01-02,\n",
+		expectedReservations: 3,
+		expectedBlocks:       2,
+		expectedConflicts: []string{
+			"line 4: General's Quarters has no unit left on 2040-01-04, taken by lines 2, 5",
+			"line 6: Major's Suite has no unit left on 2040-01-02, taken by line 3",
+		},
+	},
+	{
+		name:        "missing-columns",
+		csv:         "first_name,last_name,email\nJohn,Smith,john@smith.com\n",
+		expectedErr: true,
+	},
+	{
+		name:        "bad-mapping",
+		csv:         "first_name,last_name,email,room,start_date\n",
+		mapping:     "guest=Name",
+		expectedErr: true,
+	},
+	{
+		name:        "empty",
+		csv:         "",
+		expectedErr: true,
+	},
+	{
+		name:        "no-rows",
+		csv:         "first_name,last_name,email,room,start_date,end_date\n",
+		expectedErr: true,
+	},
+	{
+		name: "database-error",
+		csv: "first_name,last_name,email,room,start_date,end_date\n" +
+			"John,Smith,john@smith.com,1,2060-01-01,2060-01-03\n",
+		expectedErr: true,
+	},
+}
+
+func TestCheck(t *testing.T) {
+	im := newTestImporter()
+
+	for _, e := range checkTests {
+		mapping, err := ParseMapping(e.mapping)
+		if err != nil {
+			if !e.expectedErr {
+				t.Errorf("%s: unexpected mapping error %s", e.name, err)
+			}
+			continue
+		}
+
+		res, err := im.Check(context.Background(), strings.NewReader(e.csv), mapping)
+		if (err != nil) != e.expectedErr {
+			t.Errorf("%s: got error %v, expected error %v", e.name, err, e.expectedErr)
+			continue
+		}
+		if e.expectedErr {
+			continue
+		}
+
+		if res.OK() != e.expectedOK {
+			t.Errorf("%s: got OK %v, wanted %v (errors %v, conflicts %v)", e.name, res.OK(), e.expectedOK, res.Errors, res.Conflicts)
+		}
+		if res.Reservations != e.expectedReservations || res.Blocks != e.expectedBlocks {
+			t.Errorf("%s: got %d reservations and %d blocks, wanted %d and %d", e.name, res.Reservations, res.Blocks, e.expectedReservations, e.expectedBlocks)
+		}
+		compareIssues(t, e.name, "errors", res.Errors, e.expectedErrors)
+		compareIssues(t, e.name, "conflicts", res.Conflicts, e.expectedConflicts)
+	}
+}
+
+func compareIssues(t *testing.T, name, kind string, got []Issue, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: got %d %s %v, wanted %d", name, len(got), kind, got, len(want))
+		return
+	}
+	for i := range got {
+		if got[i].String() != want[i] {
+			t.Errorf("%s: got %s %q, wanted %q", name, kind, got[i].String(), want[i])
+		}
+	}
+}
+
+func TestBlockDefaultsToOneNight(t *testing.T) {
+	im := newTestImporter()
+	res, err := im.Check(context.Background(), strings.NewReader("type,room,start_date\nblock,1,2040-01-01\n"), DefaultMapping())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Rows) != 1 {
+		t.Fatalf("expected one row, got %d", len(res.Rows))
+	}
+	if got := res.Rows[0].EndDate.Format(dateLayout); got != "2040-01-02" {
+		t.Errorf("expected the block to end on 2040-01-02, got %s", got)
+	}
+	if res.Rows[0].Reservation.FirstName != "" {
+		t.Error("expected a block without a reservation")
+	}
+}
+
+func TestCommit(t *testing.T) {
+	im := newTestImporter()
+
+	res, _ := im.Check(context.Background(), strings.NewReader("first_name,last_name,email,room,start_date,end_date\nJohn,Smith,john@smith.com,1,2040-01-01,2040-01-03\n"), DefaultMapping())
+	if err := im.Commit(context.Background(), res); err != nil {
+		t.Errorf("expected a clean file to commit, got %s", err)
+	}
+
+	res, _ = im.Check(context.Background(), strings.NewReader("first_name,last_name,email,room,start_date,end_date\nJohn,Smith,john@smith.com,1,2050-01-01,2050-01-03\n"), DefaultMapping())
+	if err := im.Commit(context.Background(), res); err == nil {
+		t.Error("expected a file with conflicts to be refused")
+	}
+
+	// room 1000 has no units in the test repository, so the rows are built by hand
+	start := time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC)
+	res = Result{Rows: []models.RoomRestriction{{RoomID: 1000, StartDate: start, EndDate: start.AddDate(0, 0, 2), RestrictionID: restrictionBlock}}, Lines: []int{2}}
+	if err := im.Commit(context.Background(), res); err == nil {
+		t.Error("expected the database error to be returned")
+	}
+}
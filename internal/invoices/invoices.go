@@ -0,0 +1,166 @@
+// Package invoices renders the invoices of reservations as PDF documents
+package invoices
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/florian-lahitte-uvi/bookings/internal/pdf"
+)
+
+// Issuer is who the invoices are from
+type Issuer struct {
+	Name string
+	// Address is printed under the name, one line per line of the string
+	Address string
+}
+
+// page layout, in points
+const (
+	left   = 50.0
+	right  = pdf.PageWidth - 50
+	top    = 60.0
+	bottom = pdf.PageHeight - 60
+
+	qtyRight    = 360.0
+	priceRight  = 455.0
+	lineSpacing = 16.0
+)
+
+// Filename returns the name an invoice is downloaded or attached as
+func Filename(inv models.Invoice) string {
+	return inv.Reference() + ".pdf"
+}
+
+// PDF renders an invoice with the payments received so far. An invoice paid in full
+// is marked paid, and serves as the guest's receipt.
+func PDF(inv models.Invoice, payments []models.Payment, issuer Issuer) ([]byte, error) {
+	w := &writer{doc: pdf.New()}
+	w.doc.Title = "Invoice " + inv.Reference()
+	w.newPage()
+
+	money := func(cents int) string {
+		return models.FormatMoney(cents, inv.Currency)
+	}
+
+	w.page.Text(left, w.y, pdf.HelveticaBold, 16, issuer.Name)
+	w.page.TextRight(right, w.y, pdf.HelveticaBold, 20, "INVOICE")
+	w.y += 20
+	for _, line := range strings.Split(issuer.Address, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			w.page.Text(left, w.y, pdf.Helvetica, 10, line)
+			w.y += 13
+		}
+	}
+
+	w.y += 20
+	details := [][2]string{
+		{"Invoice", inv.Reference()},
+		{"Date", inv.IssuedAt.Format("2006-01-02")},
+	}
+	if inv.ReservationID != 0 {
+		details = append(details, [2]string{"Reservation", fmt.Sprintf("#%d", inv.ReservationID)})
+	}
+
+	w.page.Text(left, w.y, pdf.HelveticaBold, 10, "Bill to")
+	for i, d := range details {
+		y := w.y + float64(i)*13
+		w.page.Text(priceRight-60, y, pdf.HelveticaBold, 10, d[0])
+		w.page.TextRight(right, y, pdf.Helvetica, 10, d[1])
+	}
+	rows := len(details) - 1
+	billed := 0
+	for _, line := range []string{inv.BillTo, inv.Email} {
+		if line != "" {
+			billed++
+			w.page.Text(left, w.y+float64(billed)*13, pdf.Helvetica, 10, line)
+		}
+	}
+	w.y += 13*float64(max(rows, billed)) + 40
+
+	w.header()
+	for _, l := range inv.Lines {
+		if w.need(lineSpacing) {
+			w.header()
+		}
+		w.page.Text(left, w.y, pdf.Helvetica, 10, l.Description)
+		w.page.TextRight(qtyRight, w.y, pdf.Courier, 10, fmt.Sprint(l.Quantity))
+		w.page.TextRight(priceRight, w.y, pdf.Courier, 10, money(l.UnitPrice))
+		w.page.TextRight(right, w.y, pdf.Courier, 10, money(l.Amount))
+		w.y += lineSpacing
+	}
+
+	w.need(lineSpacing * 2)
+	w.page.Line(left, w.y-10, right, w.y-10)
+	w.y += 4
+	w.page.Text(priceRight-60, w.y, pdf.HelveticaBold, 11, "Total")
+	w.page.TextRight(right, w.y, pdf.Courier, 11, money(inv.Total))
+	w.y += lineSpacing * 2
+
+	if len(payments) > 0 {
+		w.need(lineSpacing * 2)
+		w.page.Text(left, w.y, pdf.HelveticaBold, 11, "Payments received")
+		w.y += lineSpacing
+		for _, p := range payments {
+			w.need(lineSpacing)
+			w.page.Text(left, w.y, pdf.Helvetica, 10, fmt.Sprintf("%s  %s by %s", p.CreatedAt.Format("2006-01-02"), p.Kind, p.Method))
+			w.page.TextRight(right, w.y, pdf.Courier, 10, money(p.Signed()))
+			w.y += lineSpacing
+		}
+		w.y += lineSpacing
+	}
+
+	balance := models.Balance(inv.Total, payments)
+	w.need(lineSpacing * 3)
+	if balance > 0 {
+		w.page.Text(priceRight-60, w.y, pdf.HelveticaBold, 11, "Balance due")
+		w.page.TextRight(right, w.y, pdf.Courier, 11, money(balance))
+	} else {
+		w.page.Text(left, w.y, pdf.HelveticaBold, 14, "PAID")
+		w.page.Text(left, w.y+lineSpacing, pdf.Helvetica, 10, "Paid in full, thank you. This invoice is your receipt.")
+		if balance < 0 {
+			w.page.Text(priceRight-60, w.y, pdf.HelveticaBold, 11, "Overpaid")
+			w.page.TextRight(right, w.y, pdf.Courier, 11, money(-balance))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := w.doc.Write(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writer tracks where the next line goes, starting new pages as they fill up
+type writer struct {
+	doc  *pdf.Document
+	page *pdf.Page
+	y    float64
+}
+
+func (w *writer) newPage() {
+	w.page = w.doc.AddPage()
+	w.y = top
+}
+
+// need starts a new page if less than height is left on this one, and reports
+// whether it did
+func (w *writer) need(height float64) bool {
+	if w.y+height <= bottom {
+		return false
+	}
+	w.newPage()
+	return true
+}
+
+// header writes the column titles of the price lines
+func (w *writer) header() {
+	w.page.Text(left, w.y, pdf.HelveticaBold, 10, "Description")
+	w.page.TextRight(qtyRight, w.y, pdf.HelveticaBold, 10, "Qty")
+	w.page.TextRight(priceRight, w.y, pdf.HelveticaBold, 10, "Unit price")
+	w.page.TextRight(right, w.y, pdf.HelveticaBold, 10, "Amount")
+	w.page.Line(left, w.y+6, right, w.y+6)
+	w.y += lineSpacing + 4
+}
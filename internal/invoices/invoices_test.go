@@ -0,0 +1,84 @@
+package invoices
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+)
+
+var invoice = models.Invoice{
+	Number:        7,
+	ReservationID: 1,
+	BillTo:        "John Smith",
+	Email:         "john@smith.com",
+	Currency:      "usd",
+	Total:         20000,
+	Lines: []models.InvoiceLine{
+		{Description: "General's Quarters, 2050-01-01 to 2050-01-03", Quantity: 2, UnitPrice: 10000, Amount: 20000},
+	},
+	IssuedAt: time.Date(2040, 1, 5, 9, 0, 0, 0, time.UTC),
+}
+
+var pdfTests = []struct {
+	name     string
+	payments []models.Payment
+	expected []string
+	missing  []string
+}{
+	{"unpaid", nil, []string{"(INV-000007)", "(John Smith)", "(200.00 USD)", "(Balance due)"}, []string{"(PAID)", "Payments received"}},
+	{"deposit", []models.Payment{{Kind: models.PaymentDeposit, Method: models.MethodTransfer, Amount: 5000}},
+		[]string{"(Payments received)", "(50.00 USD)", "(Balance due)", "(150.00 USD)"}, []string{"(PAID)"}},
+	{"paid", []models.Payment{{Kind: models.PaymentPayment, Method: models.MethodCard, Amount: 20000}},
+		[]string{"(PAID)", "This invoice is your receipt"}, []string{"(Balance due)", "(Overpaid)"}},
+	{"overpaid", []models.Payment{{Kind: models.PaymentPayment, Method: models.MethodCash, Amount: 25000}},
+		[]string{"(PAID)", "(Overpaid)", "(50.00 USD)"}, []string{"(Balance due)"}},
+}
+
+func TestPDF(t *testing.T) {
+	issuer := Issuer{Name: "Fort Smythe Bed and Breakfast", Address: "1 Main Street\nSmythe"}
+	for _, e := range pdfTests {
+		out, err := PDF(invoice, e.payments, issuer)
+		if err != nil {
+			t.Fatalf("%s: %v", e.name, err)
+		}
+		if !bytes.HasPrefix(out, []byte("%PDF-")) {
+			t.Errorf("%s: not a PDF document", e.name)
+		}
+		for _, want := range append(e.expected, "(Fort Smythe Bed and Breakfast)", "(Smythe)") {
+			if !bytes.Contains(out, []byte(want)) {
+				t.Errorf("%s: expected %q in the document", e.name, want)
+			}
+		}
+		for _, unwanted := range e.missing {
+			if bytes.Contains(out, []byte(unwanted)) {
+				t.Errorf("%s: did not expect %q in the document", e.name, unwanted)
+			}
+		}
+	}
+}
+
+func TestPDFPages(t *testing.T) {
+	long := invoice
+	long.Lines = nil
+	for i := 0; i < 100; i++ {
+		long.Lines = append(long.Lines, models.InvoiceLine{Description: "Breakfast", Quantity: 1, UnitPrice: 1500, Amount: 1500})
+	}
+
+	out, err := PDF(long, nil, Issuer{Name: "Fort Smythe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "/Count 3") {
+		t.Error("expected the lines to run over 3 pages")
+	}
+	if got := strings.Count(string(out), "(Unit price)"); got != 3 {
+		t.Errorf("expected the column titles on every page, got them %d times", got)
+	}
+
+	if got := Filename(invoice); got != "INV-000007.pdf" {
+		t.Errorf("wrong file name %q", got)
+	}
+}
@@ -0,0 +1,68 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestNewAddsContextAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, "json", "info")
+
+	ctx := WithAttrs(context.Background(), slog.String("request_id", "abc-123"))
+	ctx = WithAttrs(ctx, slog.Int("user_id", 7))
+
+	l.With("component", "test").InfoContext(ctx, "hello")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("log line is not json: %s", buf.String())
+	}
+
+	if line["request_id"] != "abc-123" {
+		t.Errorf("expected request_id abc-123, got %v", line["request_id"])
+	}
+	if line["user_id"] != float64(7) {
+		t.Errorf("expected user_id 7, got %v", line["user_id"])
+	}
+	if line["component"] != "test" {
+		t.Errorf("expected component test, got %v", line["component"])
+	}
+}
+
+func TestNewRespectsLevel(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, "text", "warn")
+
+	l.Info("hidden")
+	if buf.Len() != 0 {
+		t.Errorf("expected info to be filtered at warn level, got %s", buf.String())
+	}
+
+	l.Warn("shown")
+	if buf.Len() == 0 {
+		t.Error("expected warn to be logged at warn level")
+	}
+}
+
+var levelTests = []struct {
+	name     string
+	expected slog.Level
+}{
+	{"debug", slog.LevelDebug},
+	{"INFO", slog.LevelInfo},
+	{"warning", slog.LevelWarn},
+	{"error", slog.LevelError},
+	{"nonsense", slog.LevelInfo},
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, e := range levelTests {
+		if got := ParseLevel(e.name); got != e.expected {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, got)
+		}
+	}
+}
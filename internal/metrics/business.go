@@ -0,0 +1,40 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/repository"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var unprocessedReservationsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "reservations_unprocessed"),
+	"Number of reservations not yet marked as processed.",
+	nil, nil,
+)
+
+// businessCollector queries the repository for business gauges at scrape time
+type businessCollector struct {
+	repo repository.DatabaseRepo
+}
+
+// RegisterBusiness exposes business gauges computed from repo
+func (m *Metrics) RegisterBusiness(repo repository.DatabaseRepo) error {
+	return m.Registry.Register(&businessCollector{repo: repo})
+}
+
+// Describe sends the descriptors of the business gauges
+func (c *businessCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- unprocessedReservationsDesc
+}
+
+// Collect queries the repository and sends the current values. A scrape has no
+// request context, the query is bounded by the repository timeout
+func (c *businessCollector) Collect(ch chan<- prometheus.Metric) {
+	count, err := c.repo.CountNewReservations(context.Background())
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(unprocessedReservationsDesc, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(unprocessedReservationsDesc, prometheus.GaugeValue, float64(count))
+}
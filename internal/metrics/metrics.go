@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "bookings"
+
+// Metrics holds the registry exposed on the metrics endpoint and the collectors
+// recording into it. Each application has its own, so several can run in one process.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	// HTTPRequests counts handled requests by chi route pattern
+	HTTPRequests *prometheus.CounterVec
+	// HTTPDuration observes request latency by chi route pattern
+	HTTPDuration *prometheus.HistogramVec
+	// DBQueryDuration observes the latency of each DatabaseRepo method
+	DBQueryDuration *prometheus.HistogramVec
+	// DBQueryErrors counts failed DatabaseRepo calls
+	DBQueryErrors *prometheus.CounterVec
+	// MailSent counts mails handed to the SMTP server
+	MailSent prometheus.Counter
+	// MailFailed counts mails that could not be sent
+	MailFailed prometheus.Counter
+}
+
+// New creates the collectors and registers them in a new registry
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		HTTPRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests handled, by method, route and status code.",
+		}, []string{"method", "route", "status"}),
+		HTTPDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests, by method and route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		DBQueryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "db_query_duration_seconds",
+			Help:      "Latency of repository calls, by method.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 3},
+		}, []string{"method"}),
+		DBQueryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "db_query_errors_total",
+			Help:      "Number of repository calls that returned an error, by method.",
+		}, []string{"method"}),
+		MailSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "mail_sent_total",
+			Help:      "Number of mails sent successfully.",
+		}),
+		MailFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "mail_failed_total",
+			Help:      "Number of mails that failed to send.",
+		}),
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.HTTPRequests,
+		m.HTTPDuration,
+		m.DBQueryDuration,
+		m.DBQueryErrors,
+		m.MailSent,
+		m.MailFailed,
+	)
+	return m
+}
+
+// Handler serves the registry in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{})
+}
+
+// RegisterDB exposes the connection pool stats of db
+func (m *Metrics) RegisterDB(db *sql.DB) error {
+	return m.Registry.Register(collectors.NewDBStatsCollector(db, namespace))
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/config"
+	"github.com/florian-lahitte-uvi/bookings/internal/repository/dbrepo"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentRepo(t *testing.T) {
+	var app config.AppConfig
+	m := New()
+	repo := m.InstrumentRepo(dbrepo.NewTestingRepo(&app))
+
+	before := testutil.ToFloat64(m.DBQueryErrors.WithLabelValues("GetRoomByID"))
+
+	// the test repo fails for room ids above 2
+	_, _ = repo.GetRoomByID(context.Background(), 1)
+	_, _ = repo.GetRoomByID(context.Background(), 3)
+
+	after := testutil.ToFloat64(m.DBQueryErrors.WithLabelValues("GetRoomByID"))
+	if after-before != 1 {
+		t.Errorf("expected one recorded error, got %v", after-before)
+	}
+
+	if n := testutil.CollectAndCount(m.DBQueryDuration, "bookings_db_query_duration_seconds"); n == 0 {
+		t.Error("expected query durations to be observed")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	m := New()
+	m.MailSent.Inc()
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	m.Handler().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected status 200 but got %d", rr.Code)
+	}
+
+	if !strings.Contains(rr.Body.String(), "bookings_mail_sent_total") {
+		t.Error("expected mail counter in metrics output")
+	}
+}
+
+func TestBusinessCollector(t *testing.T) {
+	var app config.AppConfig
+	c := &businessCollector{repo: dbrepo.NewTestingRepo(&app)}
+
+	if n := testutil.CollectAndCount(c); n != 1 {
+		t.Errorf("expected 1 business metric, got %d", n)
+	}
+}
+
+// TestNewIsIndependent tests two applications in one process can each register
+// their collectors
+func TestNewIsIndependent(t *testing.T) {
+	var app config.AppConfig
+	repo := dbrepo.NewTestingRepo(&app)
+
+	for i := 0; i < 2; i++ {
+		if err := New().RegisterBusiness(repo); err != nil {
+			t.Errorf("registry %d: %s", i+1, err)
+		}
+	}
+}
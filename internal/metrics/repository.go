@@ -0,0 +1,438 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/florian-lahitte-uvi/bookings/internal/repository"
+)
+
+// instrumentedRepo records latency and errors for every DatabaseRepo call
+type instrumentedRepo struct {
+	next    repository.DatabaseRepo
+	metrics *Metrics
+}
+
+// InstrumentRepo wraps repo so each method call is observed in m
+func (m *Metrics) InstrumentRepo(repo repository.DatabaseRepo) repository.DatabaseRepo {
+	return &instrumentedRepo{next: repo, metrics: m}
+}
+
+// observe records the duration of a repository call started at start
+func (r *instrumentedRepo) observe(method string, start time.Time, err error) {
+	r.metrics.DBQueryDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	if err != nil {
+		r.metrics.DBQueryErrors.WithLabelValues(method).Inc()
+	}
+}
+
+// ForProperty keeps observing the calls of the scoped repository
+func (r *instrumentedRepo) ForProperty(propertyID int) repository.DatabaseRepo {
+	return &instrumentedRepo{next: r.next.ForProperty(propertyID), metrics: r.metrics}
+}
+
+func (r *instrumentedRepo) Ping(ctx context.Context) (err error) {
+	defer func(start time.Time) { r.observe("Ping", start, err) }(time.Now())
+	return r.next.Ping(ctx)
+}
+
+func (r *instrumentedRepo) SchemaVersion(ctx context.Context) (version string, err error) {
+	defer func(start time.Time) { r.observe("SchemaVersion", start, err) }(time.Now())
+	return r.next.SchemaVersion(ctx)
+}
+
+func (r *instrumentedRepo) InsertReservation(ctx context.Context, res models.Reservation, holdID int) (id int, err error) {
+	defer func(start time.Time) { r.observe("InsertReservation", start, err) }(time.Now())
+	return r.next.InsertReservation(ctx, res, holdID)
+}
+
+func (r *instrumentedRepo) CreateReservation(ctx context.Context, res models.Reservation, override bool) (id int, err error) {
+	defer func(start time.Time) { r.observe("CreateReservation", start, err) }(time.Now())
+	return r.next.CreateReservation(ctx, res, override)
+}
+
+func (r *instrumentedRepo) SearchAvaibilityByDatesByRoomID(ctx context.Context, roomID int, start, end time.Time) (ok bool, err error) {
+	defer func(t time.Time) { r.observe("SearchAvaibilityByDatesByRoomID", t, err) }(time.Now())
+	return r.next.SearchAvaibilityByDatesByRoomID(ctx, roomID, start, end)
+}
+
+func (r *instrumentedRepo) SearchAvaibilityForAllRooms(ctx context.Context, start, end time.Time) (rooms []models.Room, err error) {
+	defer func(t time.Time) { r.observe("SearchAvaibilityForAllRooms", t, err) }(time.Now())
+	return r.next.SearchAvaibilityForAllRooms(ctx, start, end)
+}
+
+func (r *instrumentedRepo) SearchAvailabilityExcludingReservation(ctx context.Context, roomID int, start, end time.Time, reservationID int) (ok bool, err error) {
+	defer func(t time.Time) { r.observe("SearchAvailabilityExcludingReservation", t, err) }(time.Now())
+	return r.next.SearchAvailabilityExcludingReservation(ctx, roomID, start, end, reservationID)
+}
+
+func (r *instrumentedRepo) GetRoomByID(ctx context.Context, id int) (room models.Room, err error) {
+	defer func(start time.Time) { r.observe("GetRoomByID", start, err) }(time.Now())
+	return r.next.GetRoomByID(ctx, id)
+}
+
+func (r *instrumentedRepo) GetUserByID(ctx context.Context, id int) (u models.User, err error) {
+	defer func(start time.Time) { r.observe("GetUserByID", start, err) }(time.Now())
+	return r.next.GetUserByID(ctx, id)
+}
+
+func (r *instrumentedRepo) UpdateUser(ctx context.Context, u models.User) (err error) {
+	defer func(start time.Time) { r.observe("UpdateUser", start, err) }(time.Now())
+	return r.next.UpdateUser(ctx, u)
+}
+
+func (r *instrumentedRepo) Authenticate(ctx context.Context, email, testPassword string) (id int, hash string, err error) {
+	defer func(start time.Time) { r.observe("Authenticate", start, err) }(time.Now())
+	return r.next.Authenticate(ctx, email, testPassword)
+}
+
+func (r *instrumentedRepo) ListReservations(ctx context.Context, q repository.ReservationQuery) (page repository.ReservationPage, err error) {
+	defer func(start time.Time) { r.observe("ListReservations", start, err) }(time.Now())
+	return r.next.ListReservations(ctx, q)
+}
+
+func (r *instrumentedRepo) EachReservation(ctx context.Context, q repository.ReservationQuery, fn func(models.Reservation) error) (err error) {
+	defer func(start time.Time) { r.observe("EachReservation", start, err) }(time.Now())
+	return r.next.EachReservation(ctx, q, fn)
+}
+
+func (r *instrumentedRepo) CountNewReservations(ctx context.Context) (count int, err error) {
+	defer func(start time.Time) { r.observe("CountNewReservations", start, err) }(time.Now())
+	return r.next.CountNewReservations(ctx)
+}
+
+func (r *instrumentedRepo) GetReservationByID(ctx context.Context, id int) (res models.Reservation, err error) {
+	defer func(start time.Time) { r.observe("GetReservationByID", start, err) }(time.Now())
+	return r.next.GetReservationByID(ctx, id)
+}
+
+func (r *instrumentedRepo) UpdateReservation(ctx context.Context, res models.Reservation) (err error) {
+	defer func(start time.Time) { r.observe("UpdateReservation", start, err) }(time.Now())
+	return r.next.UpdateReservation(ctx, res)
+}
+
+func (r *instrumentedRepo) MoveReservation(ctx context.Context, res models.Reservation) (err error) {
+	defer func(start time.Time) { r.observe("MoveReservation", start, err) }(time.Now())
+	return r.next.MoveReservation(ctx, res)
+}
+
+func (r *instrumentedRepo) DeleteReservation(ctx context.Context, id int) (err error) {
+	defer func(start time.Time) { r.observe("DeleteReservation", start, err) }(time.Now())
+	return r.next.DeleteReservation(ctx, id)
+}
+
+func (r *instrumentedRepo) UpdateProcessedForReservation(ctx context.Context, id, processed int) (err error) {
+	defer func(start time.Time) { r.observe("UpdateProcessedForReservation", start, err) }(time.Now())
+	return r.next.UpdateProcessedForReservation(ctx, id, processed)
+}
+
+func (r *instrumentedRepo) AllRooms(ctx context.Context) (rooms []models.Room, err error) {
+	defer func(start time.Time) { r.observe("AllRooms", start, err) }(time.Now())
+	return r.next.AllRooms(ctx)
+}
+
+func (r *instrumentedRepo) RoomAvailability(ctx context.Context, roomID int, start, end time.Time) (nights []models.NightAvailability, err error) {
+	defer func(t time.Time) { r.observe("RoomAvailability", t, err) }(time.Now())
+	return r.next.RoomAvailability(ctx, roomID, start, end)
+}
+
+func (r *instrumentedRepo) FreeUnitNights(ctx context.Context, start, end time.Time) (nights []models.RoomNight, err error) {
+	defer func(t time.Time) { r.observe("FreeUnitNights", t, err) }(time.Now())
+	return r.next.FreeUnitNights(ctx, start, end)
+}
+
+func (r *instrumentedRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) (restrictions []models.RoomRestriction, err error) {
+	defer func(t time.Time) { r.observe("GetRestrictionsForRoomByDate", t, err) }(time.Now())
+	return r.next.GetRestrictionsForRoomByDate(ctx, roomID, start, end)
+}
+
+func (r *instrumentedRepo) RestrictionsForPeriod(ctx context.Context, start, end time.Time) (restrictions []models.RoomRestriction, err error) {
+	defer func(start time.Time) { r.observe("RestrictionsForPeriod", start, err) }(time.Now())
+	return r.next.RestrictionsForPeriod(ctx, start, end)
+}
+
+func (r *instrumentedRepo) InsertBlock(ctx context.Context, unitID int, start, end time.Time) (id int, err error) {
+	defer func(start time.Time) { r.observe("InsertBlock", start, err) }(time.Now())
+	return r.next.InsertBlock(ctx, unitID, start, end)
+}
+
+func (r *instrumentedRepo) DeleteBlockByID(ctx context.Context, id int) (err error) {
+	defer func(start time.Time) { r.observe("DeleteBlockByID", start, err) }(time.Now())
+	return r.next.DeleteBlockByID(ctx, id)
+}
+
+func (r *instrumentedRepo) HoldRoom(ctx context.Context, roomID int, start, end, until time.Time) (id int, err error) {
+	defer func(t time.Time) { r.observe("HoldRoom", t, err) }(time.Now())
+	return r.next.HoldRoom(ctx, roomID, start, end, until)
+}
+
+func (r *instrumentedRepo) ReleaseRoomHold(ctx context.Context, id int) (err error) {
+	defer func(start time.Time) { r.observe("ReleaseRoomHold", start, err) }(time.Now())
+	return r.next.ReleaseRoomHold(ctx, id)
+}
+
+func (r *instrumentedRepo) ReleaseExpiredRoomHolds(ctx context.Context, now time.Time) (n int, err error) {
+	defer func(start time.Time) { r.observe("ReleaseExpiredRoomHolds", start, err) }(time.Now())
+	return r.next.ReleaseExpiredRoomHolds(ctx, now)
+}
+
+func (r *instrumentedRepo) ArrivalsForDate(ctx context.Context, day time.Time) (res []models.Reservation, err error) {
+	defer func(start time.Time) { r.observe("ArrivalsForDate", start, err) }(time.Now())
+	return r.next.ArrivalsForDate(ctx, day)
+}
+
+func (r *instrumentedRepo) DeparturesForDate(ctx context.Context, day time.Time) (res []models.Reservation, err error) {
+	defer func(start time.Time) { r.observe("DeparturesForDate", start, err) }(time.Now())
+	return r.next.DeparturesForDate(ctx, day)
+}
+
+func (r *instrumentedRepo) CountInHouseReservations(ctx context.Context, day time.Time) (count int, err error) {
+	defer func(start time.Time) { r.observe("CountInHouseReservations", start, err) }(time.Now())
+	return r.next.CountInHouseReservations(ctx, day)
+}
+
+func (r *instrumentedRepo) OccupancyByRoom(ctx context.Context, start, end time.Time) (occupancy []models.RoomOccupancy, err error) {
+	defer func(t time.Time) { r.observe("OccupancyByRoom", t, err) }(time.Now())
+	return r.next.OccupancyByRoom(ctx, start, end)
+}
+
+func (r *instrumentedRepo) ReservationsCreatedByDay(ctx context.Context, start, end time.Time) (counts []models.DailyCount, err error) {
+	defer func(t time.Time) { r.observe("ReservationsCreatedByDay", t, err) }(time.Now())
+	return r.next.ReservationsCreatedByDay(ctx, start, end)
+}
+
+func (r *instrumentedRepo) AverageLengthOfStay(ctx context.Context, start, end time.Time) (avg float64, err error) {
+	defer func(t time.Time) { r.observe("AverageLengthOfStay", t, err) }(time.Now())
+	return r.next.AverageLengthOfStay(ctx, start, end)
+}
+
+func (r *instrumentedRepo) EachRoomNight(ctx context.Context, start, end time.Time, fn func(models.RoomNight) error) (err error) {
+	defer func(t time.Time) { r.observe("EachRoomNight", t, err) }(time.Now())
+	return r.next.EachRoomNight(ctx, start, end, fn)
+}
+
+func (r *instrumentedRepo) ImportRestrictions(ctx context.Context, rows []models.RoomRestriction) (err error) {
+	defer func(start time.Time) { r.observe("ImportRestrictions", start, err) }(time.Now())
+	return r.next.ImportRestrictions(ctx, rows)
+}
+
+func (r *instrumentedRepo) ListGuests(ctx context.Context, search string) (guests []models.Guest, err error) {
+	defer func(start time.Time) { r.observe("ListGuests", start, err) }(time.Now())
+	return r.next.ListGuests(ctx, search)
+}
+
+func (r *instrumentedRepo) GetGuestByID(ctx context.Context, id int) (g models.Guest, err error) {
+	defer func(start time.Time) { r.observe("GetGuestByID", start, err) }(time.Now())
+	return r.next.GetGuestByID(ctx, id)
+}
+
+func (r *instrumentedRepo) GuestStays(ctx context.Context, guestID int) (stays []models.Reservation, err error) {
+	defer func(start time.Time) { r.observe("GuestStays", start, err) }(time.Now())
+	return r.next.GuestStays(ctx, guestID)
+}
+
+func (r *instrumentedRepo) UpdateGuest(ctx context.Context, g models.Guest) (err error) {
+	defer func(start time.Time) { r.observe("UpdateGuest", start, err) }(time.Now())
+	return r.next.UpdateGuest(ctx, g)
+}
+
+func (r *instrumentedRepo) AddReservationNote(ctx context.Context, n models.ReservationNote) (id int, err error) {
+	defer func(start time.Time) { r.observe("AddReservationNote", start, err) }(time.Now())
+	return r.next.AddReservationNote(ctx, n)
+}
+
+func (r *instrumentedRepo) ReservationNotes(ctx context.Context, reservationID int) (notes []models.ReservationNote, err error) {
+	defer func(start time.Time) { r.observe("ReservationNotes", start, err) }(time.Now())
+	return r.next.ReservationNotes(ctx, reservationID)
+}
+
+func (r *instrumentedRepo) AddReservationMessage(ctx context.Context, msg models.ReservationMessage) (id int, err error) {
+	defer func(start time.Time) { r.observe("AddReservationMessage", start, err) }(time.Now())
+	return r.next.AddReservationMessage(ctx, msg)
+}
+
+func (r *instrumentedRepo) ReservationMessages(ctx context.Context, reservationID int) (messages []models.ReservationMessage, err error) {
+	defer func(start time.Time) { r.observe("ReservationMessages", start, err) }(time.Now())
+	return r.next.ReservationMessages(ctx, reservationID)
+}
+
+func (r *instrumentedRepo) ReplyToken(ctx context.Context, reservationID int) (token string, err error) {
+	defer func(start time.Time) { r.observe("ReplyToken", start, err) }(time.Now())
+	return r.next.ReplyToken(ctx, reservationID)
+}
+
+func (r *instrumentedRepo) ReservationIDByReplyToken(ctx context.Context, token string) (id int, err error) {
+	defer func(start time.Time) { r.observe("ReservationIDByReplyToken", start, err) }(time.Now())
+	return r.next.ReservationIDByReplyToken(ctx, token)
+}
+
+func (r *instrumentedRepo) AddPayment(ctx context.Context, p models.Payment) (id int, err error) {
+	defer func(start time.Time) { r.observe("AddPayment", start, err) }(time.Now())
+	return r.next.AddPayment(ctx, p)
+}
+
+func (r *instrumentedRepo) ReservationPayments(ctx context.Context, reservationID int) (payments []models.Payment, err error) {
+	defer func(start time.Time) { r.observe("ReservationPayments", start, err) }(time.Now())
+	return r.next.ReservationPayments(ctx, reservationID)
+}
+
+func (r *instrumentedRepo) UpdateAmountDue(ctx context.Context, reservationID, amount int) (err error) {
+	defer func(start time.Time) { r.observe("UpdateAmountDue", start, err) }(time.Now())
+	return r.next.UpdateAmountDue(ctx, reservationID, amount)
+}
+
+func (r *instrumentedRepo) RecordCheckoutPayment(ctx context.Context, p models.Payment) (recorded bool, err error) {
+	defer func(start time.Time) { r.observe("RecordCheckoutPayment", start, err) }(time.Now())
+	return r.next.RecordCheckoutPayment(ctx, p)
+}
+
+func (r *instrumentedRepo) ReleaseHold(ctx context.Context, reservationID int) (released bool, err error) {
+	defer func(start time.Time) { r.observe("ReleaseHold", start, err) }(time.Now())
+	return r.next.ReleaseHold(ctx, reservationID)
+}
+
+func (r *instrumentedRepo) ReleaseExpiredHolds(ctx context.Context, now time.Time) (ids []int, err error) {
+	defer func(start time.Time) { r.observe("ReleaseExpiredHolds", start, err) }(time.Now())
+	return r.next.ReleaseExpiredHolds(ctx, now)
+}
+
+func (r *instrumentedRepo) CreateInvoice(ctx context.Context, inv models.Invoice) (invoice models.Invoice, err error) {
+	defer func(start time.Time) { r.observe("CreateInvoice", start, err) }(time.Now())
+	return r.next.CreateInvoice(ctx, inv)
+}
+
+func (r *instrumentedRepo) InvoiceForReservation(ctx context.Context, reservationID int) (invoice models.Invoice, err error) {
+	defer func(start time.Time) { r.observe("InvoiceForReservation", start, err) }(time.Now())
+	return r.next.InvoiceForReservation(ctx, reservationID)
+}
+
+func (r *instrumentedRepo) GuestToken(ctx context.Context, reservationID int) (token string, err error) {
+	defer func(start time.Time) { r.observe("GuestToken", start, err) }(time.Now())
+	return r.next.GuestToken(ctx, reservationID)
+}
+
+func (r *instrumentedRepo) ReservationIDByGuestToken(ctx context.Context, token string) (id int, err error) {
+	defer func(start time.Time) { r.observe("ReservationIDByGuestToken", start, err) }(time.Now())
+	return r.next.ReservationIDByGuestToken(ctx, token)
+}
+
+func (r *instrumentedRepo) AllPromoCodes(ctx context.Context) (codes []models.PromoCode, err error) {
+	defer func(start time.Time) { r.observe("AllPromoCodes", start, err) }(time.Now())
+	return r.next.AllPromoCodes(ctx)
+}
+
+func (r *instrumentedRepo) GetPromoCodeByID(ctx context.Context, id int) (p models.PromoCode, err error) {
+	defer func(start time.Time) { r.observe("GetPromoCodeByID", start, err) }(time.Now())
+	return r.next.GetPromoCodeByID(ctx, id)
+}
+
+func (r *instrumentedRepo) GetPromoCodeByCode(ctx context.Context, code string) (p models.PromoCode, err error) {
+	defer func(start time.Time) { r.observe("GetPromoCodeByCode", start, err) }(time.Now())
+	return r.next.GetPromoCodeByCode(ctx, code)
+}
+
+func (r *instrumentedRepo) InsertPromoCode(ctx context.Context, p models.PromoCode) (id int, err error) {
+	defer func(start time.Time) { r.observe("InsertPromoCode", start, err) }(time.Now())
+	return r.next.InsertPromoCode(ctx, p)
+}
+
+func (r *instrumentedRepo) UpdatePromoCode(ctx context.Context, p models.PromoCode) (err error) {
+	defer func(start time.Time) { r.observe("UpdatePromoCode", start, err) }(time.Now())
+	return r.next.UpdatePromoCode(ctx, p)
+}
+
+func (r *instrumentedRepo) DeletePromoCode(ctx context.Context, id int) (err error) {
+	defer func(start time.Time) { r.observe("DeletePromoCode", start, err) }(time.Now())
+	return r.next.DeletePromoCode(ctx, id)
+}
+
+func (r *instrumentedRepo) PromoCodeRedemptions(ctx context.Context, promoCodeID int) (reservations []models.Reservation, err error) {
+	defer func(start time.Time) { r.observe("PromoCodeRedemptions", start, err) }(time.Now())
+	return r.next.PromoCodeRedemptions(ctx, promoCodeID)
+}
+
+func (r *instrumentedRepo) AllCancellationPolicies(ctx context.Context) (policies []models.CancellationPolicy, err error) {
+	defer func(start time.Time) { r.observe("AllCancellationPolicies", start, err) }(time.Now())
+	return r.next.AllCancellationPolicies(ctx)
+}
+
+func (r *instrumentedRepo) GetCancellationPolicyByID(ctx context.Context, id int) (p models.CancellationPolicy, err error) {
+	defer func(start time.Time) { r.observe("GetCancellationPolicyByID", start, err) }(time.Now())
+	return r.next.GetCancellationPolicyByID(ctx, id)
+}
+
+func (r *instrumentedRepo) InsertCancellationPolicy(ctx context.Context, p models.CancellationPolicy) (id int, err error) {
+	defer func(start time.Time) { r.observe("InsertCancellationPolicy", start, err) }(time.Now())
+	return r.next.InsertCancellationPolicy(ctx, p)
+}
+
+func (r *instrumentedRepo) UpdateCancellationPolicy(ctx context.Context, p models.CancellationPolicy) (err error) {
+	defer func(start time.Time) { r.observe("UpdateCancellationPolicy", start, err) }(time.Now())
+	return r.next.UpdateCancellationPolicy(ctx, p)
+}
+
+func (r *instrumentedRepo) DeleteCancellationPolicy(ctx context.Context, id int) (err error) {
+	defer func(start time.Time) { r.observe("DeleteCancellationPolicy", start, err) }(time.Now())
+	return r.next.DeleteCancellationPolicy(ctx, id)
+}
+
+func (r *instrumentedRepo) CancelReservation(ctx context.Context, id, fee int, reason string) (cancelled bool, err error) {
+	defer func(start time.Time) { r.observe("CancelReservation", start, err) }(time.Now())
+	return r.next.CancelReservation(ctx, id, fee, reason)
+}
+
+func (r *instrumentedRepo) GetPropertyByID(ctx context.Context, id int) (p models.Property, err error) {
+	defer func(start time.Time) { r.observe("GetPropertyByID", start, err) }(time.Now())
+	return r.next.GetPropertyByID(ctx, id)
+}
+
+func (r *instrumentedRepo) PropertyByHost(ctx context.Context, host string) (p models.Property, err error) {
+	defer func(start time.Time) { r.observe("PropertyByHost", start, err) }(time.Now())
+	return r.next.PropertyByHost(ctx, host)
+}
+
+func (r *instrumentedRepo) PropertyBySlug(ctx context.Context, slug string) (p models.Property, err error) {
+	defer func(start time.Time) { r.observe("PropertyBySlug", start, err) }(time.Now())
+	return r.next.PropertyBySlug(ctx, slug)
+}
+
+func (r *instrumentedRepo) UserProperties(ctx context.Context, userID int) (properties []models.Property, err error) {
+	defer func(start time.Time) { r.observe("UserProperties", start, err) }(time.Now())
+	return r.next.UserProperties(ctx, userID)
+}
+
+func (r *instrumentedRepo) InsertProperty(ctx context.Context, p models.Property) (id int, err error) {
+	defer func(start time.Time) { r.observe("InsertProperty", start, err) }(time.Now())
+	return r.next.InsertProperty(ctx, p)
+}
+
+func (r *instrumentedRepo) UpdateProperty(ctx context.Context, p models.Property) (err error) {
+	defer func(start time.Time) { r.observe("UpdateProperty", start, err) }(time.Now())
+	return r.next.UpdateProperty(ctx, p)
+}
+
+func (r *instrumentedRepo) ListUsers(ctx context.Context, userID int) (users []models.User, err error) {
+	defer func(start time.Time) { r.observe("ListUsers", start, err) }(time.Now())
+	return r.next.ListUsers(ctx, userID)
+}
+
+func (r *instrumentedRepo) InsertRoom(ctx context.Context, room models.Room) (id int, err error) {
+	defer func(start time.Time) { r.observe("InsertRoom", start, err) }(time.Now())
+	return r.next.InsertRoom(ctx, room)
+}
+
+func (r *instrumentedRepo) AllRoomUnits(ctx context.Context) (units []models.RoomUnit, err error) {
+	defer func(start time.Time) { r.observe("AllRoomUnits", start, err) }(time.Now())
+	return r.next.AllRoomUnits(ctx)
+}
+
+func (r *instrumentedRepo) AddRoomUnit(ctx context.Context, roomID int, name string) (id int, err error) {
+	defer func(start time.Time) { r.observe("AddRoomUnit", start, err) }(time.Now())
+	return r.next.AddRoomUnit(ctx, roomID, name)
+}
+
+func (r *instrumentedRepo) AssignUnit(ctx context.Context, reservationID, unitID int) (err error) {
+	defer func(start time.Time) { r.observe("AssignUnit", start, err) }(time.Now())
+	return r.next.AssignUnit(ctx, reservationID, unitID)
+}
@@ -1,6 +1,11 @@
 package models
 
 import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -16,14 +21,76 @@ type User struct {
 	UpdatedAt   time.Time
 }
 
-// Room is the room model
+// Room is the room model. A room is a type guests book, such as Double, with
+// identical units staff assign to each stay.
 type Room struct {
+	ID       int
+	RoomName string
+	// Price is the price of a night, in cents
+	Price      int
+	PropertyID int
+	// Units is the number of units of the type, and Available the number free for
+	// the dates searched
+	Units     int
+	Available int
+	// MinNights is the shortest stay the room is booked for
+	MinNights int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// NightAvailability is how many units of a room are free on a night, and its price
+type NightAvailability struct {
+	Date  time.Time
+	Free  int
+	Price int
+}
+
+// RoomUnit is a unit of a room type, such as Double 12, each booked by one stay at a time
+type RoomUnit struct {
 	ID        int
-	RoomName  string
+	RoomID    int
+	Name      string
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// UnitNames returns the names of the units of a new room: the name of the room for a
+// single unit, or else the name followed by the number of each unit
+func UnitNames(roomName string, units int) []string {
+	if units <= 1 {
+		return []string{roomName}
+	}
+	names := make([]string, units)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s %d", roomName, i+1)
+	}
+	return names
+}
+
+// Property is a bed and breakfast with its own rooms, guests and staff. Its
+// public pages are served on Hostname if it has one, or under /p/{Slug}.
+type Property struct {
+	ID       int
+	Name     string
+	Slug     string
+	Hostname string
+	// UserIDs are the staff members managing the property
+	UserIDs   []int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// HasUser reports whether a staff member manages the property
+func (p Property) HasUser(userID int) bool {
+	for _, id := range p.UserIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
 // Restrictions is the restriction model
 type Restriction struct {
 	ID              int
@@ -46,21 +113,541 @@ type Reservation struct {
 	UpdatedAt time.Time
 	Room      Room
 	Processed int
+	// UnitID is the unit of the room assigned to the stay, and Unit its name, 0 once
+	// the reservation is cancelled or released
+	UnitID int
+	Unit   string
+	// Source tells how the reservation was made, one of the Source constants
+	Source string
+	// OverrideReason explains why staff booked the room although it was not available
+	OverrideReason string
+	// CreatedBy is the id of the staff member who entered the reservation, 0 for guests
+	CreatedBy int
+	// GuestID links the reservation to the guest with the same email, 0 without email
+	GuestID int
+	// AmountDue is the price of the stay, in cents
+	AmountDue int
+	// PaymentStatus is one of the Payment status constants
+	PaymentStatus string
+	// HoldUntil is when a reservation pending payment is released, zero if not held
+	HoldUntil time.Time
+	// PromoCodeID is the promo code the guest booked with, 0 for none, PromoCode its
+	// code and Discount what it took off the stay, in cents
+	PromoCodeID int
+	PromoCode   string
+	Discount    int
+	// CancellationPolicyID is the policy of the room when the reservation was made,
+	// 0 for free cancellation, and CancellationPolicy its name
+	CancellationPolicyID int
+	CancellationPolicy   string
+	// CancelledAt is when the reservation was cancelled, zero if it wasn't, with the
+	// fee owed for it in cents and the reason given
+	CancelledAt        time.Time
+	CancellationFee    int
+	CancellationReason string
+}
+
+// Nights returns the number of nights of the stay
+func (r Reservation) Nights() int {
+	return int(r.EndDate.Sub(r.StartDate).Hours() / 24)
+}
+
+// Cancelled reports whether the reservation was cancelled
+func (r Reservation) Cancelled() bool {
+	return !r.CancelledAt.IsZero()
+}
+
+// Cancellable reports whether the reservation can still be cancelled at now: it
+// isn't cancelled or released already, and the stay hasn't ended
+func (r Reservation) Cancellable(now time.Time) bool {
+	return !r.Cancelled() && r.PaymentStatus != PaymentReleased && DaysBefore(r.EndDate, now) > 0
+}
+
+// Owed returns what the guest owes for the reservation, in cents: the amount due,
+// or the cancellation fee once cancelled
+func (r Reservation) Owed() int {
+	if r.Cancelled() {
+		return r.CancellationFee
+	}
+	return r.AmountDue
+}
+
+// Payment statuses of a reservation. Reservations paid online are pending until
+// the provider confirms the payment, and released if that doesn't happen in time.
+const (
+	PaymentNone     = "none"
+	PaymentPending  = "pending"
+	PaymentPaid     = "paid"
+	PaymentReleased = "released"
+)
+
+// Payment kinds. Deposits and payments add to what was paid, refunds take from it.
+const (
+	PaymentDeposit = "deposit"
+	PaymentPayment = "payment"
+	PaymentRefund  = "refund"
+)
+
+// PaymentKinds are the kinds of payment staff can record
+var PaymentKinds = []string{PaymentDeposit, PaymentPayment, PaymentRefund}
+
+// Payment methods
+const (
+	MethodCard     = "card"
+	MethodCash     = "cash"
+	MethodTransfer = "transfer"
+)
+
+// PaymentMethods are the methods staff can record a payment with
+var PaymentMethods = []string{MethodCard, MethodCash, MethodTransfer}
+
+// Payment is money received for, or refunded on, a reservation
+type Payment struct {
+	ID            int
+	ReservationID int
+	Kind          string
+	Method        string
+	// Amount is in cents, and positive for refunds too
+	Amount int
+	// Reference is whatever staff want to keep, such as a transfer reference
+	Reference string
+	// CheckoutID is the provider's checkout, for card payments taken online
+	CheckoutID string
+	// UserID is the staff member who recorded the payment, Author their name
+	UserID    int
+	Author    string
+	CreatedAt time.Time
+}
+
+// Signed returns the amount of the payment, negative for refunds
+func (p Payment) Signed() int {
+	if p.Kind == PaymentRefund {
+		return -p.Amount
+	}
+	return p.Amount
+}
+
+// Balance returns what is left to pay on amountDue, in cents, after payments
+func Balance(amountDue int, payments []Payment) int {
+	for _, p := range payments {
+		amountDue -= p.Signed()
+	}
+	return amountDue
+}
+
+// FormatMoney formats cents as an amount with two decimals and the currency code
+func FormatMoney(cents int, currency string) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return strings.TrimSpace(fmt.Sprintf("%s%d.%02d %s", sign, cents/100, cents%100, strings.ToUpper(currency)))
+}
+
+// ParseMoney parses an amount with up to two decimals, such as 95 or 95.50, into cents
+func ParseMoney(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	if hasFrac && (frac == "" || len(frac) > 2) {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+
+	units, err := strconv.ParseUint(whole, 10, 31)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	cents, err := strconv.ParseUint(frac, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	return int(units)*100 + int(cents), nil
+}
+
+// DefaultPropertyID is the property served on hostnames no property claims
+const DefaultPropertyID = 1
+
+// Invoice is a numbered bill for a reservation. It keeps a copy of who was billed
+// and of the price lines, so it reads the same after the reservation changes.
+type Invoice struct {
+	ID         int
+	PropertyID int
+	// Number follows the previous invoice of the property, with no gaps
+	Number        int
+	ReservationID int
+	BillTo        string
+	Email         string
+	Currency      string
+	Lines         []InvoiceLine
+	// Total is the sum of the lines, in cents
+	Total    int
+	IssuedAt time.Time
+}
+
+// Reference returns the invoice number as printed, such as INV-000042
+func (i Invoice) Reference() string {
+	return fmt.Sprintf("INV-%06d", i.Number)
+}
+
+// InvoiceLine is a priced line of an invoice
+type InvoiceLine struct {
+	Description string
+	Quantity    int
+	// UnitPrice and Amount are in cents
+	UnitPrice int
+	Amount    int
+}
+
+// PriceLines returns the lines billed for a reservation: its nights at the room's
+// price, and an adjustment for any difference with the amount due. A cancelled
+// reservation is billed its cancellation fee.
+func PriceLines(r Reservation) []InvoiceLine {
+	var lines []InvoiceLine
+	if r.Cancelled() {
+		if r.CancellationFee > 0 {
+			lines = append(lines, InvoiceLine{
+				Description: fmt.Sprintf("Cancellation fee, %s, %s to %s", r.Room.RoomName, r.StartDate.Format("2006-01-02"), r.EndDate.Format("2006-01-02")),
+				Quantity:    1,
+				UnitPrice:   r.CancellationFee,
+				Amount:      r.CancellationFee,
+			})
+		}
+		return lines
+	}
+
+	nights := r.Nights()
+	if nights > 0 && r.Room.Price > 0 {
+		lines = append(lines, InvoiceLine{
+			Description: fmt.Sprintf("%s, %s to %s", r.Room.RoomName, r.StartDate.Format("2006-01-02"), r.EndDate.Format("2006-01-02")),
+			Quantity:    nights,
+			UnitPrice:   r.Room.Price,
+			Amount:      nights * r.Room.Price,
+		})
+	}
+
+	if r.Discount > 0 {
+		lines = append(lines, InvoiceLine{Description: "Promo code " + r.PromoCode, Quantity: 1, UnitPrice: -r.Discount, Amount: -r.Discount})
+	}
+
+	var total int
+	for _, l := range lines {
+		total += l.Amount
+	}
+	if diff := r.AmountDue - total; diff != 0 {
+		lines = append(lines, InvoiceLine{Description: "Adjustment", Quantity: 1, UnitPrice: diff, Amount: diff})
+	}
+	return lines
+}
+
+// Promo code discount kinds
+const (
+	DiscountPercent = "percent"
+	DiscountFixed   = "fixed"
+)
+
+// DiscountKinds are the kinds of discount a promo code can give
+var DiscountKinds = []string{DiscountPercent, DiscountFixed}
+
+// Reasons a promo code can't be used for a stay, shown to the guest
+var (
+	ErrPromoInactive = errors.New("This code is no longer valid")
+	ErrPromoDates    = errors.New("This code is not valid for these dates")
+	ErrPromoRoom     = errors.New("This code is not valid for this room")
+	ErrPromoNights   = errors.New("This code needs a stay")
+	// ErrPromoUsedUp and ErrPromoAlreadyUsed are found when the booking is saved
+	ErrPromoUsedUp      = errors.New("This code has been used up")
+	ErrPromoAlreadyUsed = errors.New("You have already used this code")
+)
+
+// PromoCode is a discount guests get by entering its code when booking
+type PromoCode struct {
+	ID int
+	// Code is what guests enter, stored upper case
+	Code        string
+	Description string
+	Kind        string
+	// Value is a percentage for percent codes, and cents for fixed ones
+	Value int
+	// ValidFrom and ValidTo are the first and last nights the code applies to,
+	// zero for no limit
+	ValidFrom time.Time
+	ValidTo   time.Time
+	// RoomIDs are the rooms the code applies to, empty for all
+	RoomIDs   []int
+	MinNights int
+	// MaxRedemptions caps the reservations made with the code, 0 for no cap
+	MaxRedemptions int
+	Active         bool
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	// Redemptions, Discounted and Revenue are the number of reservations made with
+	// the code, what it took off them and what they are worth, in cents
+	Redemptions int
+	Discounted  int
+	Revenue     int
+}
+
+// NormalizeCode returns a promo code as stored, whatever the case it was typed in
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Check returns why the code can't be used for a reservation, or nil if it can
+func (p PromoCode) Check(r Reservation) error {
+	if !p.Active {
+		return ErrPromoInactive
+	}
+	lastNight := r.EndDate.AddDate(0, 0, -1)
+	if (!p.ValidFrom.IsZero() && r.StartDate.Before(p.ValidFrom)) || (!p.ValidTo.IsZero() && lastNight.After(p.ValidTo)) {
+		return ErrPromoDates
+	}
+	if len(p.RoomIDs) > 0 && !p.HasRoom(r.RoomID) {
+		return ErrPromoRoom
+	}
+	if r.Nights() < p.MinNights {
+		return fmt.Errorf("%w of at least %d nights", ErrPromoNights, p.MinNights)
+	}
+	return nil
+}
+
+// HasRoom reports whether the code is limited to a list of rooms including roomID
+func (p PromoCode) HasRoom(roomID int) bool {
+	for _, id := range p.RoomIDs {
+		if id == roomID {
+			return true
+		}
+	}
+	return false
+}
+
+// Discount returns what the code takes off amount, in cents
+func (p PromoCode) Discount(amount int) int {
+	discount := p.Value
+	if p.Kind == DiscountPercent {
+		discount = amount * p.Value / 100
+	}
+	return min(discount, amount)
+}
+
+// CancellationPolicy is what cancelling a reservation costs, depending on how long
+// before arrival it is cancelled
+type CancellationPolicy struct {
+	ID          int
+	Name        string
+	Description string
+	// Rules are the fees charged closer to arrival, cancelling earlier is free
+	Rules []CancellationRule
+	// RoomIDs are the rooms booked with the policy
+	RoomIDs   []int
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// CancellationRule charges FeePercent of the stay for cancelling less than
+// DaysBefore days before arrival
+type CancellationRule struct {
+	DaysBefore int
+	FeePercent int
+}
+
+// DaysBefore returns the number of days from the date of now to start, negative
+// once the stay has started
+func DaysBefore(start, now time.Time) int {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	day := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	return int(day.Sub(today).Hours() / 24)
+}
+
+// FeePercent returns the share of the stay charged for cancelling days before
+// arrival: that of the closest rule the cancellation falls under, or 0
+func (p CancellationPolicy) FeePercent(days int) int {
+	percent, closest := 0, -1
+	for _, rule := range p.Rules {
+		if days < rule.DaysBefore && (closest < 0 || rule.DaysBefore < closest) {
+			percent, closest = rule.FeePercent, rule.DaysBefore
+		}
+	}
+	return percent
+}
+
+// Fee returns what cancelling r at now costs, in cents
+func (p CancellationPolicy) Fee(r Reservation, now time.Time) int {
+	return r.AmountDue * p.FeePercent(DaysBefore(r.StartDate, now)) / 100
+}
+
+// Terms describes the policy for guests, one sentence per rule, starting with
+// the free period
+func (p CancellationPolicy) Terms() []string {
+	rules := append([]CancellationRule(nil), p.Rules...)
+	sort.Slice(rules, func(i, j int) bool { return rules[i].DaysBefore > rules[j].DaysBefore })
+
+	if len(rules) == 0 {
+		return []string{"Free cancellation at any time."}
+	}
+
+	terms := []string{"Free cancellation until arrival."}
+	if rules[0].DaysBefore > 0 {
+		terms[0] = fmt.Sprintf("Free cancellation up to %s before arrival.", days(rules[0].DaysBefore))
+	}
+	for _, rule := range rules {
+		if rule.DaysBefore == 0 {
+			terms = append(terms, fmt.Sprintf("%d%% of the stay once it has started.", rule.FeePercent))
+			continue
+		}
+		terms = append(terms, fmt.Sprintf("%d%% of the stay when cancelled less than %s before arrival.", rule.FeePercent, days(rule.DaysBefore)))
+	}
+	return terms
+}
+
+// days returns n days in words
+func days(n int) string {
+	if n == 1 {
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", n)
+}
+
+// HasRoom reports whether the policy applies to roomID
+func (p CancellationPolicy) HasRoom(roomID int) bool {
+	for _, id := range p.RoomIDs {
+		if id == roomID {
+			return true
+		}
+	}
+	return false
+}
+
+// ReservationNote is a note staff keep on a reservation, never shown to the guest
+type ReservationNote struct {
+	ID            int
+	ReservationID int
+	// UserID is the staff member who wrote the note, Author their name
+	UserID    int
+	Author    string
+	Body      string
+	CreatedAt time.Time
+}
+
+// Directions of a ReservationMessage
+const (
+	MessageOut = "out"
+	MessageIn  = "in"
+)
+
+// ReservationMessage is an email sent to, or received from, the guest of a reservation
+type ReservationMessage struct {
+	ID            int
+	ReservationID int
+	Direction     string
+	// UserID is the staff member who sent an outgoing message, Author their name
+	UserID    int
+	Author    string
+	From      string
+	To        string
+	Subject   string
+	Body      string
+	CreatedAt time.Time
+}
+
+// Guest is someone who booked, recognised by their email address
+type Guest struct {
+	ID        int
+	Email     string
+	FirstName string
+	LastName  string
+	Phone     string
+	// Notes are for staff only
+	Notes     string
+	Tags      []string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	// Stays and Nights count the guest's reservations, when listing guests
+	Stays  int
+	Nights int
+}
+
+// Guest tags with a special meaning
+const (
+	TagVIP       = "vip"
+	TagDoNotRent = "do-not-rent"
+)
+
+// HasTag reports whether the guest is tagged with tag
+func (g Guest) HasTag(tag string) bool {
+	for _, t := range g.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
 }
 
+// ParseTags splits a comma separated list of tags, in lower case with dashes
+// for spaces and without duplicates
+func ParseTags(s string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, t := range strings.Split(s, ",") {
+		t = strings.Join(strings.Fields(strings.ToLower(t)), "-")
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		tags = append(tags, t)
+	}
+	return tags
+}
+
+// Reservation sources
+const (
+	SourceWeb    = "web"
+	SourcePhone  = "phone"
+	SourceWalkIn = "walk-in"
+	SourceEmail  = "email"
+	SourceImport = "import"
+)
+
+// AdminSources are the sources staff can choose from when entering a reservation
+var AdminSources = []string{SourcePhone, SourceWalkIn, SourceEmail}
+
 // RoomRestrictions is the room restriction model
 type RoomRestriction struct {
-	ID            int
-	StartDate     time.Time
-	EndDate       time.Time
-	RoomID        int
+	ID        int
+	StartDate time.Time
+	EndDate   time.Time
+	RoomID    int
+	// UnitID is the unit of the room held, assigned a free one when 0 on insert
+	UnitID        int
 	ReservationID int
 	RestrictionID int
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
-	Room          Room
-	Reservation   Reservation
-	Restriction   Restriction
+	// ExpiresAt is when a hold stops keeping the unit, zero for other restrictions
+	ExpiresAt time.Time
+	// HoldID is the hold of the guest turned into this restriction on insert, if any
+	HoldID      int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Room        Room
+	Reservation Reservation
+	Restriction Restriction
+}
+
+// RestrictionHold is the restriction of a room held for a guest filling in the
+// reservation form
+const RestrictionHold = 3
+
+// Active reports whether the restriction keeps its unit at t, which all but
+// expired holds do
+func (r RoomRestriction) Active(t time.Time) bool {
+	return r.ExpiresAt.IsZero() || r.ExpiresAt.After(t)
+}
+
+// Covers reports whether the restriction keeps a unit of the room from start to end
+// at t
+func (r RoomRestriction) Covers(roomID int, start, end, t time.Time) bool {
+	return r.RoomID == roomID && r.StartDate.Equal(start) && r.EndDate.Equal(end) && r.Active(t)
 }
 
 // MailData is the data structure for sending reservation emails
@@ -70,4 +657,53 @@ type MailData struct {
 	Subject  string
 	Content  string
 	Template string
+	// RequestID ties the mail log lines back to the request that queued it
+	RequestID string
+	// ReplyTo is where replies go, if not to From
+	ReplyTo string
+	// Attachments are sent along with the message
+	Attachments []MailAttachment
+}
+
+// MailAttachment is a file attached to an email. Its type is told by the
+// extension of Name.
+type MailAttachment struct {
+	Name string
+	Data []byte
+}
+
+// RoomOccupancy is the number of booked nights for a room over a period
+type RoomOccupancy struct {
+	Room         Room
+	BookedNights int
+	TotalNights  int
+}
+
+// Rate returns the share of nights booked, between 0 and 1
+func (o RoomOccupancy) Rate() float64 {
+	if o.TotalNights == 0 {
+		return 0
+	}
+	return float64(o.BookedNights) / float64(o.TotalNights)
+}
+
+// DailyCount is a count of events on a single day
+type DailyCount struct {
+	Date  time.Time
+	Count int
+}
+
+// RoomNight is a single night of a room unit, with the reservation or block occupying it
+type RoomNight struct {
+	Date          time.Time
+	Room          Room
+	Unit          RoomUnit
+	ReservationID int
+	RestrictionID int
+	GuestName     string
+}
+
+// Occupied reports whether the room is reserved or blocked that night
+func (n RoomNight) Occupied() bool {
+	return n.RestrictionID != 0
 }
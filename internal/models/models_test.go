@@ -0,0 +1,394 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var parseTagsTests = []struct {
+	name     string
+	input    string
+	expected []string
+}{
+	{"empty", "", nil},
+	{"single", "vip", []string{"vip"}},
+	{"normalised", " VIP , Do  not rent,", []string{"vip", "do-not-rent"}},
+	{"duplicates", "vip,VIP, vip", []string{"vip"}},
+}
+
+func TestParseTags(t *testing.T) {
+	for _, e := range parseTagsTests {
+		got := ParseTags(e.input)
+		if !reflect.DeepEqual(got, e.expected) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, got)
+		}
+	}
+}
+
+var unitNamesTests = []struct {
+	name     string
+	units    int
+	expected []string
+}{
+	{"single", 1, []string{"Double"}},
+	{"none", 0, []string{"Double"}},
+	{"several", 3, []string{"Double 1", "Double 2", "Double 3"}},
+}
+
+func TestUnitNames(t *testing.T) {
+	for _, e := range unitNamesTests {
+		got := UnitNames("Double", e.units)
+		if !reflect.DeepEqual(got, e.expected) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, got)
+		}
+	}
+}
+
+var parseMoneyTests = []struct {
+	input    string
+	expected int
+	valid    bool
+}{
+	{"95", 9500, true},
+	{"95.5", 9550, true},
+	{" 95.05 ", 9505, true},
+	{"0.99", 99, true},
+	{"", 0, false},
+	{"95.", 0, false},
+	{"95.123", 0, false},
+	{"-5", 0, false},
+	{"1e3", 0, false},
+}
+
+func TestParseMoney(t *testing.T) {
+	for _, e := range parseMoneyTests {
+		got, err := ParseMoney(e.input)
+		if (err == nil) != e.valid {
+			t.Errorf("%q: expected valid %v, got error %v", e.input, e.valid, err)
+		}
+		if got != e.expected {
+			t.Errorf("%q: expected %d cents, got %d", e.input, e.expected, got)
+		}
+	}
+}
+
+func TestBalance(t *testing.T) {
+	payments := []Payment{
+		{Kind: PaymentDeposit, Amount: 5000},
+		{Kind: PaymentPayment, Amount: 15000},
+		{Kind: PaymentRefund, Amount: 2500},
+	}
+	if got := Balance(20000, payments); got != 2500 {
+		t.Errorf("expected a balance of 2500, got %d", got)
+	}
+	if got := FormatMoney(-2550, "usd"); got != "-25.50 USD" {
+		t.Errorf("wrong format %q", got)
+	}
+}
+
+var priceLinesTests = []struct {
+	name      string
+	amountDue int
+	price     int
+	discount  int
+	expected  []InvoiceLine
+}{
+	{"nights", 20000, 10000, 0, []InvoiceLine{
+		{Description: "General's Quarters, 2040-01-01 to 2040-01-03", Quantity: 2, UnitPrice: 10000, Amount: 20000},
+	}},
+	{"adjusted", 18000, 10000, 0, []InvoiceLine{
+		{Description: "General's Quarters, 2040-01-01 to 2040-01-03", Quantity: 2, UnitPrice: 10000, Amount: 20000},
+		{Description: "Adjustment", Quantity: 1, UnitPrice: -2000, Amount: -2000},
+	}},
+	{"promo-code", 18000, 10000, 2000, []InvoiceLine{
+		{Description: "General's Quarters, 2040-01-01 to 2040-01-03", Quantity: 2, UnitPrice: 10000, Amount: 20000},
+		{Description: "Promo code SUMMER", Quantity: 1, UnitPrice: -2000, Amount: -2000},
+	}},
+	{"unpriced-room", 15000, 0, 0, []InvoiceLine{
+		{Description: "Adjustment", Quantity: 1, UnitPrice: 15000, Amount: 15000},
+	}},
+	{"free", 0, 0, 0, nil},
+}
+
+func TestPriceLines(t *testing.T) {
+	for _, e := range priceLinesTests {
+		res := Reservation{
+			StartDate: time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2040, 1, 3, 0, 0, 0, 0, time.UTC),
+			AmountDue: e.amountDue,
+			Room:      Room{RoomName: "General's Quarters", Price: e.price},
+			PromoCode: "SUMMER",
+			Discount:  e.discount,
+		}
+		got := PriceLines(res)
+		if !reflect.DeepEqual(got, e.expected) {
+			t.Errorf("%s: expected %+v, got %+v", e.name, e.expected, got)
+		}
+	}
+
+	if got := (Invoice{Number: 42}).Reference(); got != "INV-000042" {
+		t.Errorf("wrong invoice reference %q", got)
+	}
+}
+
+var promoCheckTests = []struct {
+	name     string
+	promo    PromoCode
+	start    string
+	end      string
+	expected error
+}{
+	{"valid", PromoCode{Active: true}, "2040-06-01", "2040-06-03", nil},
+	{"inactive", PromoCode{}, "2040-06-01", "2040-06-03", ErrPromoInactive},
+	{"within-dates", PromoCode{Active: true, ValidFrom: date("2040-06-01"), ValidTo: date("2040-06-02")}, "2040-06-01", "2040-06-03", nil},
+	{"before-dates", PromoCode{Active: true, ValidFrom: date("2040-06-02")}, "2040-06-01", "2040-06-03", ErrPromoDates},
+	{"after-dates", PromoCode{Active: true, ValidTo: date("2040-06-01")}, "2040-06-01", "2040-06-03", ErrPromoDates},
+	{"room", PromoCode{Active: true, RoomIDs: []int{1, 2}}, "2040-06-01", "2040-06-03", nil},
+	{"other-room", PromoCode{Active: true, RoomIDs: []int{2}}, "2040-06-01", "2040-06-03", ErrPromoRoom},
+	{"min-nights", PromoCode{Active: true, MinNights: 2}, "2040-06-01", "2040-06-03", nil},
+	{"too-short", PromoCode{Active: true, MinNights: 3}, "2040-06-01", "2040-06-03", ErrPromoNights},
+}
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestPromoCodeCheck(t *testing.T) {
+	for _, e := range promoCheckTests {
+		res := Reservation{RoomID: 1, StartDate: date(e.start), EndDate: date(e.end)}
+		err := e.promo.Check(res)
+		if !errors.Is(err, e.expected) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, err)
+		}
+	}
+
+	err := PromoCode{Active: true, MinNights: 3}.Check(Reservation{StartDate: date("2040-06-01"), EndDate: date("2040-06-02")})
+	if err == nil || err.Error() != "This code needs a stay of at least 3 nights" {
+		t.Errorf("wrong message %v", err)
+	}
+}
+
+var discountTests = []struct {
+	name     string
+	promo    PromoCode
+	amount   int
+	expected int
+}{
+	{"percent", PromoCode{Kind: DiscountPercent, Value: 15}, 20000, 3000},
+	{"fixed", PromoCode{Kind: DiscountFixed, Value: 2500}, 20000, 2500},
+	{"fixed-above-price", PromoCode{Kind: DiscountFixed, Value: 25000}, 20000, 20000},
+	{"free-stay", PromoCode{Kind: DiscountPercent, Value: 100}, 20000, 20000},
+}
+
+func TestPromoCodeDiscount(t *testing.T) {
+	for _, e := range discountTests {
+		if got := e.promo.Discount(e.amount); got != e.expected {
+			t.Errorf("%s: expected %d, got %d", e.name, e.expected, got)
+		}
+	}
+	if got := NormalizeCode(" summer-24 "); got != "SUMMER-24" {
+		t.Errorf("wrong normalized code %q", got)
+	}
+}
+
+var cancellationFeeTests = []struct {
+	name     string
+	now      string
+	expected int
+}{
+	{"early", "2040-05-01", 0},
+	{"free-limit", "2040-05-18", 0},
+	{"late", "2040-05-19", 10000},
+	{"last-day", "2040-05-31", 15000},
+	{"started", "2040-06-02", 20000},
+}
+
+func TestCancellationPolicyFee(t *testing.T) {
+	policy := CancellationPolicy{Rules: []CancellationRule{
+		{DaysBefore: 0, FeePercent: 100},
+		{DaysBefore: 14, FeePercent: 50},
+		{DaysBefore: 2, FeePercent: 75},
+	}}
+	res := Reservation{
+		StartDate: time.Date(2040, 6, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2040, 6, 3, 0, 0, 0, 0, time.UTC),
+		AmountDue: 20000,
+	}
+
+	for _, e := range cancellationFeeTests {
+		now, _ := time.Parse("2006-01-02", e.now)
+		now = now.Add(15 * time.Hour)
+		if got := policy.Fee(res, now); got != e.expected {
+			t.Errorf("%s: expected a fee of %d, got %d", e.name, e.expected, got)
+		}
+	}
+
+	if got := (CancellationPolicy{}).Fee(res, res.StartDate); got != 0 {
+		t.Errorf("expected no fee without rules, got %d", got)
+	}
+}
+
+func TestCancellationPolicyTerms(t *testing.T) {
+	policy := CancellationPolicy{Rules: []CancellationRule{
+		{DaysBefore: 0, FeePercent: 100},
+		{DaysBefore: 14, FeePercent: 50},
+	}}
+	expected := []string{
+		"Free cancellation up to 14 days before arrival.",
+		"50% of the stay when cancelled less than 14 days before arrival.",
+		"100% of the stay once it has started.",
+	}
+	if got := policy.Terms(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	if got := (CancellationPolicy{}).Terms(); !reflect.DeepEqual(got, []string{"Free cancellation at any time."}) {
+		t.Errorf("wrong terms without rules %q", got)
+	}
+}
+
+func TestReservationCancellation(t *testing.T) {
+	res := Reservation{
+		StartDate: time.Date(2040, 6, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2040, 6, 3, 0, 0, 0, 0, time.UTC),
+		AmountDue: 20000,
+		Room:      Room{RoomName: "General's Quarters", Price: 10000},
+	}
+	if !res.Cancellable(time.Date(2040, 6, 2, 10, 0, 0, 0, time.UTC)) {
+		t.Error("expected a stay under way to be cancellable")
+	}
+	if res.Cancellable(time.Date(2040, 6, 3, 10, 0, 0, 0, time.UTC)) {
+		t.Error("expected an ended stay not to be cancellable")
+	}
+	if res.Owed() != 20000 {
+		t.Errorf("expected the amount due to be owed, got %d", res.Owed())
+	}
+
+	res.CancelledAt = time.Date(2040, 5, 20, 10, 0, 0, 0, time.UTC)
+	res.CancellationFee = 10000
+	if res.Cancellable(res.CancelledAt) {
+		t.Error("expected a cancelled reservation not to be cancellable")
+	}
+	if res.Owed() != 10000 {
+		t.Errorf("expected the fee to be owed, got %d", res.Owed())
+	}
+
+	expected := []InvoiceLine{
+		{Description: "Cancellation fee, General's Quarters, 2040-06-01 to 2040-06-03", Quantity: 1, UnitPrice: 10000, Amount: 10000},
+	}
+	if got := PriceLines(res); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+	res.CancellationFee = 0
+	if got := PriceLines(res); got != nil {
+		t.Errorf("expected nothing billed for a free cancellation, got %+v", got)
+	}
+}
+
+func TestRoomRestrictionCovers(t *testing.T) {
+	start := time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 2)
+	now := time.Date(2039, 12, 1, 12, 0, 0, 0, time.UTC)
+	hold := RoomRestriction{RoomID: 1, StartDate: start, EndDate: end, RestrictionID: RestrictionHold, ExpiresAt: now.Add(10 * time.Minute)}
+
+	var tests = []struct {
+		name     string
+		roomID   int
+		end      time.Time
+		at       time.Time
+		expected bool
+	}{
+		{"held", 1, end, now, true},
+		{"other-room", 2, end, now, false},
+		{"other-dates", 1, end.AddDate(0, 0, 1), now, false},
+		{"expired", 1, end, now.Add(10 * time.Minute), false},
+	}
+
+	for _, e := range tests {
+		if got := hold.Covers(e.roomID, start, e.end, e.at); got != e.expected {
+			t.Errorf("%s: expected %t, got %t", e.name, e.expected, got)
+		}
+	}
+
+	block := RoomRestriction{RoomID: 1, StartDate: start, EndDate: end, RestrictionID: 2}
+	if !block.Active(now.AddDate(1, 0, 0)) {
+		t.Error("expected a block never to expire")
+	}
+}
+
+// gridNights returns the nights from start up to end of a unit of a room
+func gridNights(roomID, unitID int, start, end time.Time) []RoomNight {
+	var nights []RoomNight
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		nights = append(nights, RoomNight{Date: d, Room: Room{ID: roomID}, Unit: RoomUnit{ID: unitID, RoomID: roomID}})
+	}
+	return nights
+}
+
+func TestAvailabilityGrid(t *testing.T) {
+	from, to := date("2040-01-01"), date("2040-01-11")
+	rooms := []Room{
+		{ID: 1, RoomName: "General's Quarters", Price: 10000, MinNights: 1},
+		{ID: 2, RoomName: "Major's Suite", Price: 5000, MinNights: 1},
+		{ID: 3, RoomName: "Colonel's Loft", Price: 20000, MinNights: 3},
+	}
+	// room 1 has a unit free until the 5th and another from the 6th, room 2 is free
+	// from the 4th to the 8th, and room 3 every night
+	var free []RoomNight
+	free = append(free, gridNights(1, 1, from, date("2040-01-05"))...)
+	free = append(free, gridNights(1, 2, date("2040-01-06"), to)...)
+	free = append(free, gridNights(2, 3, date("2040-01-04"), date("2040-01-08"))...)
+	free = append(free, gridNights(3, 4, from, to)...)
+	grid := NewAvailabilityGrid(from, to, rooms, free)
+
+	var freeRoomsTests = []struct {
+		name     string
+		start    string
+		end      string
+		expected []int
+	}{
+		{"all-rooms-but-short", "2040-01-04", "2040-01-05", []int{1, 2}},
+		{"long-enough", "2040-01-02", "2040-01-05", []int{1, 3}},
+		{"across-units", "2040-01-04", "2040-01-07", []int{2, 3}},
+		{"outside-grid", "2040-01-10", "2040-01-12", nil},
+	}
+	for _, e := range freeRoomsTests {
+		var got []int
+		for _, r := range grid.FreeRooms(date(e.start), date(e.end)) {
+			got = append(got, r.ID)
+		}
+		if !reflect.DeepEqual(got, e.expected) {
+			t.Errorf("%s: expected rooms %v, got %v", e.name, e.expected, got)
+		}
+	}
+
+	// nearest first, earlier first, and none before the 4th
+	flexible := NewAvailabilityGrid(from, to, rooms[:2], free).FlexibleStays(date("2040-01-05"), date("2040-01-07"), 3, date("2040-01-04"))
+	var got []string
+	for _, s := range flexible {
+		got = append(got, s.StartDate.Format("02"))
+	}
+	if expected := []string{"04", "06", "07", "08"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected stays from %v, got %v", expected, got)
+	}
+
+	split := NewAvailabilityGrid(from, to, rooms[:2], free).SplitStay(date("2040-01-03"), date("2040-01-09"))
+	var parts []string
+	for _, p := range split {
+		parts = append(parts, fmt.Sprintf("%d:%s-%s", p.Room.ID, p.StartDate.Format("02"), p.EndDate.Format("02")))
+	}
+	if expected := []string{"1:03-05", "2:05-08", "1:08-09"}; !reflect.DeepEqual(parts, expected) {
+		t.Errorf("expected split stay %v, got %v", expected, parts)
+	}
+
+	if split := grid.SplitStay(date("2040-01-02"), date("2040-01-05")); split != nil {
+		t.Errorf("expected no split stay when a room is free for all the nights, got %+v", split)
+	}
+	if split := NewAvailabilityGrid(from, to, rooms[1:2], free).SplitStay(date("2040-01-02"), date("2040-01-05")); split != nil {
+		t.Errorf("expected no split stay with nights no room is free, got %+v", split)
+	}
+}
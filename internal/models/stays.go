@@ -0,0 +1,149 @@
+package models
+
+import (
+	"sort"
+	"time"
+)
+
+// maxFlexibleStays caps the other dates suggested for a stay
+const maxFlexibleStays = 5
+
+// maxSplitParts caps the rooms a split stay moves between
+const maxSplitParts = 3
+
+// StayOption is a room free from StartDate until EndDate
+type StayOption struct {
+	Room      Room
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+// Nights returns the number of nights of the stay
+func (s StayOption) Nights() int {
+	return int(s.EndDate.Sub(s.StartDate).Hours() / 24)
+}
+
+// FlexibleStay is a stay moved to other dates, with the rooms free on all its nights
+type FlexibleStay struct {
+	StartDate time.Time
+	EndDate   time.Time
+	Rooms     []Room
+}
+
+// AvailabilityGrid tells which units of the rooms are free on each night of a period,
+// to find stays on other dates or across rooms without asking the database again
+type AvailabilityGrid struct {
+	start time.Time
+	rooms []Room
+	// free holds, for every unit of a room, whether it is free each night from start
+	free map[int]map[int][]bool
+}
+
+// NewAvailabilityGrid builds the grid of the nights from start up to end, given the
+// free nights of the units of the rooms
+func NewAvailabilityGrid(start, end time.Time, rooms []Room, free []RoomNight) AvailabilityGrid {
+	g := AvailabilityGrid{start: start, rooms: rooms, free: make(map[int]map[int][]bool)}
+	nights := int(end.Sub(start).Hours() / 24)
+	for _, n := range free {
+		i := g.night(n.Date)
+		if i < 0 || i >= nights {
+			continue
+		}
+		units, ok := g.free[n.Room.ID]
+		if !ok {
+			units = make(map[int][]bool)
+			g.free[n.Room.ID] = units
+		}
+		if units[n.Unit.ID] == nil {
+			units[n.Unit.ID] = make([]bool, nights)
+		}
+		units[n.Unit.ID][i] = true
+	}
+	return g
+}
+
+// night returns the index of the night of day in the grid
+func (g AvailabilityGrid) night(day time.Time) int {
+	return int(day.Sub(g.start).Hours() / 24)
+}
+
+// run returns the number of nights in a row a unit of the room is free from the night
+// of day, up to limit
+func (g AvailabilityGrid) run(roomID int, day time.Time, limit int) int {
+	first := g.night(day)
+	best := 0
+	for _, nights := range g.free[roomID] {
+		n := 0
+		for i := first; i >= 0 && i < len(nights) && n < limit && nights[i]; i++ {
+			n++
+		}
+		best = max(best, n)
+	}
+	return best
+}
+
+// FreeRooms returns the rooms with a unit free on every night from start until end,
+// for a stay no shorter than their minimum
+func (g AvailabilityGrid) FreeRooms(start, end time.Time) []Room {
+	nights := int(end.Sub(start).Hours() / 24)
+	var rooms []Room
+	for _, r := range g.rooms {
+		if nights >= max(r.MinNights, 1) && g.run(r.ID, start, nights) == nights {
+			rooms = append(rooms, r)
+		}
+	}
+	return rooms
+}
+
+// FlexibleStays returns the stays of the same nights as start to end moved by up to
+// days days either way, nearest first and never starting before earliest, on which
+// some room is free
+func (g AvailabilityGrid) FlexibleStays(start, end time.Time, days int, earliest time.Time) []FlexibleStay {
+	var stays []FlexibleStay
+	for shift := 1; shift <= days && len(stays) < maxFlexibleStays; shift++ {
+		for _, d := range []int{-shift, shift} {
+			s, e := start.AddDate(0, 0, d), end.AddDate(0, 0, d)
+			if s.Before(earliest) {
+				continue
+			}
+			if rooms := g.FreeRooms(s, e); len(rooms) > 0 {
+				stays = append(stays, FlexibleStay{StartDate: s, EndDate: e, Rooms: rooms})
+			}
+		}
+	}
+	if len(stays) > maxFlexibleStays {
+		stays = stays[:maxFlexibleStays]
+	}
+	return stays
+}
+
+// SplitStay returns the fewest rooms to stay in one after the other from start until
+// end, taking at each move the room free for the most nights. It returns nil if the
+// stay can't be split across at most maxSplitParts rooms.
+func (g AvailabilityGrid) SplitStay(start, end time.Time) []StayOption {
+	rooms := make([]Room, len(g.rooms))
+	copy(rooms, g.rooms)
+	// cheaper rooms first among those free as long
+	sort.SliceStable(rooms, func(i, j int) bool { return rooms[i].Price < rooms[j].Price })
+
+	var parts []StayOption
+	for day := start; day.Before(end); {
+		left := int(end.Sub(day).Hours() / 24)
+		var best StayOption
+		for _, r := range rooms {
+			n := g.run(r.ID, day, left)
+			if n >= max(r.MinNights, 1) && n > best.Nights() {
+				best = StayOption{Room: r, StartDate: day, EndDate: day.AddDate(0, 0, n)}
+			}
+		}
+		if best.Nights() == 0 || len(parts) == maxSplitParts {
+			return nil
+		}
+		parts = append(parts, best)
+		day = best.EndDate
+	}
+	if len(parts) < 2 {
+		return nil
+	}
+	return parts
+}
@@ -14,4 +14,9 @@ type TemplateData struct {
 	Error           string
 	Form            *forms.Form
 	IsAuthenticated int
+	// Property is the property the page is for, BasePath prefixes the links to its
+	// public pages, and Properties are those the staff member can switch to
+	Property   Property
+	BasePath   string
+	Properties []Property
 }
@@ -0,0 +1,128 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// FakeCheckoutPath is where the fake provider's checkout pages are served, see Fake
+const FakeCheckoutPath = "/payments/fake/"
+
+// ErrUnknownCheckout is returned by the fake provider for a checkout it didn't start
+var ErrUnknownCheckout = errors.New("unknown checkout")
+
+// Fake is an in-memory provider for development and tests. Its checkout pages
+// are served by the application itself under FakeCheckoutPath, and paying there
+// produces the same signed webhook call a real provider would send.
+type Fake struct {
+	secret string
+
+	mu        sync.Mutex
+	next      int
+	checkouts map[string]CheckoutRequest
+	// Now is the clock used to sign events, time.Now if nil
+	Now func() time.Time
+}
+
+// NewFake returns a fake provider signing its webhook calls with secret
+func NewFake(secret string) *Fake {
+	return &Fake{
+		secret:    secret,
+		checkouts: make(map[string]CheckoutRequest),
+	}
+}
+
+// Name identifies the fake provider
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+// CreateCheckout remembers the checkout and returns its page
+func (f *Fake) CreateCheckout(ctx context.Context, req CheckoutRequest) (Checkout, error) {
+	if req.Amount <= 0 {
+		return Checkout{}, fmt.Errorf("invalid checkout amount %d", req.Amount)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.next++
+	id := fmt.Sprintf("fake_cs_%d", f.next)
+	f.checkouts[id] = req
+	return Checkout{ID: id, URL: FakeCheckoutPath + id}, nil
+}
+
+// Checkout returns a checkout started with CreateCheckout
+func (f *Fake) Checkout(id string) (CheckoutRequest, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	req, ok := f.checkouts[id]
+	return req, ok
+}
+
+// Complete pays a checkout and returns the webhook call telling the application,
+// as its payload and headers
+func (f *Fake) Complete(id string) ([]byte, http.Header, error) {
+	return f.event(EventCheckoutCompleted, id)
+}
+
+// Expire abandons a checkout and returns the webhook call telling the application
+func (f *Fake) Expire(id string) ([]byte, http.Header, error) {
+	return f.event(EventCheckoutExpired, id)
+}
+
+// event removes a checkout and returns the signed webhook call for it
+func (f *Fake) event(eventType, id string) ([]byte, http.Header, error) {
+	f.mu.Lock()
+	req, ok := f.checkouts[id]
+	delete(f.checkouts, id)
+	f.mu.Unlock()
+
+	if !ok {
+		return nil, nil, ErrUnknownCheckout
+	}
+
+	payload, err := json.Marshal(Event{
+		Type:          eventType,
+		CheckoutID:    id,
+		ReservationID: req.ReservationID,
+		Amount:        req.Amount,
+		Currency:      req.Currency,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := make(http.Header)
+	header.Set(SignatureHeader, Sign(f.secret, payload, f.now()))
+	return payload, header, nil
+}
+
+// ParseWebhook checks the signature of a webhook call and decodes its event
+func (f *Fake) ParseWebhook(payload []byte, header http.Header) (Event, error) {
+	var event Event
+
+	err := Verify(f.secret, payload, header.Get(SignatureHeader), f.now())
+	if err != nil {
+		return event, err
+	}
+
+	err = json.Unmarshal(payload, &event)
+	if err != nil {
+		return event, fmt.Errorf("cannot decode webhook event: %w", err)
+	}
+	return event, nil
+}
+
+func (f *Fake) now() time.Time {
+	if f.Now != nil {
+		return f.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,78 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var verifyTests = []struct {
+	name     string
+	secret   string
+	payload  string
+	header   string
+	expected error
+}{
+	{"valid", "s3cret", `{"type":"checkout.completed"}`, Sign("s3cret", []byte(`{"type":"checkout.completed"}`), time.Unix(1000, 0)), nil},
+	{"wrong-secret", "guess", `{"type":"checkout.completed"}`, Sign("s3cret", []byte(`{"type":"checkout.completed"}`), time.Unix(1000, 0)), ErrInvalidSignature},
+	{"tampered", "s3cret", `{"type":"checkout.expired"}`, Sign("s3cret", []byte(`{"type":"checkout.completed"}`), time.Unix(1000, 0)), ErrInvalidSignature},
+	{"too-old", "s3cret", `{}`, Sign("s3cret", []byte(`{}`), time.Unix(1000, 0).Add(-SignatureTolerance-time.Second)), ErrExpiredSignature},
+	{"missing", "s3cret", `{}`, "", ErrInvalidSignature},
+	{"garbage", "s3cret", `{}`, "t=now,v1=abc", ErrInvalidSignature},
+}
+
+func TestVerify(t *testing.T) {
+	for _, e := range verifyTests {
+		err := Verify(e.secret, []byte(e.payload), e.header, time.Unix(1000, 0))
+		if !errors.Is(err, e.expected) {
+			t.Errorf("%s: got error %v, wanted %v", e.name, err, e.expected)
+		}
+	}
+}
+
+func TestFake(t *testing.T) {
+	f := NewFake("s3cret")
+
+	_, err := f.CreateCheckout(context.Background(), CheckoutRequest{ReservationID: 1})
+	if err == nil {
+		t.Error("expected an error for a checkout without amount")
+	}
+
+	c, err := f.CreateCheckout(context.Background(), CheckoutRequest{ReservationID: 7, Amount: 20000, Currency: "usd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.URL != FakeCheckoutPath+c.ID {
+		t.Errorf("wrong checkout page %q", c.URL)
+	}
+	if req, ok := f.Checkout(c.ID); !ok || req.Amount != 20000 {
+		t.Errorf("checkout not remembered: %v %v", req, ok)
+	}
+
+	payload, header, err := f.Complete(c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := f.ParseWebhook(payload, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Event{Type: EventCheckoutCompleted, CheckoutID: c.ID, ReservationID: 7, Amount: 20000, Currency: "usd"}
+	if event != expected {
+		t.Errorf("got event %+v, wanted %+v", event, expected)
+	}
+
+	if _, _, err := f.Complete(c.ID); !errors.Is(err, ErrUnknownCheckout) {
+		t.Errorf("a checkout can only be paid once, got %v", err)
+	}
+
+	// a call signed by someone else is refused
+	forged := make(http.Header)
+	forged.Set(SignatureHeader, Sign("guess", payload, time.Now()))
+	if _, err := f.ParseWebhook(payload, forged); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected a forged call to be refused, got %v", err)
+	}
+}
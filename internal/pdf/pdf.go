@@ -0,0 +1,188 @@
+// Package pdf writes simple PDF documents: text in the standard fonts and lines on
+// A4 pages. The standard fonts need no embedding, so the output stays small.
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A4 page size, in points
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+// Font is one of the standard PDF fonts
+type Font int
+
+// Fonts available to Text
+const (
+	Helvetica Font = iota
+	HelveticaBold
+	Courier
+)
+
+var fontNames = []string{"Helvetica", "Helvetica-Bold", "Courier"}
+
+// Document is a PDF document being built
+type Document struct {
+	Title string
+	pages []*Page
+}
+
+// New returns an empty document
+func New() *Document {
+	return &Document{}
+}
+
+// AddPage adds an A4 page at the end of the document
+func (d *Document) AddPage() *Page {
+	p := &Page{}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Page is a page of a document. Positions are in points from the top left corner.
+type Page struct {
+	content bytes.Buffer
+}
+
+// Text writes s with its baseline at x, y
+func (p *Page) Text(x, y float64, font Font, size float64, s string) {
+	fmt.Fprintf(&p.content, "BT /F%d %.2f Tf %.2f %.2f Td (%s) Tj ET\n", font+1, size, x, PageHeight-y, encode(s))
+}
+
+// TextRight writes s so it ends at x
+func (p *Page) TextRight(x, y float64, font Font, size float64, s string) {
+	p.Text(x-Width(font, size, s), y, font, size, s)
+}
+
+// Line draws a thin line from x1, y1 to x2, y2
+func (p *Page) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&p.content, "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, PageHeight-y1, x2, PageHeight-y2)
+}
+
+// Width returns the width of s in points. It is exact for Courier, and an
+// estimate for Helvetica, good enough to align figures.
+func Width(font Font, size float64, s string) float64 {
+	var units float64
+	for _, r := range s {
+		units += glyphWidth(font, r)
+	}
+	return units * size / 1000
+}
+
+// glyphWidth returns the width of r in thousandths of the font size
+func glyphWidth(font Font, r rune) float64 {
+	if font == Courier {
+		return 600
+	}
+	switch {
+	case r >= '0' && r <= '9':
+		return 556
+	case r == ' ' || r == '.' || r == ',' || r == ':':
+		return 278
+	case r == '-':
+		return 333
+	case r >= 'A' && r <= 'Z':
+		return 667
+	case r == 'i' || r == 'j' || r == 'l':
+		return 222
+	case r == 'm' || r == 'w':
+		return 833
+	}
+	return 556
+}
+
+// encode converts s to the WinAnsi encoding of the standard fonts, and escapes it
+// for a PDF string. Characters the encoding lacks are replaced by question marks.
+func encode(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '€':
+			b.WriteString(`\200`)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, `\%03o`, r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// Write writes the document to w
+func (d *Document) Write(w io.Writer) error {
+	out := &counter{w: bufio.NewWriter(w)}
+	var offsets []int
+
+	// objects are numbered from 1: the catalog, the page tree, the fonts, the
+	// document information, then a page and its content for every page
+	obj := func(body string) {
+		offsets = append(offsets, out.n)
+		fmt.Fprintf(out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	fonts := len(fontNames)
+	firstPage := 4 + fonts
+
+	fmt.Fprint(out, "%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+
+	var fontRefs []string
+	for i, name := range fontNames {
+		obj(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", name))
+		fontRefs = append(fontRefs, fmt.Sprintf("/F%d %d 0 R", i+1, 3+i))
+	}
+	obj(fmt.Sprintf("<< /Title (%s) /Producer (bookings) >>", encode(d.Title)))
+
+	for i, p := range d.pages {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << %s >> >> /Contents %d 0 R >>",
+			PageWidth, PageHeight, strings.Join(fontRefs, " "), firstPage+2*i+1))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.content.Len(), p.content.String()))
+	}
+
+	xref := out.n
+	fmt.Fprintf(out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(out, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, 3+fonts, xref)
+
+	if out.err != nil {
+		return out.err
+	}
+	return out.w.Flush()
+}
+
+// counter counts the bytes written, for the cross-reference table, and keeps the first error
+type counter struct {
+	w   *bufio.Writer
+	n   int
+	err error
+}
+
+func (c *counter) Write(b []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(b)
+	c.n += n
+	c.err = err
+	return n, err
+}
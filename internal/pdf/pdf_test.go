@@ -0,0 +1,75 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var encodeTests = []struct {
+	input    string
+	expected string
+}{
+	{"Total", "Total"},
+	{`a (b) \c`, `a \(b\) \\c`},
+	{"Café", `Caf\351`},
+	{"10 €", `10 \200`},
+	{"日本", "??"},
+}
+
+func TestEncode(t *testing.T) {
+	for _, e := range encodeTests {
+		if got := encode(e.input); got != e.expected {
+			t.Errorf("%q: expected %q, got %q", e.input, e.expected, got)
+		}
+	}
+}
+
+func TestWidth(t *testing.T) {
+	if got := Width(Courier, 10, "12.50"); got != 30 {
+		t.Errorf("expected courier text 30 points wide, got %v", got)
+	}
+	if got := Width(Helvetica, 10, "100"); got != 16.68 {
+		t.Errorf("expected helvetica figures 16.68 points wide, got %v", got)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	doc := New()
+	doc.Title = "Invoice (test)"
+	doc.AddPage().Text(50, 60, HelveticaBold, 12, "Hello")
+	p := doc.AddPage()
+	p.TextRight(545, 60, Courier, 10, "12.50")
+	p.Line(50, 70, 545, 70)
+
+	var buf bytes.Buffer
+	if err := doc.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{"%PDF-1.4", "/Count 2", "(Hello) Tj", "/BaseFont /Courier", "/Title (Invoice \\(test\\))", "%%EOF"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in the document", want)
+		}
+	}
+
+	// every entry of the cross-reference table points at its object
+	start, err := strconv.Atoi(regexp.MustCompile(`startxref\n(\d+)`).FindStringSubmatch(out)[1])
+	if err != nil || !strings.HasPrefix(out[start:], "xref") {
+		t.Fatalf("startxref doesn't point at the xref table")
+	}
+	offsets := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllStringSubmatch(out, -1)
+	if len(offsets) != 10 {
+		t.Fatalf("expected 10 objects, got %d", len(offsets))
+	}
+	for i, o := range offsets {
+		off, _ := strconv.Atoi(o[1])
+		if want := fmt.Sprintf("%d 0 obj", i+1); !strings.HasPrefix(out[off:], want) {
+			t.Errorf("object %d is not at offset %d", i+1, off)
+		}
+	}
+}
@@ -9,20 +9,36 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/alexedwards/scs/v2"
+	"github.com/florian-lahitte-uvi/bookings/helpers"
 	"github.com/florian-lahitte-uvi/bookings/internal/config"
 	"github.com/florian-lahitte-uvi/bookings/internal/models"
 	"github.com/justinas/nosurf"
 )
 
-var functions = template.FuncMap{
-	"humanDate":  HumanDate,
-	"formatDate": FormatDate,
-	"iterate":    Iterate,
-	"add":        Add,
+var pathToTemplates = "./templates"
+
+// Renderer renders the pages of the site with the config and session of an application
+type Renderer struct {
+	App     *config.AppConfig
+	Session *scs.SessionManager
 }
 
-var app *config.AppConfig
-var pathToTemplates = "./templates"
+// New creates a renderer for the config and session
+func New(a *config.AppConfig, session *scs.SessionManager) *Renderer {
+	return &Renderer{App: a, Session: session}
+}
+
+// Funcs returns the functions available to the templates
+func (rd *Renderer) Funcs() template.FuncMap {
+	return template.FuncMap{
+		"humanDate":  HumanDate,
+		"formatDate": FormatDate,
+		"iterate":    Iterate,
+		"add":        Add,
+		"money":      rd.Money,
+	}
+}
 
 func Add(a, b int) int {
 	return a + b
@@ -39,11 +55,6 @@ func Iterate(count int) []int {
 	return items
 }
 
-// NewRenderer sets the config for the template package
-func NewRenderer(a *config.AppConfig) {
-	app = a
-}
-
 // Return time in "YYYY-MM-DD" format
 func HumanDate(t time.Time) string {
 	return t.Format("2006-01-02")
@@ -54,27 +65,37 @@ func FormatDate(t time.Time, f string) string {
 	return t.Format(f)
 }
 
+// Money formats an amount in cents in the application currency
+func (rd *Renderer) Money(cents int) string {
+	return models.FormatMoney(cents, rd.App.Currency)
+}
+
 // AddDefaultData adds data for all templates
-func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateData {
-	td.Flash = app.Session.PopString(r.Context(), "flash")
-	td.Warning = app.Session.PopString(r.Context(), "warning")
-	td.Error = app.Session.PopString(r.Context(), "error")
+func (rd *Renderer) AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateData {
+	td.Flash = rd.Session.PopString(r.Context(), "flash")
+	td.Warning = rd.Session.PopString(r.Context(), "warning")
+	td.Error = rd.Session.PopString(r.Context(), "error")
 	td.CSRFToken = nosurf.Token(r)
-	if app.Session.Exists(r.Context(), "user_id") {
+	if rd.Session.Exists(r.Context(), "user_id") {
 		td.IsAuthenticated = 1
 	}
+	if pc, ok := helpers.PropertyFromContext(r.Context()); ok {
+		td.Property = pc.Property
+		td.BasePath = pc.BasePath
+		td.Properties = pc.Choices
+	}
 	return td
 }
 
 // Template renders a template
-func Template(w http.ResponseWriter, r *http.Request, tmpl string, td *models.TemplateData) error {
+func (rd *Renderer) Template(w http.ResponseWriter, r *http.Request, tmpl string, td *models.TemplateData) error {
 	var tc map[string]*template.Template
 
-	if app.UseCache {
+	if rd.App.UseCache {
 		// get the template cache from the app config
-		tc = app.TemplateCache
+		tc = rd.App.TemplateCache
 	} else {
-		tc, _ = CreateTemplateCache()
+		tc, _ = rd.CreateTemplateCache()
 	}
 
 	t, ok := tc[tmpl]
@@ -85,13 +106,13 @@ func Template(w http.ResponseWriter, r *http.Request, tmpl string, td *models.Te
 
 	buf := new(bytes.Buffer)
 
-	td = AddDefaultData(td, r)
+	td = rd.AddDefaultData(td, r)
 
 	_ = t.Execute(buf, td)
 
 	_, err := buf.WriteTo(w)
 	if err != nil {
-		fmt.Println("error writing template to browser", err)
+		rd.App.Logger.ErrorContext(r.Context(), "error writing template to browser", "error", err)
 		return err
 	}
 
@@ -100,7 +121,7 @@ func Template(w http.ResponseWriter, r *http.Request, tmpl string, td *models.Te
 }
 
 // CreateTemplateCache creates a template cache as a map
-func CreateTemplateCache() (map[string]*template.Template, error) {
+func (rd *Renderer) CreateTemplateCache() (map[string]*template.Template, error) {
 
 	myCache := map[string]*template.Template{}
 
@@ -111,7 +132,7 @@ func CreateTemplateCache() (map[string]*template.Template, error) {
 
 	for _, page := range pages {
 		name := filepath.Base(page)
-		ts, err := template.New(name).Funcs(functions).ParseFiles(page)
+		ts, err := template.New(name).Funcs(rd.Funcs()).ParseFiles(page)
 		if err != nil {
 			return myCache, err
 		}
@@ -17,7 +17,7 @@ func TestAddDefaultData(t *testing.T) {
 
 	session.Put(r.Context(), "flash", "123")
 
-	result := AddDefaultData(&td, r)
+	result := rd.AddDefaultData(&td, r)
 	if result.Flash != "123" {
 		t.Error("flash value of 123 not found in session")
 	}
@@ -26,12 +26,12 @@ func TestAddDefaultData(t *testing.T) {
 
 func TestRenderTemplate(t *testing.T) {
 	pathToTemplates = "./../../templates"
-	tc, err := CreateTemplateCache()
+	tc, err := rd.CreateTemplateCache()
 	if err != nil {
 		t.Error(err)
 	}
 
-	app.TemplateCache = tc
+	testApp.TemplateCache = tc
 
 	r, err := getSession()
 	if err != nil {
@@ -40,12 +40,12 @@ func TestRenderTemplate(t *testing.T) {
 
 	var ww myWriter
 
-	err = Template(&ww, r, "home.page.tmpl", &models.TemplateData{})
+	err = rd.Template(&ww, r, "home.page.tmpl", &models.TemplateData{})
 	if err != nil {
 		t.Error("error writing template to browser", err)
 	}
 
-	err = Template(&ww, r, "non-existent.page.tmpl", &models.TemplateData{})
+	err = rd.Template(&ww, r, "non-existent.page.tmpl", &models.TemplateData{})
 	if err == nil {
 		t.Error("rendered template that does not exist")
 	}
@@ -64,14 +64,16 @@ func getSession() (*http.Request, error) {
 	return r, nil
 }
 
-func TestNewTemplates(t *testing.T) {
-	NewRenderer(app)
+func TestNew(t *testing.T) {
+	if got := New(&testApp, session); got.App != &testApp || got.Session != session {
+		t.Error("renderer not set up with the config and session")
+	}
 }
 
 func TestCreateTemplateCache(t *testing.T) {
 	pathToTemplates = "./../../templates"
 
-	_, err := CreateTemplateCache()
+	_, err := rd.CreateTemplateCache()
 	if err != nil {
 		t.Error(err)
 	}
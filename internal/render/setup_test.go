@@ -2,7 +2,6 @@ package render
 
 import (
 	"encoding/gob"
-	"log"
 	"net/http"
 	"os"
 	"testing"
@@ -10,11 +9,13 @@ import (
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/florian-lahitte-uvi/bookings/internal/config"
+	"github.com/florian-lahitte-uvi/bookings/internal/logger"
 	"github.com/florian-lahitte-uvi/bookings/internal/models"
 )
 
 var session *scs.SessionManager
 var testApp config.AppConfig
+var rd *Renderer
 
 func TestMain(m *testing.M) {
 
@@ -23,11 +24,7 @@ func TestMain(m *testing.M) {
 	// change this to true when in production
 	testApp.InProduction = false
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	testApp.InfoLog = infoLog
-
-	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	testApp.ErrorLog = errorLog
+	testApp.Logger = logger.New(os.Stdout, "text", "info")
 	// set up the session
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
@@ -35,9 +32,7 @@ func TestMain(m *testing.M) {
 	session.Cookie.SameSite = http.SameSiteLaxMode
 	session.Cookie.Secure = false
 
-	testApp.Session = session
-
-	app = &testApp
+	rd = New(&testApp, session)
 
 	os.Exit(m.Run())
 }
@@ -0,0 +1,58 @@
+package dbrepo
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+)
+
+// sortColumn maps a sort key to its SQL expression, the type its cursor value
+// is cast to, and how to read that value from a reservation
+type sortColumn struct {
+	expr  string
+	cast  string
+	value func(r models.Reservation) string
+}
+
+var reservationSortColumns = map[string]sortColumn{
+	"id":         {"r.id", "integer", func(r models.Reservation) string { return strconv.Itoa(r.ID) }},
+	"last_name":  {"r.last_name", "text", func(r models.Reservation) string { return r.LastName }},
+	"first_name": {"r.first_name", "text", func(r models.Reservation) string { return r.FirstName }},
+	"email":      {"r.email", "text", func(r models.Reservation) string { return r.Email }},
+	"phone":      {"r.phone", "text", func(r models.Reservation) string { return r.Phone }},
+	"room":       {"coalesce(rm.room_name, '')", "text", func(r models.Reservation) string { return r.Room.RoomName }},
+	"start_date": {"r.start_date", "date", func(r models.Reservation) string { return r.StartDate.Format("2006-01-02") }},
+	"end_date":   {"r.end_date", "date", func(r models.Reservation) string { return r.EndDate.Format("2006-01-02") }},
+	"created_at": {"r.created_at", "timestamp", func(r models.Reservation) string { return r.CreatedAt.Format(time.RFC3339Nano) }},
+}
+
+var errInvalidCursor = errors.New("invalid page cursor")
+
+// encodeCursor packs the sort value and id of a row into an opaque string
+func encodeCursor(value string, id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(id) + ":" + value))
+}
+
+// decodeCursor unpacks a cursor made by encodeCursor
+func decodeCursor(cursor string) (string, int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", 0, errInvalidCursor
+	}
+
+	idPart, value, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return "", 0, errInvalidCursor
+	}
+
+	id, err := strconv.Atoi(idPart)
+	if err != nil {
+		return "", 0, errInvalidCursor
+	}
+
+	return value, id, nil
+}
@@ -2,18 +2,23 @@ package dbrepo
 
 import (
 	"database/sql"
+	"log/slog"
 
 	"github.com/florian-lahitte-uvi/bookings/internal/config"
 	"github.com/florian-lahitte-uvi/bookings/internal/repository"
 )
 
+// The repositories see every property until ForProperty scopes them to one,
+// propertyID is then the id of that property
 type postgresDBRepo struct {
-	App *config.AppConfig
-	DB  *sql.DB
+	App        *config.AppConfig
+	DB         *loggedDB
+	propertyID int
 }
 
 type testDBRepo struct {
-	App *config.AppConfig
+	App        *config.AppConfig
+	propertyID int
 }
 
 func NewTestingRepo(a *config.AppConfig) repository.DatabaseRepo {
@@ -22,9 +27,15 @@ func NewTestingRepo(a *config.AppConfig) repository.DatabaseRepo {
 	}
 }
 
+// NewPostgresRepo returns a repository on conn, logging its failed and slow
+// queries to the logger of a
 func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
+	logger := a.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
 	return &postgresDBRepo{
 		App: a,
-		DB:  conn,
+		DB:  &loggedDB{DB: conn, log: queryLogger{logger: logger}},
 	}
 }
@@ -2,99 +2,426 @@ package dbrepo
 
 import (
 	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/florian-lahitte-uvi/bookings/internal/repository"
+	"github.com/jackc/pgconn"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func (m *postgresDBRepo) AllUsers() bool {
-	return true
+// pingTimeout bounds the readiness checks, which must answer quickly
+const pingTimeout = 1 * time.Second
+
+// defaultQueryTimeout bounds the queries of a call when the config sets no timeout
+const defaultQueryTimeout = 3 * time.Second
+
+// queryContext bounds the queries of a call by the query timeout of the config, and
+// by the deadline of ctx, which is cancelled with the request it comes from
+func (m *postgresDBRepo) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := m.App.QueryTimeout
+	if timeout <= 0 {
+		timeout = defaultQueryTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Ping checks that the database answers
+func (m *postgresDBRepo) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	return m.DB.PingContext(ctx)
+}
+
+// SchemaVersion returns the latest migration applied by soda
+func (m *postgresDBRepo) SchemaVersion(ctx context.Context) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	var version string
+
+	query := `select version from schema_migration order by version desc limit 1`
+	err := m.DB.QueryRowContext(ctx, query).Scan(&version)
+	if err != nil {
+		return "", err
+	}
+	return version, nil
+}
+
+// ForProperty returns a copy of the repository whose queries only see the rooms,
+// reservations, guests, promo codes and cancellation policies of one property.
+// A property id of 0 sees all of them.
+func (m *postgresDBRepo) ForProperty(propertyID int) repository.DatabaseRepo {
+	scoped := *m
+	scoped.propertyID = propertyID
+	return &scoped
+}
+
+// propertyScope returns the condition keeping the rows whose property column col
+// is the property of the repository
+func (m *postgresDBRepo) propertyScope(col string) string {
+	if m.propertyID == 0 {
+		return "true"
+	}
+	return fmt.Sprintf("%s = %d", col, m.propertyID)
+}
+
+// roomScope returns the condition keeping the rows whose room column col is a room
+// of the property of the repository
+func (m *postgresDBRepo) roomScope(col string) string {
+	if m.propertyID == 0 {
+		return "true"
+	}
+	return fmt.Sprintf("%s in (select id from rooms where property_id = %d)", col, m.propertyID)
+}
+
+// reservationScope returns the condition keeping the rows whose reservation column
+// col is a reservation of the property of the repository
+func (m *postgresDBRepo) reservationScope(col string) string {
+	if m.propertyID == 0 {
+		return "true"
+	}
+	return fmt.Sprintf("%s in (select id from reservations where %s)", col, m.roomScope("room_id"))
+}
+
+// insertPropertyID returns the property of the rooms, promo codes and cancellation
+// policies added, those added unscoped go to the default property
+func (m *postgresDBRepo) insertPropertyID() int {
+	if m.propertyID == 0 {
+		return models.DefaultPropertyID
+	}
+	return m.propertyID
+}
+
+// queryRower runs single row queries, in or out of a transaction
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// ownReservation returns sql.ErrNoRows if the reservation isn't one of the property
+// of the repository
+func (m *postgresDBRepo) ownReservation(ctx context.Context, q queryRower, reservationID int) error {
+	if m.propertyID == 0 {
+		return nil
+	}
+	var id int
+	return q.QueryRowContext(ctx, `select id from reservations where id = $1 and `+m.roomScope("room_id"), reservationID).Scan(&id)
+}
+
+// ownRoom returns sql.ErrNoRows if the room isn't one of the property of the
+// repository, so nothing is written for the rooms of another property
+func (m *postgresDBRepo) ownRoom(ctx context.Context, q queryRower, roomID int) error {
+	if m.propertyID == 0 {
+		return nil
+	}
+	var id int
+	return q.QueryRowContext(ctx, `select id from rooms where id = $1 and `+m.propertyScope("property_id"), roomID).Scan(&id)
+}
+
+// activeRestriction filters out the holds of room_restrictions rr that have expired
+const activeRestriction = `(rr.expires_at is null or rr.expires_at > now())`
+
+// freeUnitQuery selects a unit of the room $1 with no restriction overlapping $2 to
+// $3, leaving out those of the reservation $4 and expired holds, and preferring the
+// unit $5
+const freeUnitQuery = `select u.id from room_units u
+	where u.room_id = $1 and not exists (select 1 from room_restrictions rr
+		where rr.unit_id = u.id and $2 < rr.end_date and $3 > rr.start_date
+		and (rr.reservation_id is null or rr.reservation_id <> $4) and ` + activeRestriction + `)
+	order by u.id = $5 desc, u.id
+	limit 1`
+
+// lockUnits locks the units of a room until tx ends, so that concurrent bookings of
+// the room take turns picking a free unit
+func lockUnits(ctx context.Context, tx *loggedTx, roomID int) error {
+	_, err := tx.ExecContext(ctx, `select id from room_units where room_id = $1 for update`, roomID)
+	return err
 }
 
-// InsertReservation inserts a reservation into the database
-func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
+// freeUnit returns a unit of the room free from start to end, ignoring the restriction
+// of the reservation reservationID and preferring the unit preferred. It returns
+// ErrRestrictionConflict if every unit is taken.
+func freeUnit(ctx context.Context, q queryRower, roomID int, start, end time.Time, reservationID, preferred int) (int, error) {
+	var id int
+	err := q.QueryRowContext(ctx, freeUnitQuery, roomID, start, end, reservationID, preferred).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrRestrictionConflict
+	}
+	return id, err
+}
+
+// assignUnit returns the unit given to a stay in the room: a free one, or with override
+// the first unit of the room, as staff can book a room that isn't available. Without
+// override it returns ErrRestrictionConflict if every unit is taken.
+func assignUnit(ctx context.Context, q queryRower, roomID int, start, end time.Time, override bool) (int, error) {
+	id, err := freeUnit(ctx, q, roomID, start, end, 0, 0)
+	if !override || !errors.Is(err, ErrRestrictionConflict) {
+		return id, err
+	}
+	err = q.QueryRowContext(ctx, `select id from room_units where room_id = $1 order by id limit 1`, roomID).Scan(&id)
+	return id, err
+}
+
+// InsertReservation inserts a reservation made by a guest and the restriction booking
+// a free unit of its room in one transaction. The hold holdID the guest had on the
+// room, if any, is released in the same transaction and its unit preferred. It
+// returns ErrRestrictionConflict if every unit is taken, and a reservation made with
+// a promo code fails with models.ErrPromoUsedUp or models.ErrPromoAlreadyUsed if the
+// code can't be redeemed anymore.
+func (m *postgresDBRepo) InsertReservation(ctx context.Context, res models.Reservation, holdID int) (int, error) {
 	// Give a context with a timeout
 	// This is a good practice to avoid long-running queries
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := m.ownRoom(ctx, tx, res.RoomID); err != nil {
+		return 0, err
+	}
+
+	if err := lockUnits(ctx, tx, res.RoomID); err != nil {
+		return 0, err
+	}
+
+	var held int
+	if holdID > 0 {
+		err = tx.QueryRowContext(ctx, `delete from room_restrictions where id = $1 and restriction_id = $2 and room_id = $3 returning unit_id`,
+			holdID, models.RestrictionHold, res.RoomID).Scan(&held)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return 0, err
+		}
+	}
+
+	unitID, err := freeUnit(ctx, tx, res.RoomID, res.StartDate, res.EndDate, 0, held)
+	if err != nil {
+		return 0, err
+	}
+
+	if res.PromoCodeID != 0 {
+		if err := redeemPromoCode(ctx, tx, res); err != nil {
+			return 0, err
+		}
+	}
+
 	var newId int
 
-	// Prepare the SQL statement to insert a reservation
-	stmt := `insert into reservations (first_name, last_name, email, phone, start_date, end_date, room_id, created_at, updated_at)
-	values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+	err = tx.QueryRowContext(ctx, insertReservationStmt, reservationArgs(res)...).Scan(&newId)
+	if err != nil {
+		return 0, err
+	}
 
-	err := m.DB.QueryRowContext(ctx, stmt, res.FirstName, res.LastName, res.Email, res.Phone, res.StartDate, res.EndDate, res.RoomID, time.Now(), time.Now()).Scan(&newId)
+	_, err = tx.ExecContext(ctx, insertRestrictionStmt, res.StartDate, res.EndDate, res.RoomID, unitID, newId, 1, time.Now(), time.Now())
 	if err != nil {
 		return 0, err
 	}
-	return newId, nil
+	return newId, tx.Commit()
 }
 
-// InsertRoomRestriction inserts a room restriction into the database
-func (m *postgresDBRepo) InsertRoomRestriction(restriction models.RoomRestriction) error {
-	// Give a context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	// Prepare the SQL statement to insert a room restriction
-	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id, restriction_id, created_at, updated_at)
-	values ($1, $2, $3, $4, $5, $6, $7)`
+// redeemPromoCode locks the promo code of a reservation about to be inserted in tx,
+// so concurrent bookings take turns, and checks it can still be used by the guest.
+// Reservations released unpaid don't count.
+func redeemPromoCode(ctx context.Context, tx *loggedTx, res models.Reservation) error {
+	var maxRedemptions int
+	err := tx.QueryRowContext(ctx, `select max_redemptions from promo_codes where id = $1 for update`, res.PromoCodeID).Scan(&maxRedemptions)
+	if err != nil {
+		return err
+	}
 
-	_, err := m.DB.ExecContext(ctx, stmt, restriction.StartDate, restriction.EndDate, restriction.RoomID, restriction.ReservationID, restriction.RestrictionID, time.Now(), time.Now())
+	var redemptions, byGuest int
+	query := `select count(*), count(*) filter (where lower(email) = lower($2))
+	from reservations where promo_code_id = $1 and payment_status <> 'released'`
+	err = tx.QueryRowContext(ctx, query, res.PromoCodeID, res.Email).Scan(&redemptions, &byGuest)
 	if err != nil {
 		return err
 	}
+
+	if maxRedemptions > 0 && redemptions >= maxRedemptions {
+		return models.ErrPromoUsedUp
+	}
+	if byGuest > 0 {
+		return models.ErrPromoAlreadyUsed
+	}
 	return nil
 }
 
-// return true if there are no room restrictions for the given dates
-// return false if there are room restrictions for the given dates
-func (m *postgresDBRepo) SearchAvaibilityByDatesByRoomID(RoomID int, start, end time.Time) (bool, error) {
+// upsertGuest returns a common table expression named guest, which adds the guest
+// with the email $3, or refreshes their details, and returns their id. The first
+// name, last name and phone are $1, $2 and $4, now is the parameter of the current
+// time and property the expression of the guest's property, each property has its
+// own guests. A reservation without email has no guest.
+func upsertGuest(now, property string) string {
+	return `with guest as (
+		insert into guests (email, first_name, last_name, phone, created_at, updated_at, property_id)
+		select $3::varchar, $1::varchar, $2::varchar, $4::varchar, ` + now + `::timestamp, ` + now + `::timestamp, ` + property + `
+		where $3::varchar <> ''
+		on conflict (property_id, (lower(email))) do update set
+			first_name = excluded.first_name,
+			last_name = excluded.last_name,
+			phone = case when excluded.phone <> '' then excluded.phone else guests.phone end,
+			updated_at = excluded.updated_at
+		returning id
+	)
+	`
+}
+
+// insertReservationStmt inserts a reservation with the arguments of reservationArgs,
+// linked to the guest with the same email and to the cancellation policy of its room
+var insertReservationStmt = upsertGuest("$12", "(select property_id from rooms where id = $7)") + `insert into reservations (first_name, last_name, email, phone, start_date, end_date, room_id, processed, source, override_reason, created_by, created_at, updated_at,
+		amount_due, payment_status, hold_until, promo_code_id, discount, guest_id, cancellation_policy_id)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, (select id from guest),
+		(select cancellation_policy_id from rooms where id = $7)) returning id`
+
+// reservationArgs returns the values inserted by insertReservationStmt
+func reservationArgs(res models.Reservation) []interface{} {
+	source := res.Source
+	if source == "" {
+		source = models.SourceWeb
+	}
+
+	var createdBy sql.NullInt64
+	if res.CreatedBy > 0 {
+		createdBy = sql.NullInt64{Int64: int64(res.CreatedBy), Valid: true}
+	}
+
+	paymentStatus := res.PaymentStatus
+	if paymentStatus == "" {
+		paymentStatus = models.PaymentNone
+	}
+
+	var holdUntil sql.NullTime
+	if !res.HoldUntil.IsZero() {
+		holdUntil = sql.NullTime{Time: res.HoldUntil, Valid: true}
+	}
+
+	var promoCodeID sql.NullInt64
+	if res.PromoCodeID > 0 {
+		promoCodeID = sql.NullInt64{Int64: int64(res.PromoCodeID), Valid: true}
+	}
+
+	now := time.Now()
+	return []interface{}{res.FirstName, res.LastName, res.Email, res.Phone, res.StartDate, res.EndDate, res.RoomID,
+		res.Processed, source, res.OverrideReason, createdBy, now, now, res.AmountDue, paymentStatus, holdUntil,
+		promoCodeID, res.Discount}
+}
+
+// CreateReservation inserts a reservation and the restriction booking its room in one
+// transaction. It returns ErrRestrictionConflict if every unit of the room is taken,
+// unless staff override the availability.
+func (m *postgresDBRepo) CreateReservation(ctx context.Context, res models.Reservation, override bool) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := m.ownRoom(ctx, tx, res.RoomID); err != nil {
+		return 0, err
+	}
+
+	if err := lockUnits(ctx, tx, res.RoomID); err != nil {
+		return 0, err
+	}
+	unitID, err := assignUnit(ctx, tx, res.RoomID, res.StartDate, res.EndDate, override)
+	if err != nil {
+		return 0, err
+	}
+
+	var newId int
+	err = tx.QueryRowContext(ctx, insertReservationStmt, reservationArgs(res)...).Scan(&newId)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, insertRestrictionStmt, res.StartDate, res.EndDate, res.RoomID, unitID, newId, 1, time.Now(), time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return newId, tx.Commit()
+}
+
+// insertRestrictionStmt inserts a restriction holding a unit of a room
+const insertRestrictionStmt = `insert into room_restrictions (start_date, end_date, room_id, unit_id, reservation_id, restriction_id, created_at, updated_at)
+	values ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+// return true if a unit of the room has no room restrictions for the given dates
+// return false if every unit has room restrictions for the given dates
+func (m *postgresDBRepo) SearchAvaibilityByDatesByRoomID(ctx context.Context, RoomID int, start, end time.Time) (bool, error) {
 
 	// Give a context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
-	var numRows int
+	if err := m.ownRoom(ctx, m.DB, RoomID); err != nil {
+		return false, err
+	}
 
-	// Prepare the SQL statement to search for available rooms
-	query := `select 
-				count(id) 
-			from 
-				room_restrictions 
-			where
-				room_id = $1 
-			and $2 < end_date and $3 > start_date`
+	var available bool
 
-	row := m.DB.QueryRowContext(ctx, query, RoomID, start, end)
-	err := row.Scan(&numRows)
+	// Prepare the SQL statement to search for a free unit of the room
+	row := m.DB.QueryRowContext(ctx, `select exists (`+freeUnitQuery+`)`, RoomID, start, end, 0, 0)
+	err := row.Scan(&available)
 
 	if err != nil {
 		return false, err
 	}
 
-	if numRows == 0 {
-		return true, nil
+	return available, nil
+}
+
+// SearchAvailabilityExcludingReservation returns true if a unit of the room is free between
+// start and end, ignoring the restriction of the reservation being changed
+func (m *postgresDBRepo) SearchAvailabilityExcludingReservation(ctx context.Context, roomID int, start, end time.Time, reservationID int) (bool, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	if err := m.ownRoom(ctx, m.DB, roomID); err != nil {
+		return false, err
+	}
+
+	var available bool
+	err := m.DB.QueryRowContext(ctx, `select exists (`+freeUnitQuery+`)`, roomID, start, end, reservationID, 0).Scan(&available)
+	if err != nil {
+		return false, err
 	}
-	return false, nil
+
+	return available, nil
 }
 
-// SearchAvaibilityForAllRooms returns a slice of available rooms for the given dates
-// It returns an empty slice if there are no available rooms
-func (m *postgresDBRepo) SearchAvaibilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+// SearchAvaibilityForAllRooms returns a slice of available rooms for the given dates, with
+// the number of their units free on every night, leaving out rooms with a longer minimum
+// stay. It returns an empty slice if there are no available rooms
+func (m *postgresDBRepo) SearchAvaibilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
 	// Give a context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
 	// Prepare the SQL statement to search for available rooms
-	query := `select r.id, r.room_name
-	 from 
-	 	rooms r 
-	where r.id not in 
-	(select rr.room_id from room_restrictions rr where $1 < rr.end_date and $2 > rr.start_date) `
+	query := `select r.id, r.room_name, r.price,
+		(select count(*) from room_units where room_id = r.id), count(u.id)
+	from rooms r
+	join room_units u on (u.room_id = r.id)
+	where not exists
+	(select 1 from room_restrictions rr where rr.unit_id = u.id and $1 < rr.end_date and $2 > rr.start_date and ` + activeRestriction + `)
+	and r.min_nights <= $2::date - $1::date and ` + m.propertyScope("r.property_id") + `
+	group by r.id
+	order by r.id`
 
 	rows, err := m.DB.QueryContext(ctx, query, start, end)
 	if err != nil {
@@ -105,7 +432,7 @@ func (m *postgresDBRepo) SearchAvaibilityForAllRooms(start, end time.Time) ([]mo
 	var rooms []models.Room
 	for rows.Next() {
 		var room models.Room
-		err := rows.Scan(&room.ID, &room.RoomName)
+		err := rows.Scan(&room.ID, &room.RoomName, &room.Price, &room.Units, &room.Available)
 		if err != nil {
 			return rooms, err
 		}
@@ -120,25 +447,26 @@ func (m *postgresDBRepo) SearchAvaibilityForAllRooms(start, end time.Time) ([]mo
 }
 
 // get RoomByID returns a room
-func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
+func (m *postgresDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
 	// Give a context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
 	var room models.Room
 
 	// Prepare the SQL statement to get a room by ID
-	stmt := `select id, room_name, created_at, updated_at from rooms where id = $1`
-	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(&room.ID, &room.RoomName, &room.CreatedAt, &room.UpdatedAt)
+	stmt := `select id, room_name, price, property_id, (select count(*) from room_units where room_id = rooms.id), min_nights, created_at, updated_at
+	from rooms where id = $1 and ` + m.propertyScope("property_id")
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(&room.ID, &room.RoomName, &room.Price, &room.PropertyID, &room.Units, &room.MinNights, &room.CreatedAt, &room.UpdatedAt)
 	if err != nil {
 		return models.Room{}, err
 	}
 	return room, nil
 }
 
-func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
+func (m *postgresDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
 	// Give a context with a timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
 	var user models.User
@@ -152,8 +480,8 @@ func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
 	return user, nil
 }
 
-func (m *postgresDBRepo) UpdateUser(u models.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) UpdateUser(ctx context.Context, u models.User) error {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
 	// Prepare the SQL statement to update a user
@@ -166,8 +494,8 @@ func (m *postgresDBRepo) UpdateUser(u models.User) error {
 }
 
 // Authenticate a user
-func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
 	var id int
@@ -190,107 +518,258 @@ func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string,
 	return id, hashedPassword, nil
 }
 
-// Return a slice of all reservations
-func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// exportTimeout bounds the queries streamed into exports, which may read the whole table
+const exportTimeout = 5 * time.Minute
+
+// reservationListSelect is the query shared by the reservation lists and exports
+const reservationListSelect = `select r.id, r.room_id, r.email, r.first_name, r.last_name, r.phone, r.start_date, r.end_date, r.created_at, r.updated_at, r.processed, coalesce(rm.room_name, '')
+	from reservations r
+	left join rooms rm on (r.room_id = rm.id)`
+
+// scanListedReservation reads a row selected by reservationListSelect
+func scanListedReservation(rows *sql.Rows) (models.Reservation, error) {
+	var r models.Reservation
+	err := rows.Scan(&r.ID, &r.RoomID, &r.Email, &r.FirstName, &r.LastName, &r.Phone, &r.StartDate, &r.EndDate, &r.CreatedAt, &r.UpdatedAt, &r.Processed, &r.Room.RoomName)
+	r.Room.ID = r.RoomID // Set the room ID manually
+	return r, err
+}
+
+// likeEscaper escapes the wildcards of like patterns, which are matched with escape '\'
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// containsPattern returns the like pattern of text containing s, wildcards included
+func containsPattern(s string) string {
+	return "%" + likeEscaper.Replace(s) + "%"
+}
+
+// reservationFilters returns the where conditions for the search and filters of q,
+// adding their values through arg
+func reservationFilters(q repository.ReservationQuery, arg func(v interface{}) string) []string {
+	var where []string
+
+	if q.Search != "" {
+		p := arg(containsPattern(q.Search))
+		where = append(where, fmt.Sprintf(`(r.first_name ilike %[1]s escape '\' or r.last_name ilike %[1]s escape '\'
+			or r.email ilike %[1]s escape '\' or r.phone ilike %[1]s escape '\')`, p))
+	}
+	if q.RoomID > 0 {
+		where = append(where, "r.room_id = "+arg(q.RoomID))
+	}
+	if !q.From.IsZero() {
+		where = append(where, "r.end_date > "+arg(q.From))
+	}
+	if !q.To.IsZero() {
+		where = append(where, "r.start_date < "+arg(q.To))
+	}
+	switch q.Status {
+	case repository.StatusNew:
+		where = append(where, "r.processed = 0")
+	case repository.StatusProcessed:
+		where = append(where, "r.processed = 1")
+	}
+
+	return where
+}
+
+// ListReservations returns one page of reservations matching q, using keyset
+// pagination on the sort column and the reservation id
+func (m *postgresDBRepo) ListReservations(ctx context.Context, q repository.ReservationQuery) (repository.ReservationPage, error) {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
-	var reservations []models.Reservation
+	var page repository.ReservationPage
 
-	query := `select r.id, r.room_id, r.email, r.first_name, r.last_name, r.phone, r.start_date, r.end_date, r.created_at, r.updated_at, r.processed, rm.room_name
-	from reservations r 
-	left join rooms rm on (r.room_id = rm.id) 
-	order by r.start_date asc`
+	col, ok := reservationSortColumns[q.Sort]
+	if !ok {
+		col = reservationSortColumns["start_date"]
+	}
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	where := append(reservationFilters(q, arg), m.roomScope("r.room_id"))
+
+	// walking backwards flips both the comparison and the order, the rows are reversed afterwards
+	backwards := q.Before != "" && q.After == ""
+	desc := q.Desc != backwards
+
+	cursor := q.After
+	if backwards {
+		cursor = q.Before
+	}
+	if cursor != "" {
+		value, id, err := decodeCursor(cursor)
+		if err != nil {
+			return page, err
+		}
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		where = append(where, fmt.Sprintf("(%s, r.id) %s (%s::%s, %s)", col.expr, op, arg(value), col.cast, arg(id)))
+	}
+
+	direction := "asc"
+	if desc {
+		direction = "desc"
+	}
+
+	limit := q.PageSize()
+
+	query := reservationListSelect + "\n\twhere " + strings.Join(where, " and ")
+	// one extra row tells whether another page follows
+	query += fmt.Sprintf("\n\torder by %[1]s %[2]s, r.id %[2]s\n\tlimit %[3]d", col.expr, direction, limit+1)
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return reservations, err
+		return page, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var r models.Reservation
-		err := rows.Scan(&r.ID, &r.RoomID, &r.Email, &r.FirstName, &r.LastName, &r.Phone, &r.StartDate, &r.EndDate, &r.CreatedAt, &r.UpdatedAt, &r.Processed, &r.Room.RoomName)
+		r, err := scanListedReservation(rows)
 		if err != nil {
-			return reservations, err
+			return page, err
 		}
-		r.Room.ID = r.RoomID // Set the room ID manually
-		reservations = append(reservations, r)
+		page.Reservations = append(page.Reservations, r)
 	}
 
 	if err = rows.Err(); err != nil {
-		return reservations, err
+		return page, err
 	}
 
-	if err = rows.Err(); err != nil {
-		return reservations, err
+	more := len(page.Reservations) > limit
+	if more {
+		page.Reservations = page.Reservations[:limit]
 	}
 
-	return reservations, nil
+	if backwards {
+		for i, j := 0, len(page.Reservations)-1; i < j; i, j = i+1, j-1 {
+			page.Reservations[i], page.Reservations[j] = page.Reservations[j], page.Reservations[i]
+		}
+	}
+
+	if len(page.Reservations) > 0 {
+		first := page.Reservations[0]
+		last := page.Reservations[len(page.Reservations)-1]
+
+		if (backwards && more) || (!backwards && cursor != "") {
+			page.PrevCursor = encodeCursor(col.value(first), first.ID)
+		}
+		if (!backwards && more) || backwards {
+			page.NextCursor = encodeCursor(col.value(last), last.ID)
+		}
+	}
+
+	return page, nil
 }
 
-func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// EachReservation calls fn for every reservation matching the filters and sort of q,
+// reading them one at a time. Cursors and limit are ignored. It stops at the first error fn returns.
+func (m *postgresDBRepo) EachReservation(ctx context.Context, q repository.ReservationQuery, fn func(models.Reservation) error) error {
+	ctx, cancel := context.WithTimeout(ctx, exportTimeout)
 	defer cancel()
 
-	var reservations []models.Reservation
+	col, ok := reservationSortColumns[q.Sort]
+	if !ok {
+		col = reservationSortColumns["start_date"]
+	}
 
-	query := `select r.id, r.room_id, r.email, r.first_name, r.last_name, r.phone, r.start_date, r.end_date, r.created_at, r.updated_at, r.processed, rm.room_name
-	from reservations r 
-	left join rooms rm on (r.room_id = rm.id)
-	where r.processed = 0
-	order by r.start_date asc`
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	where := append(reservationFilters(q, arg), m.roomScope("r.room_id"))
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	direction := "asc"
+	if q.Desc {
+		direction = "desc"
+	}
+
+	query := reservationListSelect + "\n\twhere " + strings.Join(where, " and ")
+	query += fmt.Sprintf("\n\torder by %[1]s %[2]s, r.id %[2]s", col.expr, direction)
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return reservations, err
+		return err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var r models.Reservation
-		err := rows.Scan(&r.ID, &r.RoomID, &r.Email, &r.FirstName, &r.LastName, &r.Phone, &r.StartDate, &r.EndDate, &r.CreatedAt, &r.UpdatedAt, &r.Processed, &r.Room.RoomName)
+		r, err := scanListedReservation(rows)
 		if err != nil {
-			return reservations, err
+			return err
+		}
+		if err := fn(r); err != nil {
+			return err
 		}
-		r.Room.ID = r.RoomID // Set the room ID manually
-		reservations = append(reservations, r)
 	}
 
-	if err = rows.Err(); err != nil {
-		return reservations, err
-	}
+	return rows.Err()
+}
 
-	return reservations, nil
+// CountNewReservations returns the number of reservations not yet processed
+func (m *postgresDBRepo) CountNewReservations(ctx context.Context) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var count int
+
+	query := `select count(id) from reservations where processed = 0 and ` + m.roomScope("room_id")
+	err := m.DB.QueryRowContext(ctx, query).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
 }
 
 // Return a reservation by ID
-func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
 	var res models.Reservation
 
-	query := `select r.id, r.room_id, r.email, r.first_name, r.last_name, r.phone, r.start_date, r.end_date, r.created_at, r.updated_at, r.processed, rm.room_name, rm.id
+	query := `select r.id, r.room_id, r.email, r.first_name, r.last_name, r.phone, r.start_date, r.end_date, r.created_at, r.updated_at, r.processed,
+		r.source, r.override_reason, coalesce(r.created_by, 0), coalesce(r.guest_id, 0), r.amount_due, r.payment_status, r.hold_until,
+		coalesce(r.promo_code_id, 0), coalesce(p.code, ''), r.discount,
+		coalesce(r.cancellation_policy_id, 0), coalesce(cp.name, ''), r.cancelled_at, r.cancellation_fee, r.cancellation_reason,
+		rm.room_name, rm.id, rm.price, rm.property_id, coalesce(u.id, 0), coalesce(u.name, '')
 	from reservations r
 	left join rooms rm on (r.room_id = rm.id)
-	where r.id = $1`
-
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(&res.ID, &res.RoomID, &res.Email, &res.FirstName, &res.LastName, &res.Phone, &res.StartDate, &res.EndDate, &res.CreatedAt, &res.UpdatedAt, &res.Processed, &res.Room.RoomName, &res.Room.ID)
+	left join promo_codes p on (p.id = r.promo_code_id)
+	left join cancellation_policies cp on (cp.id = r.cancellation_policy_id)
+	left join room_restrictions rr on (rr.reservation_id = r.id)
+	left join room_units u on (u.id = rr.unit_id)
+	where r.id = $1 and ` + m.roomScope("r.room_id")
+
+	var holdUntil, cancelledAt sql.NullTime
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&res.ID, &res.RoomID, &res.Email, &res.FirstName, &res.LastName, &res.Phone, &res.StartDate, &res.EndDate, &res.CreatedAt, &res.UpdatedAt, &res.Processed,
+		&res.Source, &res.OverrideReason, &res.CreatedBy, &res.GuestID, &res.AmountDue, &res.PaymentStatus, &holdUntil,
+		&res.PromoCodeID, &res.PromoCode, &res.Discount,
+		&res.CancellationPolicyID, &res.CancellationPolicy, &cancelledAt, &res.CancellationFee, &res.CancellationReason,
+		&res.Room.RoomName, &res.Room.ID, &res.Room.Price, &res.Room.PropertyID, &res.UnitID, &res.Unit)
 	if err != nil {
 		return res, err
 	}
+	res.HoldUntil = holdUntil.Time
+	res.CancelledAt = cancelledAt.Time
 
 	return res, nil
 }
 
 // Update reservations
-func (m *postgresDBRepo) UpdateReservation(r models.Reservation) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) UpdateReservation(ctx context.Context, r models.Reservation) error {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
-	// Prepare the SQL statement to update a Reservation
-	stmt := `update reservations set first_name = $1, last_name = $2, email = $3, phone = $4, updated_at = $5 where id = $6`
+	// Prepare the SQL statement to update a Reservation, and link it to the guest with its email
+	stmt := upsertGuest("$5", "(select rm.property_id from reservations r join rooms rm on (rm.id = r.room_id) where r.id = $6)") +
+		`update reservations set first_name = $1, last_name = $2, email = $3, phone = $4, updated_at = $5,
+		guest_id = (select id from guest) where id = $6 and ` + m.roomScope("room_id")
 	_, err := m.DB.ExecContext(ctx, stmt, r.FirstName, r.LastName, r.Email, r.Phone, time.Now(), r.ID)
 	if err != nil {
 		return err
@@ -298,13 +777,65 @@ func (m *postgresDBRepo) UpdateReservation(r models.Reservation) error {
 	return nil
 }
 
+// MoveReservation changes the room and dates of a reservation and of its room
+// restriction in one transaction. The stay keeps its unit if it is free, or else
+// moves to a free unit. It returns ErrRestrictionConflict if every unit is booked
+// or blocked on the new dates.
+func (m *postgresDBRepo) MoveReservation(ctx context.Context, res models.Reservation) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.ownRoom(ctx, tx, res.RoomID); err != nil {
+		return err
+	}
+
+	// availability was checked before, but the room could have been booked since
+	if err := lockUnits(ctx, tx, res.RoomID); err != nil {
+		return err
+	}
+	var current int
+	err = tx.QueryRowContext(ctx, `select coalesce(max(unit_id), 0) from room_restrictions where reservation_id = $1`, res.ID).Scan(&current)
+	if err != nil {
+		return err
+	}
+	unitID, err := freeUnit(ctx, tx, res.RoomID, res.StartDate, res.EndDate, res.ID, current)
+	if err != nil {
+		return err
+	}
+
+	stmt := `update reservations set room_id = $1, start_date = $2, end_date = $3, updated_at = $4 where id = $5 and ` + m.roomScope("room_id")
+	result, err := tx.ExecContext(ctx, stmt, res.RoomID, res.StartDate, res.EndDate, time.Now(), res.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	stmt = `update room_restrictions set room_id = $1, unit_id = $2, start_date = $3, end_date = $4, updated_at = $5 where reservation_id = $6`
+	_, err = tx.ExecContext(ctx, stmt, res.RoomID, unitID, res.StartDate, res.EndDate, time.Now(), res.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 // Delete reservations by id
-func (m *postgresDBRepo) DeleteReservation(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) DeleteReservation(ctx context.Context, id int) error {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
 	// Prepare the SQL statement to update a Reservation
-	stmt := `delete from reservations where id = $1 `
+	stmt := `delete from reservations where id = $1 and ` + m.roomScope("room_id")
 	_, err := m.DB.ExecContext(ctx, stmt, id)
 	if err != nil {
 		return err
@@ -313,12 +844,12 @@ func (m *postgresDBRepo) DeleteReservation(id int) error {
 }
 
 // update processed by reservation id
-func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) UpdateProcessedForReservation(ctx context.Context, id, processed int) error {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
 	// Prepare the SQL statement to update a Reservation
-	stmt := `update reservations set processed = $1 where id = $2 `
+	stmt := `update reservations set processed = $1 where id = $2 and ` + m.roomScope("room_id")
 	_, err := m.DB.ExecContext(ctx, stmt, processed, id)
 	if err != nil {
 		return err
@@ -327,13 +858,14 @@ func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) error
 }
 
 // Get All Rooms
-func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
 	var rooms []models.Room
 
-	smtp := `select id , room_name, created_at, updated_at from rooms order by room_name`
+	smtp := `select id , room_name, price, property_id, (select count(*) from room_units where room_id = rooms.id), min_nights, created_at, updated_at
+	from rooms where ` + m.propertyScope("property_id") + ` order by room_name`
 	rows, err := m.DB.QueryContext(ctx, smtp)
 	if err != nil {
 		return nil, err
@@ -342,7 +874,7 @@ func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
 
 	for rows.Next() {
 		var r models.Room
-		err := rows.Scan(&r.ID, &r.RoomName, &r.CreatedAt, &r.UpdatedAt)
+		err := rows.Scan(&r.ID, &r.RoomName, &r.Price, &r.PropertyID, &r.Units, &r.MinNights, &r.CreatedAt, &r.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -351,61 +883,1901 @@ func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
 	return rooms, nil
 }
 
-// GetRestrictionsForRoomByDate returns a slice of room restrictions for a room by date range
-func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// RoomAvailability returns, for every night from start up to end, how many units of
+// the room are free and its price. It returns no nights for a room of another property.
+func (m *postgresDBRepo) RoomAvailability(ctx context.Context, roomID int, start, end time.Time) ([]models.NightAvailability, error) {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
-	var restrictions []models.RoomRestriction
+	var nights []models.NightAvailability
 
-	query := `select id, room_id, start_date, end_date, coalesce(reservation_id, 0), restriction_id
-	from room_restrictions
-	where $1 < end_date and $2 >= start_date and room_id = $3`
+	query := `select d::date, count(u.id) filter (where not exists (select 1 from room_restrictions rr
+			where rr.unit_id = u.id and rr.start_date <= d and rr.end_date > d and ` + activeRestriction + `)),
+		rm.price
+	from generate_series($2::date, $3::date - 1, interval '1 day') d
+	cross join rooms rm
+	left join room_units u on (u.room_id = rm.id)
+	where rm.id = $1 and ` + m.propertyScope("rm.property_id") + `
+	group by d, rm.price
+	order by d`
 
-	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID)
+	rows, err := m.DB.QueryContext(ctx, query, roomID, start, end)
 	if err != nil {
-		return restrictions, err
+		return nights, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var r models.RoomRestriction
-		err := rows.Scan(&r.ID, &r.RoomID, &r.StartDate, &r.EndDate, &r.ReservationID, &r.RestrictionID)
-		if err != nil {
-			return restrictions, err
+		var n models.NightAvailability
+		if err := rows.Scan(&n.Date, &n.Free, &n.Price); err != nil {
+			return nights, err
 		}
-		restrictions = append(restrictions, r)
+		nights = append(nights, n)
 	}
 
 	if err = rows.Err(); err != nil {
-		return restrictions, err
+		return nights, err
 	}
 
-	return restrictions, nil
+	return nights, nil
 }
 
-func (m *postgresDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// FreeUnitNights returns the nights from start up to end on which each unit of every
+// room is free, ordered by room, unit and night, to suggest other stays from
+func (m *postgresDBRepo) FreeUnitNights(ctx context.Context, start, end time.Time) ([]models.RoomNight, error) {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
-	query := `insert into room_restrictions (start_date, end_date, room_id, restriction_id, created_at, updated_at)
-	values ($1, $2, $3, $4, $5, $6)`
-	_, err := m.DB.ExecContext(ctx, query, startDate, startDate, id, 2, time.Now(), time.Now())
+	var nights []models.RoomNight
+
+	query := `select d::date, u.room_id, u.id
+	from generate_series($1::date, $2::date - 1, interval '1 day') d
+	cross join room_units u
+	join rooms rm on (rm.id = u.room_id)
+	where not exists (select 1 from room_restrictions rr
+		where rr.unit_id = u.id and rr.start_date <= d and rr.end_date > d and ` + activeRestriction + `)
+	and ` + m.propertyScope("rm.property_id") + `
+	order by u.room_id, u.id, d`
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	if err != nil {
+		return nights, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n models.RoomNight
+		if err := rows.Scan(&n.Date, &n.Room.ID, &n.Unit.ID); err != nil {
+			return nights, err
+		}
+		nights = append(nights, n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nights, err
+	}
+
+	return nights, nil
+}
+
+// GetRestrictionsForRoomByDate returns a slice of room restrictions for a room by date range
+func (m *postgresDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	query := `select id, room_id, unit_id, start_date, end_date, coalesce(reservation_id, 0), restriction_id
+	from room_restrictions rr
+	where $1 < end_date and $2 >= start_date and room_id = $3 and ` + activeRestriction + ` and ` + m.roomScope("room_id")
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(&r.ID, &r.RoomID, &r.UnitID, &r.StartDate, &r.EndDate, &r.ReservationID, &r.RestrictionID)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+
+	return restrictions, nil
+}
+
+// RestrictionsForPeriod returns the reservations, blocks and unexpired holds of every
+// room that overlap the period, with the guest name of the reservations. Blocks ending
+// on the day they start cover that one night.
+func (m *postgresDBRepo) RestrictionsForPeriod(ctx context.Context, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	query := `select rr.id, rr.room_id, rr.unit_id, rr.start_date, rr.end_date, coalesce(rr.reservation_id, 0), rr.restriction_id,
+		rr.expires_at, coalesce(r.first_name, ''), coalesce(r.last_name, '')
+	from room_restrictions rr
+	left join reservations r on (r.id = rr.reservation_id)
+	where rr.start_date < $2 and greatest(rr.end_date, rr.start_date + 1) > $1 and ` + activeRestriction + `
+	and ` + m.roomScope("rr.room_id") + `
+	order by rr.room_id, rr.unit_id, rr.start_date`
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	if err != nil {
+		return restrictions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRestriction
+		var expiresAt sql.NullTime
+		err := rows.Scan(&r.ID, &r.RoomID, &r.UnitID, &r.StartDate, &r.EndDate, &r.ReservationID, &r.RestrictionID,
+			&expiresAt, &r.Reservation.FirstName, &r.Reservation.LastName)
+		if err != nil {
+			return restrictions, err
+		}
+		r.ExpiresAt = expiresAt.Time
+		r.Reservation.ID = r.ReservationID
+		restrictions = append(restrictions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+
+	return restrictions, nil
+}
+
+// InsertBlock blocks a room unit from start until the day before end and returns the id
+// of the new restriction. It returns ErrRestrictionConflict if the unit is already booked
+// or blocked on one of those nights, and sql.ErrNoRows if there is no such unit.
+func (m *postgresDBRepo) InsertBlock(ctx context.Context, unitID int, start, end time.Time) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	err = tx.QueryRowContext(ctx, `select room_id from room_units where id = $1`, unitID).Scan(&roomID)
+	if err != nil {
+		return 0, err
+	}
+	if err := m.ownRoom(ctx, tx, roomID); err != nil {
+		return 0, err
+	}
+
+	if err := lockUnits(ctx, tx, roomID); err != nil {
+		return 0, err
+	}
+	if free, err := freeUnit(ctx, tx, roomID, start, end, 0, unitID); err != nil && !errors.Is(err, ErrRestrictionConflict) {
+		return 0, err
+	} else if free != unitID {
+		return 0, ErrRestrictionConflict
+	}
+
+	var id int
+	query := `insert into room_restrictions (start_date, end_date, room_id, unit_id, restriction_id, created_at, updated_at)
+	values ($1, $2, $3, $4, $5, $6, $7) returning id`
+	err = tx.QueryRowContext(ctx, query, start, end, roomID, unitID, 2, time.Now(), time.Now()).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// DeleteBlockByID removes a block. It returns sql.ErrNoRows if there is no block with
+// that id, so that reservations can't be removed this way.
+func (m *postgresDBRepo) DeleteBlockByID(ctx context.Context, id int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	query := `delete from room_restrictions where id = $1 and reservation_id is null and expires_at is null and ` + m.roomScope("room_id")
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// HoldRoom holds a free unit of the room from start to end until the time until, for
+// a guest filling in the reservation form, and returns the id of the hold. It returns
+// ErrRestrictionConflict if every unit is taken.
+func (m *postgresDBRepo) HoldRoom(ctx context.Context, roomID int, start, end, until time.Time) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := m.ownRoom(ctx, tx, roomID); err != nil {
+		return 0, err
+	}
+
+	if err := lockUnits(ctx, tx, roomID); err != nil {
+		return 0, err
+	}
+	unitID, err := freeUnit(ctx, tx, roomID, start, end, 0, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	query := `insert into room_restrictions (start_date, end_date, room_id, unit_id, restriction_id, expires_at, created_at, updated_at)
+	values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+	err = tx.QueryRowContext(ctx, query, start, end, roomID, unitID, models.RestrictionHold, until, time.Now(), time.Now()).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// ReleaseRoomHold gives back the unit kept by a hold, such as when the guest picks
+// another room. Releasing a hold that is already gone is not an error.
+func (m *postgresDBRepo) ReleaseRoomHold(ctx context.Context, id int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	query := `delete from room_restrictions where id = $1 and restriction_id = $2 and ` + m.roomScope("room_id")
+	_, err := m.DB.ExecContext(ctx, query, id, models.RestrictionHold)
+	return err
+}
+
+// ReleaseExpiredRoomHolds removes the holds expired by now and returns how many
+// were removed
+func (m *postgresDBRepo) ReleaseExpiredRoomHolds(ctx context.Context, now time.Time) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	query := `delete from room_restrictions where restriction_id = $1 and expires_at <= $2 and ` + m.roomScope("room_id")
+	result, err := m.DB.ExecContext(ctx, query, models.RestrictionHold, now)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// ArrivalsForDate returns the reservations starting on day
+func (m *postgresDBRepo) ArrivalsForDate(ctx context.Context, day time.Time) ([]models.Reservation, error) {
+	query := `select r.id, r.room_id, r.email, r.first_name, r.last_name, r.phone, r.start_date, r.end_date, r.created_at, r.updated_at, r.processed, rm.room_name
+	from reservations r
+	left join rooms rm on (r.room_id = rm.id)
+	where r.start_date = $1 and r.payment_status <> 'released' and r.cancelled_at is null and ` + m.roomScope("r.room_id") + `
+	order by r.last_name asc`
+
+	return m.queryReservations(ctx, query, day)
+}
+
+// DeparturesForDate returns the reservations ending on day
+func (m *postgresDBRepo) DeparturesForDate(ctx context.Context, day time.Time) ([]models.Reservation, error) {
+	query := `select r.id, r.room_id, r.email, r.first_name, r.last_name, r.phone, r.start_date, r.end_date, r.created_at, r.updated_at, r.processed, rm.room_name
+	from reservations r
+	left join rooms rm on (r.room_id = rm.id)
+	where r.end_date = $1 and r.payment_status <> 'released' and r.cancelled_at is null and ` + m.roomScope("r.room_id") + `
+	order by r.last_name asc`
+
+	return m.queryReservations(ctx, query, day)
+}
+
+// queryReservations runs a reservation listing query and scans the rows
+func (m *postgresDBRepo) queryReservations(ctx context.Context, query string, args ...interface{}) ([]models.Reservation, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.Reservation
+		err := rows.Scan(&r.ID, &r.RoomID, &r.Email, &r.FirstName, &r.LastName, &r.Phone, &r.StartDate, &r.EndDate, &r.CreatedAt, &r.UpdatedAt, &r.Processed, &r.Room.RoomName)
+		if err != nil {
+			return reservations, err
+		}
+		r.Room.ID = r.RoomID
+		reservations = append(reservations, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+}
+
+// CountInHouseReservations returns the number of reservations staying the night of day
+func (m *postgresDBRepo) CountInHouseReservations(ctx context.Context, day time.Time) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var count int
+
+	query := `select count(id) from reservations where start_date <= $1 and end_date > $1 and payment_status <> 'released' and cancelled_at is null
+	and ` + m.roomScope("room_id")
+	err := m.DB.QueryRowContext(ctx, query, day).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// OccupancyByRoom returns the nights booked by reservations for every room between start and
+// end, out of the nights of all its units
+func (m *postgresDBRepo) OccupancyByRoom(ctx context.Context, start, end time.Time) ([]models.RoomOccupancy, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var occupancy []models.RoomOccupancy
+	totalNights := int(end.Sub(start).Hours() / 24)
+
+	// only count the part of each stay that falls inside the period
+	query := `select rm.id, rm.room_name, (select count(*) from room_units where room_id = rm.id),
+		coalesce(sum(greatest(0, least(rr.end_date, $2::date) - greatest(rr.start_date, $1::date))), 0)
+	from rooms rm
+	left join room_restrictions rr on (rr.room_id = rm.id and rr.reservation_id is not null
+		and rr.start_date < $2 and rr.end_date > $1)
+	where ` + m.propertyScope("rm.property_id") + `
+	group by rm.id, rm.room_name
+	order by rm.room_name`
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	if err != nil {
+		return occupancy, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var o models.RoomOccupancy
+		err := rows.Scan(&o.Room.ID, &o.Room.RoomName, &o.Room.Units, &o.BookedNights)
+		if err != nil {
+			return occupancy, err
+		}
+		o.TotalNights = totalNights * o.Room.Units
+		occupancy = append(occupancy, o)
+	}
+
+	if err = rows.Err(); err != nil {
+		return occupancy, err
+	}
+
+	return occupancy, nil
+}
+
+// ReservationsCreatedByDay returns the number of reservations made on each day from start up to end
+func (m *postgresDBRepo) ReservationsCreatedByDay(ctx context.Context, start, end time.Time) ([]models.DailyCount, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var counts []models.DailyCount
+
+	query := `select d::date, count(r.id)
+	from generate_series($1::date, $2::date - 1, interval '1 day') d
+	left join reservations r on (r.created_at::date = d::date and ` + m.roomScope("r.room_id") + `)
+	group by d
+	order by d`
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	if err != nil {
+		return counts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.DailyCount
+		err := rows.Scan(&c.Date, &c.Count)
+		if err != nil {
+			return counts, err
+		}
+		counts = append(counts, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return counts, err
+	}
+
+	return counts, nil
+}
+
+// AverageLengthOfStay returns the mean number of nights of reservations arriving between start and end
+func (m *postgresDBRepo) AverageLengthOfStay(ctx context.Context, start, end time.Time) (float64, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var avg float64
+
+	query := `select coalesce(avg(end_date - start_date), 0)::float8
+	from reservations
+	where start_date >= $1 and start_date < $2 and payment_status <> 'released' and cancelled_at is null
+	and ` + m.roomScope("room_id")
+	err := m.DB.QueryRowContext(ctx, query, start, end).Scan(&avg)
+	if err != nil {
+		return 0, err
+	}
+	return avg, nil
+}
+
+// EachRoomNight calls fn for every room unit and every night from start up to end, ordered
+// by night then room and unit, with the reservation or block occupying it if any
+func (m *postgresDBRepo) EachRoomNight(ctx context.Context, start, end time.Time, fn func(models.RoomNight) error) error {
+	ctx, cancel := context.WithTimeout(ctx, exportTimeout)
+	defer cancel()
+
+	// a night overlapped by several restrictions reports the reservation first
+	query := `select distinct on (d, rm.id, u.id) d::date, rm.id, rm.room_name, u.id, u.name,
+		coalesce(rr.reservation_id, 0), coalesce(rr.restriction_id, 0),
+		coalesce(r.first_name || ' ' || r.last_name, '')
+	from generate_series($1::date, $2::date - 1, interval '1 day') d
+	cross join rooms rm
+	join room_units u on (u.room_id = rm.id)
+	left join room_restrictions rr on (rr.unit_id = u.id and rr.start_date <= d and rr.end_date > d and rr.expires_at is null)
+	left join reservations r on (r.id = rr.reservation_id)
+	where ` + m.propertyScope("rm.property_id") + `
+	order by d, rm.id, u.id, rr.reservation_id nulls last`
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n models.RoomNight
+		err := rows.Scan(&n.Date, &n.Room.ID, &n.Room.RoomName, &n.Unit.ID, &n.Unit.Name, &n.ReservationID, &n.RestrictionID, &n.GuestName)
+		if err != nil {
+			return err
+		}
+		n.Unit.RoomID = n.Room.ID
+		if err := fn(n); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// ErrRestrictionConflict is returned by ImportRestrictions when a row overlaps an existing restriction
+var ErrRestrictionConflict = errors.New("room is already booked or blocked for these dates")
+
+// ImportRestrictions inserts reservations and blocks in a single transaction, each on
+// a free unit of its room. Rows with a RestrictionID of 1 also insert their Reservation.
+// Nothing is written if any row fails or finds every unit of its room taken.
+func (m *postgresDBRepo) ImportRestrictions(ctx context.Context, rows []models.RoomRestriction) error {
+	ctx, cancel := context.WithTimeout(ctx, exportTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	for _, row := range rows {
+		if err := m.ownRoom(ctx, tx, row.RoomID); err != nil {
+			return fmt.Errorf("room %d: %w", row.RoomID, err)
+		}
+
+		// the rows were checked before, but the room could have been booked since
+		if err := lockUnits(ctx, tx, row.RoomID); err != nil {
+			return err
+		}
+		unitID, err := freeUnit(ctx, tx, row.RoomID, row.StartDate, row.EndDate, 0, 0)
+		if errors.Is(err, ErrRestrictionConflict) {
+			return fmt.Errorf("room %d from %s: %w", row.RoomID, row.StartDate.Format("2006-01-02"), err)
+		}
+		if err != nil {
+			return err
+		}
+
+		var reservationID sql.NullInt64
+		if row.RestrictionID == 1 {
+			err := tx.QueryRowContext(ctx, insertReservationStmt, reservationArgs(row.Reservation)...).Scan(&reservationID)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, insertRestrictionStmt, row.StartDate, row.EndDate, row.RoomID, unitID, reservationID, row.RestrictionID, now, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// maxGuestsListed caps the guests returned by ListGuests
+const maxGuestsListed = 100
+
+// ListGuests returns the guests whose name, email or phone matches search, with the
+// number of stays and nights they booked
+func (m *postgresDBRepo) ListGuests(ctx context.Context, search string) ([]models.Guest, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var guests []models.Guest
+
+	query := `select g.id, g.email, g.first_name, g.last_name, g.phone, g.notes, g.tags, g.created_at, g.updated_at,
+		count(r.id), coalesce(sum(r.end_date - r.start_date), 0)
+	from guests g
+	left join reservations r on (r.guest_id = g.id)
+	where ($1 = '' or g.first_name ilike $2 escape '\' or g.last_name ilike $2 escape '\'
+		or g.email ilike $2 escape '\' or g.phone ilike $2 escape '\')
+	and ` + m.propertyScope("g.property_id") + `
+	group by g.id
+	order by g.last_name, g.first_name, g.id
+	limit $3`
+
+	rows, err := m.DB.QueryContext(ctx, query, search, containsPattern(search), maxGuestsListed)
+	if err != nil {
+		return guests, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var g models.Guest
+		var tags string
+		err := rows.Scan(&g.ID, &g.Email, &g.FirstName, &g.LastName, &g.Phone, &g.Notes, &tags, &g.CreatedAt, &g.UpdatedAt,
+			&g.Stays, &g.Nights)
+		if err != nil {
+			return guests, err
+		}
+		g.Tags = models.ParseTags(tags)
+		guests = append(guests, g)
+	}
+
+	if err = rows.Err(); err != nil {
+		return guests, err
+	}
+
+	return guests, nil
+}
+
+// GetGuestByID returns a guest
+func (m *postgresDBRepo) GetGuestByID(ctx context.Context, id int) (models.Guest, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var g models.Guest
+	var tags string
+
+	query := `select id, email, first_name, last_name, phone, notes, tags, created_at, updated_at
+	from guests where id = $1 and ` + m.propertyScope("property_id")
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&g.ID, &g.Email, &g.FirstName, &g.LastName, &g.Phone, &g.Notes, &tags,
+		&g.CreatedAt, &g.UpdatedAt)
+	if err != nil {
+		return g, err
+	}
+	g.Tags = models.ParseTags(tags)
+
+	return g, nil
+}
+
+// GuestStays returns the reservations of a guest, latest first
+func (m *postgresDBRepo) GuestStays(ctx context.Context, guestID int) ([]models.Reservation, error) {
+	query := `select r.id, r.room_id, r.email, r.first_name, r.last_name, r.phone, r.start_date, r.end_date, r.created_at, r.updated_at, r.processed, rm.room_name
+	from reservations r
+	left join rooms rm on (r.room_id = rm.id)
+	where r.guest_id = $1 and ` + m.roomScope("r.room_id") + `
+	order by r.start_date desc`
+
+	return m.queryReservations(ctx, query, guestID)
+}
+
+// UpdateGuest saves the name, phone, notes and tags of a guest
+func (m *postgresDBRepo) UpdateGuest(ctx context.Context, g models.Guest) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	stmt := `update guests set first_name = $1, last_name = $2, phone = $3, notes = $4, tags = $5, updated_at = $6
+	where id = $7 and ` + m.propertyScope("property_id")
+
+	result, err := m.DB.ExecContext(ctx, stmt, g.FirstName, g.LastName, g.Phone, g.Notes, strings.Join(g.Tags, ","), time.Now(), g.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AddReservationNote saves a staff note on a reservation and returns its id
+func (m *postgresDBRepo) AddReservationNote(ctx context.Context, n models.ReservationNote) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var userID sql.NullInt64
+	if n.UserID > 0 {
+		userID = sql.NullInt64{Int64: int64(n.UserID), Valid: true}
+	}
+
+	var id int
+	stmt := `insert into reservation_notes (reservation_id, user_id, body, created_at)
+	select $1, $2, $3, $4 where ` + m.reservationScope("$1::int") + ` returning id`
+	err := m.DB.QueryRowContext(ctx, stmt, n.ReservationID, userID, n.Body, time.Now()).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// ReservationNotes returns the notes on a reservation, latest first
+func (m *postgresDBRepo) ReservationNotes(ctx context.Context, reservationID int) ([]models.ReservationNote, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var notes []models.ReservationNote
+
+	query := `select n.id, n.reservation_id, coalesce(n.user_id, 0), coalesce(u.first_name || ' ' || u.last_name, ''), n.body, n.created_at
+	from reservation_notes n
+	left join users u on (u.id = n.user_id)
+	where n.reservation_id = $1 and ` + m.reservationScope("n.reservation_id") + `
+	order by n.created_at desc, n.id desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return notes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n models.ReservationNote
+		err := rows.Scan(&n.ID, &n.ReservationID, &n.UserID, &n.Author, &n.Body, &n.CreatedAt)
+		if err != nil {
+			return notes, err
+		}
+		notes = append(notes, n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return notes, err
+	}
+
+	return notes, nil
+}
+
+// AddReservationMessage records an email sent to or received from a guest and returns its id
+func (m *postgresDBRepo) AddReservationMessage(ctx context.Context, msg models.ReservationMessage) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var userID sql.NullInt64
+	if msg.UserID > 0 {
+		userID = sql.NullInt64{Int64: int64(msg.UserID), Valid: true}
+	}
+
+	var id int
+	stmt := `insert into reservation_messages (reservation_id, direction, user_id, from_address, to_address, subject, body, created_at)
+	select $1, $2, $3, $4, $5, $6, $7, $8 where ` + m.reservationScope("$1::int") + ` returning id`
+	err := m.DB.QueryRowContext(ctx, stmt, msg.ReservationID, msg.Direction, userID, msg.From, msg.To, msg.Subject, msg.Body, time.Now()).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// ReservationMessages returns the emails exchanged with the guest of a reservation, oldest first
+func (m *postgresDBRepo) ReservationMessages(ctx context.Context, reservationID int) ([]models.ReservationMessage, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var messages []models.ReservationMessage
+
+	query := `select rm.id, rm.reservation_id, rm.direction, coalesce(rm.user_id, 0), coalesce(u.first_name || ' ' || u.last_name, ''),
+		rm.from_address, rm.to_address, rm.subject, rm.body, rm.created_at
+	from reservation_messages rm
+	left join users u on (u.id = rm.user_id)
+	where rm.reservation_id = $1 and ` + m.reservationScope("rm.reservation_id") + `
+	order by rm.created_at, rm.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return messages, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msg models.ReservationMessage
+		err := rows.Scan(&msg.ID, &msg.ReservationID, &msg.Direction, &msg.UserID, &msg.Author,
+			&msg.From, &msg.To, &msg.Subject, &msg.Body, &msg.CreatedAt)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return messages, err
+	}
+
+	return messages, nil
+}
+
+// ReplyToken returns the token threading the guest replies of a reservation,
+// creating it the first time
+func (m *postgresDBRepo) ReplyToken(ctx context.Context, reservationID int) (string, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	var token string
+	stmt := `update reservations set reply_token = coalesce(reply_token, $1) where id = $2 and ` + m.roomScope("room_id") + ` returning reply_token`
+	err := m.DB.QueryRowContext(ctx, stmt, hex.EncodeToString(b), reservationID).Scan(&token)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ReservationIDByReplyToken returns the reservation a reply token belongs to. It
+// returns sql.ErrNoRows for an unknown token.
+func (m *postgresDBRepo) ReservationIDByReplyToken(ctx context.Context, token string) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, `select id from reservations where reply_token = $1 and `+m.roomScope("room_id"), token).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// AddPayment records a payment or refund on a reservation and returns its id
+func (m *postgresDBRepo) AddPayment(ctx context.Context, p models.Payment) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	if err := m.ownReservation(ctx, m.DB, p.ReservationID); err != nil {
+		return 0, err
+	}
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, insertPaymentStmt, paymentArgs(p)...).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// insertPaymentStmt inserts a payment with the arguments of paymentArgs. A payment
+// for a checkout already recorded is skipped, and returns no rows.
+const insertPaymentStmt = `insert into payments (reservation_id, kind, method, amount, reference, checkout_id, user_id, created_at)
+	values ($1, $2, $3, $4, $5, $6, $7, $8)
+	on conflict (checkout_id) do nothing
+	returning id`
+
+// paymentArgs returns the values inserted by insertPaymentStmt
+func paymentArgs(p models.Payment) []interface{} {
+	var checkoutID sql.NullString
+	if p.CheckoutID != "" {
+		checkoutID = sql.NullString{String: p.CheckoutID, Valid: true}
+	}
+
+	var userID sql.NullInt64
+	if p.UserID > 0 {
+		userID = sql.NullInt64{Int64: int64(p.UserID), Valid: true}
+	}
+
+	return []interface{}{p.ReservationID, p.Kind, p.Method, p.Amount, p.Reference, checkoutID, userID, time.Now()}
+}
+
+// ReservationPayments returns the payments and refunds of a reservation, oldest first
+func (m *postgresDBRepo) ReservationPayments(ctx context.Context, reservationID int) ([]models.Payment, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var payments []models.Payment
+
+	query := `select p.id, p.reservation_id, p.kind, p.method, p.amount, p.reference, coalesce(p.checkout_id, ''),
+		coalesce(p.user_id, 0), coalesce(u.first_name || ' ' || u.last_name, ''), p.created_at
+	from payments p
+	left join users u on (u.id = p.user_id)
+	where p.reservation_id = $1 and ` + m.reservationScope("p.reservation_id") + `
+	order by p.created_at, p.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return payments, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.Payment
+		err := rows.Scan(&p.ID, &p.ReservationID, &p.Kind, &p.Method, &p.Amount, &p.Reference, &p.CheckoutID,
+			&p.UserID, &p.Author, &p.CreatedAt)
+		if err != nil {
+			return payments, err
+		}
+		payments = append(payments, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return payments, err
+	}
+
+	return payments, nil
+}
+
+// UpdateAmountDue changes the price of a reservation's stay
+func (m *postgresDBRepo) UpdateAmountDue(ctx context.Context, reservationID, amount int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	stmt := `update reservations set amount_due = $1, updated_at = $2 where id = $3 and ` + m.roomScope("room_id")
+	result, err := m.DB.ExecContext(ctx, stmt, amount, time.Now(), reservationID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RecordCheckoutPayment records a card payment confirmed by the payment provider
+// and marks its reservation paid, if it was pending payment, in one transaction. It
+// reports false if the checkout was recorded already, as providers can repeat
+// their webhook calls.
+func (m *postgresDBRepo) RecordCheckoutPayment(ctx context.Context, p models.Payment) (bool, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if err := m.ownReservation(ctx, tx, p.ReservationID); err != nil {
+		return false, err
+	}
+
+	var id int
+	err = tx.QueryRowContext(ctx, insertPaymentStmt, paymentArgs(p)...).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	stmt := `update reservations set payment_status = $1, hold_until = null, updated_at = $2
+	where id = $3 and payment_status = $4`
+	_, err = tx.ExecContext(ctx, stmt, models.PaymentPaid, time.Now(), p.ReservationID, models.PaymentPending)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// releaseHoldsStmt releases the reservations pending payment matching cond, freeing
+// their room, and returns their ids. $1 is the current time and $2 is for cond.
+func releaseHoldsStmt(cond string) string {
+	return `with released as (
+		update reservations set payment_status = '` + models.PaymentReleased + `', hold_until = null, updated_at = $1
+		where payment_status = '` + models.PaymentPending + `' and ` + cond + `
+		returning id
+	), freed as (
+		delete from room_restrictions where reservation_id in (select id from released)
+	)
+	select id from released order by id`
+}
+
+// ReleaseHold releases a reservation still pending payment, such as when the guest
+// abandons the checkout. It reports whether the reservation was pending.
+func (m *postgresDBRepo) ReleaseHold(ctx context.Context, reservationID int) (bool, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, releaseHoldsStmt("id = $2 and "+m.roomScope("room_id")), time.Now(), reservationID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReleaseExpiredHolds releases the reservations still pending payment after their
+// hold ended, and returns their ids
+func (m *postgresDBRepo) ReleaseExpiredHolds(ctx context.Context, now time.Time) ([]int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var ids []int
+
+	rows, err := m.DB.QueryContext(ctx, releaseHoldsStmt("hold_until < $2 and "+m.roomScope("room_id")), time.Now(), now)
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return ids, err
+	}
+
+	return ids, nil
+}
+
+// CreateInvoice issues the invoice of a reservation with the next number of its
+// property, and returns it. A reservation is invoiced once: if it was already,
+// its invoice is returned unchanged. The counter is bumped in the same
+// transaction as the insert, so numbers have no gaps.
+func (m *postgresDBRepo) CreateInvoice(ctx context.Context, inv models.Invoice) (models.Invoice, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return inv, err
+	}
+	defer tx.Rollback()
+
+	// lock the reservation so it can't be invoiced twice at the same time
+	var invoiced bool
+	err = tx.QueryRowContext(ctx, `select exists (select 1 from invoices where reservation_id = r.id)
+	from reservations r where r.id = $1 and `+m.roomScope("r.room_id")+` for update`, inv.ReservationID).Scan(&invoiced)
+	if err != nil {
+		return inv, err
+	}
+	if invoiced {
+		if err := tx.Commit(); err != nil {
+			return inv, err
+		}
+		return m.InvoiceForReservation(ctx, inv.ReservationID)
+	}
+
+	stmt := `insert into invoice_counters (property_id, last_number) values ($1, 1)
+	on conflict (property_id) do update set last_number = invoice_counters.last_number + 1
+	returning last_number`
+	err = tx.QueryRowContext(ctx, stmt, inv.PropertyID).Scan(&inv.Number)
+	if err != nil {
+		return inv, err
+	}
+
+	inv.Total = 0
+	for _, l := range inv.Lines {
+		inv.Total += l.Amount
+	}
+	inv.IssuedAt = time.Now()
+
+	stmt = `insert into invoices (property_id, number, reservation_id, bill_to, email, currency, total, issued_at)
+	values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+	err = tx.QueryRowContext(ctx, stmt, inv.PropertyID, inv.Number, inv.ReservationID, inv.BillTo, inv.Email,
+		inv.Currency, inv.Total, inv.IssuedAt).Scan(&inv.ID)
+	if err != nil {
+		return inv, err
+	}
+
+	stmt = `insert into invoice_lines (invoice_id, position, description, quantity, unit_price, amount)
+	values ($1, $2, $3, $4, $5, $6)`
+	for i, l := range inv.Lines {
+		_, err = tx.ExecContext(ctx, stmt, inv.ID, i+1, l.Description, l.Quantity, l.UnitPrice, l.Amount)
+		if err != nil {
+			return inv, err
+		}
+	}
+
+	return inv, tx.Commit()
+}
+
+// InvoiceForReservation returns the invoice of a reservation with its lines. It
+// returns sql.ErrNoRows if the reservation wasn't invoiced.
+func (m *postgresDBRepo) InvoiceForReservation(ctx context.Context, reservationID int) (models.Invoice, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var inv models.Invoice
+
+	query := `select id, property_id, number, coalesce(reservation_id, 0), bill_to, email, currency, total, issued_at
+	from invoices where reservation_id = $1 and ` + m.reservationScope("reservation_id")
+	err := m.DB.QueryRowContext(ctx, query, reservationID).Scan(&inv.ID, &inv.PropertyID, &inv.Number,
+		&inv.ReservationID, &inv.BillTo, &inv.Email, &inv.Currency, &inv.Total, &inv.IssuedAt)
+	if err != nil {
+		return inv, err
+	}
+
+	query = `select description, quantity, unit_price, amount from invoice_lines where invoice_id = $1 order by position`
+	rows, err := m.DB.QueryContext(ctx, query, inv.ID)
+	if err != nil {
+		return inv, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l models.InvoiceLine
+		if err := rows.Scan(&l.Description, &l.Quantity, &l.UnitPrice, &l.Amount); err != nil {
+			return inv, err
+		}
+		inv.Lines = append(inv.Lines, l)
+	}
+
+	if err = rows.Err(); err != nil {
+		return inv, err
+	}
+
+	return inv, nil
+}
+
+// GuestToken returns the token of the guest's link to their reservation, creating
+// it the first time
+func (m *postgresDBRepo) GuestToken(ctx context.Context, reservationID int) (string, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	var token string
+	stmt := `update reservations set guest_token = coalesce(guest_token, $1) where id = $2 and ` + m.roomScope("room_id") + ` returning guest_token`
+	err := m.DB.QueryRowContext(ctx, stmt, hex.EncodeToString(b), reservationID).Scan(&token)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ReservationIDByGuestToken returns the reservation a guest link belongs to. It
+// returns sql.ErrNoRows for an unknown token.
+func (m *postgresDBRepo) ReservationIDByGuestToken(ctx context.Context, token string) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, `select id from reservations where guest_token = $1 and `+m.roomScope("room_id"), token).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// ErrPromoCodeExists is returned when saving a promo code whose code is taken
+var ErrPromoCodeExists = errors.New("promo code already exists")
+
+// ErrPromoCodeInUse is returned when deleting a promo code reservations were made with
+var ErrPromoCodeInUse = errors.New("promo code is used by reservations")
+
+// SQLSTATE codes of the constraint violations handled
+const (
+	foreignKeyViolation = "23503"
+	uniqueViolation     = "23505"
+)
+
+// pgErrorCode returns the SQLSTATE code of a postgres error, or an empty string
+func pgErrorCode(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// promoCodeQuery selects promo codes with their rooms, as ids separated by commas,
+// and their redemption figures. Reservations released unpaid don't count.
+const promoCodeQuery = `select p.id, p.code, p.description, p.kind, p.value, p.valid_from, p.valid_to,
+		p.min_nights, p.max_redemptions, p.active, p.created_at, p.updated_at,
+		coalesce((select string_agg(pr.room_id::text, ',' order by pr.room_id) from promo_code_rooms pr where pr.promo_code_id = p.id), ''),
+		count(r.id), coalesce(sum(r.discount), 0), coalesce(sum(r.amount_due), 0)
+	from promo_codes p
+	left join reservations r on (r.promo_code_id = p.id and r.payment_status <> 'released')`
+
+// scanPromoCode scans a row of promoCodeQuery
+func scanPromoCode(row interface{ Scan(...interface{}) error }) (models.PromoCode, error) {
+	var p models.PromoCode
+	var validFrom, validTo sql.NullTime
+	var rooms string
+	err := row.Scan(&p.ID, &p.Code, &p.Description, &p.Kind, &p.Value, &validFrom, &validTo,
+		&p.MinNights, &p.MaxRedemptions, &p.Active, &p.CreatedAt, &p.UpdatedAt,
+		&rooms, &p.Redemptions, &p.Discounted, &p.Revenue)
+	if err != nil {
+		return p, err
+	}
+	p.ValidFrom = validFrom.Time
+	p.ValidTo = validTo.Time
+
+	for _, id := range strings.Split(rooms, ",") {
+		if id == "" {
+			continue
+		}
+		roomID, err := strconv.Atoi(id)
+		if err != nil {
+			return p, err
+		}
+		p.RoomIDs = append(p.RoomIDs, roomID)
+	}
+	return p, nil
+}
+
+// AllPromoCodes returns the promo codes with their redemption figures, active ones first
+func (m *postgresDBRepo) AllPromoCodes(ctx context.Context) ([]models.PromoCode, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var codes []models.PromoCode
+
+	rows, err := m.DB.QueryContext(ctx, promoCodeQuery+` where `+m.propertyScope("p.property_id")+` group by p.id order by p.active desc, p.code`)
+	if err != nil {
+		return codes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanPromoCode(rows)
+		if err != nil {
+			return codes, err
+		}
+		codes = append(codes, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return codes, err
+	}
+
+	return codes, nil
+}
+
+// GetPromoCodeByID returns a promo code with its redemption figures
+func (m *postgresDBRepo) GetPromoCodeByID(ctx context.Context, id int) (models.PromoCode, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	return scanPromoCode(m.DB.QueryRowContext(ctx, promoCodeQuery+` where p.id = $1 and `+m.propertyScope("p.property_id")+` group by p.id`, id))
+}
+
+// GetPromoCodeByCode returns the promo code a guest entered, whatever its case. It
+// returns sql.ErrNoRows for an unknown code.
+func (m *postgresDBRepo) GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	return scanPromoCode(m.DB.QueryRowContext(ctx, promoCodeQuery+` where upper(p.code) = upper($1) and `+m.propertyScope("p.property_id")+` group by p.id`, code))
+}
+
+// promoCodeArgs returns the values of the columns saved from a promo code
+func promoCodeArgs(p models.PromoCode) []interface{} {
+	var validFrom, validTo sql.NullTime
+	if !p.ValidFrom.IsZero() {
+		validFrom = sql.NullTime{Time: p.ValidFrom, Valid: true}
+	}
+	if !p.ValidTo.IsZero() {
+		validTo = sql.NullTime{Time: p.ValidTo, Valid: true}
+	}
+	return []interface{}{models.NormalizeCode(p.Code), p.Description, p.Kind, p.Value, validFrom, validTo,
+		p.MinNights, p.MaxRedemptions, p.Active, time.Now()}
+}
+
+// savePromoCodeRooms replaces the rooms a promo code applies to, leaving out those
+// of other properties
+func (m *postgresDBRepo) savePromoCodeRooms(ctx context.Context, tx *loggedTx, p models.PromoCode) error {
+	_, err := tx.ExecContext(ctx, `delete from promo_code_rooms where promo_code_id = $1`, p.ID)
+	if err != nil {
+		return err
+	}
+	for _, roomID := range p.RoomIDs {
+		_, err = tx.ExecContext(ctx, `insert into promo_code_rooms (promo_code_id, room_id)
+		select $1, id from rooms where id = $2 and `+m.propertyScope("property_id"), p.ID, roomID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// InsertPromoCode adds a promo code and returns its id. It returns
+// ErrPromoCodeExists if the code is taken.
+func (m *postgresDBRepo) InsertPromoCode(ctx context.Context, p models.PromoCode) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `insert into promo_codes (code, description, kind, value, valid_from, valid_to, min_nights, max_redemptions, active, created_at, updated_at, property_id)
+	values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10, $11) returning id`
+	err = tx.QueryRowContext(ctx, stmt, append(promoCodeArgs(p), m.insertPropertyID())...).Scan(&p.ID)
+	if pgErrorCode(err) == uniqueViolation {
+		return 0, ErrPromoCodeExists
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := m.savePromoCodeRooms(ctx, tx, p); err != nil {
+		return 0, err
+	}
+
+	return p.ID, tx.Commit()
+}
+
+// UpdatePromoCode saves a promo code. It returns ErrPromoCodeExists if the code is
+// taken by another one.
+func (m *postgresDBRepo) UpdatePromoCode(ctx context.Context, p models.PromoCode) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update promo_codes set code = $1, description = $2, kind = $3, value = $4, valid_from = $5, valid_to = $6,
+		min_nights = $7, max_redemptions = $8, active = $9, updated_at = $10
+	where id = $11 and ` + m.propertyScope("property_id")
+	result, err := tx.ExecContext(ctx, stmt, append(promoCodeArgs(p), p.ID)...)
+	if pgErrorCode(err) == uniqueViolation {
+		return ErrPromoCodeExists
+	}
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := m.savePromoCodeRooms(ctx, tx, p); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeletePromoCode deletes a promo code. It returns ErrPromoCodeInUse if reservations
+// were made with it, those codes can only be deactivated.
+func (m *postgresDBRepo) DeletePromoCode(ctx context.Context, id int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from promo_codes where id = $1 and `+m.propertyScope("property_id"), id)
+	if pgErrorCode(err) == foreignKeyViolation {
+		return ErrPromoCodeInUse
+	}
+	return err
+}
+
+// PromoCodeRedemptions returns the reservations made with a promo code, latest first
+func (m *postgresDBRepo) PromoCodeRedemptions(ctx context.Context, promoCodeID int) ([]models.Reservation, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := `select r.id, r.room_id, r.email, r.first_name, r.last_name, r.start_date, r.end_date, r.created_at,
+		r.amount_due, r.discount, r.payment_status, rm.room_name
+	from reservations r
+	left join rooms rm on (r.room_id = rm.id)
+	where r.promo_code_id = $1 and ` + m.roomScope("r.room_id") + `
+	order by r.created_at desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, promoCodeID)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.Reservation
+		err := rows.Scan(&r.ID, &r.RoomID, &r.Email, &r.FirstName, &r.LastName, &r.StartDate, &r.EndDate, &r.CreatedAt,
+			&r.AmountDue, &r.Discount, &r.PaymentStatus, &r.Room.RoomName)
+		if err != nil {
+			return reservations, err
+		}
+		r.Room.ID = r.RoomID
+		r.PromoCodeID = promoCodeID
+		reservations = append(reservations, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+}
+
+// ErrCancellationPolicyExists is returned when saving a cancellation policy whose name is taken
+var ErrCancellationPolicyExists = errors.New("cancellation policy already exists")
+
+// ErrCancellationPolicyInUse is returned when deleting a cancellation policy reservations were made with
+var ErrCancellationPolicyInUse = errors.New("cancellation policy is used by reservations")
+
+// cancellationPolicyQuery selects cancellation policies with their rules, as
+// days:percent pairs separated by commas, and their rooms, as ids separated by commas
+const cancellationPolicyQuery = `select cp.id, cp.name, cp.description, cp.created_at, cp.updated_at,
+		coalesce((select string_agg(cr.days_before || ':' || cr.fee_percent, ',' order by cr.days_before desc)
+			from cancellation_policy_rules cr where cr.cancellation_policy_id = cp.id), ''),
+		coalesce((select string_agg(rm.id::text, ',' order by rm.id) from rooms rm where rm.cancellation_policy_id = cp.id), '')
+	from cancellation_policies cp`
+
+// scanCancellationPolicy scans a row of cancellationPolicyQuery
+func scanCancellationPolicy(row interface{ Scan(...interface{}) error }) (models.CancellationPolicy, error) {
+	var p models.CancellationPolicy
+	var rules, rooms string
+	err := row.Scan(&p.ID, &p.Name, &p.Description, &p.CreatedAt, &p.UpdatedAt, &rules, &rooms)
+	if err != nil {
+		return p, err
+	}
+
+	for _, rule := range strings.Split(rules, ",") {
+		if rule == "" {
+			continue
+		}
+		days, percent, _ := strings.Cut(rule, ":")
+		var r models.CancellationRule
+		if r.DaysBefore, err = strconv.Atoi(days); err != nil {
+			return p, err
+		}
+		if r.FeePercent, err = strconv.Atoi(percent); err != nil {
+			return p, err
+		}
+		p.Rules = append(p.Rules, r)
+	}
+
+	for _, id := range strings.Split(rooms, ",") {
+		if id == "" {
+			continue
+		}
+		roomID, err := strconv.Atoi(id)
+		if err != nil {
+			return p, err
+		}
+		p.RoomIDs = append(p.RoomIDs, roomID)
+	}
+	return p, nil
+}
+
+// AllCancellationPolicies returns the cancellation policies by name
+func (m *postgresDBRepo) AllCancellationPolicies(ctx context.Context) ([]models.CancellationPolicy, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var policies []models.CancellationPolicy
+
+	rows, err := m.DB.QueryContext(ctx, cancellationPolicyQuery+` where `+m.propertyScope("cp.property_id")+` order by cp.name`)
+	if err != nil {
+		return policies, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanCancellationPolicy(rows)
+		if err != nil {
+			return policies, err
+		}
+		policies = append(policies, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return policies, err
+	}
+
+	return policies, nil
+}
+
+// GetCancellationPolicyByID returns a cancellation policy with its rules and rooms
+func (m *postgresDBRepo) GetCancellationPolicyByID(ctx context.Context, id int) (models.CancellationPolicy, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	return scanCancellationPolicy(m.DB.QueryRowContext(ctx, cancellationPolicyQuery+` where cp.id = $1 and `+m.propertyScope("cp.property_id"), id))
+}
+
+// saveCancellationPolicy replaces the rules of a cancellation policy, and moves
+// its rooms to it from their previous policy. Rooms of other properties are left out.
+func (m *postgresDBRepo) saveCancellationPolicy(ctx context.Context, tx *loggedTx, p models.CancellationPolicy) error {
+	_, err := tx.ExecContext(ctx, `delete from cancellation_policy_rules where cancellation_policy_id = $1`, p.ID)
+	if err != nil {
+		return err
+	}
+	for _, r := range p.Rules {
+		_, err = tx.ExecContext(ctx, `insert into cancellation_policy_rules (cancellation_policy_id, days_before, fee_percent) values ($1, $2, $3)`,
+			p.ID, r.DaysBefore, r.FeePercent)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `update rooms set cancellation_policy_id = null where cancellation_policy_id = $1`, p.ID)
 	if err != nil {
 		return err
 	}
+	for _, roomID := range p.RoomIDs {
+		_, err = tx.ExecContext(ctx, `update rooms set cancellation_policy_id = $1 where id = $2 and `+m.propertyScope("property_id"), p.ID, roomID)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (m *postgresDBRepo) DeleteBlockByID(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// InsertCancellationPolicy adds a cancellation policy and returns its id. It
+// returns ErrCancellationPolicyExists if the name is taken.
+func (m *postgresDBRepo) InsertCancellationPolicy(ctx context.Context, p models.CancellationPolicy) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `insert into cancellation_policies (name, description, created_at, updated_at, property_id) values ($1, $2, $3, $3, $4) returning id`
+	err = tx.QueryRowContext(ctx, stmt, p.Name, p.Description, time.Now(), m.insertPropertyID()).Scan(&p.ID)
+	if pgErrorCode(err) == uniqueViolation {
+		return 0, ErrCancellationPolicyExists
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := m.saveCancellationPolicy(ctx, tx, p); err != nil {
+		return 0, err
+	}
+
+	return p.ID, tx.Commit()
+}
+
+// UpdateCancellationPolicy saves a cancellation policy. It returns
+// ErrCancellationPolicyExists if the name is taken by another one. The new rules
+// apply to the reservations already made with the policy.
+func (m *postgresDBRepo) UpdateCancellationPolicy(ctx context.Context, p models.CancellationPolicy) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update cancellation_policies set name = $1, description = $2, updated_at = $3 where id = $4 and ` + m.propertyScope("property_id")
+	result, err := tx.ExecContext(ctx, stmt, p.Name, p.Description, time.Now(), p.ID)
+	if pgErrorCode(err) == uniqueViolation {
+		return ErrCancellationPolicyExists
+	}
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := m.saveCancellationPolicy(ctx, tx, p); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteCancellationPolicy deletes a cancellation policy, its rooms are then free to
+// cancel. It returns ErrCancellationPolicyInUse if reservations were made with it.
+func (m *postgresDBRepo) DeleteCancellationPolicy(ctx context.Context, id int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from cancellation_policies where id = $1 and `+m.propertyScope("property_id"), id)
+	if pgErrorCode(err) == foreignKeyViolation {
+		return ErrCancellationPolicyInUse
+	}
+	return err
+}
+
+// CancelReservation cancels a reservation with the fee owed for it and the reason
+// given, and frees its room. It reports whether the reservation was cancelled now,
+// false if it already was.
+func (m *postgresDBRepo) CancelReservation(ctx context.Context, id, fee int, reason string) (bool, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	stmt := `with cancelled as (
+		update reservations set cancelled_at = $1, cancellation_fee = $2, cancellation_reason = $3, updated_at = $1
+		where id = $4 and cancelled_at is null and ` + m.roomScope("room_id") + `
+		returning id
+	), freed as (
+		delete from room_restrictions where reservation_id in (select id from cancelled)
+	)
+	select id from cancelled`
+
+	var cancelledID int
+	err := m.DB.QueryRowContext(ctx, stmt, time.Now(), fee, reason, id).Scan(&cancelledID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ErrPropertyExists is returned when saving a property whose slug or hostname is taken
+var ErrPropertyExists = errors.New("property slug or hostname already exists")
+
+// propertyQuery selects properties with their staff, as user ids separated by commas
+const propertyQuery = `select p.id, p.name, p.slug, coalesce(p.hostname, ''), p.created_at, p.updated_at,
+		coalesce((select string_agg(up.user_id::text, ',' order by up.user_id) from user_properties up where up.property_id = p.id), '')
+	from properties p`
+
+// scanProperty scans a row of propertyQuery
+func scanProperty(row interface{ Scan(...interface{}) error }) (models.Property, error) {
+	var p models.Property
+	var users string
+	err := row.Scan(&p.ID, &p.Name, &p.Slug, &p.Hostname, &p.CreatedAt, &p.UpdatedAt, &users)
+	if err != nil {
+		return p, err
+	}
+
+	for _, id := range strings.Split(users, ",") {
+		if id == "" {
+			continue
+		}
+		userID, err := strconv.Atoi(id)
+		if err != nil {
+			return p, err
+		}
+		p.UserIDs = append(p.UserIDs, userID)
+	}
+	return p, nil
+}
+
+// GetPropertyByID returns a property with its staff
+func (m *postgresDBRepo) GetPropertyByID(ctx context.Context, id int) (models.Property, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	return scanProperty(m.DB.QueryRowContext(ctx, propertyQuery+` where p.id = $1`, id))
+}
+
+// PropertyByHost returns the property served on a hostname, whatever its case. It
+// returns sql.ErrNoRows if no property claims it.
+func (m *postgresDBRepo) PropertyByHost(ctx context.Context, host string) (models.Property, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	return scanProperty(m.DB.QueryRowContext(ctx, propertyQuery+` where lower(p.hostname) = lower($1)`, host))
+}
+
+// PropertyBySlug returns the property served under /p/{slug}. It returns
+// sql.ErrNoRows for an unknown slug.
+func (m *postgresDBRepo) PropertyBySlug(ctx context.Context, slug string) (models.Property, error) {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 
-	query := `delete from room_restrictions where id = $1`
+	return scanProperty(m.DB.QueryRowContext(ctx, propertyQuery+` where lower(p.slug) = lower($1)`, slug))
+}
+
+// UserProperties returns the properties a staff member manages, by name
+func (m *postgresDBRepo) UserProperties(ctx context.Context, userID int) ([]models.Property, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var properties []models.Property
+
+	query := propertyQuery + ` where p.id in (select property_id from user_properties where user_id = $1) order by p.name`
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return properties, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p, err := scanProperty(rows)
+		if err != nil {
+			return properties, err
+		}
+		properties = append(properties, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return properties, err
+	}
+
+	return properties, nil
+}
+
+// propertyArgs returns the name, slug and hostname saved for a property, a
+// property without hostname is only served under its slug
+func propertyArgs(p models.Property) []interface{} {
+	var hostname sql.NullString
+	if p.Hostname != "" {
+		hostname = sql.NullString{String: strings.ToLower(p.Hostname), Valid: true}
+	}
+	return []interface{}{p.Name, strings.ToLower(p.Slug), hostname, time.Now()}
+}
 
-	_, err := m.DB.ExecContext(ctx, query, id)
+// saveUserProperties replaces the staff members managing a property
+func saveUserProperties(ctx context.Context, tx *loggedTx, p models.Property) error {
+	_, err := tx.ExecContext(ctx, `delete from user_properties where property_id = $1`, p.ID)
 	if err != nil {
 		return err
 	}
+	for _, userID := range p.UserIDs {
+		_, err = tx.ExecContext(ctx, `insert into user_properties (user_id, property_id) values ($1, $2)`, userID, p.ID)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
+
+// InsertProperty adds a property managed by its UserIDs and returns its id. It
+// returns ErrPropertyExists if the slug or hostname is taken.
+func (m *postgresDBRepo) InsertProperty(ctx context.Context, p models.Property) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	stmt := `insert into properties (name, slug, hostname, created_at, updated_at) values ($1, $2, $3, $4, $4) returning id`
+	err = tx.QueryRowContext(ctx, stmt, propertyArgs(p)...).Scan(&p.ID)
+	if pgErrorCode(err) == uniqueViolation {
+		return 0, ErrPropertyExists
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := saveUserProperties(ctx, tx, p); err != nil {
+		return 0, err
+	}
+
+	return p.ID, tx.Commit()
+}
+
+// UpdateProperty saves a property and its staff. It returns ErrPropertyExists if
+// the slug or hostname is taken by another one.
+func (m *postgresDBRepo) UpdateProperty(ctx context.Context, p models.Property) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update properties set name = $1, slug = $2, hostname = $3, updated_at = $4 where id = $5`
+	result, err := tx.ExecContext(ctx, stmt, append(propertyArgs(p), p.ID)...)
+	if pgErrorCode(err) == uniqueViolation {
+		return ErrPropertyExists
+	}
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := saveUserProperties(ctx, tx, p); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ListUsers returns, by name, the staff members managing one of the properties the
+// staff member userID manages, themselves included
+func (m *postgresDBRepo) ListUsers(ctx context.Context, userID int) ([]models.User, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var users []models.User
+
+	query := `select id, first_name, last_name, email, access_level, created_at, updated_at from users
+	where id in (select up.user_id from user_properties up
+		join user_properties mine on (mine.property_id = up.property_id) where mine.user_id = $1)
+	order by last_name, first_name`
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u models.User
+		err := rows.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.AccessLevel, &u.CreatedAt, &u.UpdatedAt)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return users, err
+	}
+
+	return users, nil
+}
+
+// InsertRoom adds a room to the property of the repository and returns its id
+func (m *postgresDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	stmt := `insert into rooms (room_name, price, property_id, min_nights, created_at, updated_at) values ($1, $2, $3, $4, $5, $5) returning id`
+	err = tx.QueryRowContext(ctx, stmt, room.RoomName, room.Price, m.insertPropertyID(), max(room.MinNights, 1), time.Now()).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, name := range models.UnitNames(room.RoomName, room.Units) {
+		_, err := tx.ExecContext(ctx, `insert into room_units (room_id, name, created_at, updated_at) values ($1, $2, $3, $3)`,
+			id, name, time.Now())
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return id, tx.Commit()
+}
+
+// AllRoomUnits returns the units of every room, ordered by room and name
+func (m *postgresDBRepo) AllRoomUnits(ctx context.Context) ([]models.RoomUnit, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	var units []models.RoomUnit
+
+	query := `select id, room_id, name, created_at, updated_at from room_units
+	where ` + m.roomScope("room_id") + `
+	order by room_id, length(name), name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return units, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u models.RoomUnit
+		err := rows.Scan(&u.ID, &u.RoomID, &u.Name, &u.CreatedAt, &u.UpdatedAt)
+		if err != nil {
+			return units, err
+		}
+		units = append(units, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return units, err
+	}
+
+	return units, nil
+}
+
+// ErrUnitExists is returned when a room already has a unit with the same name
+var ErrUnitExists = errors.New("the room already has a unit with this name")
+
+// AddRoomUnit adds a unit to a room and returns its id. It returns ErrUnitExists if
+// the room has a unit with the same name.
+func (m *postgresDBRepo) AddRoomUnit(ctx context.Context, roomID int, name string) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	if err := m.ownRoom(ctx, m.DB, roomID); err != nil {
+		return 0, err
+	}
+
+	var id int
+	stmt := `insert into room_units (room_id, name, created_at, updated_at) values ($1, $2, $3, $3) returning id`
+	err := m.DB.QueryRowContext(ctx, stmt, roomID, name, time.Now()).Scan(&id)
+	if pgErrorCode(err) == uniqueViolation {
+		return 0, ErrUnitExists
+	}
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// AssignUnit moves a reservation to another unit of its room. It returns
+// ErrRestrictionConflict if the unit is booked or blocked during the stay, and
+// sql.ErrNoRows if the reservation holds no unit or the unit isn't of its room.
+func (m *postgresDBRepo) AssignUnit(ctx context.Context, reservationID, unitID int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	var start, end time.Time
+	query := `select rr.room_id, rr.start_date, rr.end_date from room_restrictions rr
+	join room_units u on (u.room_id = rr.room_id and u.id = $2)
+	where rr.reservation_id = $1 and ` + m.roomScope("rr.room_id")
+	err = tx.QueryRowContext(ctx, query, reservationID, unitID).Scan(&roomID, &start, &end)
+	if err != nil {
+		return err
+	}
+
+	if err := lockUnits(ctx, tx, roomID); err != nil {
+		return err
+	}
+	if free, err := freeUnit(ctx, tx, roomID, start, end, reservationID, unitID); err != nil && !errors.Is(err, ErrRestrictionConflict) {
+		return err
+	} else if free != unitID {
+		return ErrRestrictionConflict
+	}
+
+	_, err = tx.ExecContext(ctx, `update room_restrictions set unit_id = $1, updated_at = $2 where reservation_id = $3`,
+		unitID, time.Now(), reservationID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,104 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// slowQuery is the duration from which a query is logged as slow
+const slowQuery = 500 * time.Millisecond
+
+// queryLogger logs the queries that fail or are slow, with the context of the
+// call so each line carries the request id
+type queryLogger struct {
+	logger *slog.Logger
+}
+
+// done logs query if it returned err or took longer than slowQuery since start
+func (l queryLogger) done(ctx context.Context, query string, start time.Time, err error) {
+	elapsed := time.Since(start)
+	switch {
+	case errors.Is(err, context.Canceled):
+		l.logger.WarnContext(ctx, "query cancelled", "query", compactQuery(query), "duration", elapsed)
+	case err != nil:
+		l.logger.ErrorContext(ctx, "query failed", "query", compactQuery(query), "duration", elapsed, "error", err)
+	case elapsed >= slowQuery:
+		l.logger.WarnContext(ctx, "slow query", "query", compactQuery(query), "duration", elapsed)
+	}
+}
+
+// compactQuery puts query on one line for the log
+func compactQuery(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+// loggedDB is the connection pool of the repository, logging its queries
+type loggedDB struct {
+	*sql.DB
+	log queryLogger
+}
+
+func (db *loggedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	res, err := db.DB.ExecContext(ctx, query, args...)
+	db.log.done(ctx, query, start, err)
+	return res, err
+}
+
+func (db *loggedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	db.log.done(ctx, query, start, err)
+	return rows, err
+}
+
+// QueryRowContext logs the errors of the query itself, sql.ErrNoRows is only
+// known to the caller when it scans the row
+func (db *loggedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	db.log.done(ctx, query, start, row.Err())
+	return row
+}
+
+// BeginTx starts a transaction logging its queries as well
+func (db *loggedDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*loggedTx, error) {
+	start := time.Now()
+	tx, err := db.DB.BeginTx(ctx, opts)
+	db.log.done(ctx, "begin", start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &loggedTx{Tx: tx, log: db.log}, nil
+}
+
+// loggedTx is a transaction of the repository, logging its queries
+type loggedTx struct {
+	*sql.Tx
+	log queryLogger
+}
+
+func (tx *loggedTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	res, err := tx.Tx.ExecContext(ctx, query, args...)
+	tx.log.done(ctx, query, start, err)
+	return res, err
+}
+
+func (tx *loggedTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := tx.Tx.QueryContext(ctx, query, args...)
+	tx.log.done(ctx, query, start, err)
+	return rows, err
+}
+
+func (tx *loggedTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := tx.Tx.QueryRowContext(ctx, query, args...)
+	tx.log.done(ctx, query, start, row.Err())
+	return row
+}
@@ -0,0 +1,49 @@
+package dbrepo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/logger"
+)
+
+func TestQueryLogger(t *testing.T) {
+	var tests = []struct {
+		name     string
+		elapsed  time.Duration
+		err      error
+		expected string
+	}{
+		{"fast", 0, nil, ""},
+		{"failed", 0, errors.New("relation does not exist"), `level=ERROR msg="query failed"`},
+		{"cancelled", 0, context.Canceled, `level=WARN msg="query cancelled"`},
+		{"slow", slowQuery, nil, `level=WARN msg="slow query"`},
+	}
+
+	for _, e := range tests {
+		var buf bytes.Buffer
+		l := queryLogger{logger: logger.New(&buf, "text", "info")}
+		ctx := logger.WithAttrs(context.Background(), slog.String("request_id", "abc-123"))
+
+		l.done(ctx, "select id\n\tfrom rooms", time.Now().Add(-e.elapsed), e.err)
+
+		out := buf.String()
+		if e.expected == "" {
+			if out != "" {
+				t.Errorf("%s: expected nothing logged, got %q", e.name, out)
+			}
+			continue
+		}
+		if !strings.Contains(out, e.expected) {
+			t.Errorf("%s: expected %q in %q", e.name, e.expected, out)
+		}
+		if !strings.Contains(out, "request_id=abc-123") || !strings.Contains(out, `query="select id from rooms"`) {
+			t.Errorf("%s: expected the request id and the query in %q", e.name, out)
+		}
+	}
+}
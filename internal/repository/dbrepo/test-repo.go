@@ -1,36 +1,81 @@
 package dbrepo
 
 import (
+	"context"
+	"database/sql"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/florian-lahitte-uvi/bookings/internal/models"
+	"github.com/florian-lahitte-uvi/bookings/internal/repository"
 )
 
-func (m *testDBRepo) AllUsers() bool {
-	return true
+func (m *testDBRepo) ForProperty(propertyID int) repository.DatabaseRepo {
+	scoped := *m
+	scoped.propertyID = propertyID
+	return &scoped
 }
 
-// InsertReservation inserts a reservation into the database
-func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
+// otherProperty reports whether the repository is scoped to a property other than
+// the default one. For testing: those have no rooms, reservations or guests.
+func (m *testDBRepo) otherProperty() bool {
+	return m.propertyID > models.DefaultPropertyID
+}
+
+func (m *testDBRepo) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *testDBRepo) SchemaVersion(ctx context.Context) (string, error) {
+	return "20250811195409", nil
+}
+
+// InsertReservation inserts a reservation and its room restriction
+func (m *testDBRepo) InsertReservation(ctx context.Context, res models.Reservation, holdID int) (int, error) {
 	// if the room id is 2, then fail; otherwise, pass
 	if res.RoomID == 2 {
 		return 0, errors.New("error inserting reservation")
 	}
+	if res.RoomID == 1000 {
+		return 0, errors.New("error inserting room restriction")
+	}
+	// 2050 dates are taken unless the guest holds the room, and 2060 dates fail
+	switch res.StartDate.Year() {
+	case 2050:
+		if holdID == 0 {
+			return 0, ErrRestrictionConflict
+		}
+	case 2060:
+		return 0, errors.New("error inserting room restriction")
+	}
+	// promo code 3 is used up, and the guest used promo code 4 already
+	switch res.PromoCodeID {
+	case 3:
+		return 0, models.ErrPromoUsedUp
+	case 4:
+		return 0, models.ErrPromoAlreadyUsed
+	}
 	return 1, nil
 }
 
-// InsertRoomRestriction inserts a room restriction into the database
-func (m *testDBRepo) InsertRoomRestriction(restriction models.RoomRestriction) error {
-	if restriction.RoomID == 1000 {
-		return errors.New("error inserting room restriction")
+// CreateReservation inserts a reservation and its room restriction
+func (m *testDBRepo) CreateReservation(ctx context.Context, res models.Reservation, override bool) (int, error) {
+	// if the room id is 2, then fail; otherwise, pass
+	if res.RoomID == 2 {
+		return 0, errors.New("error creating reservation")
 	}
-	return nil
+	// 2050 dates are taken, and so is the 2040 Christmas stay once it has been searched
+	taken := res.StartDate.Year() == 2050 || res.StartDate.Equal(time.Date(2040, 12, 25, 0, 0, 0, 0, time.UTC))
+	if taken && !override {
+		return 0, ErrRestrictionConflict
+	}
+	return 1, nil
 }
 
 // return true if there are no room restrictions for the given dates
 // return false if there are room restrictions for the given dates
-func (m *testDBRepo) SearchAvaibilityByDatesByRoomID(RoomID int, start, end time.Time) (bool, error) {
+func (m *testDBRepo) SearchAvaibilityByDatesByRoomID(ctx context.Context, RoomID int, start, end time.Time) (bool, error) {
 	// For testing: 2040 dates are available, 2050 dates are not, 2060 dates cause error
 	if start.Year() == 2060 {
 		return false, errors.New("database error")
@@ -43,7 +88,7 @@ func (m *testDBRepo) SearchAvaibilityByDatesByRoomID(RoomID int, start, end time
 
 // SearchAvaibilityForAllRooms returns a slice of available rooms for the given dates
 // It returns an empty slice if there are no available rooms
-func (m *testDBRepo) SearchAvaibilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+func (m *testDBRepo) SearchAvaibilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
 	var rooms []models.Room
 
 	// For testing: 2060 dates cause error, 2040 dates return rooms, 2050 dates return no rooms
@@ -53,8 +98,10 @@ func (m *testDBRepo) SearchAvaibilityForAllRooms(start, end time.Time) ([]models
 
 	if start.Year() == 2040 {
 		room := models.Room{
-			ID:       1,
-			RoomName: "General's Quarters",
+			ID:        1,
+			RoomName:  "General's Quarters",
+			Units:     2,
+			Available: 1,
 		}
 		rooms = append(rooms, room)
 	}
@@ -62,17 +109,57 @@ func (m *testDBRepo) SearchAvaibilityForAllRooms(start, end time.Time) ([]models
 	return rooms, nil
 }
 
+// SearchAvailabilityExcludingReservation follows SearchAvaibilityByDatesByRoomID, except that
+// reservation 1 owns the 2050 dates, so they stay available to it
+func (m *testDBRepo) SearchAvailabilityExcludingReservation(ctx context.Context, roomID int, start, end time.Time, reservationID int) (bool, error) {
+	if start.Year() == 2050 && reservationID == 1 {
+		return true, nil
+	}
+	return m.SearchAvaibilityByDatesByRoomID(ctx, roomID, start, end)
+}
+
 // get RoomByID returns a room
-func (m *testDBRepo) GetRoomByID(id int) (models.Room, error) {
+func (m *testDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
 	var room models.Room
 	if id > 2 {
 		return room, errors.New("room not found")
 	}
+	if m.otherProperty() {
+		return room, sql.ErrNoRows
+	}
+
+	// For testing: a night in room 1 is 100.00, and room 2 is free. Room 1 has two
+	// units, room 2 one.
+	room.ID = id
+	room.Units = 1
+	room.MinNights = 1
+	if id == 1 {
+		room.Price = 10000
+		room.Units = 2
+	}
 
 	return room, nil
 }
 
-func (m *testDBRepo) GetUserByID(id int) (models.User, error) {
+func (m *testDBRepo) RoomAvailability(ctx context.Context, roomID int, start, end time.Time) ([]models.NightAvailability, error) {
+	var nights []models.NightAvailability
+
+	// For testing: periods starting in 2060 fail, and the second night of the others
+	// is taken
+	if start.Year() == 2060 {
+		return nights, errors.New("database error")
+	}
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		n := models.NightAvailability{Date: d, Free: 1, Price: 10000}
+		if d.Equal(start.AddDate(0, 0, 1)) {
+			n.Free = 0
+		}
+		nights = append(nights, n)
+	}
+	return nights, nil
+}
+
+func (m *testDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
 	var u models.User
 	if id > 2 {
 		return u, errors.New("User not found")
@@ -80,74 +167,833 @@ func (m *testDBRepo) GetUserByID(id int) (models.User, error) {
 	return u, nil
 }
 
-func (m *testDBRepo) UpdateUser(u models.User) error {
+func (m *testDBRepo) UpdateUser(ctx context.Context, u models.User) error {
 	if u.ID == 0 {
 		return errors.New("User not found")
 	}
 	return nil
 }
 
-func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, error) {
+func (m *testDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
 	if email == "me@here.ca" && testPassword == "password" {
 		return 1, "hashedPassword", nil
 	}
 	return 0, "", errors.New("invalid credentials")
 }
 
-func (m *testDBRepo) AllReservations() ([]models.Reservation, error) {
+func (m *testDBRepo) ListReservations(ctx context.Context, q repository.ReservationQuery) (repository.ReservationPage, error) {
+	var page repository.ReservationPage
 
-	var reservations []models.Reservation
+	// For testing: searching for "error" fails, anything else returns one reservation
+	if q.Search == "error" {
+		return page, errors.New("database error")
+	}
 
-	return reservations, nil
-}
+	page.Reservations = append(page.Reservations, models.Reservation{
+		ID:        1,
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		RoomID:    1,
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+	})
+	page.NextCursor = "next"
+	if q.After != "" {
+		page.PrevCursor = "prev"
+	}
 
-func (m *testDBRepo) AllNewReservations() ([]models.Reservation, error) {
+	return page, nil
+}
 
-	var reservations []models.Reservation
+func (m *testDBRepo) EachReservation(ctx context.Context, q repository.ReservationQuery, fn func(models.Reservation) error) error {
+	// For testing: searching for "error" fails, searching for "broken" fails after the
+	// first page, anything else streams the first page
+	page, err := m.ListReservations(ctx, q)
+	if err != nil {
+		return err
+	}
+	for _, r := range page.Reservations {
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	if q.Search == "broken" {
+		return errors.New("connection lost")
+	}
+	return nil
+}
 
-	return reservations, nil
+func (m *testDBRepo) CountNewReservations(ctx context.Context) (int, error) {
+	return 0, nil
 }
 
-func (m *testDBRepo) GetReservationByID(id int) (models.Reservation, error) {
+func (m *testDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
 
 	var res models.Reservation
 	if id > 2 {
 		return res, errors.New("Reservation not found")
 	}
+	if m.otherProperty() {
+		return res, sql.ErrNoRows
+	}
+
+	// For testing: every reservation is in room 1 for the first two nights of 2050,
+	// and only reservation 1 has an email address
+	res.ID = id
+	res.FirstName = "John"
+	res.LastName = "Smith"
+	if id == 1 {
+		res.Email = "john@smith.com"
+	}
+	res.RoomID = 1
+	res.Room = models.Room{ID: 1, RoomName: "General's Quarters", PropertyID: models.DefaultPropertyID}
+	res.UnitID, res.Unit = 3, "General's Quarters 2"
+	res.StartDate = time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	res.EndDate = time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC)
+	res.GuestID = 1
+	res.AmountDue = 20000
+	res.PaymentStatus = models.PaymentNone
+	res.CancellationPolicyID = id
+	res.CancellationPolicy = testCancellationPolicies[id-1].Name
 
 	return res, nil
 }
 
-func (m *testDBRepo) UpdateReservation(r models.Reservation) error {
+func (m *testDBRepo) UpdateReservation(ctx context.Context, r models.Reservation) error {
 	return nil
 }
 
-func (m *testDBRepo) DeleteReservation(id int) error {
+func (m *testDBRepo) MoveReservation(ctx context.Context, r models.Reservation) error {
+	// For testing: moving to room 2 fails
+	if r.RoomID == 2 {
+		return errors.New("error moving reservation")
+	}
 	return nil
 }
 
-func (m *testDBRepo) UpdateProcessedForReservation(id, processed int) error {
+func (m *testDBRepo) DeleteReservation(ctx context.Context, id int) error {
 	return nil
 }
 
-func (m *testDBRepo) AllRooms() ([]models.Room, error) {
-	var rooms []models.Room
+func (m *testDBRepo) UpdateProcessedForReservation(ctx context.Context, id, processed int) error {
+	return nil
+}
+
+func (m *testDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	if m.otherProperty() {
+		return nil, nil
+	}
+	rooms := []models.Room{{ID: 1, RoomName: "General's Quarters", Price: 10000, Units: 2, MinNights: 1}, {ID: 2, RoomName: "Major's Suite", Units: 1, MinNights: 1}}
 	return rooms, nil
 }
 
-func (m *testDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+func (m *testDBRepo) FreeUnitNights(ctx context.Context, start, end time.Time) ([]models.RoomNight, error) {
+	var nights []models.RoomNight
+
+	// For testing: periods ending in 2060 fail. From 2050-01-01 to 2050-01-03, unit 2
+	// of room 2 is only free on the first night, unit 1 of room 1 on the second, and
+	// unit 3 of room 1 on none. Every unit is free on the other nights.
+	if end.Year() == 2060 {
+		return nights, errors.New("database error")
+	}
+	taken := func(unitID int, d time.Time) bool {
+		first := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+		switch d.Sub(first).Hours() / 24 {
+		case 0:
+			return unitID != 2
+		case 1:
+			return unitID != 1
+		case 2:
+			return true
+		}
+		return false
+	}
+	for _, u := range []models.RoomUnit{{ID: 1, RoomID: 1}, {ID: 3, RoomID: 1}, {ID: 2, RoomID: 2}} {
+		for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+			if !taken(u.ID, d) {
+				nights = append(nights, models.RoomNight{Date: d, Room: models.Room{ID: u.RoomID}, Unit: u})
+			}
+		}
+	}
+	return nights, nil
+}
+
+func (m *testDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+
+	var restrictions []models.RoomRestriction
+
+	return restrictions, nil
+}
 
+func (m *testDBRepo) RestrictionsForPeriod(ctx context.Context, start, end time.Time) ([]models.RoomRestriction, error) {
 	var restrictions []models.RoomRestriction
 
+	// For testing: periods starting in 2060 fail, others have a reservation in room 1
+	// on the first two nights and a one-night block in room 2 on the third
+	if start.Year() == 2060 {
+		return restrictions, errors.New("database error")
+	}
+
+	restrictions = append(restrictions,
+		models.RoomRestriction{
+			ID:            1,
+			RoomID:        1,
+			UnitID:        3,
+			ReservationID: 1,
+			RestrictionID: 1,
+			StartDate:     start,
+			EndDate:       start.AddDate(0, 0, 2),
+			Reservation:   models.Reservation{ID: 1, FirstName: "John", LastName: "Smith"},
+		},
+		models.RoomRestriction{
+			ID:            2,
+			RoomID:        2,
+			UnitID:        2,
+			RestrictionID: 2,
+			StartDate:     start.AddDate(0, 0, 2),
+			EndDate:       start.AddDate(0, 0, 2),
+		},
+	)
 	return restrictions, nil
 }
 
-func (m *testDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
+func (m *testDBRepo) InsertBlock(ctx context.Context, unitID int, start, end time.Time) (int, error) {
+	// For testing: 2050 dates are taken, 2060 dates and unit 1000 fail, and only
+	// units 1 to 3 exist
+	if start.Year() == 2060 || unitID == 1000 {
+		return 0, errors.New("error inserting block")
+	}
+	if unitID < 1 || unitID > 3 {
+		return 0, sql.ErrNoRows
+	}
+	if start.Year() == 2050 {
+		return 0, ErrRestrictionConflict
+	}
+	return 1, nil
+}
+
+func (m *testDBRepo) DeleteBlockByID(ctx context.Context, id int) error {
+	// For testing: only blocks 1 and 2 exist, and deleting block 2 fails
+	if id == 2 {
+		return errors.New("error deleting block")
+	}
+	if id != 1 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (m *testDBRepo) HoldRoom(ctx context.Context, roomID int, start, end, until time.Time) (int, error) {
+	// For testing: 2050 dates are taken and 2060 dates fail
+	switch start.Year() {
+	case 2050:
+		return 0, ErrRestrictionConflict
+	case 2060:
+		return 0, errors.New("error holding room")
+	}
+	return 1, nil
+}
+
+func (m *testDBRepo) ReleaseRoomHold(ctx context.Context, id int) error {
+	// For testing: releasing hold 2 fails
+	if id == 2 {
+		return errors.New("error releasing room hold")
+	}
+	return nil
+}
+
+func (m *testDBRepo) ReleaseExpiredRoomHolds(ctx context.Context, now time.Time) (int, error) {
+	// For testing: room holds expire in 2060, and checking in 2070 fails
+	switch now.Year() {
+	case 2060:
+		return 3, nil
+	case 2070:
+		return 0, errors.New("error releasing room holds")
+	}
+	return 0, nil
+}
+
+func (m *testDBRepo) ImportRestrictions(ctx context.Context, rows []models.RoomRestriction) error {
+	// For testing: room 1000 fails the whole import
+	for _, row := range rows {
+		if row.RoomID == 1000 {
+			return errors.New("error importing room restrictions")
+		}
+	}
+	return nil
+}
+
+func (m *testDBRepo) ArrivalsForDate(ctx context.Context, day time.Time) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+	if day.Year() == 2060 {
+		return reservations, errors.New("database error")
+	}
+
+	reservations = append(reservations, models.Reservation{
+		ID:        1,
+		FirstName: "John",
+		LastName:  "Smith",
+		StartDate: day,
+		EndDate:   day.AddDate(0, 0, 2),
+		RoomID:    1,
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+	})
+	return reservations, nil
+}
+
+func (m *testDBRepo) DeparturesForDate(ctx context.Context, day time.Time) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+	return reservations, nil
+}
+
+func (m *testDBRepo) CountInHouseReservations(ctx context.Context, day time.Time) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) OccupancyByRoom(ctx context.Context, start, end time.Time) ([]models.RoomOccupancy, error) {
+	total := int(end.Sub(start).Hours() / 24)
+	occupancy := []models.RoomOccupancy{
+		{Room: models.Room{ID: 1, RoomName: "General's Quarters"}, BookedNights: total / 2, TotalNights: total},
+		{Room: models.Room{ID: 2, RoomName: "Major's Suite"}, BookedNights: 0, TotalNights: total},
+	}
+	return occupancy, nil
+}
+
+func (m *testDBRepo) ReservationsCreatedByDay(ctx context.Context, start, end time.Time) ([]models.DailyCount, error) {
+	var counts []models.DailyCount
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		counts = append(counts, models.DailyCount{Date: d, Count: 1})
+	}
+	return counts, nil
+}
+
+func (m *testDBRepo) AverageLengthOfStay(ctx context.Context, start, end time.Time) (float64, error) {
+	return 2.5, nil
+}
+
+func (m *testDBRepo) EachRoomNight(ctx context.Context, start, end time.Time, fn func(models.RoomNight) error) error {
+	// For testing: periods starting in 2060 fail
+	if start.Year() == 2060 {
+		return errors.New("database error")
+	}
+
+	rooms := []models.Room{{ID: 1, RoomName: "General's Quarters"}, {ID: 2, RoomName: "Major's Suite"}}
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		for _, rm := range rooms {
+			n := models.RoomNight{Date: d, Room: rm, Unit: models.RoomUnit{ID: rm.ID, RoomID: rm.ID, Name: rm.RoomName}}
+			// the first room is reserved on the first night and blocked on the second
+			if rm.ID == 1 && d.Equal(start) {
+				n.ReservationID, n.RestrictionID, n.GuestName = 1, 1, "John Smith"
+			} else if rm.ID == 1 && d.Equal(start.AddDate(0, 0, 1)) {
+				n.RestrictionID = 2
+			}
+			if err := fn(n); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *testDBRepo) ListGuests(ctx context.Context, search string) ([]models.Guest, error) {
+	var guests []models.Guest
+
+	// For testing: searching for "error" fails, anything else returns one guest
+	if search == "error" {
+		return guests, errors.New("database error")
+	}
+
+	guests = append(guests, models.Guest{
+		ID:        1,
+		Email:     "john@smith.com",
+		FirstName: "John",
+		LastName:  "Smith",
+		Tags:      []string{models.TagVIP},
+		Stays:     2,
+		Nights:    5,
+	})
+	return guests, nil
+}
+
+func (m *testDBRepo) GetGuestByID(ctx context.Context, id int) (models.Guest, error) {
+	var g models.Guest
+	if id > 2 {
+		return g, errors.New("guest not found")
+	}
+
+	g.ID = id
+	g.Email = "john@smith.com"
+	g.FirstName = "John"
+	g.LastName = "Smith"
+	g.Notes = "Prefers a quiet room"
+	g.Tags = []string{models.TagVIP}
+	return g, nil
+}
+
+func (m *testDBRepo) GuestStays(ctx context.Context, guestID int) ([]models.Reservation, error) {
+	var stays []models.Reservation
+
+	// For testing: guest 2 fails, others stayed 3 nights in 2020 and come back for 2 in 2040
+	if guestID == 2 {
+		return stays, errors.New("database error")
+	}
+
+	room := models.Room{ID: 1, RoomName: "General's Quarters"}
+	stays = append(stays,
+		models.Reservation{ID: 2, RoomID: 1, Room: room, FirstName: "John", LastName: "Smith",
+			StartDate: time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2040, 1, 3, 0, 0, 0, 0, time.UTC)},
+		models.Reservation{ID: 1, RoomID: 1, Room: room, FirstName: "John", LastName: "Smith",
+			StartDate: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2020, 1, 4, 0, 0, 0, 0, time.UTC)},
+	)
+	return stays, nil
+}
+
+func (m *testDBRepo) UpdateGuest(ctx context.Context, g models.Guest) error {
+	// For testing: guest 2 can't be saved
+	if g.ID == 2 {
+		return errors.New("error updating guest")
+	}
+	return nil
+}
+
+func (m *testDBRepo) AddReservationNote(ctx context.Context, n models.ReservationNote) (int, error) {
+	// For testing: notes on reservation 2 fail
+	if n.ReservationID == 2 {
+		return 0, errors.New("error adding note")
+	}
+	return 1, nil
+}
+
+func (m *testDBRepo) ReservationNotes(ctx context.Context, reservationID int) ([]models.ReservationNote, error) {
+	notes := []models.ReservationNote{
+		{ID: 1, ReservationID: reservationID, UserID: 1, Author: "Admin User", Body: "Asked for an early check-in",
+			CreatedAt: time.Date(2040, 1, 1, 9, 0, 0, 0, time.UTC)},
+	}
+	return notes, nil
+}
+
+func (m *testDBRepo) AddReservationMessage(ctx context.Context, msg models.ReservationMessage) (int, error) {
+	// For testing: messages about reservation 2 fail
+	if msg.ReservationID == 2 {
+		return 0, errors.New("error adding message")
+	}
+	return 1, nil
+}
+
+func (m *testDBRepo) ReservationMessages(ctx context.Context, reservationID int) ([]models.ReservationMessage, error) {
+	messages := []models.ReservationMessage{
+		{ID: 1, ReservationID: reservationID, Direction: models.MessageOut, UserID: 1, Author: "Admin User",
+			From: "me@here.com", To: "john@smith.com", Subject: "Your stay", Body: "Anything we can prepare?",
+			CreatedAt: time.Date(2040, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{ID: 2, ReservationID: reservationID, Direction: models.MessageIn,
+			From: "john@smith.com", To: "reply+abc123@bookings.test", Subject: "Re: Your stay", Body: "Extra pillows please",
+			CreatedAt: time.Date(2040, 1, 1, 11, 0, 0, 0, time.UTC)},
+	}
+	return messages, nil
+}
+
+func (m *testDBRepo) ReplyToken(ctx context.Context, reservationID int) (string, error) {
+	if reservationID > 2 {
+		return "", sql.ErrNoRows
+	}
+	return "abc123", nil
+}
+
+func (m *testDBRepo) ReservationIDByReplyToken(ctx context.Context, token string) (int, error) {
+	// For testing: abc123 belongs to reservation 1, def456 to reservation 2, and
+	// fail is a database error
+	switch token {
+	case "abc123":
+		return 1, nil
+	case "def456":
+		return 2, nil
+	case "fail":
+		return 0, errors.New("database error")
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (m *testDBRepo) AddPayment(ctx context.Context, p models.Payment) (int, error) {
+	// For testing: payments on reservation 2 fail
+	if p.ReservationID == 2 {
+		return 0, errors.New("error adding payment")
+	}
+	return 1, nil
+}
+
+func (m *testDBRepo) ReservationPayments(ctx context.Context, reservationID int) ([]models.Payment, error) {
+	payments := []models.Payment{
+		{ID: 1, ReservationID: reservationID, Kind: models.PaymentDeposit, Method: models.MethodTransfer, Amount: 5000,
+			Reference: "TR-1", UserID: 1, Author: "Admin User", CreatedAt: time.Date(2040, 1, 1, 9, 0, 0, 0, time.UTC)},
+		{ID: 2, ReservationID: reservationID, Kind: models.PaymentRefund, Method: models.MethodCash, Amount: 1000,
+			UserID: 1, Author: "Admin User", CreatedAt: time.Date(2040, 1, 2, 9, 0, 0, 0, time.UTC)},
+	}
+	return payments, nil
+}
 
+func (m *testDBRepo) UpdateAmountDue(ctx context.Context, reservationID, amount int) error {
+	// For testing: reservation 2 fails
+	if reservationID == 2 {
+		return errors.New("error updating amount due")
+	}
 	return nil
 }
 
-func (m *testDBRepo) DeleteBlockByID(id int) error {
+func (m *testDBRepo) RecordCheckoutPayment(ctx context.Context, p models.Payment) (bool, error) {
+	// For testing: payments on reservation 2 fail, and those on reservation 3 were
+	// recorded already
+	switch p.ReservationID {
+	case 2:
+		return false, errors.New("error recording payment")
+	case 3:
+		return false, nil
+	}
+	return true, nil
+}
+
+func (m *testDBRepo) ReleaseHold(ctx context.Context, reservationID int) (bool, error) {
+	// For testing: reservation 1 is pending payment and reservation 2 fails
+	switch reservationID {
+	case 1:
+		return true, nil
+	case 2:
+		return false, errors.New("error releasing hold")
+	}
+	return false, nil
+}
+
+func (m *testDBRepo) ReleaseExpiredHolds(ctx context.Context, now time.Time) ([]int, error) {
+	// For testing: holds expire in 2060, and checking in 2070 fails
+	switch now.Year() {
+	case 2060:
+		return []int{1, 2}, nil
+	case 2070:
+		return nil, errors.New("error releasing holds")
+	}
+	return nil, nil
+}
+
+func (m *testDBRepo) CreateInvoice(ctx context.Context, inv models.Invoice) (models.Invoice, error) {
+	// For testing: invoicing reservation 2 fails
+	if inv.ReservationID == 2 {
+		return inv, errors.New("error creating invoice")
+	}
+	inv.ID = 1
+	inv.Number = 1
+	inv.Total = 0
+	for _, l := range inv.Lines {
+		inv.Total += l.Amount
+	}
+	inv.IssuedAt = time.Date(2040, 1, 5, 9, 0, 0, 0, time.UTC)
+	return inv, nil
+}
+
+func (m *testDBRepo) InvoiceForReservation(ctx context.Context, reservationID int) (models.Invoice, error) {
+	// For testing: reservation 1 is invoiced, reservation 2 is not, and the others fail
+	switch reservationID {
+	case 1:
+		return models.Invoice{
+			ID: 1, PropertyID: models.DefaultPropertyID, Number: 7, ReservationID: 1,
+			BillTo: "John Smith", Email: "john@smith.com", Currency: "usd", Total: 20000,
+			Lines: []models.InvoiceLine{
+				{Description: "General's Quarters, 2050-01-01 to 2050-01-03", Quantity: 2, UnitPrice: 10000, Amount: 20000},
+			},
+			IssuedAt: time.Date(2040, 1, 5, 9, 0, 0, 0, time.UTC),
+		}, nil
+	case 2:
+		return models.Invoice{}, sql.ErrNoRows
+	}
+	return models.Invoice{}, errors.New("error loading invoice")
+}
+
+func (m *testDBRepo) GuestToken(ctx context.Context, reservationID int) (string, error) {
+	if reservationID > 2 {
+		return "", sql.ErrNoRows
+	}
+	return "guest123", nil
+}
+
+func (m *testDBRepo) ReservationIDByGuestToken(ctx context.Context, token string) (int, error) {
+	// For testing: guest123 belongs to reservation 1, guest456 to reservation 2, and
+	// fail is a database error
+	if m.otherProperty() {
+		return 0, sql.ErrNoRows
+	}
+	switch token {
+	case "guest123":
+		return 1, nil
+	case "guest456":
+		return 2, nil
+	case "fail":
+		return 0, errors.New("database error")
+	}
+	return 0, sql.ErrNoRows
+}
+
+// testPromoCodes are the promo codes of the test repository: SUMMER takes 10% off
+// stays of 2 nights or more, WINTER is inactive, USEDUP and TAKEN take 20.00 off
+var testPromoCodes = []models.PromoCode{
+	{ID: 1, Code: "SUMMER", Kind: models.DiscountPercent, Value: 10, MinNights: 2, Active: true,
+		Redemptions: 2, Discounted: 4000, Revenue: 36000},
+	{ID: 2, Code: "WINTER", Kind: models.DiscountPercent, Value: 20, Active: false},
+	{ID: 3, Code: "USEDUP", Kind: models.DiscountFixed, Value: 2000, MaxRedemptions: 1, Active: true, Redemptions: 1},
+	{ID: 4, Code: "TAKEN", Kind: models.DiscountFixed, Value: 2000, Active: true},
+}
+
+func (m *testDBRepo) AllPromoCodes(ctx context.Context) ([]models.PromoCode, error) {
+	return testPromoCodes, nil
+}
+
+func (m *testDBRepo) GetPromoCodeByID(ctx context.Context, id int) (models.PromoCode, error) {
+	if id < 1 || id > len(testPromoCodes) {
+		return models.PromoCode{}, errors.New("error loading promo code")
+	}
+	return testPromoCodes[id-1], nil
+}
+
+func (m *testDBRepo) GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error) {
+	// For testing: FAIL is a database error
+	code = models.NormalizeCode(code)
+	if code == "FAIL" {
+		return models.PromoCode{}, errors.New("error loading promo code")
+	}
+	for _, p := range testPromoCodes {
+		if p.Code == code {
+			return p, nil
+		}
+	}
+	return models.PromoCode{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) InsertPromoCode(ctx context.Context, p models.PromoCode) (int, error) {
+	// For testing: SUMMER is taken and FAIL fails
+	switch models.NormalizeCode(p.Code) {
+	case "SUMMER":
+		return 0, ErrPromoCodeExists
+	case "FAIL":
+		return 0, errors.New("error inserting promo code")
+	}
+	return len(testPromoCodes) + 1, nil
+}
+
+func (m *testDBRepo) UpdatePromoCode(ctx context.Context, p models.PromoCode) error {
+	// For testing: SUMMER is taken by promo code 1, and saving promo code 2 fails
+	if models.NormalizeCode(p.Code) == "SUMMER" && p.ID != 1 {
+		return ErrPromoCodeExists
+	}
+	if p.ID == 2 {
+		return errors.New("error updating promo code")
+	}
+	return nil
+}
 
+func (m *testDBRepo) DeletePromoCode(ctx context.Context, id int) error {
+	// For testing: promo code 1 was redeemed, and deleting promo code 2 fails
+	switch id {
+	case 1:
+		return ErrPromoCodeInUse
+	case 2:
+		return errors.New("error deleting promo code")
+	}
+	return nil
+}
+
+func (m *testDBRepo) PromoCodeRedemptions(ctx context.Context, promoCodeID int) ([]models.Reservation, error) {
+	reservations := []models.Reservation{
+		{ID: 1, RoomID: 1, FirstName: "John", LastName: "Smith", Email: "john@smith.com",
+			StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+			AmountDue: 18000, Discount: 2000, PaymentStatus: models.PaymentNone, PromoCodeID: promoCodeID,
+			Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
+	}
+	return reservations, nil
+}
+
+// testCancellationPolicies are the cancellation policies of the test repository:
+// reservation 1 is Flexible, and reservation 2 Non-refundable
+var testCancellationPolicies = []models.CancellationPolicy{
+	{ID: 1, Name: "Flexible", Rules: []models.CancellationRule{{DaysBefore: 14, FeePercent: 50}}, RoomIDs: []int{1}},
+	{ID: 2, Name: "Non-refundable", Rules: []models.CancellationRule{{DaysBefore: 36500, FeePercent: 100}}, RoomIDs: []int{2}},
+}
+
+func (m *testDBRepo) AllCancellationPolicies(ctx context.Context) ([]models.CancellationPolicy, error) {
+	return testCancellationPolicies, nil
+}
+
+func (m *testDBRepo) GetCancellationPolicyByID(ctx context.Context, id int) (models.CancellationPolicy, error) {
+	if id < 1 || id > len(testCancellationPolicies) {
+		return models.CancellationPolicy{}, errors.New("error loading cancellation policy")
+	}
+	return testCancellationPolicies[id-1], nil
+}
+
+func (m *testDBRepo) InsertCancellationPolicy(ctx context.Context, p models.CancellationPolicy) (int, error) {
+	// For testing: Flexible is taken and fail fails
+	switch strings.ToLower(p.Name) {
+	case "flexible":
+		return 0, ErrCancellationPolicyExists
+	case "fail":
+		return 0, errors.New("error inserting cancellation policy")
+	}
+	return len(testCancellationPolicies) + 1, nil
+}
+
+func (m *testDBRepo) UpdateCancellationPolicy(ctx context.Context, p models.CancellationPolicy) error {
+	// For testing: Flexible is taken by policy 1, and saving policy 2 fails
+	if strings.ToLower(p.Name) == "flexible" && p.ID != 1 {
+		return ErrCancellationPolicyExists
+	}
+	if p.ID == 2 {
+		return errors.New("error updating cancellation policy")
+	}
+	return nil
+}
+
+func (m *testDBRepo) DeleteCancellationPolicy(ctx context.Context, id int) error {
+	// For testing: reservations were made with policy 1, and deleting policy 2 fails
+	switch id {
+	case 1:
+		return ErrCancellationPolicyInUse
+	case 2:
+		return errors.New("error deleting cancellation policy")
+	}
+	return nil
+}
+
+func (m *testDBRepo) CancelReservation(ctx context.Context, id, fee int, reason string) (bool, error) {
+	// For testing: the reason fail fails
+	if reason == "fail" {
+		return false, errors.New("error cancelling reservation")
+	}
+	return true, nil
+}
+
+// testProperties are the properties of the test repository, both managed by user 1
+var testProperties = []models.Property{
+	{ID: 1, Name: "Fort Smythe Bed and Breakfast", Slug: "fort-smythe", UserIDs: []int{1}},
+	{ID: 2, Name: "Harbour House", Slug: "harbour", Hostname: "harbour.example.com", UserIDs: []int{1}},
+}
+
+func (m *testDBRepo) GetPropertyByID(ctx context.Context, id int) (models.Property, error) {
+	if id < 1 || id > len(testProperties) {
+		return models.Property{}, sql.ErrNoRows
+	}
+	return testProperties[id-1], nil
+}
+
+func (m *testDBRepo) PropertyByHost(ctx context.Context, host string) (models.Property, error) {
+	for _, p := range testProperties {
+		if p.Hostname != "" && strings.EqualFold(p.Hostname, host) {
+			return p, nil
+		}
+	}
+	return models.Property{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) PropertyBySlug(ctx context.Context, slug string) (models.Property, error) {
+	// For testing: fail is a database error
+	if slug == "fail" {
+		return models.Property{}, errors.New("database error")
+	}
+	for _, p := range testProperties {
+		if strings.EqualFold(p.Slug, slug) {
+			return p, nil
+		}
+	}
+	return models.Property{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) UserProperties(ctx context.Context, userID int) ([]models.Property, error) {
+	// For testing: user 1 manages both properties, user 2 none, others fail
+	switch userID {
+	case 1:
+		return testProperties, nil
+	case 2:
+		return nil, nil
+	}
+	return nil, errors.New("database error")
+}
+
+func (m *testDBRepo) InsertProperty(ctx context.Context, p models.Property) (int, error) {
+	// For testing: the slug harbour is taken, and fail is a database error
+	switch p.Slug {
+	case "harbour":
+		return 0, ErrPropertyExists
+	case "fail":
+		return 0, errors.New("error inserting property")
+	}
+	return 3, nil
+}
+
+func (m *testDBRepo) UpdateProperty(ctx context.Context, p models.Property) error {
+	// For testing: the slug harbour belongs to property 2, and fail is a database error
+	if p.Slug == "harbour" && p.ID != 2 {
+		return ErrPropertyExists
+	}
+	if p.Slug == "fail" {
+		return errors.New("error updating property")
+	}
+	return nil
+}
+
+func (m *testDBRepo) ListUsers(ctx context.Context, userID int) ([]models.User, error) {
+	// For testing: user 1 manages the properties with user 2, others manage none
+	if userID != 1 {
+		return nil, nil
+	}
+	return []models.User{
+		{ID: 1, FirstName: "Admin", LastName: "User", Email: "me@here.ca"},
+		{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@here.ca"},
+	}, nil
+}
+
+func (m *testDBRepo) InsertRoom(ctx context.Context, room models.Room) (int, error) {
+	// For testing: a room named fail is a database error
+	if room.RoomName == "fail" {
+		return 0, errors.New("error inserting room")
+	}
+	return 3, nil
+}
+
+func (m *testDBRepo) AllRoomUnits(ctx context.Context) ([]models.RoomUnit, error) {
+	if m.otherProperty() {
+		return nil, nil
+	}
+	// For testing: room 1 has units 1 and 3, room 2 has unit 2
+	units := []models.RoomUnit{
+		{ID: 1, RoomID: 1, Name: "General's Quarters 1"},
+		{ID: 3, RoomID: 1, Name: "General's Quarters 2"},
+		{ID: 2, RoomID: 2, Name: "Major's Suite"},
+	}
+	return units, nil
+}
+
+func (m *testDBRepo) AddRoomUnit(ctx context.Context, roomID int, name string) (int, error) {
+	// For testing: a unit named fail is a database error, only rooms 1 and 2 exist,
+	// and room 1 has a unit named General's Quarters 1 already
+	if name == "fail" {
+		return 0, errors.New("error adding unit")
+	}
+	if roomID < 1 || roomID > 2 {
+		return 0, sql.ErrNoRows
+	}
+	if roomID == 1 && name == "General's Quarters 1" {
+		return 0, ErrUnitExists
+	}
+	return 4, nil
+}
+
+func (m *testDBRepo) AssignUnit(ctx context.Context, reservationID, unitID int) error {
+	// For testing: reservation 1 holds unit 3 of room 1, unit 1 is booked during its
+	// stay, unit 2 is of another room, and unit 1000 fails
+	switch {
+	case unitID == 1000:
+		return errors.New("error assigning unit")
+	case reservationID != 1 || unitID == 2:
+		return sql.ErrNoRows
+	case unitID == 1:
+		return ErrRestrictionConflict
+	}
 	return nil
 }
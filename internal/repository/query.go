@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/florian-lahitte-uvi/bookings/internal/models"
+)
+
+// Reservation statuses accepted by ReservationQuery.Status
+const (
+	StatusAll       = ""
+	StatusNew       = "new"
+	StatusProcessed = "processed"
+)
+
+// ReservationSortKeys lists the columns the reservation lists can be sorted on
+var ReservationSortKeys = []string{"id", "last_name", "first_name", "email", "phone", "room", "start_date", "end_date", "created_at"}
+
+// DefaultPageSize is used when ReservationQuery.Limit is not set
+const DefaultPageSize = 25
+
+// MaxPageSize caps ReservationQuery.Limit
+const MaxPageSize = 100
+
+// ReservationQuery searches, filters, sorts and pages the reservation lists
+type ReservationQuery struct {
+	// Search matches the guest name, email or phone
+	Search string
+	RoomID int
+	// From and To keep the reservations whose stay overlaps the period
+	From   time.Time
+	To     time.Time
+	Status string
+	Sort   string
+	Desc   bool
+	Limit  int
+	// After and Before are opaque cursors returned in a ReservationPage
+	After  string
+	Before string
+}
+
+// ReservationPage is one page of a reservation list
+type ReservationPage struct {
+	Reservations []models.Reservation
+	// NextCursor and PrevCursor are empty when there is no page in that direction
+	NextCursor string
+	PrevCursor string
+}
+
+// IsSortKey reports whether key is a valid sort column
+func IsSortKey(key string) bool {
+	for _, k := range ReservationSortKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// PageSize returns the limit to use, applying the default and the maximum
+func (q ReservationQuery) PageSize() int {
+	if q.Limit <= 0 {
+		return DefaultPageSize
+	}
+	if q.Limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return q.Limit
+}
@@ -1,31 +1,107 @@
 package repository
 
 import (
+	"context"
 	"time"
 
 	"github.com/florian-lahitte-uvi/bookings/internal/models"
 )
 
 type DatabaseRepo interface {
-	AllUsers() bool
-	InsertReservation(res models.Reservation) (int, error)
-	InsertRoomRestriction(restriction models.RoomRestriction) error
-	SearchAvaibilityByDatesByRoomID(RoomID int, start, end time.Time) (bool, error)
-	SearchAvaibilityForAllRooms(start, end time.Time) ([]models.Room, error)
-	GetRoomByID(id int) (models.Room, error)
-
-	GetUserByID(id int) (models.User, error)
-	UpdateUser(u models.User) error
-	Authenticate(email, testPassword string) (int, string, error)
-
-	AllReservations() ([]models.Reservation, error)
-	AllNewReservations() ([]models.Reservation, error)
-	GetReservationByID(id int) (models.Reservation, error)
-	UpdateReservation(r models.Reservation) error
-	DeleteReservation(id int) error
-	UpdateProcessedForReservation(id, processed int) error
-	AllRooms() ([]models.Room, error)
-	GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
-	InsertBlockForRoom(id int, startDate time.Time) error
-	DeleteBlockByID(id int) error
+	// ForProperty returns the repository seeing only the data of one property,
+	// 0 sees every property
+	ForProperty(propertyID int) DatabaseRepo
+
+	Ping(ctx context.Context) error
+	SchemaVersion(ctx context.Context) (string, error)
+	InsertReservation(ctx context.Context, res models.Reservation, holdID int) (int, error)
+	CreateReservation(ctx context.Context, res models.Reservation, override bool) (int, error)
+	SearchAvaibilityByDatesByRoomID(ctx context.Context, RoomID int, start, end time.Time) (bool, error)
+	SearchAvaibilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error)
+	SearchAvailabilityExcludingReservation(ctx context.Context, roomID int, start, end time.Time, reservationID int) (bool, error)
+	GetRoomByID(ctx context.Context, id int) (models.Room, error)
+
+	GetUserByID(ctx context.Context, id int) (models.User, error)
+	UpdateUser(ctx context.Context, u models.User) error
+	Authenticate(ctx context.Context, email, testPassword string) (int, string, error)
+
+	ListReservations(ctx context.Context, q ReservationQuery) (ReservationPage, error)
+	EachReservation(ctx context.Context, q ReservationQuery, fn func(models.Reservation) error) error
+	CountNewReservations(ctx context.Context) (int, error)
+	GetReservationByID(ctx context.Context, id int) (models.Reservation, error)
+	UpdateReservation(ctx context.Context, r models.Reservation) error
+	MoveReservation(ctx context.Context, r models.Reservation) error
+	DeleteReservation(ctx context.Context, id int) error
+	UpdateProcessedForReservation(ctx context.Context, id, processed int) error
+	AllRooms(ctx context.Context) ([]models.Room, error)
+	RoomAvailability(ctx context.Context, roomID int, start, end time.Time) ([]models.NightAvailability, error)
+	FreeUnitNights(ctx context.Context, start, end time.Time) ([]models.RoomNight, error)
+	GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error)
+	RestrictionsForPeriod(ctx context.Context, start, end time.Time) ([]models.RoomRestriction, error)
+	InsertBlock(ctx context.Context, unitID int, start, end time.Time) (int, error)
+	DeleteBlockByID(ctx context.Context, id int) error
+	HoldRoom(ctx context.Context, roomID int, start, end, until time.Time) (int, error)
+	ReleaseRoomHold(ctx context.Context, id int) error
+	ReleaseExpiredRoomHolds(ctx context.Context, now time.Time) (int, error)
+	ImportRestrictions(ctx context.Context, rows []models.RoomRestriction) error
+
+	ArrivalsForDate(ctx context.Context, day time.Time) ([]models.Reservation, error)
+	DeparturesForDate(ctx context.Context, day time.Time) ([]models.Reservation, error)
+	CountInHouseReservations(ctx context.Context, day time.Time) (int, error)
+	OccupancyByRoom(ctx context.Context, start, end time.Time) ([]models.RoomOccupancy, error)
+	ReservationsCreatedByDay(ctx context.Context, start, end time.Time) ([]models.DailyCount, error)
+	AverageLengthOfStay(ctx context.Context, start, end time.Time) (float64, error)
+	EachRoomNight(ctx context.Context, start, end time.Time, fn func(models.RoomNight) error) error
+
+	ListGuests(ctx context.Context, search string) ([]models.Guest, error)
+	GetGuestByID(ctx context.Context, id int) (models.Guest, error)
+	GuestStays(ctx context.Context, guestID int) ([]models.Reservation, error)
+	UpdateGuest(ctx context.Context, g models.Guest) error
+
+	AddReservationNote(ctx context.Context, n models.ReservationNote) (int, error)
+	ReservationNotes(ctx context.Context, reservationID int) ([]models.ReservationNote, error)
+	AddReservationMessage(ctx context.Context, msg models.ReservationMessage) (int, error)
+	ReservationMessages(ctx context.Context, reservationID int) ([]models.ReservationMessage, error)
+	ReplyToken(ctx context.Context, reservationID int) (string, error)
+	ReservationIDByReplyToken(ctx context.Context, token string) (int, error)
+
+	AddPayment(ctx context.Context, p models.Payment) (int, error)
+	ReservationPayments(ctx context.Context, reservationID int) ([]models.Payment, error)
+	UpdateAmountDue(ctx context.Context, reservationID, amount int) error
+	RecordCheckoutPayment(ctx context.Context, p models.Payment) (bool, error)
+	ReleaseHold(ctx context.Context, reservationID int) (bool, error)
+	ReleaseExpiredHolds(ctx context.Context, now time.Time) ([]int, error)
+
+	CreateInvoice(ctx context.Context, inv models.Invoice) (models.Invoice, error)
+	InvoiceForReservation(ctx context.Context, reservationID int) (models.Invoice, error)
+	GuestToken(ctx context.Context, reservationID int) (string, error)
+	ReservationIDByGuestToken(ctx context.Context, token string) (int, error)
+
+	AllPromoCodes(ctx context.Context) ([]models.PromoCode, error)
+	GetPromoCodeByID(ctx context.Context, id int) (models.PromoCode, error)
+	GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error)
+	InsertPromoCode(ctx context.Context, p models.PromoCode) (int, error)
+	UpdatePromoCode(ctx context.Context, p models.PromoCode) error
+	DeletePromoCode(ctx context.Context, id int) error
+	PromoCodeRedemptions(ctx context.Context, promoCodeID int) ([]models.Reservation, error)
+
+	AllCancellationPolicies(ctx context.Context) ([]models.CancellationPolicy, error)
+	GetCancellationPolicyByID(ctx context.Context, id int) (models.CancellationPolicy, error)
+	InsertCancellationPolicy(ctx context.Context, p models.CancellationPolicy) (int, error)
+	UpdateCancellationPolicy(ctx context.Context, p models.CancellationPolicy) error
+	DeleteCancellationPolicy(ctx context.Context, id int) error
+	CancelReservation(ctx context.Context, id, fee int, reason string) (bool, error)
+
+	GetPropertyByID(ctx context.Context, id int) (models.Property, error)
+	PropertyByHost(ctx context.Context, host string) (models.Property, error)
+	PropertyBySlug(ctx context.Context, slug string) (models.Property, error)
+	UserProperties(ctx context.Context, userID int) ([]models.Property, error)
+	InsertProperty(ctx context.Context, p models.Property) (int, error)
+	UpdateProperty(ctx context.Context, p models.Property) error
+	ListUsers(ctx context.Context, userID int) ([]models.User, error)
+	InsertRoom(ctx context.Context, room models.Room) (int, error)
+
+	AllRoomUnits(ctx context.Context) ([]models.RoomUnit, error)
+	AddRoomUnit(ctx context.Context, roomID int, name string) (int, error)
+	AssignUnit(ctx context.Context, reservationID, unitID int) error
 }
@@ -0,0 +1,3 @@
+-- SQL in section 'Down' is executed when this migration is rolled back
+ALTER TABLE reservations DROP COLUMN guest_id;
+DROP TABLE guests;
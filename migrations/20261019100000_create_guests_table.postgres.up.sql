@@ -0,0 +1,27 @@
+-- SQL in section 'Up' is executed when this migration is applied
+-- Guests are recognised by their email address, whatever its case
+CREATE TABLE guests (
+    id serial PRIMARY KEY,
+    email varchar(255) NOT NULL,
+    first_name varchar(255) NOT NULL DEFAULT '',
+    last_name varchar(255) NOT NULL DEFAULT '',
+    phone varchar(255) NOT NULL DEFAULT '',
+    notes text NOT NULL DEFAULT '',
+    tags varchar(255) NOT NULL DEFAULT '',
+    created_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL
+);
+CREATE UNIQUE INDEX guests_email_idx ON guests (lower(email));
+
+ALTER TABLE reservations ADD COLUMN guest_id integer REFERENCES guests (id) ON DELETE SET NULL;
+CREATE INDEX reservations_guest_id_idx ON reservations (guest_id);
+
+-- One guest per email address, with the details given in their latest booking
+INSERT INTO guests (email, first_name, last_name, phone, created_at, updated_at)
+SELECT DISTINCT ON (lower(email)) email, first_name, last_name, phone,
+    min(created_at) OVER (PARTITION BY lower(email)), max(updated_at) OVER (PARTITION BY lower(email))
+FROM reservations
+WHERE coalesce(email, '') <> ''
+ORDER BY lower(email), created_at DESC;
+
+UPDATE reservations r SET guest_id = g.id FROM guests g WHERE lower(r.email) = lower(g.email);
@@ -0,0 +1,4 @@
+-- SQL in section 'Down' is executed when this migration is rolled back
+ALTER TABLE reservations DROP COLUMN reply_token;
+DROP TABLE reservation_messages;
+DROP TABLE reservation_notes;
@@ -0,0 +1,26 @@
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE reservation_notes (
+    id serial PRIMARY KEY,
+    reservation_id integer NOT NULL REFERENCES reservations (id) ON DELETE CASCADE,
+    user_id integer REFERENCES users (id) ON DELETE SET NULL,
+    body text NOT NULL,
+    created_at timestamp NOT NULL
+);
+CREATE INDEX reservation_notes_reservation_id_idx ON reservation_notes (reservation_id);
+
+CREATE TABLE reservation_messages (
+    id serial PRIMARY KEY,
+    reservation_id integer NOT NULL REFERENCES reservations (id) ON DELETE CASCADE,
+    direction varchar(3) NOT NULL,
+    user_id integer REFERENCES users (id) ON DELETE SET NULL,
+    from_address varchar(255) NOT NULL,
+    to_address varchar(255) NOT NULL,
+    subject varchar(255) NOT NULL DEFAULT '',
+    body text NOT NULL,
+    created_at timestamp NOT NULL
+);
+CREATE INDEX reservation_messages_reservation_id_idx ON reservation_messages (reservation_id);
+
+-- Guest replies are threaded by the token in the Reply-To address
+ALTER TABLE reservations ADD COLUMN reply_token varchar(32);
+CREATE UNIQUE INDEX reservations_reply_token_idx ON reservations (reply_token);
@@ -0,0 +1,6 @@
+-- SQL in section 'Down' is executed when this migration is rolled back
+DROP TABLE payments;
+ALTER TABLE reservations DROP COLUMN hold_until;
+ALTER TABLE reservations DROP COLUMN payment_status;
+ALTER TABLE reservations DROP COLUMN amount_due;
+ALTER TABLE rooms DROP COLUMN price;
@@ -0,0 +1,23 @@
+-- SQL in section 'Up' is executed when this migration is applied
+-- Prices and amounts are in cents
+ALTER TABLE rooms ADD COLUMN price integer NOT NULL DEFAULT 0;
+
+ALTER TABLE reservations ADD COLUMN amount_due integer NOT NULL DEFAULT 0;
+ALTER TABLE reservations ADD COLUMN payment_status varchar(10) NOT NULL DEFAULT 'none';
+ALTER TABLE reservations ADD COLUMN hold_until timestamp;
+CREATE INDEX reservations_hold_until_idx ON reservations (hold_until) WHERE payment_status = 'pending';
+
+CREATE TABLE payments (
+    id serial PRIMARY KEY,
+    reservation_id integer NOT NULL REFERENCES reservations (id) ON DELETE CASCADE,
+    kind varchar(10) NOT NULL,
+    method varchar(10) NOT NULL,
+    amount integer NOT NULL CHECK (amount > 0),
+    reference varchar(255) NOT NULL DEFAULT '',
+    checkout_id varchar(255),
+    user_id integer REFERENCES users (id) ON DELETE SET NULL,
+    created_at timestamp NOT NULL
+);
+CREATE INDEX payments_reservation_id_idx ON payments (reservation_id);
+-- a provider can call the webhook more than once for the same checkout
+CREATE UNIQUE INDEX payments_checkout_id_idx ON payments (checkout_id);
@@ -0,0 +1,5 @@
+-- SQL in section 'Down' is executed when this migration is rolled back
+ALTER TABLE reservations DROP COLUMN guest_token;
+DROP TABLE invoice_lines;
+DROP TABLE invoices;
+DROP TABLE invoice_counters;
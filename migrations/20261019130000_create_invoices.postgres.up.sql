@@ -0,0 +1,37 @@
+-- SQL in section 'Up' is executed when this migration is applied
+-- Invoice numbers follow each other per property, the counter row is locked while
+-- an invoice is issued so a failed issue leaves no gap
+CREATE TABLE invoice_counters (
+    property_id integer PRIMARY KEY,
+    last_number integer NOT NULL
+);
+
+-- Invoices outlive their reservation, they keep who was billed and the price lines
+CREATE TABLE invoices (
+    id serial PRIMARY KEY,
+    property_id integer NOT NULL,
+    number integer NOT NULL,
+    reservation_id integer REFERENCES reservations (id) ON DELETE SET NULL,
+    bill_to varchar(255) NOT NULL,
+    email varchar(255) NOT NULL DEFAULT '',
+    currency varchar(3) NOT NULL,
+    total integer NOT NULL,
+    issued_at timestamp NOT NULL
+);
+CREATE UNIQUE INDEX invoices_property_id_number_idx ON invoices (property_id, number);
+CREATE UNIQUE INDEX invoices_reservation_id_idx ON invoices (reservation_id);
+
+CREATE TABLE invoice_lines (
+    id serial PRIMARY KEY,
+    invoice_id integer NOT NULL REFERENCES invoices (id) ON DELETE CASCADE,
+    position integer NOT NULL,
+    description varchar(255) NOT NULL,
+    quantity integer NOT NULL,
+    unit_price integer NOT NULL,
+    amount integer NOT NULL
+);
+CREATE INDEX invoice_lines_invoice_id_idx ON invoice_lines (invoice_id);
+
+-- Guests open their reservation, and download its invoice, with this token
+ALTER TABLE reservations ADD COLUMN guest_token varchar(32);
+CREATE UNIQUE INDEX reservations_guest_token_idx ON reservations (guest_token);
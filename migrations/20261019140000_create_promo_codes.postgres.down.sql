@@ -0,0 +1,5 @@
+-- SQL in section 'Down' is executed when this migration is rolled back
+ALTER TABLE reservations DROP COLUMN discount;
+ALTER TABLE reservations DROP COLUMN promo_code_id;
+DROP TABLE promo_code_rooms;
+DROP TABLE promo_codes;
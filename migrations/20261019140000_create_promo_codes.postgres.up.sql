@@ -0,0 +1,29 @@
+-- SQL in section 'Up' is executed when this migration is applied
+-- Values are a percentage for percent codes, and cents for fixed ones
+CREATE TABLE promo_codes (
+    id serial PRIMARY KEY,
+    code varchar(50) NOT NULL,
+    description varchar(255) NOT NULL DEFAULT '',
+    kind varchar(10) NOT NULL,
+    value integer NOT NULL CHECK (value > 0),
+    valid_from date,
+    valid_to date,
+    min_nights integer NOT NULL DEFAULT 0,
+    max_redemptions integer NOT NULL DEFAULT 0,
+    active boolean NOT NULL DEFAULT true,
+    created_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL
+);
+CREATE UNIQUE INDEX promo_codes_code_idx ON promo_codes (upper(code));
+
+-- A code without rooms applies to all of them
+CREATE TABLE promo_code_rooms (
+    promo_code_id integer NOT NULL REFERENCES promo_codes (id) ON DELETE CASCADE,
+    room_id integer NOT NULL REFERENCES rooms (id) ON DELETE CASCADE,
+    PRIMARY KEY (promo_code_id, room_id)
+);
+
+-- Codes used by reservations can't be deleted, only deactivated
+ALTER TABLE reservations ADD COLUMN promo_code_id integer REFERENCES promo_codes (id) ON DELETE RESTRICT;
+ALTER TABLE reservations ADD COLUMN discount integer NOT NULL DEFAULT 0;
+CREATE INDEX reservations_promo_code_id_idx ON reservations (promo_code_id);
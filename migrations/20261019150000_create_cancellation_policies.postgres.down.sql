@@ -0,0 +1,8 @@
+-- SQL in section 'Down' is executed when this migration is rolled back
+ALTER TABLE reservations DROP COLUMN cancellation_reason;
+ALTER TABLE reservations DROP COLUMN cancellation_fee;
+ALTER TABLE reservations DROP COLUMN cancelled_at;
+ALTER TABLE reservations DROP COLUMN cancellation_policy_id;
+ALTER TABLE rooms DROP COLUMN cancellation_policy_id;
+DROP TABLE cancellation_policy_rules;
+DROP TABLE cancellation_policies;
@@ -0,0 +1,27 @@
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE cancellation_policies (
+    id serial PRIMARY KEY,
+    name varchar(255) NOT NULL,
+    description varchar(255) NOT NULL DEFAULT '',
+    created_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL
+);
+CREATE UNIQUE INDEX cancellation_policies_name_idx ON cancellation_policies (lower(name));
+
+-- Cancelling less than days_before days before arrival costs fee_percent of the stay
+CREATE TABLE cancellation_policy_rules (
+    cancellation_policy_id integer NOT NULL REFERENCES cancellation_policies (id) ON DELETE CASCADE,
+    days_before integer NOT NULL CHECK (days_before >= 0),
+    fee_percent integer NOT NULL CHECK (fee_percent BETWEEN 0 AND 100),
+    PRIMARY KEY (cancellation_policy_id, days_before)
+);
+
+-- Rooms without a policy can be cancelled for free. Reservations keep the policy of
+-- their room when they were made, so policies in use can't be deleted.
+ALTER TABLE rooms ADD COLUMN cancellation_policy_id integer REFERENCES cancellation_policies (id) ON DELETE SET NULL;
+ALTER TABLE reservations ADD COLUMN cancellation_policy_id integer REFERENCES cancellation_policies (id) ON DELETE RESTRICT;
+CREATE INDEX reservations_cancellation_policy_id_idx ON reservations (cancellation_policy_id);
+
+ALTER TABLE reservations ADD COLUMN cancelled_at timestamp;
+ALTER TABLE reservations ADD COLUMN cancellation_fee integer NOT NULL DEFAULT 0;
+ALTER TABLE reservations ADD COLUMN cancellation_reason text NOT NULL DEFAULT '';
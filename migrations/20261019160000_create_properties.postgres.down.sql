@@ -0,0 +1,16 @@
+-- SQL in section 'Down' is executed when this migration is rolled back
+DROP INDEX cancellation_policies_name_idx;
+ALTER TABLE cancellation_policies DROP COLUMN property_id;
+CREATE UNIQUE INDEX cancellation_policies_name_idx ON cancellation_policies (lower(name));
+
+DROP INDEX promo_codes_code_idx;
+ALTER TABLE promo_codes DROP COLUMN property_id;
+CREATE UNIQUE INDEX promo_codes_code_idx ON promo_codes (upper(code));
+
+DROP INDEX guests_email_idx;
+ALTER TABLE guests DROP COLUMN property_id;
+CREATE UNIQUE INDEX guests_email_idx ON guests (lower(email));
+
+ALTER TABLE rooms DROP COLUMN property_id;
+DROP TABLE user_properties;
+DROP TABLE properties;
@@ -0,0 +1,41 @@
+-- SQL in section 'Up' is executed when this migration is applied
+-- Properties are served on their own hostname, or under /p/{slug} on the main one
+CREATE TABLE properties (
+    id serial PRIMARY KEY,
+    name varchar(255) NOT NULL,
+    slug varchar(50) NOT NULL,
+    hostname varchar(255),
+    created_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL
+);
+CREATE UNIQUE INDEX properties_slug_idx ON properties (lower(slug));
+CREATE UNIQUE INDEX properties_hostname_idx ON properties (lower(hostname));
+
+-- The existing rooms, guests, codes and policies belong to the first property
+INSERT INTO properties (id, name, slug, created_at, updated_at)
+VALUES (1, 'Fort Smythe Bed and Breakfast', 'fort-smythe', now(), now());
+SELECT setval('properties_id_seq', 1);
+
+-- Staff only see the properties they belong to
+CREATE TABLE user_properties (
+    user_id integer NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    property_id integer NOT NULL REFERENCES properties (id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, property_id)
+);
+INSERT INTO user_properties (user_id, property_id) SELECT id, 1 FROM users;
+
+ALTER TABLE rooms ADD COLUMN property_id integer NOT NULL DEFAULT 1 REFERENCES properties (id) ON DELETE RESTRICT;
+CREATE INDEX rooms_property_id_idx ON rooms (property_id);
+
+-- Guests, promo codes and policy names are unique within their property
+ALTER TABLE guests ADD COLUMN property_id integer NOT NULL DEFAULT 1 REFERENCES properties (id) ON DELETE RESTRICT;
+DROP INDEX guests_email_idx;
+CREATE UNIQUE INDEX guests_email_idx ON guests (property_id, lower(email));
+
+ALTER TABLE promo_codes ADD COLUMN property_id integer NOT NULL DEFAULT 1 REFERENCES properties (id) ON DELETE RESTRICT;
+DROP INDEX promo_codes_code_idx;
+CREATE UNIQUE INDEX promo_codes_code_idx ON promo_codes (property_id, upper(code));
+
+ALTER TABLE cancellation_policies ADD COLUMN property_id integer NOT NULL DEFAULT 1 REFERENCES properties (id) ON DELETE RESTRICT;
+DROP INDEX cancellation_policies_name_idx;
+CREATE UNIQUE INDEX cancellation_policies_name_idx ON cancellation_policies (property_id, lower(name));
@@ -0,0 +1,3 @@
+-- SQL in section 'Down' is executed when this migration is rolled back
+ALTER TABLE room_restrictions DROP COLUMN unit_id;
+DROP TABLE room_units;
@@ -0,0 +1,20 @@
+-- SQL in section 'Up' is executed when this migration is applied
+-- A room is a type guests book, such as Double, with identical units staff assign
+-- to each stay. Existing rooms become types of a single unit named after them.
+CREATE TABLE room_units (
+    id serial PRIMARY KEY,
+    room_id integer NOT NULL REFERENCES rooms (id) ON DELETE CASCADE,
+    name varchar(255) NOT NULL,
+    created_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL
+);
+CREATE UNIQUE INDEX room_units_room_id_name_idx ON room_units (room_id, lower(name));
+
+INSERT INTO room_units (room_id, name, created_at, updated_at)
+SELECT id, room_name, now(), now() FROM rooms ORDER BY id;
+
+-- Reservations and blocks hold one unit of their room
+ALTER TABLE room_restrictions ADD COLUMN unit_id integer REFERENCES room_units (id) ON DELETE CASCADE;
+UPDATE room_restrictions rr SET unit_id = u.id FROM room_units u WHERE u.room_id = rr.room_id;
+ALTER TABLE room_restrictions ALTER COLUMN unit_id SET NOT NULL;
+CREATE INDEX room_restrictions_unit_id_idx ON room_restrictions (unit_id, start_date, end_date);
@@ -0,0 +1,4 @@
+-- SQL in section 'Down' is executed when this migration is rolled back
+DELETE FROM room_restrictions WHERE restriction_id = 3;
+ALTER TABLE room_restrictions DROP COLUMN expires_at;
+DELETE FROM restrictions WHERE id = 3;
@@ -0,0 +1,10 @@
+-- SQL in section 'Up' is executed when this migration is applied
+-- A guest filling in the reservation form holds a unit of the room they picked until
+-- expires_at, after which the hold no longer counts and the sweeper removes it
+INSERT INTO restrictions (id, restriction_name, created_at, updated_at)
+VALUES (3, 'Hold', now(), now())
+ON CONFLICT (id) DO NOTHING;
+SELECT setval('restrictions_id_seq', (SELECT max(id) FROM restrictions));
+
+ALTER TABLE room_restrictions ADD COLUMN expires_at timestamp;
+CREATE INDEX room_restrictions_expires_at_idx ON room_restrictions (expires_at) WHERE expires_at IS NOT NULL;
@@ -0,0 +1,2 @@
+-- SQL in section 'Down' is executed when this migration is rolled back
+ALTER TABLE rooms DROP COLUMN min_nights;
@@ -0,0 +1,3 @@
+-- SQL in section 'Up' is executed when this migration is applied
+-- The shortest stay a room is booked for, one night unless set
+ALTER TABLE rooms ADD COLUMN min_nights integer NOT NULL DEFAULT 1 CHECK (min_nights >= 1);
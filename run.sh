@@ -1,2 +1,2 @@
 #!/bin/bash
-go build -o bookings cmd/web/*.go && ./bookings -dbname=bookings -dbuser=florian.lahitte -dbpass=postgres -dbhost=localhost -dbport=5432 -dbssl=disable
\ No newline at end of file
+go build -o bookings cmd/web/*.go && ./bookings -config="${BOOKINGS_CONFIG:-bookings.yml}"
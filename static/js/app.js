@@ -82,6 +82,11 @@ function Prompt() {
                           c.didOpen();
                       }
                   },
+                  preConfirm: () => {
+                      if (c.preConfirm !== undefined) {
+                          return c.preConfirm();
+                      }
+                  },
               })
 
               if (result) {
@@ -105,4 +110,92 @@ function Prompt() {
               error: error,
               custom: custom,
           }
-      }
\ No newline at end of file
+      }
+
+// isoDate formats a local date as yyyy-mm-dd
+function isoDate(d) {
+    return d.getFullYear() + "-" + String(d.getMonth() + 1).padStart(2, "0") + "-" + String(d.getDate()).padStart(2, "0");
+}
+
+// availabilityCalendar loads which nights of a room can be booked over the next year,
+// to grey out the others in a date range picker and check a stay before it is sent
+function availabilityCalendar(basePath, roomID) {
+    const full = new Set();
+    let minNights = 1;
+
+    const from = new Date();
+    const to = new Date();
+    to.setFullYear(to.getFullYear() + 1);
+    fetch(basePath + "/api/availability/calendar?room_id=" + roomID + "&from=" + isoDate(from) + "&to=" + isoDate(to))
+        .then(response => response.json())
+        .then(data => {
+            if (!data.ok) {
+                return;
+            }
+            minNights = data.min_nights;
+            data.nights.forEach(night => {
+                if (!night.available) {
+                    full.add(night.date);
+                }
+            });
+        });
+
+    return {
+        // apply greys out the nights that can't be booked in the arrival picker, and the
+        // departures after them in the departure picker
+        apply: function (rangePicker) {
+            rangePicker.datepickers[0].setOptions({
+                beforeShowDay: date => !full.has(isoDate(date)),
+            });
+            rangePicker.datepickers[1].setOptions({
+                beforeShowDay: date => {
+                    const night = new Date(date);
+                    night.setDate(night.getDate() - 1);
+                    return !full.has(isoDate(night));
+                },
+            });
+        },
+        // check returns why the stay from start to end, as yyyy-mm-dd, can't be booked, or
+        // an empty string if it can
+        check: function (start, end) {
+            if (start === "" || end === "") {
+                return "Choose your arrival and departure dates.";
+            }
+            const night = new Date(start + "T00:00:00");
+            const last = new Date(end + "T00:00:00");
+            let nights = 0;
+            for (; night < last; night.setDate(night.getDate() + 1)) {
+                if (full.has(isoDate(night))) {
+                    return "The room is taken on " + isoDate(night) + ".";
+                }
+                nights++;
+            }
+            if (nights < minNights) {
+                return "This room is booked for " + minNights + " nights or more.";
+            }
+            return "";
+        },
+    };
+}
+
+// staySuggestions returns links to book the other dates and the split stay suggested
+// when a room isn't available, or an empty string if there are none
+function staySuggestions(basePath, data) {
+    const link = stay => '<a href="' + basePath + '/book-room?id=' + stay.room_id + '&s=' + stay.start_date + '&e=' + stay.end_date + '">';
+    let html = "";
+    if (data.alternatives) {
+        html += "<p>The room is free on other dates:</p><ul>";
+        data.alternatives.forEach(stay => {
+            html += "<li>" + link(stay) + stay.start_date + " to " + stay.end_date + "</a></li>";
+        });
+        html += "</ul>";
+    }
+    if (data.split_stay) {
+        html += "<p>Or split your stay between rooms:</p><ol>";
+        data.split_stay.forEach(stay => {
+            html += "<li>" + link(stay) + stay.room_name + "</a>, " + stay.start_date + " to " + stay.end_date + "</li>";
+        });
+        html += "</ol>";
+    }
+    return html;
+}